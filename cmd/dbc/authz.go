@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kardianos/task"
+	"github.com/solidcoredata/dbc/runner"
+)
+
+// authz prints the access granted to each role of each port of the schema.
+type authz struct{}
+
+func (authz) Run(ctx context.Context, st *task.State, sc task.Script) error {
+	s, err := loadStore(ctx, st.Filepath(st.Get("schema")))
+	if err != nil {
+		return err
+	}
+	r := runner.NewReport(s)
+	if fmt.Sprint(st.Get("json")) == "true" {
+		e := json.NewEncoder(st.Stdout)
+		e.SetIndent("", "\t")
+		e.SetEscapeHTML(false)
+		return e.Encode(r)
+	}
+	return writeReport(st.Stdout, r)
+}
+
+// writeReport writes the report as text, with a table of the access
+// to each table and column for each role of each port.
+func writeReport(w io.Writer, r *runner.Report) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if len(r.Open) > 0 {
+		fmt.Fprintf(tw, "open tables: %s\n", strings.Join(r.Open, ", "))
+	}
+	for _, p := range r.Port {
+		for _, role := range p.Role {
+			fmt.Fprintf(tw, "\nport %q role %q\n", p.Name, role.Name)
+			fmt.Fprintf(tw, "\tTABLE\tCOLUMN\tREAD\tRETURN\tINSERT\tUPDATE\tDELETE\n")
+			var deny []string
+			for _, t := range role.Table {
+				fmt.Fprintf(tw, "\t%s\t\t%s\n", t.Name, access(t.Access))
+				for _, c := range t.Column {
+					fmt.Fprintf(tw, "\t\t%s\t%s\n", c.Name, access(c.Access))
+					if len(c.DenyRead) > 0 {
+						deny = append(deny, fmt.Sprintf("deny read %s.%s: %s", t.Name, c.Name, c.DenyRead))
+					}
+					if len(c.DenyUpdate) > 0 {
+						deny = append(deny, fmt.Sprintf("deny update %s.%s: %s", t.Name, c.Name, c.DenyUpdate))
+					}
+				}
+				for _, e := range t.Read {
+					deny = append(deny, fmt.Sprintf("read %s: %s", t.Name, e))
+				}
+				for _, d := range []struct{ op, e string }{
+					{"read", t.DenyRead},
+					{"insert", t.DenyInsert},
+					{"update", t.DenyUpdate},
+					{"delete", t.DenyDelete},
+				} {
+					if len(d.e) > 0 {
+						deny = append(deny, fmt.Sprintf("deny %s %s: %s", d.op, t.Name, d.e))
+					}
+				}
+			}
+			for _, d := range deny {
+				fmt.Fprintf(tw, "\t%s\n", d)
+			}
+			for _, q := range role.Query {
+				if q.Allow {
+					fmt.Fprintf(tw, "\tquery %s: allowed\n", q.Name)
+					continue
+				}
+				fmt.Fprintf(tw, "\tquery %s: denied: %s\n", q.Name, q.Deny)
+			}
+		}
+	}
+	for _, warn := range r.Warning {
+		fmt.Fprintf(tw, "warning: %s\n", warn)
+	}
+	return tw.Flush()
+}
+
+// access returns the columns of an access row of the report table.
+func access(a runner.Access) string {
+	mark := func(ok bool) string {
+		if ok {
+			return "yes"
+		}
+		return "-"
+	}
+	return strings.Join([]string{mark(a.Read), mark(a.Return), mark(a.Insert), mark(a.Update), mark(a.Delete)}, "\t")
+}
@@ -4,10 +4,13 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 
 	"github.com/kardianos/task"
+	"github.com/solidcoredata/dbc/compile"
+	"github.com/solidcoredata/dbc/query"
 )
 
 func main() {
@@ -36,6 +39,35 @@ func run(ctx context.Context) error {
 				Flags:  flags,
 				Action: build{release: true},
 			},
+			{
+				Name:  "fmt",
+				Usage: "Format the schema source files",
+				Flags: []*task.Flag{
+					{Name: "schema", Usage: "schema definition directory", Default: "schema"},
+					{Name: "l", Usage: "list files whose formatting differs, do not write them", Default: false},
+					{Name: "d", Usage: "display diffs of formatting changes, do not write them", Default: false},
+				},
+				Action: format{},
+			},
+			{
+				Name:  "authz",
+				Usage: "Report the access granted to each role of each port",
+				Flags: []*task.Flag{
+					{Name: "schema", Usage: "schema definition directory", Default: "schema"},
+					{Name: "json", Usage: "write the report as JSON", Default: false},
+				},
+				Action: authz{},
+			},
+			{
+				Name:  "test",
+				Usage: "Run the query tests of the schema package on the memory runner",
+				Flags: []*task.Flag{
+					{Name: "schema", Usage: "schema definition directory", Default: "schema"},
+					{Name: "run", Usage: "only run tests with names that match the regular expression", Default: ""},
+					{Name: "v", Usage: "list each test that passes", Default: false},
+				},
+				Action: test{},
+			},
 		},
 	}
 
@@ -65,6 +97,14 @@ func (b build) Run(ctx context.Context, st *task.State, sc task.Script) error {
 	// 1. Read current schema files from schema directory.
 	// 2. Lex and parse the schema files. On error, fail and display errors.
 	// 3. Verify the schema is valid and consistent.
+	s, err := loadStore(ctx, schemaPath)
+	if err != nil {
+		return err
+	}
+	for _, w := range query.CheckAuthn(s) {
+		fmt.Fprintf(st.Stderr, "warning: %s\n", w)
+	}
+
 	// 4. Read the most recent alter version.
 	// 5. Verify the new schema is compatible with the previous version.
 	//     The schema may introduce a field or table, or remove an unused field
@@ -74,6 +114,20 @@ func (b build) Run(ctx context.Context, st *task.State, sc task.Script) error {
 	// 7. Update the schema version and write a new alter version.
 	//     Each alter version needs to record the full schema as it stands
 	//     at that version.
+	_ = alterPath
 
 	return nil
 }
+
+// loadStore loads and compiles the package in dir along with its imports.
+func loadStore(ctx context.Context, dir string) (*query.Store, error) {
+	l, err := compile.NewLoader(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	pkgs, err := l.Load(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	return compile.CompilePackages(pkgs...)
+}
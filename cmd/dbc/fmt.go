@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/kardianos/task"
+	"github.com/solidcoredata/dbc/compile"
+	"github.com/solidcoredata/dbc/internal/diff"
+	"github.com/solidcoredata/dbc/internal/elist"
+	"github.com/solidcoredata/dbc/parser"
+)
+
+// format rewrites each source file under the schema directory in the
+// canonical form. Files with syntax errors are reported and left as is.
+type format struct{}
+
+func (format) Run(ctx context.Context, st *task.State, sc task.Script) error {
+	root := st.Filepath(st.Get("schema"))
+	list := fmt.Sprint(st.Get("l")) == "true"
+	diff := fmt.Sprint(st.Get("d")) == "true"
+
+	var el elist.EList
+	err := filepath.WalkDir(root, func(fn string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(fn) != compile.SourceExt {
+			return nil
+		}
+		src, err := os.ReadFile(fn)
+		if err != nil {
+			return err
+		}
+		f, err := parser.Parse(ctx, fn, string(src))
+		if err != nil {
+			return err
+		}
+		if len(f.Errors) > 0 {
+			for _, e := range f.Errors {
+				el.Add(e)
+			}
+			return nil
+		}
+		out := parser.Format(f)
+		if bytes.Equal(src, out) {
+			return nil
+		}
+		switch {
+		case list:
+			fmt.Fprintln(st.Stdout, fn)
+		case diff:
+			return formatDiff(st, fn, src, out)
+		default:
+			return os.WriteFile(fn, out, 0666)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return el.ErrNil()
+}
+
+// formatDiff writes the difference between the source and the formatted
+// source of the file fn as a unified diff.
+func formatDiff(st *task.State, fn string, src, out []byte) error {
+	_, err := st.Stdout.Write(diff.Unified(fn+".orig", fn, src, out))
+	return err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kardianos/task"
+	"github.com/solidcoredata/dbc/compile"
+	"github.com/solidcoredata/dbc/runner/memrunner"
+)
+
+// test runs the tests declared in the test files of the schema package on
+// the memory runner, and reports each test that fails.
+type test struct{}
+
+func (test) Run(ctx context.Context, st *task.State, sc task.Script) error {
+	dir := st.Filepath(st.Get("schema"))
+	verbose := fmt.Sprint(st.Get("v")) == "true"
+	var match *regexp.Regexp
+	if run := fmt.Sprint(st.Get("run")); len(run) > 0 {
+		var err error
+		if match, err = regexp.Compile(run); err != nil {
+			return fmt.Errorf("invalid run pattern: %w", err)
+		}
+	}
+
+	l, err := compile.NewLoader(ctx, dir)
+	if err != nil {
+		return err
+	}
+	pkgs, err := l.Load(ctx, dir)
+	if err != nil {
+		return err
+	}
+	s, err := compile.CompilePackages(pkgs...)
+	if err != nil {
+		return err
+	}
+	pkg := pkgs[len(pkgs)-1]
+	list, err := compile.CompileTests(s, pkg)
+	if err != nil {
+		return err
+	}
+
+	ran, failed := 0, 0
+	for _, t := range list {
+		if match != nil && !match.MatchString(t.Name) {
+			continue
+		}
+		ran++
+		if err := memrunner.RunTest(s, t); err != nil {
+			failed++
+			fmt.Fprintf(st.Stdout, "--- FAIL: %s (%s)\n", t.Name, t.FileName)
+			for _, line := range strings.Split(strings.TrimRight(err.Error(), "\n"), "\n") {
+				fmt.Fprintf(st.Stdout, "\t%s\n", line)
+			}
+			continue
+		}
+		if verbose {
+			fmt.Fprintf(st.Stdout, "--- PASS: %s\n", t.Name)
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(st.Stdout, "FAIL\t%s\t%d of %d tests failed\n", pkg.Path, failed, ran)
+		return errors.New("tests failed")
+	}
+	fmt.Fprintf(st.Stdout, "ok\t%s\t%d tests\n", pkg.Path, ran)
+	return nil
+}
@@ -0,0 +1,343 @@
+// Copyright 2018 solidcoredata authors.
+
+// Package compile verifies parsed source files and compiles them into
+// a single query.Store.
+package compile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/solidcoredata/dbc/internal/elist"
+	"github.com/solidcoredata/dbc/parser"
+	"github.com/solidcoredata/dbc/query"
+)
+
+// Error is a compile error within a declaration.
+type Error struct {
+	FileName string
+	Declare  string
+	Message  string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.FileName, e.Declare, e.Message)
+}
+
+// Package is the set of files in one directory. Each file must declare
+// the same package name. Test files are kept apart from the package files,
+// so they are not compiled into the Store, see CompileTests.
+type Package struct {
+	Path     string // Import path.
+	Name     string
+	Dir      string
+	File     []*parser.File
+	TestFile []*parser.File // Files with names that end in TestSuffix.
+	Import   []*Package     // Packages imported by the files.
+}
+
+type compiler struct {
+	el    elist.EList
+	store *query.Store
+
+	// table is keyed by package path, then by table name.
+	table map[string]map[string]*query.StoreTable
+	// owner is the path of the package that declared each table name.
+	owner map[string]string
+
+	// declared lists each table in declaration order along with where
+	// it was declared.
+	declared   []declared
+	fileImport map[*parser.File]map[string]*fileImport
+
+	pkg     *Package
+	file    *parser.File
+	imports map[string]*fileImport
+
+	fileName string
+	declare  string
+}
+
+type declared struct {
+	pkg   *Package
+	file  *parser.File
+	table *query.StoreTable
+	src   parser.Table
+}
+
+type fileImport struct {
+	parser.Import
+	used bool
+}
+
+func (c *compiler) errorf(f string, v ...interface{}) {
+	c.el.Add(Error{
+		FileName: c.fileName,
+		Declare:  c.declare,
+		Message:  fmt.Sprintf(f, v...),
+	})
+}
+
+// Compile the files of a single package, without imports, into a Store.
+func Compile(files ...*parser.File) (*query.Store, error) {
+	pkg := &Package{File: files}
+	if len(files) > 0 {
+		pkg.Name = files[0].Package.Name
+	}
+	return CompilePackages(pkg)
+}
+
+// CompilePackages compiles the packages into a single Store. Packages must
+// be listed after the packages they import, as returned by Loader.Load.
+// All tables are compiled before queries so queries may reference tables
+// declared in any file. The row rules and ports of tables are compiled
+// once all tables are declared, as rules may refer to any table. A column
+// grant that exceeds the table grant of its port is not an error; it is
+// reported as a warning by query.CheckAuthn. The generated CRUD queries of
+// each table are added after the declared queries, see AddCRUD.
+func CompilePackages(pkgs ...*Package) (*query.Store, error) {
+	c := &compiler{
+		store: &query.Store{},
+		table: make(map[string]map[string]*query.StoreTable),
+		owner: make(map[string]string),
+
+		fileImport: make(map[*parser.File]map[string]*fileImport),
+	}
+	c.each(pkgs, func(f *parser.File) {
+		for _, t := range f.Table {
+			c.declare = t.Name
+			c.addTable(t)
+		}
+	})
+	for _, d := range c.declared {
+		c.pkg = d.pkg
+		c.fileName = d.file.Name
+		c.imports = c.fileImport[d.file]
+		c.declare = d.table.Name
+		c.resolveLinks(d.table)
+	}
+	for _, d := range c.declared {
+		c.pkg = d.pkg
+		c.fileName = d.file.Name
+		c.imports = c.fileImport[d.file]
+		c.declare = d.table.Name
+		c.addRules(d.table, d.src)
+	}
+	c.each(pkgs, func(f *parser.File) {
+		for _, q := range f.Query {
+			c.declare = q.Name
+			c.addQuery(q)
+		}
+		for _, t := range f.Test {
+			c.declare = t.Name
+			c.errorf("tests must be declared in a file that ends in %s", TestSuffix)
+		}
+		c.declare = "import"
+		for _, imp := range f.Import {
+			if fi := c.imports[imp.Name]; fi != nil && !fi.used {
+				c.errorf("%q imported and not used", imp.Path)
+			}
+		}
+	})
+	if err := c.el.ErrNil(); err != nil {
+		return nil, err
+	}
+	AddCRUD(c.store)
+	return c.store, nil
+}
+
+// each calls fn for each file in each package with the file imports set.
+// Import states persist between calls so usage is tracked across passes.
+func (c *compiler) each(pkgs []*Package, fn func(f *parser.File)) {
+	for _, pkg := range pkgs {
+		c.pkg = pkg
+		if c.table[pkg.Path] == nil {
+			c.table[pkg.Path] = make(map[string]*query.StoreTable)
+		}
+		for _, f := range pkg.File {
+			c.fileName = f.Name
+			c.file = f
+			c.imports = c.fileImports(f)
+			fn(f)
+		}
+	}
+}
+
+// fileImports returns the imports of the file, keeping their used state
+// between passes.
+func (c *compiler) fileImports(f *parser.File) map[string]*fileImport {
+	if m, ok := c.fileImport[f]; ok {
+		return m
+	}
+	m := make(map[string]*fileImport, len(f.Import))
+	for _, imp := range f.Import {
+		m[imp.Name] = &fileImport{Import: imp}
+	}
+	c.fileImport[f] = m
+	return m
+}
+
+// lookupTable returns the table with the given name, which may be qualified
+// by the name of an imported package.
+func (c *compiler) lookupTable(name string) *query.StoreTable {
+	i := strings.LastIndex(name, ".")
+	if i < 0 {
+		return c.table[c.pkg.Path][name]
+	}
+	qual, name := name[:i], name[i+1:]
+	imp, ok := c.imports[qual]
+	if !ok {
+		c.errorf("unknown package %q", qual)
+		return nil
+	}
+	imp.used = true
+	tables, ok := c.table[imp.Path]
+	if !ok {
+		c.errorf("package %q not loaded", imp.Path)
+		return nil
+	}
+	return tables[name]
+}
+
+var typeName = map[string]query.DataType{
+	"text":       query.TypeString,
+	"string":     query.TypeString,
+	"binary":     query.TypeBinary,
+	"bool":       query.TypeBoolean,
+	"int":        query.TypeInteger,
+	"int64":      query.TypeInteger,
+	"bigint":     query.TypeInteger,
+	"float":      query.TypeFloat,
+	"float64":    query.TypeFloat,
+	"decimal":    query.TypeDecimal,
+	"rational":   query.TypeRational,
+	"time":       query.TypeTime,
+	"date":       query.TypeDate,
+	"datez":      query.TypeDatez,
+	"timestamp":  query.TypeTimestamp,
+	"timestampz": query.TypeTimestampZ,
+	"uuid":       query.TypeUUID,
+	"json":       query.TypeJSON,
+}
+
+func (c *compiler) addTable(t parser.Table) {
+	if owner, found := c.owner[t.Name]; found {
+		if owner == c.pkg.Path {
+			c.errorf("table declared more than once")
+		} else {
+			c.errorf("table also declared in package %q", owner)
+		}
+		return
+	}
+	st := &query.StoreTable{
+		Name:     t.Name,
+		Alias:    t.Alias,
+		Display:  t.Display,
+		Comment:  t.Comment,
+		Tag:      t.Tag,
+		ReadOnly: t.ReadOnly,
+	}
+	seen := make(map[string]bool, len(t.Column))
+	for _, col := range t.Column {
+		if seen[col.Name] {
+			c.errorf("column %q declared more than once", col.Name)
+			continue
+		}
+		seen[col.Name] = true
+		sc := &query.StoreColumn{
+			Name:         col.Name,
+			Comment:      col.Comment,
+			Tag:          col.Tag,
+			Display:      col.Display,
+			Key:          col.Key,
+			Serial:       col.Serial,
+			Nullable:     col.Nullable,
+			ReadOnly:     col.ReadOnly,
+			LinkToTable:  col.LinkTable,
+			LinkToColumn: col.LinkColumn,
+			UpdateLock:   col.UpdateLock,
+			DeleteLock:   col.DeleteLock,
+		}
+		if (col.UpdateLock || col.DeleteLock) && col.Nullable {
+			c.errorf("lock column %q may not be null", col.Name)
+		}
+		if len(col.LinkTable) == 0 {
+			dt, ok := typeName[col.Type]
+			if !ok {
+				c.errorf("column %q has unknown type %q", col.Name, col.Type)
+			}
+			sc.Type = dt
+		}
+		if len(col.Default) > 0 {
+			v, err := literalValue(col.Default)
+			if err != nil {
+				c.errorf("column %q default: %v", col.Name, err)
+			}
+			sc.Default = v
+		}
+		st.Column = append(st.Column, sc)
+	}
+	c.table[c.pkg.Path][t.Name] = st
+	c.owner[t.Name] = c.pkg.Path
+	c.declared = append(c.declared, declared{pkg: c.pkg, file: c.file, table: st, src: t})
+	c.store.Table = append(c.store.Table, st)
+}
+
+// resolveLinks sets the type of each link column to the type of the column it links to.
+func (c *compiler) resolveLinks(st *query.StoreTable) {
+	for _, col := range st.Column {
+		if len(col.LinkToTable) == 0 {
+			continue
+		}
+		lt := c.lookupTable(col.LinkToTable)
+		if lt == nil {
+			c.errorf("column %q links to unknown table %q", col.Name, col.LinkToTable)
+			continue
+		}
+		col.LinkToTable = lt.Name
+		lc := findColumn(lt, col.LinkToColumn)
+		if lc == nil {
+			c.errorf("column %q links to unknown column %s.%s", col.Name, col.LinkToTable, col.LinkToColumn)
+			continue
+		}
+		col.Type = lc.Type
+		col.Length = lc.Length
+	}
+}
+
+func findColumn(t *query.StoreTable, name string) *query.StoreColumn {
+	for _, col := range t.Column {
+		if col.Name == name {
+			return col
+		}
+	}
+	return nil
+}
+
+// literalValue converts literal source text to a value.
+func literalValue(v string) (interface{}, error) {
+	switch v {
+	case "null":
+		return nil, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if strings.HasPrefix(v, "'") {
+		return strings.ReplaceAll(strings.Trim(v, "'"), "''", "'"), nil
+	}
+	base := 10
+	if h := strings.TrimPrefix(v, "-"); strings.HasPrefix(h, "0x") || strings.HasPrefix(h, "0X") {
+		base = 0
+	}
+	if i, err := strconv.ParseInt(v, base, 64); err == nil {
+		return i, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid literal %s", v)
+	}
+	return f, nil
+}
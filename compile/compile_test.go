@@ -0,0 +1,474 @@
+// Copyright 2018 solidcoredata authors.
+
+package compile
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/solidcoredata/dbc/parser"
+	"github.com/solidcoredata/dbc/query"
+)
+
+const testSource = `package ar
+
+account table {
+	id int64 serial key
+	name text
+	number int64 null default null
+	deleted bool default false
+}
+
+ledger table {
+	id int64 serial key
+	name text
+	balance decimal default 0
+}
+
+account_ledger table {
+	id int64 serial key
+	account *account.id
+	ledger *ledger.id
+}
+
+ckone query {
+	from account a
+	from account_ledger al and (a.id = al.account)
+	from ledger l and (l.id = al.ledger)
+	and
+		a.deleted = false
+		#where1
+		exists (
+			from ledger l2
+			and (
+				l2.id = al.ledger
+				#inner
+			)
+		)
+	select a.name "Account Name", l.name "Ledger", l.balance bal
+}
+`
+
+func compileSource(t *testing.T, src string) *query.Store {
+	t.Helper()
+	f, err := parser.Parse(context.Background(), "ar.scd", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range f.Errors {
+		t.Fatal(e)
+	}
+	st, err := Compile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func TestCompileAnchor(t *testing.T) {
+	st := compileSource(t, testSource)
+	if g, w := len(st.Table), 3; g != w {
+		t.Fatalf("got %d tables, want %d", g, w)
+	}
+	if g, w := st.Table[2].Column[1].Type, query.TypeInteger; g != w {
+		t.Fatalf("link column type got %v, want %v", g, w)
+	}
+	// The declared query, then the generated queries of each table.
+	if g, w := len(st.Query), 1+3*5; g != w {
+		t.Fatalf("got %d queries, want %d", g, w)
+	}
+	if g, w := st.Query[0].Name, "ckone"; g != w {
+		t.Fatalf("first query got %q, want %q", g, w)
+	}
+	s := &st.Query[0].Stmt[0]
+	if g, w := len(s.Return), 3; g != w {
+		t.Fatalf("got %d return columns, want %d", g, w)
+	}
+	if g, w := s.Return[0].QueryName, "Account Name"; g != w {
+		t.Fatalf("return name got %q, want %q", g, w)
+	}
+
+	err := s.AddConditionAt(st, "where1", query.Binary(query.ExpGreater, query.Column("l", "balance"), query.Literal(int64(0))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.AddConditionAt(st, "inner", query.Equal(query.Column("l2", "name"), query.Column("a", "name")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.AddConditionAt(st, "where1", query.Equal(query.Column("l2", "name"), query.Literal("x")))
+	if err == nil {
+		t.Fatal("expected alias l2 to not be visible at where1")
+	}
+	err = s.AddConditionAt(st, "missing", query.Equal(query.Column("a", "id"), query.Literal(int64(1))))
+	if err == nil {
+		t.Fatal("expected missing anchor error")
+	}
+	err = s.AddConditionAt(st, "where1", query.Equal(query.Column("l", "missing"), query.Literal(int64(1))))
+	if err == nil || !strings.Contains(err.Error(), "column ledger.missing not found") {
+		t.Fatalf("got %v, want missing column error", err)
+	}
+	err = s.AddConditionAt(st, "where1", query.Exists(&query.SubQuery{
+		From:  []*query.ResultTableSchema{{Name: "missing", Alias: "m"}},
+		Where: query.Equal(query.Column("m", "id"), query.Column("a", "id")),
+	}))
+	if err == nil || !strings.Contains(err.Error(), `table "missing" not found`) {
+		t.Fatalf("got %v, want missing table error", err)
+	}
+	err = s.AddConditionAt(st, "where1", query.Or(query.Exp{Op: query.ExpAnchor, Name: "nested"}))
+	if err == nil || !strings.Contains(err.Error(), `may not declare anchor "nested"`) {
+		t.Fatalf("got %v, want nested anchor error", err)
+	}
+	s.ExpList.AddCondition(query.Equal(query.Column("a", "id"), query.Parameter("aid")))
+
+	want := "and (a.id = al.account, l.id = al.ledger, a.deleted = false, l.balance > 0, exists (from ledger l2 and (l2.id = al.ledger, l2.name = a.name)), a.id = aid)"
+	if g := s.Condition().String(); g != want {
+		t.Fatalf("condition\ngot:  %s\nwant: %s", g, want)
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	f, err := parser.Parse(context.Background(), "bad.scd", `package ar
+
+book table {
+	id int64 key
+}
+
+q1 query {
+	from book b
+	and b.name = 'x'
+	select c.id
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Errors) > 0 {
+		t.Fatal(f.Errors)
+	}
+	_, err = Compile(f)
+	if err == nil {
+		t.Fatal("expected compile error")
+	}
+	t.Log(err)
+}
+
+func TestCompileDetail(t *testing.T) {
+	st := compileSource(t, testSource+`
+accounts query {
+	from account a
+	select a.id, a.name
+	detail ledgers (
+		from account_ledger al
+		join ledger l and l.id = al.ledger
+		and al.account = a.id
+		select l.name, l.balance
+		order l.name
+	)
+}
+`)
+	s := &st.Query[1].Stmt[0]
+	if g, w := len(s.Interleave), 1; g != w {
+		t.Fatalf("got %d interleaved statements, want %d", g, w)
+	}
+	d := s.Interleave[0]
+	if d.Name != "ledgers" || len(d.Stmt.Return) != 2 || d.Stmt.Return[1].Type != query.TypeDecimal {
+		t.Fatalf("bad detail: %+v", d)
+	}
+	if g, w := d.Stmt.Condition().String(), "and (l.id = al.ledger, al.account = a.id)"; g != w {
+		t.Fatalf("detail condition got %s, want %s", g, w)
+	}
+	// The parent column is read by the detail.
+	parent := false
+	for _, r := range d.Stmt.Read {
+		parent = parent || r.Table == s.From[0] && r.StoreName == "id"
+	}
+	if !parent {
+		t.Fatal("detail does not read the parent column")
+	}
+
+	for _, src := range []string{
+		"q query {\n\tfrom account a\n\tselect a.id\n\tdetail x (from ledger l and l.id = b.id select l.id)\n}\n",
+		"q query {\n\tfrom account a\n\tselect a.id\n\tdetail x (from ledger l select l.id)\n\tdetail x (from ledger l select l.id)\n}\n",
+	} {
+		f, err := parser.Parse(context.Background(), "bad.scd", testSource+src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(f.Errors) > 0 {
+			t.Fatal(f.Errors)
+		}
+		if _, err := Compile(f); err == nil {
+			t.Errorf("expected compile error for:\n%s", src)
+		}
+	}
+}
+
+func TestCompileLock(t *testing.T) {
+	st := compileSource(t, `package ar
+
+account table {
+	id int64 serial key
+	version int64 lock
+	closed bool {lock: delete}
+}
+`)
+	col := st.Table[0].Column
+	if !col[1].UpdateLock || !col[1].DeleteLock {
+		t.Errorf("version column got update lock %t delete lock %t, want both", col[1].UpdateLock, col[1].DeleteLock)
+	}
+	if col[2].UpdateLock || !col[2].DeleteLock {
+		t.Errorf("closed column got update lock %t delete lock %t, want delete only", col[2].UpdateLock, col[2].DeleteLock)
+	}
+
+	f, err := parser.Parse(context.Background(), "ar.scd", "package ar\n\naccount table {\n\tid int64 key\n\tversion int64 null lock\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Errors) > 0 {
+		t.Fatal(f.Errors)
+	}
+	_, err = Compile(f)
+	if err == nil || !strings.Contains(err.Error(), `lock column "version" may not be null`) {
+		t.Fatalf("got %v, want nullable lock error", err)
+	}
+}
+
+func TestCompilePort(t *testing.T) {
+	st := compileSource(t, `package ar
+
+account table {
+	alias: a
+	read: a.deleted = false
+	read: a.org = org
+
+	id int64 serial key
+	org int64
+	name text
+	deleted bool
+
+	port web {
+		role reader: return
+		role admin: full
+		deny delete: exists (from ledger l and l.account = a.id)
+		column name {
+			role reader: read
+			deny update: a.org <> org
+		}
+	}
+}
+
+ledger table {
+	id int64 serial key
+	account *account.id
+}
+`)
+	tb := st.Table[0]
+	if g, w := len(tb.Read), 2; g != w {
+		t.Fatalf("got %d read rules, want %d", g, w)
+	}
+	if g, w := tb.Read[1].Q.String(), "a.org = org"; g != w {
+		t.Errorf("read rule got %s, want %s", g, w)
+	}
+	if in := tb.Read[1].Input; len(in) != 1 || in[0].Name != "org" {
+		t.Errorf("read rule got inputs %+v, want org", in)
+	}
+	port, ok := tb.Port["web"]
+	if !ok {
+		t.Fatal("port web not compiled")
+	}
+	if g, w := port.RoleAuthn["reader"], query.AllowReturn; g != w {
+		t.Errorf("reader got %v, want %v", g, w)
+	}
+	if g, w := port.RoleAuthn["admin"], query.AllowFull; g != w {
+		t.Errorf("admin got %v, want %v", g, w)
+	}
+	if port.DenyDelete.Q.Op != query.ExpExists || !port.DenyRead.Q.IsZero() {
+		t.Errorf("got deny read %s, deny delete %s", port.DenyRead.Q, port.DenyDelete.Q)
+	}
+	if g, w := len(port.Column), len(tb.Column); g != w {
+		t.Fatalf("got %d column ports, want %d", g, w)
+	}
+	name := port.Column[2]
+	if name.RoleAuthn["reader"] != query.AllowRead || name.DenyUpdate.Q.IsZero() || len(name.DenyUpdate.Input) != 1 {
+		t.Errorf("bad name column port %+v", name)
+	}
+	if port.Column[0].RoleAuthn != nil {
+		t.Errorf("id column port got grants %v", port.Column[0].RoleAuthn)
+	}
+
+	for _, item := range []struct {
+		body string
+		want string
+	}{
+		{"read: b.id = 1", `unknown alias "b"`},
+		{"port web {\n\t\trole reader: read\n\t}\n\tport web {\n\t\trole reader: read\n\t}", `port "web" declared more than once`},
+		{"port web {\n\t\trole reader: read write\n\t}", `role "reader" has unknown access "write"`},
+		{"port web {\n\t\trole reader: read\n\t\trole reader: full\n\t}", `role "reader" declared more than once`},
+		{"port web {\n\t\tdeny select: true\n\t}", `unknown deny "select"`},
+		{"port web {\n\t\tdeny read: true\n\t\tdeny read: false\n\t}", `port "web" deny read declared more than once`},
+		{"port web {\n\t\tcolumn missing {\n\t\t\trole reader: read\n\t\t}\n\t}", `port "web" column "missing" not found`},
+		{"port web {\n\t\tcolumn id {\n\t\t\tdeny delete: true\n\t\t}\n\t}", `column "id" has unknown deny "delete"`},
+	} {
+		f, err := parser.Parse(context.Background(), "ar.scd", "package ar\n\naccount table {\n\tid int64 key\n\t"+item.body+"\n}\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(f.Errors) > 0 {
+			t.Fatal(item.body, f.Errors)
+		}
+		_, err = Compile(f)
+		if err == nil || !strings.Contains(err.Error(), item.want) {
+			t.Errorf("%s: got %v, want %s", item.body, err, item.want)
+		}
+	}
+
+	// A column grant beyond the table grant compiles, and is reported as a
+	// warning, as it is never granted.
+	st = compileSource(t, "package ar\n\naccount table {\n\tid int64 key\n\tport web {\n\t\trole reader: read\n\t\tcolumn id {\n\t\t\trole reader: return\n\t\t}\n\t}\n}\n")
+	warn := query.CheckAuthn(st)
+	if len(warn) != 1 || warn[0].Column != "id" || warn[0].Role != "reader" {
+		t.Errorf("got warnings %v, want the id column grant", warn)
+	}
+}
+
+func TestCompileCRUD(t *testing.T) {
+	st := compileSource(t, `package ar
+
+account table {
+	alias: a
+	id int64 serial key
+	name text
+	version int64 lock
+	total int64 readonly
+}
+
+log table {
+	message text
+}
+
+summary table {
+	readonly: true
+	day text key
+	total int64
+}
+`)
+	var names []string
+	stmt := make(map[string]*query.Stmt)
+	for i := range st.Query {
+		names = append(names, st.Query[i].Name)
+		stmt[st.Query[i].Name] = &st.Query[i].Stmt[0]
+	}
+	want := "account.get account.list account.insert account.update account.delete log.list log.insert summary.get summary.list"
+	if g := strings.Join(names, " "); g != want {
+		t.Fatalf("got queries %s, want %s", g, want)
+	}
+	columns := func(list []*query.ColumnSchema) string {
+		var s []string
+		for _, col := range list {
+			s = append(s, col.StoreName)
+		}
+		return strings.Join(s, " ")
+	}
+	get := stmt["account.get"]
+	if g, w := get.Condition().String(), "and (a.id = id)"; g != w {
+		t.Errorf("get condition got %q, want %q", g, w)
+	}
+	if g, w := columns(get.Return), "id name version total"; g != w {
+		t.Errorf("get returns %q, want %q", g, w)
+	}
+	if g, w := columns(stmt["account.insert"].Insert), "name version"; g != w {
+		t.Errorf("insert columns %q, want %q", g, w)
+	}
+	update := stmt["account.update"]
+	if g, w := columns(update.Update), "name version"; g != w {
+		t.Errorf("update columns %q, want %q", g, w)
+	}
+	if g, w := update.Set[1].String(), "a.version + 1"; g != w {
+		t.Errorf("update lock value got %q, want %q", g, w)
+	}
+	if g, w := columns(stmt["summary.list"].Return), "day total"; g != w {
+		t.Errorf("read only table list returns %q, want %q", g, w)
+	}
+	list := stmt["account.list"]
+	if len(list.Order) != 1 || !list.Limit.IsZero() || !list.Offset.IsZero() {
+		t.Errorf("list not ordered by key without a limit: %+v", list)
+	}
+}
+
+func TestAddCRUD(t *testing.T) {
+	editor := map[string]query.Authn{"editor": query.AllowRead | query.AllowUpdate}
+	s := &query.Store{
+		Table: []*query.StoreTable{{
+			Name: "book",
+			Column: []*query.StoreColumn{
+				{Name: "id", Key: true, Type: query.TypeInteger},
+				{Name: "name", Type: query.TypeString},
+				{Name: "price", Type: query.TypeDecimal},
+			},
+			Port: map[string]query.StoreTablePort{
+				"web": {
+					RoleAuthn: map[string]query.Authn{"reader": query.AllowReturn, "editor": query.AllowReturn | query.AllowUpdate},
+					Column:    []query.StoreColumnPort{{}, {RoleAuthn: editor}, {RoleAuthn: map[string]query.Authn{"editor": query.AllowReturn}}},
+				},
+			},
+		}},
+		Query: []query.Query{{Name: "book.get"}},
+	}
+	AddCRUD(s)
+	var names []string
+	for _, q := range s.Query {
+		names = append(names, q.Name)
+	}
+	if g, w := strings.Join(names, " "), "book.get book.list book.update"; g != w {
+		t.Fatalf("got queries %s, want %s", g, w)
+	}
+	if s.Query[0].Stmt != nil {
+		t.Error("declared query replaced")
+	}
+	update := s.Query[2].Stmt[0].Update
+	if len(update) != 1 || update[0].StoreName != "name" {
+		t.Errorf("update columns got %v, want only name", update)
+	}
+
+	// Grants are evaluated per port. In web, a caller with roles a and b
+	// may insert a note with a body, while only api grants the secret,
+	// which may not be inserted there.
+	s = &query.Store{
+		Table: []*query.StoreTable{{
+			Name: "note",
+			Column: []*query.StoreColumn{
+				{Name: "id", Key: true, Serial: true, Type: query.TypeInteger},
+				{Name: "body", Type: query.TypeString},
+				{Name: "secret", Type: query.TypeString},
+			},
+			Port: map[string]query.StoreTablePort{
+				"web": {
+					RoleAuthn: map[string]query.Authn{"a": query.AllowReturn | query.AllowInsert, "b": query.AllowReturn},
+					Column: []query.StoreColumnPort{
+						{},
+						{RoleAuthn: map[string]query.Authn{"b": query.AllowInsert}},
+						{RoleAuthn: map[string]query.Authn{"a": query.AllowReturn}},
+					},
+				},
+				"api": {
+					RoleAuthn: map[string]query.Authn{"c": query.AllowReturn},
+					Column:    []query.StoreColumnPort{{}, {}, {RoleAuthn: map[string]query.Authn{"c": query.AllowFull}}},
+				},
+			},
+		}},
+	}
+	AddCRUD(s)
+	names = nil
+	for _, q := range s.Query {
+		names = append(names, q.Name)
+	}
+	if g, w := strings.Join(names, " "), "note.get note.list note.insert"; g != w {
+		t.Fatalf("got queries %s, want %s", g, w)
+	}
+	insert := s.Query[2].Stmt[0].Insert
+	if len(insert) != 1 || insert[0].StoreName != "body" {
+		t.Errorf("insert columns got %v, want only body", insert)
+	}
+}
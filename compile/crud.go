@@ -0,0 +1,180 @@
+// Copyright 2018 solidcoredata authors.
+
+package compile
+
+import "github.com/solidcoredata/dbc/query"
+
+// CRUD operations generated for each table. A generated query is named by
+// the table and the operation, such as "book.get", so it never collides
+// with a declared query name.
+const (
+	CRUDGet    = "get"    // Returns the row with the key given by a parameter per key column.
+	CRUDList   = "list"   // Returns the rows ordered by key. The caller may page them, see runner.Page.
+	CRUDInsert = "insert" // Inserts a row with a parameter per column, returning the row.
+	CRUDUpdate = "update" // Updates the row with the key, with a parameter per column, returning the row.
+	CRUDDelete = "delete" // Deletes the row with the key.
+)
+
+// CRUDName returns the name of the generated query of the table operation.
+func CRUDName(table, op string) string {
+	return table + "." + op
+}
+
+// AddCRUD adds the generated queries of each table of the store. A query
+// that is already in the store is kept.
+//
+// Serial and ReadOnly columns are never inserted, and key, serial, and
+// ReadOnly columns are never updated. An update sets an integer UpdateLock
+// column to its value plus one, and the runner compares each lock column
+// with its lock parameter, see query.Lock. A table without key columns has
+// no get, update, or delete query, and a ReadOnly table has no insert,
+// update, or delete query.
+//
+// If the table declares ports, a query is only added if some port grants
+// the operation to a caller with the roles of that port, and insert and
+// update only set the columns such a port grants the operation on.
+// Generated queries are checked against the caller port and roles when
+// run, like any other query.
+func AddCRUD(s *query.Store) {
+	have := make(map[string]bool, len(s.Query))
+	for _, q := range s.Query {
+		have[q.Name] = true
+	}
+	for _, t := range s.Table {
+		for _, q := range crudQueries(t) {
+			if have[q.Name] {
+				continue
+			}
+			have[q.Name] = true
+			s.Query = append(s.Query, q)
+		}
+	}
+}
+
+// crudQueries returns the generated queries of the table.
+func crudQueries(t *query.StoreTable) []query.Query {
+	alias := t.Alias
+	if len(alias) == 0 {
+		alias = t.Name
+	}
+	newStmt := func() (query.Stmt, *query.ResultTableSchema) {
+		rt := &query.ResultTableSchema{Name: t.Name, Alias: alias, IsArity: true}
+		top := &query.Anchor{From: []*query.ResultTableSchema{rt}}
+		return query.Stmt{ExpList: top, Anchor: []*query.Anchor{top}, From: []*query.ResultTableSchema{rt}}, rt
+	}
+	returnAll := func(st *query.Stmt, rt *query.ResultTableSchema) {
+		for _, col := range t.Column {
+			st.Return = append(st.Return, columnSchema(rt, col))
+			st.Select = append(st.Select, query.Column(alias, col.Name))
+		}
+	}
+	var key []query.Exp
+	for _, col := range t.Column {
+		if col.Key {
+			key = append(key, query.Equal(query.Column(alias, col.Name), query.Parameter(col.Name)))
+		}
+	}
+	var list []query.Query
+	add := func(op string, st query.Stmt) {
+		list = append(list, query.Query{Name: CRUDName(t.Name, op), Stmt: []query.Stmt{st}})
+	}
+
+	if len(key) > 0 && granted(t, -1, query.AllowReturn) {
+		st, rt := newStmt()
+		st.Where = query.And(key...)
+		returnAll(&st, rt)
+		add(CRUDGet, st)
+	}
+	if granted(t, -1, query.AllowReturn) {
+		st, rt := newStmt()
+		returnAll(&st, rt)
+		for _, col := range t.Column {
+			if col.Key {
+				st.Order = append(st.Order, query.Order{Exp: query.Column(alias, col.Name)})
+			}
+		}
+		add(CRUDList, st)
+	}
+	if !t.ReadOnly && granted(t, -1, query.AllowInsert) {
+		st, rt := newStmt()
+		st.From, st.Anchor, st.ExpList = nil, nil, nil
+		for i, col := range t.Column {
+			if col.Serial || col.ReadOnly || !granted(t, i, query.AllowInsert) {
+				continue
+			}
+			st.Insert = append(st.Insert, columnSchema(rt, col))
+			st.Set = append(st.Set, query.Parameter(col.Name))
+		}
+		returnAll(&st, rt)
+		if len(st.Insert) > 0 {
+			add(CRUDInsert, st)
+		}
+	}
+	if len(key) > 0 && !t.ReadOnly && granted(t, -1, query.AllowUpdate) {
+		st, rt := newStmt()
+		st.Where = query.And(key...)
+		for i, col := range t.Column {
+			if col.Key || col.Serial || col.ReadOnly || !granted(t, i, query.AllowUpdate) {
+				continue
+			}
+			value := query.Parameter(col.Name)
+			if col.UpdateLock && col.Type == query.TypeInteger {
+				value = query.Binary(query.ExpAdd, query.Column(alias, col.Name), query.Literal(int64(1)))
+			}
+			st.Update = append(st.Update, columnSchema(rt, col))
+			st.Set = append(st.Set, value)
+		}
+		returnAll(&st, rt)
+		if len(st.Update) > 0 {
+			add(CRUDUpdate, st)
+		}
+	}
+	if len(key) > 0 && !t.ReadOnly && granted(t, -1, query.AllowDelete) {
+		st, rt := newStmt()
+		st.Where = query.And(key...)
+		st.Delete = []*query.ResultTableSchema{rt}
+		add(CRUDDelete, st)
+	}
+	return list
+}
+
+// granted reports if some port of the table grants the access to the
+// table, or to the column at index column if not negative, to a caller of
+// the port with each role the port names. Each port is evaluated on its
+// own, as a caller has a single port. A table without ports grants all
+// access.
+func granted(t *query.StoreTable, column int, need query.Authn) bool {
+	if len(t.Port) == 0 {
+		return true
+	}
+	for _, p := range t.Port {
+		role := portRoles(p)
+		allow := query.RoleAuthn(p.RoleAuthn, role).Closure()
+		if column >= 0 && column < len(p.Column) && p.Column[column].RoleAuthn != nil {
+			allow = query.ColumnAuthn(allow, query.RoleAuthn(p.Column[column].RoleAuthn, role).Closure())
+		}
+		if allow.Satisfies(need) {
+			return true
+		}
+	}
+	return false
+}
+
+// portRoles returns each role named by the port or its column ports.
+func portRoles(p query.StoreTablePort) []string {
+	seen := make(map[string]bool)
+	var list []string
+	add := func(grant map[string]query.Authn) {
+		for role := range grant {
+			if !seen[role] {
+				seen[role] = true
+				list = append(list, role)
+			}
+		}
+	}
+	add(p.RoleAuthn)
+	for _, c := range p.Column {
+		add(c.RoleAuthn)
+	}
+	return list
+}
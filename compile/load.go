@@ -0,0 +1,287 @@
+// Copyright 2018 solidcoredata authors.
+
+package compile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/solidcoredata/dbc/internal/elist"
+	"github.com/solidcoredata/dbc/parser"
+)
+
+// SourceExt is the file extension of source files.
+const SourceExt = ".scd"
+
+// Loader reads packages from within a module. A module is a directory
+// tree rooted at a module file which declares the import path of the root.
+//
+// Packages of required modules are read from the vendor directory if it
+// exists, otherwise from the module cache. Their content must match the
+// checksum file.
+type Loader struct {
+	Root    string // Directory containing the module file.
+	Module  *parser.Module
+	Cache   string           // Module cache directory.
+	Require []*ModuleVersion // Selected version of each required module.
+
+	vendor bool
+
+	pkg     map[string]*Package
+	loading map[string]bool
+	order   []*Package
+	el      elist.EList
+}
+
+// NewLoader finds the module file in dir or the closest parent directory.
+func NewLoader(ctx context.Context, dir string) (*Loader, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for root := dir; ; {
+		fn := filepath.Join(root, parser.ModuleFileName)
+		src, err := os.ReadFile(fn)
+		if err == nil {
+			m, err := parser.ParseModule(ctx, fn, string(src))
+			if err != nil {
+				return nil, err
+			}
+			if len(m.Errors) > 0 {
+				var el elist.EList
+				for _, e := range m.Errors {
+					el.Add(e)
+				}
+				return nil, &el
+			}
+			l := &Loader{
+				Root:    root,
+				Module:  m,
+				pkg:     make(map[string]*Package),
+				loading: make(map[string]bool),
+			}
+			if fi, err := os.Stat(filepath.Join(root, VendorDir)); err == nil && fi.IsDir() {
+				l.vendor = true
+			} else if l.Cache, err = CacheDir(); err != nil {
+				return nil, err
+			}
+			if err := l.resolve(ctx); err != nil {
+				return nil, err
+			}
+			return l, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		parent := filepath.Dir(root)
+		if parent == root {
+			return nil, fmt.Errorf("compile: no %s found in %s or any parent directory", parser.ModuleFileName, dir)
+		}
+		root = parent
+	}
+}
+
+// Load the package in dir along with every package it imports.
+// The returned list is ordered so each package is after the packages it
+// imports; the package in dir is last.
+func (l *Loader) Load(ctx context.Context, dir string) ([]*Package, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(l.Root, dir)
+	if err != nil {
+		return nil, err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("compile: %s is not within module %s", dir, l.Root)
+	}
+	importPath := path.Join(l.Module.Path, filepath.ToSlash(rel))
+	pkg := l.load(ctx, importPath, nil, nil)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := l.el.ErrNil(); err != nil {
+		return nil, err
+	}
+	var list []*Package
+	seen := make(map[*Package]bool)
+	var add func(p *Package)
+	add = func(p *Package) {
+		if seen[p] {
+			return
+		}
+		seen[p] = true
+		for _, imp := range p.Import {
+			add(imp)
+		}
+		list = append(list, p)
+	}
+	add(pkg)
+	return list, nil
+}
+
+// dir returns the directory of the import path within the main module or
+// the required module with the longest matching path.
+func (l *Loader) dir(importPath string) (string, bool) {
+	if dir, ok := subDir(importPath, l.Module.Path, l.Root); ok {
+		return dir, true
+	}
+	var found *ModuleVersion
+	for _, mv := range l.Require {
+		if _, ok := subDir(importPath, mv.Path, mv.Dir); ok && (found == nil || len(mv.Path) > len(found.Path)) {
+			found = mv
+		}
+	}
+	if found == nil {
+		return "", false
+	}
+	return subDir(importPath, found.Path, found.Dir)
+}
+
+// subDir returns the directory of importPath if it is within the module.
+func subDir(importPath, modulePath, moduleDir string) (string, bool) {
+	if importPath == modulePath {
+		return moduleDir, true
+	}
+	prefix := modulePath + "/"
+	if !strings.HasPrefix(importPath, prefix) {
+		return "", false
+	}
+	return filepath.Join(moduleDir, filepath.FromSlash(importPath[len(prefix):])), true
+}
+
+// importSite is the location of an import declaration.
+type importSite struct {
+	FileName string
+	Start    parser.Position
+}
+
+// load the package at importPath. The stack is the import chain used to
+// report cycles, from is the import that requested the package.
+// A package that fails to load is recorded as nil so errors are only
+// reported once.
+func (l *Loader) load(ctx context.Context, importPath string, stack []string, from *importSite) *Package {
+	if pkg, ok := l.pkg[importPath]; ok {
+		return pkg
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	stack = append(stack, importPath)
+	if l.loading[importPath] {
+		i := 0
+		for stack[i] != importPath {
+			i++
+		}
+		l.importErr(from, "import cycle not allowed: %s", strings.Join(stack[i:], " -> "))
+		return nil
+	}
+	dir, ok := l.dir(importPath)
+	if !ok {
+		l.importErr(from, "package %q is not in module %q or a required module", importPath, l.Module.Path)
+		return nil
+	}
+	l.loading[importPath] = true
+	defer delete(l.loading, importPath)
+
+	pkg := &Package{
+		Path: importPath,
+		Dir:  dir,
+	}
+	if !l.readDir(ctx, pkg, from) {
+		l.pkg[importPath] = nil
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for _, f := range pkg.File {
+		for _, imp := range f.Import {
+			if seen[imp.Path] {
+				continue
+			}
+			seen[imp.Path] = true
+			site := &importSite{FileName: f.Name, Start: imp.Start}
+			if imp.Path == importPath {
+				l.importErr(site, "package may not import itself")
+				continue
+			}
+			if ip := l.load(ctx, imp.Path, stack, site); ip != nil {
+				pkg.Import = append(pkg.Import, ip)
+			}
+		}
+	}
+	l.pkg[importPath] = pkg
+	return pkg
+}
+
+func (l *Loader) importErr(from *importSite, format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	if from == nil {
+		l.el.Add(fmt.Errorf("compile: %s", msg))
+		return
+	}
+	l.el.Add(parser.ParseError{FileName: from.FileName, Start: from.Start, End: from.Start, Message: msg})
+}
+
+// readDir parses the source files of the package and verifies each declares
+// the same package name.
+func (l *Loader) readDir(ctx context.Context, pkg *Package, from *importSite) bool {
+	list, err := os.ReadDir(pkg.Dir)
+	if err != nil {
+		l.importErr(from, "%v", err)
+		return false
+	}
+	var names []string
+	for _, fi := range list {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != SourceExt {
+			continue
+		}
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		l.importErr(from, "no %s files in %s", SourceExt, pkg.Dir)
+		return false
+	}
+	ok := true
+	for _, name := range names {
+		fn := filepath.Join(pkg.Dir, name)
+		src, err := os.ReadFile(fn)
+		if err != nil {
+			l.el.Add(err)
+			ok = false
+			continue
+		}
+		f, err := parser.Parse(ctx, fn, string(src))
+		if err != nil {
+			l.el.Add(err)
+			return false
+		}
+		for _, e := range f.Errors {
+			l.el.Add(e)
+			ok = false
+		}
+		switch {
+		case len(f.Package.Name) == 0:
+			l.el.Add(parser.ParseError{FileName: fn, Start: f.Package.Start, End: f.Package.Start, Message: "missing package declaration"})
+			ok = false
+		case len(pkg.Name) == 0:
+			pkg.Name = f.Package.Name
+		case pkg.Name != f.Package.Name:
+			l.el.Add(parser.ParseError{FileName: fn, Start: f.Package.Start, End: f.Package.Start, Message: fmt.Sprintf("found package %s, expected %s", f.Package.Name, pkg.Name)})
+			ok = false
+		}
+		if strings.HasSuffix(name, TestSuffix) {
+			pkg.TestFile = append(pkg.TestFile, f)
+			continue
+		}
+		pkg.File = append(pkg.File, f)
+	}
+	return ok
+}
@@ -0,0 +1,240 @@
+// Copyright 2018 solidcoredata authors.
+
+package compile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTree writes each file, keyed by slash separated path, under a
+// temporary directory and returns the directory.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, src := range files {
+		fn := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(src), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func loadTree(t *testing.T, files map[string]string, dir string) ([]*Package, error) {
+	t.Helper()
+	ctx := context.Background()
+	root := writeTree(t, files)
+	l, err := NewLoader(ctx, filepath.Join(root, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l.Load(ctx, filepath.Join(root, dir))
+}
+
+func TestLoadImport(t *testing.T) {
+	pkgs, err := loadTree(t, map[string]string{
+		"scd.mod": "module coredata.biz/app1\n",
+		"role/user.scd": `package role
+
+user table {
+	id int64 serial key
+	name text
+}
+`,
+		"ar/accounts.scd": `package ar
+
+import (
+	coredata.biz/app1/role
+)
+
+account table {
+	id int64 serial key
+	owner *role.user.id
+}
+
+owned query {
+	from account a
+	from role.user u and (u.id = a.owner)
+	select a.id, u.name
+}
+`,
+	}, "ar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := len(pkgs), 2; g != w {
+		t.Fatalf("got %d packages, want %d", g, w)
+	}
+	if g, w := pkgs[0].Path, "coredata.biz/app1/role"; g != w {
+		t.Fatalf("first package got %q, want %q", g, w)
+	}
+	st, err := CompilePackages(pkgs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := st.Table[1].Column[1]
+	if owner.LinkToTable != "user" {
+		t.Fatalf("link table got %q, want user", owner.LinkToTable)
+	}
+	if g, w := st.Query[0].Stmt[0].From[1].Name, "user"; g != w {
+		t.Fatalf("from table got %q, want %q", g, w)
+	}
+}
+
+func TestLoadError(t *testing.T) {
+	list := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			name: "cycle",
+			files: map[string]string{
+				"scd.mod":  "module m\n",
+				"a/a.scd":  "package a\n\nimport m/b\n",
+				"b/b.scd":  "package b\n\nimport m/c\n",
+				"c/c.scd":  "package c\n\nimport m/a\n",
+				"c/c2.scd": "package c\n",
+			},
+			want: "import cycle not allowed: m/a -> m/b -> m/c -> m/a",
+		},
+		{
+			name: "package-name",
+			files: map[string]string{
+				"scd.mod":  "module m\n",
+				"a/a.scd":  "package a\n",
+				"a/a2.scd": "package b\n",
+			},
+			want: "found package b, expected a",
+		},
+		{
+			name: "outside-module",
+			files: map[string]string{
+				"scd.mod": "module m\n",
+				"a/a.scd": "package a\n\nimport other/b\n",
+			},
+			want: `package "other/b" is not in module "m" or a required module`,
+		},
+	}
+	for _, item := range list {
+		t.Run(item.name, func(t *testing.T) {
+			_, err := loadTree(t, item.files, "a")
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), item.want) {
+				t.Fatalf("got %q, want %q", err, item.want)
+			}
+		})
+	}
+}
+
+func TestCompileUnusedImport(t *testing.T) {
+	pkgs, err := loadTree(t, map[string]string{
+		"scd.mod":    "module m\n",
+		"role/r.scd": "package role\n\nuser table {\n\tid int64 key\n}\n",
+		"a/a.scd":    "package a\n\nimport m/role\n\nbook table {\n\tid int64 key\n}\n",
+	}, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CompilePackages(pkgs...)
+	if err == nil || !strings.Contains(err.Error(), `"m/role" imported and not used`) {
+		t.Fatalf("expected unused import error, got %v", err)
+	}
+}
+
+func TestLoadTests(t *testing.T) {
+	pkgs, err := loadTree(t, map[string]string{
+		"scd.mod": "module coredata.biz/app1\n",
+		"lib/book.scd": `package lib
+
+book table {
+	id int64 serial key
+	name text
+	pages int64
+}
+
+long query {
+	from book b
+	and b.pages > size
+	select b.name
+}
+`,
+		"lib/book_test.scd": `package lib
+
+long_books test {
+	query long
+	param size = 100
+	input book (name, pages) {
+		'Emma', 474
+	}
+	output (name) {
+		'Emma'
+	}
+}
+
+bad test {
+	query missing
+	param size = size
+	input book (name, title) {
+		'Emma', 1
+		'Lady Susan'
+	}
+}
+
+long_books test {
+	query long
+}
+`,
+	}, "lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := pkgs[0]
+	if len(pkg.File) != 1 || len(pkg.TestFile) != 1 {
+		t.Fatalf("got %d files and %d test files, want 1 of each", len(pkg.File), len(pkg.TestFile))
+	}
+	s, err := CompilePackages(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CompileTests(s, pkg)
+	if err == nil {
+		t.Fatal("expected test errors")
+	}
+	for _, want := range []string{
+		`bad: unknown query "missing"`,
+		"bad: test value must be a literal",
+		`bad: input table "book" has no column "title"`,
+		"bad: row 2 has 1 values, want 2",
+		"long_books: test declared more than once",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing error %q in:\n%v", want, err)
+		}
+	}
+
+	pkg.TestFile[0].Test = pkg.TestFile[0].Test[:1]
+	list, err := CompileTests(s, pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := list[0]
+	if x.Name != "long_books" || x.Param[0].Value != int64(100) || x.Input[0].Row[0][1] != int64(474) || x.Output[0].Row[0][0] != "Emma" {
+		t.Fatalf("bad test: %+v", x)
+	}
+
+	// Tests may only be declared in test files.
+	pkg.File = append(pkg.File, pkg.TestFile[0])
+	if _, err := CompilePackages(pkg); err == nil || !strings.Contains(err.Error(), "tests must be declared in a file that ends in _test.scd") {
+		t.Fatalf("got %v, want test file error", err)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2018 solidcoredata authors.
+
+package compile
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/solidcoredata/dbc/internal/elist"
+	"github.com/solidcoredata/dbc/parser"
+)
+
+const (
+	// SumFileName is the name of the checksum file next to the module file.
+	// Each line is "<module path> <version> h1:<hash>".
+	SumFileName = "scd.sum"
+
+	// VendorDir is the directory within the main module that may hold
+	// required modules at "vendor/<module path>". When present it is used
+	// instead of the module cache.
+	VendorDir = "vendor"
+)
+
+// CacheDir returns the module cache directory. Modules are stored at
+// "<cache>/<module path>@<version>". The SCDCACHE environment variable
+// overrides the default location.
+func CacheDir() (string, error) {
+	if dir := os.Getenv("SCDCACHE"); len(dir) > 0 {
+		return dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "scd", "mod"), nil
+}
+
+// ModuleVersion is a resolved module dependency.
+type ModuleVersion struct {
+	Path    string
+	Version string
+	Dir     string
+}
+
+// resolve selects the version of each required module, reading the
+// requirements of required modules from the cache or vendor directory.
+// The highest version required by any module is selected.
+func (l *Loader) resolve(ctx context.Context) error {
+	var el elist.EList
+	type key struct{ path, version string }
+	visited := make(map[key]bool)
+	selected := make(map[string]*ModuleVersion)
+	queue := append([]parser.Require(nil), l.Module.Require...)
+	for len(queue) > 0 && ctx.Err() == nil {
+		r := queue[0]
+		queue = queue[1:]
+		k := key{r.Path, r.Version}
+		if visited[k] || r.Path == l.Module.Path {
+			continue
+		}
+		visited[k] = true
+
+		dir := l.moduleDir(r.Path, r.Version)
+		if mv, ok := selected[r.Path]; !ok || parser.CompareVersion(r.Version, mv.Version) > 0 {
+			selected[r.Path] = &ModuleVersion{Path: r.Path, Version: r.Version, Dir: dir}
+		}
+		fn := filepath.Join(dir, parser.ModuleFileName)
+		src, err := os.ReadFile(fn)
+		if err != nil {
+			if os.IsNotExist(err) {
+				err = fmt.Errorf("compile: module %s %s not found in %s", r.Path, r.Version, dir)
+			}
+			el.Add(err)
+			continue
+		}
+		m, err := parser.ParseModule(ctx, fn, string(src))
+		if err != nil {
+			return err
+		}
+		for _, e := range m.Errors {
+			el.Add(e)
+		}
+		if m.Path != r.Path {
+			el.Add(fmt.Errorf("compile: %s declares module %q, expected %q", fn, m.Path, r.Path))
+			continue
+		}
+		queue = append(queue, m.Require...)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := el.ErrNil(); err != nil {
+		return err
+	}
+
+	l.Require = l.Require[:0]
+	for _, mv := range selected {
+		l.Require = append(l.Require, mv)
+	}
+	sort.Slice(l.Require, func(i, j int) bool {
+		return l.Require[i].Path < l.Require[j].Path
+	})
+	return l.verify()
+}
+
+// moduleDir returns the directory of the required module version.
+func (l *Loader) moduleDir(path, version string) string {
+	if l.vendor {
+		return filepath.Join(l.Root, VendorDir, filepath.FromSlash(path))
+	}
+	return filepath.Join(l.Cache, filepath.FromSlash(path)+"@"+version)
+}
+
+// verify the content of each selected module against the checksum file.
+func (l *Loader) verify() error {
+	if len(l.Require) == 0 {
+		return nil
+	}
+	fn := filepath.Join(l.Root, SumFileName)
+	sums, err := readSum(fn)
+	if err != nil {
+		return err
+	}
+	var el elist.EList
+	for _, mv := range l.Require {
+		want, ok := sums[mv.Path+" "+mv.Version]
+		if !ok {
+			el.Add(fmt.Errorf("compile: %s: missing checksum for %s %s", fn, mv.Path, mv.Version))
+			continue
+		}
+		got, err := HashDir(mv.Dir)
+		if err != nil {
+			el.Add(err)
+			continue
+		}
+		if got != want {
+			el.Add(fmt.Errorf("compile: checksum mismatch for %s %s\n\t%s: %s\n\t%s: %s", mv.Path, mv.Version, mv.Dir, got, SumFileName, want))
+		}
+	}
+	return el.ErrNil()
+}
+
+// readSum reads a checksum file into a map keyed by "<path> <version>".
+func readSum(fn string) (map[string]string, error) {
+	sums := make(map[string]string)
+	src, err := os.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return sums, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	for line := 1; sc.Scan(); line++ {
+		f := strings.Fields(sc.Text())
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) != 3 {
+			return nil, fmt.Errorf("compile: %s:%d: malformed checksum line", fn, line)
+		}
+		sums[f[0]+" "+f[1]] = f[2]
+	}
+	return sums, sc.Err()
+}
+
+// HashDir returns the checksum of every file within dir. The checksum is
+// the SHA-256 of a summary of lines "<file sha256 hex>  <slash path>\n",
+// sorted by path, and is written as "h1:<base64>".
+func HashDir(dir string) (string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(fn string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			rel, err := filepath.Rel(dir, fn)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+	summary := sha256.New()
+	for _, name := range files {
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return "", err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(summary, "%x  %s\n", h.Sum(nil), name)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(summary.Sum(nil)), nil
+}
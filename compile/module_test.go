@@ -0,0 +1,132 @@
+// Copyright 2018 solidcoredata authors.
+
+package compile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// moduleTree writes a main module in "app" requiring modules held in
+// "cache", and returns the root directory.
+func moduleTree(t *testing.T) string {
+	t.Helper()
+	root := writeTree(t, map[string]string{
+		"app/scd.mod": "module app\n\nrequire (\n\ts/v1/time v1.0.1\n\ts/v1/zone v1.0.0\n)\n",
+		"app/ar/ar.scd": `package ar
+
+import s/v1/time
+
+event table {
+	id int64 key
+	period *time.period.id
+}
+`,
+		"cache/s/v1/time@v1.0.1/scd.mod":  "module s/v1/time\n",
+		"cache/s/v1/time@v1.0.1/time.scd": "package time\n\nperiod table {\n\tid int64 key\n}\n",
+		"cache/s/v1/time@v1.2.0/scd.mod":  "module s/v1/time\n",
+		"cache/s/v1/time@v1.2.0/time.scd": "package time\n\nperiod table {\n\tid int64 key\n\tname text\n}\n",
+		"cache/s/v1/zone@v1.0.0/scd.mod":  "module s/v1/zone\n\nrequire s/v1/time v1.2.0\n",
+		"cache/s/v1/zone@v1.0.0/zone.scd": "package zone\n",
+	})
+	cache := filepath.Join(root, "cache", "s", "v1")
+	writeSum(t, filepath.Join(root, "app"),
+		"s/v1/time v1.2.0 "+filepath.Join(cache, "time@v1.2.0"),
+		"s/v1/zone v1.0.0 "+filepath.Join(cache, "zone@v1.0.0"),
+	)
+	return root
+}
+
+// writeSum writes the checksum file for each module, listed as
+// "<path> <version> <dir>".
+func writeSum(t *testing.T, app string, list ...string) {
+	t.Helper()
+	b := &strings.Builder{}
+	for _, item := range list {
+		f := strings.Fields(item)
+		h, err := HashDir(f[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(b, "%s %s %s\n", f[0], f[1], h)
+	}
+	if err := os.WriteFile(filepath.Join(app, SumFileName), []byte(b.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	root := moduleTree(t)
+	os.Setenv("SCDCACHE", filepath.Join(root, "cache"))
+	defer os.Unsetenv("SCDCACHE")
+
+	ctx := context.Background()
+	dir := filepath.Join(root, "app", "ar")
+	l, err := NewLoader(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := len(l.Require), 2; g != w {
+		t.Fatalf("got %d required modules, want %d", g, w)
+	}
+	if g, w := l.Require[0].Version, "v1.2.0"; g != w {
+		t.Fatalf("selected version got %s, want %s", g, w)
+	}
+	pkgs, err := l.Load(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := CompilePackages(pkgs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := len(st.Table[0].Column), 2; g != w {
+		t.Fatalf("required table got %d columns, want %d", g, w)
+	}
+
+	// Modify the cached module so it no longer matches the checksum.
+	fn := filepath.Join(root, "cache", "s", "v1", "zone@v1.0.0", "zone.scd")
+	if err := os.WriteFile(fn, []byte("package zone\n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewLoader(ctx, dir)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch for s/v1/zone v1.0.0") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+}
+
+func TestRequireVendor(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"app/scd.mod":                   "module app\n\nrequire s/v1/time v1.0.1\n",
+		"app/ar/ar.scd":                 "package ar\n\nimport s/v1/time\n\nevent table {\n\tperiod *time.period.id\n}\n",
+		"app/vendor/s/v1/time/scd.mod":  "module s/v1/time\n",
+		"app/vendor/s/v1/time/time.scd": "package time\n\nperiod table {\n\tid int64 key\n}\n",
+	})
+	os.Setenv("SCDCACHE", filepath.Join(root, "missing"))
+	defer os.Unsetenv("SCDCACHE")
+
+	ctx := context.Background()
+	app := filepath.Join(root, "app")
+	_, err := NewLoader(ctx, app)
+	if err == nil || !strings.Contains(err.Error(), "missing checksum for s/v1/time v1.0.1") {
+		t.Fatalf("expected missing checksum, got %v", err)
+	}
+	writeSum(t, app, "s/v1/time v1.0.1 "+filepath.Join(app, "vendor", "s", "v1", "time"))
+
+	dir := filepath.Join(app, "ar")
+	l, err := NewLoader(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkgs, err := l.Load(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompilePackages(pkgs...); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 solidcoredata authors.
+
+package compile
+
+import (
+	"github.com/solidcoredata/dbc/parser"
+	"github.com/solidcoredata/dbc/query"
+)
+
+// allowName is the access granted by each name of a role grant.
+var allowName = map[string]query.Authn{
+	"read":   query.AllowRead,
+	"return": query.AllowReturn,
+	"insert": query.AllowInsert,
+	"update": query.AllowUpdate,
+	"delete": query.AllowDelete,
+	"full":   query.AllowFull,
+}
+
+// addRules compiles the read rules and ports of a table. Rules refer to the
+// row by the table alias, or by the table name if the table has no alias.
+func (c *compiler) addRules(st *query.StoreTable, t parser.Table) {
+	from := st.Alias
+	if len(from) == 0 {
+		from = st.Name
+	}
+	sc := &scope{
+		alias:  []string{from},
+		table:  map[string]*query.StoreTable{from: st},
+		result: map[string]*query.ResultTableSchema{from: {Name: st.Name, Alias: from}},
+	}
+	for _, r := range t.Read {
+		st.Read = append(st.Read, c.rule(r.Exp, sc))
+	}
+	for _, pp := range t.Port {
+		if _, ok := st.Port[pp.Name]; ok {
+			c.errorf("port %q declared more than once", pp.Name)
+			continue
+		}
+		port := query.StoreTablePort{RoleAuthn: c.grants(pp.Role)}
+		for _, d := range pp.Deny {
+			var p *query.Param
+			switch d.Op {
+			case "read":
+				p = &port.DenyRead
+			case "insert":
+				p = &port.DenyInsert
+			case "update":
+				p = &port.DenyUpdate
+			case "delete":
+				p = &port.DenyDelete
+			default:
+				c.errorf("port %q has unknown deny %q, expected read, insert, update, or delete", pp.Name, d.Op)
+				continue
+			}
+			c.deny(pp.Name, d, p, sc)
+		}
+		seen := make(map[string]bool, len(pp.Column))
+		for _, cp := range pp.Column {
+			i := columnIndex(st, cp.Name)
+			switch {
+			case i < 0:
+				c.errorf("port %q column %q not found", pp.Name, cp.Name)
+				continue
+			case seen[cp.Name]:
+				c.errorf("port %q column %q declared more than once", pp.Name, cp.Name)
+				continue
+			}
+			seen[cp.Name] = true
+			if port.Column == nil {
+				port.Column = make([]query.StoreColumnPort, len(st.Column))
+			}
+			col := &port.Column[i]
+			col.RoleAuthn = c.grants(cp.Role)
+			for _, d := range cp.Deny {
+				switch d.Op {
+				case "read":
+					c.deny(pp.Name, d, &col.DenyRead, sc)
+				case "update":
+					c.deny(pp.Name, d, &col.DenyUpdate, sc)
+				default:
+					c.errorf("port %q column %q has unknown deny %q, expected read or update", pp.Name, cp.Name, d.Op)
+				}
+			}
+		}
+		if st.Port == nil {
+			st.Port = make(map[string]query.StoreTablePort, len(t.Port))
+		}
+		st.Port[pp.Name] = port
+	}
+}
+
+// deny sets p to the deny rule d, which may be declared once.
+func (c *compiler) deny(port string, d parser.TableRule, p *query.Param, sc *scope) {
+	if !p.Q.IsZero() {
+		c.errorf("port %q deny %s declared more than once", port, d.Op)
+		return
+	}
+	*p = c.rule(d.Exp, sc)
+}
+
+// grants returns the access granted to each role, or nil if no role is
+// listed.
+func (c *compiler) grants(list []parser.RoleGrant) map[string]query.Authn {
+	if len(list) == 0 {
+		return nil
+	}
+	m := make(map[string]query.Authn, len(list))
+	for _, g := range list {
+		if _, ok := m[g.Role]; ok {
+			c.errorf("role %q declared more than once", g.Role)
+			continue
+		}
+		var a query.Authn
+		for _, name := range g.Allow {
+			bit, ok := allowName[name]
+			if !ok {
+				c.errorf("role %q has unknown access %q", g.Role, name)
+			}
+			a |= bit
+		}
+		m[g.Role] = a
+	}
+	return m
+}
+
+// rule compiles the condition of a row rule. The inputs of the rule are
+// the parameters it refers to.
+func (c *compiler) rule(e parser.Expr, sc *scope) query.Param {
+	st := &query.Stmt{}
+	p := query.Param{Q: c.convert(e, sc, st)}
+	if len(st.Anchor) > 0 {
+		c.errorf("rule may not declare an anchor")
+	}
+	seen := make(map[string]bool)
+	query.Walk(p.Q, func(e query.Exp) bool {
+		if e.Op == query.ExpParam && !seen[e.Name] {
+			seen[e.Name] = true
+			p.Input = append(p.Input, query.Input{Name: e.Name})
+		}
+		return true
+	})
+	return p
+}
+
+func columnIndex(t *query.StoreTable, name string) int {
+	for i, col := range t.Column {
+		if col.Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,281 @@
+// Copyright 2018 solidcoredata authors.
+
+package compile
+
+import (
+	"github.com/solidcoredata/dbc/parser"
+	"github.com/solidcoredata/dbc/query"
+)
+
+// scope holds the table aliases visible to a statement. Nested statements,
+// such as exists, may see the aliases of their parent.
+type scope struct {
+	parent *scope
+	alias  []string
+	table  map[string]*query.StoreTable
+	result map[string]*query.ResultTableSchema
+}
+
+func (s *scope) lookup(alias string) (*query.StoreTable, *query.ResultTableSchema) {
+	for ; s != nil; s = s.parent {
+		if t, ok := s.table[alias]; ok {
+			return t, s.result[alias]
+		}
+	}
+	return nil, nil
+}
+
+// visible returns the tables of all aliases visible in the scope, outer
+// most first.
+func (s *scope) visible() []*query.ResultTableSchema {
+	if s == nil {
+		return nil
+	}
+	list := s.parent.visible()
+	for _, alias := range s.alias {
+		list = append(list, s.result[alias])
+	}
+	return list
+}
+
+func (c *compiler) addQuery(q parser.Query) {
+	for _, existing := range c.store.Query {
+		if existing.Name == q.Name {
+			c.errorf("query declared more than once")
+			return
+		}
+	}
+	cq := query.Query{Name: q.Name}
+	for _, ps := range q.Stmt {
+		cq.Stmt = append(cq.Stmt, c.compileStmt(ps, nil))
+	}
+	c.store.Query = append(c.store.Query, cq)
+}
+
+// compileStmt compiles a statement. Detail statements see the aliases of
+// the parent scope.
+func (c *compiler) compileStmt(ps parser.Stmt, parent *scope) query.Stmt {
+	st := query.Stmt{}
+	top := &query.Anchor{}
+	st.ExpList = top
+
+	sc := c.newScope(parent, ps.From)
+	for _, fr := range ps.From {
+		_, rt := sc.lookup(fr.Alias)
+		if rt != nil {
+			st.From = append(st.From, rt)
+		}
+	}
+	if len(st.From) > 0 {
+		st.From[0].IsArity = true
+	}
+	top.From = sc.visible()
+	st.Anchor = append(st.Anchor, top)
+
+	if cond := c.stmtCondition(ps); len(cond) > 0 {
+		st.Where = query.And(c.convertList(cond, sc, &st)...)
+	}
+
+	for _, sel := range ps.Select {
+		col := &query.ColumnSchema{QueryName: sel.Name}
+		var value query.Exp
+		if ref, ok := sel.Exp.(*parser.ColumnRef); ok {
+			value = query.Column(ref.Alias, ref.Column)
+			if t, rt := c.checkRef(ref, sc); t != nil {
+				col = columnSchema(rt, findColumn(t, ref.Column))
+				if len(sel.Name) > 0 {
+					col.QueryName = sel.Name
+				}
+			}
+		} else {
+			value = c.convert(sel.Exp, sc, &st)
+			if len(sel.Name) == 0 {
+				c.errorf("select expression requires a name")
+			}
+		}
+		st.Return = append(st.Return, col)
+		st.Select = append(st.Select, value)
+	}
+	for _, o := range ps.Order {
+		st.Order = append(st.Order, query.Order{Exp: c.convert(o.Exp, sc, &st), Desc: o.Desc})
+	}
+	st.Limit = c.count(ps.Limit)
+	st.Offset = c.count(ps.Offset)
+	for i, d := range ps.Detail {
+		for _, prev := range ps.Detail[:i] {
+			if prev.Name == d.Name {
+				c.errorf("detail %q declared more than once", d.Name)
+			}
+		}
+		if len(ps.Select) == 0 {
+			c.errorf("detail %q requires the statement to select", d.Name)
+		}
+		st.Interleave = append(st.Interleave, query.Interleave{Name: d.Name, Stmt: c.compileStmt(d.Stmt, sc)})
+	}
+	return st
+}
+
+// count returns the literal value of a limit or offset.
+func (c *compiler) count(v string) query.Exp {
+	if len(v) == 0 {
+		return query.Exp{}
+	}
+	n, err := literalValue(v)
+	if _, ok := n.(int64); !ok || err != nil {
+		c.errorf("invalid count %s", v)
+	}
+	return query.Literal(n)
+}
+
+// stmtCondition returns the join conditions followed by the statement conditions.
+func (c *compiler) stmtCondition(ps parser.Stmt) []parser.Expr {
+	var list []parser.Expr
+	for _, fr := range ps.From {
+		list = append(list, fr.And...)
+	}
+	return append(list, ps.And...)
+}
+
+func (c *compiler) newScope(parent *scope, from []parser.From) *scope {
+	sc := &scope{
+		parent: parent,
+		table:  make(map[string]*query.StoreTable, len(from)),
+		result: make(map[string]*query.ResultTableSchema, len(from)),
+	}
+	for _, fr := range from {
+		t := c.lookupTable(fr.Table)
+		if t == nil {
+			c.errorf("unknown table %q", fr.Table)
+			continue
+		}
+		if _, ok := sc.table[fr.Alias]; ok {
+			c.errorf("alias %q declared more than once", fr.Alias)
+			continue
+		}
+		sc.alias = append(sc.alias, fr.Alias)
+		sc.table[fr.Alias] = t
+		sc.result[fr.Alias] = &query.ResultTableSchema{Name: t.Name, Alias: fr.Alias}
+	}
+	return sc
+}
+
+func columnSchema(rt *query.ResultTableSchema, col *query.StoreColumn) *query.ColumnSchema {
+	return &query.ColumnSchema{
+		Table:        rt,
+		StoreName:    col.Name,
+		QueryName:    col.Name,
+		Display:      col.Display,
+		Key:          col.Key,
+		Serial:       col.Serial,
+		Nullable:     col.Nullable,
+		UpdateLock:   col.UpdateLock,
+		DeleteLock:   col.DeleteLock,
+		Length:       col.Length,
+		Type:         col.Type,
+		Default:      col.Default,
+		LinkToTable:  col.LinkToTable,
+		LinkToColumn: col.LinkToColumn,
+	}
+}
+
+// checkRef verifies the column reference is visible in scope.
+func (c *compiler) checkRef(ref *parser.ColumnRef, sc *scope) (*query.StoreTable, *query.ResultTableSchema) {
+	t, rt := sc.lookup(ref.Alias)
+	if t == nil {
+		c.errorf("unknown alias %q", ref.Alias)
+		return nil, nil
+	}
+	if findColumn(t, ref.Column) == nil {
+		c.errorf("table %q has no column %q", t.Name, ref.Column)
+		return nil, nil
+	}
+	return t, rt
+}
+
+func (c *compiler) convertList(list []parser.Expr, sc *scope, st *query.Stmt) []query.Exp {
+	out := make([]query.Exp, len(list))
+	for i, e := range list {
+		out[i] = c.convert(e, sc, st)
+	}
+	return out
+}
+
+// convert an expression to a query expression, verifying column references,
+// recording read columns, and recording anchors in the statement.
+func (c *compiler) convert(e parser.Expr, sc *scope, st *query.Stmt) query.Exp {
+	switch e := e.(type) {
+	default:
+		c.errorf("unknown expression %T", e)
+		return query.Exp{}
+	case *parser.Ident:
+		return query.Parameter(e.Name)
+	case *parser.Literal:
+		v, err := literalValue(e.Value)
+		if err != nil {
+			c.errorf("%v", err)
+		}
+		return query.Literal(v)
+	case *parser.ColumnRef:
+		if t, rt := c.checkRef(e, sc); t != nil {
+			c.addRead(st, rt, findColumn(t, e.Column))
+		}
+		return query.Column(e.Alias, e.Column)
+	case *parser.Anchor:
+		if st.FindAnchor(e.Name) != nil || len(e.Name) == 0 {
+			c.errorf("anchor %q declared more than once", e.Name)
+		}
+		st.Anchor = append(st.Anchor, &query.Anchor{Name: e.Name, From: sc.visible()})
+		return query.Exp{Op: query.ExpAnchor, Name: e.Name}
+	case *parser.Binary:
+		op, ok := query.BinaryOp(e.Op)
+		if !ok {
+			c.errorf("unknown operator %q", e.Op)
+		}
+		return query.Binary(op, c.convert(e.Left, sc, st), c.convert(e.Right, sc, st))
+	case *parser.List:
+		if e.Op == "or" {
+			return query.Or(c.convertList(e.Item, sc, st)...)
+		}
+		return query.And(c.convertList(e.Item, sc, st)...)
+	case *parser.Not:
+		return query.Not(c.convert(e.Exp, sc, st))
+	case *parser.Call:
+		return query.Call(e.Name, c.convertList(e.Arg, sc, st)...)
+	case *parser.Exists:
+		return query.Exists(c.subQuery(&e.Stmt, sc, st))
+	case *parser.In:
+		in := query.Exp{Op: query.ExpIn, Arg: []query.Exp{c.convert(e.Exp, sc, st)}}
+		if e.Stmt != nil {
+			in.Sub = c.subQuery(e.Stmt, sc, st)
+			return in
+		}
+		in.Arg = append(in.Arg, c.convertList(e.List, sc, st)...)
+		return in
+	}
+}
+
+func (c *compiler) subQuery(ps *parser.Stmt, sc *scope, st *query.Stmt) *query.SubQuery {
+	sub := c.newScope(sc, ps.From)
+	q := &query.SubQuery{}
+	for _, fr := range ps.From {
+		if _, rt := sub.lookup(fr.Alias); rt != nil {
+			q.From = append(q.From, rt)
+		}
+	}
+	if cond := c.stmtCondition(*ps); len(cond) > 0 {
+		q.Where = query.And(c.convertList(cond, sub, st)...)
+	}
+	for _, sel := range ps.Select {
+		q.Select = append(q.Select, c.convert(sel.Exp, sub, st))
+	}
+	return q
+}
+
+func (c *compiler) addRead(st *query.Stmt, rt *query.ResultTableSchema, col *query.StoreColumn) {
+	for _, r := range st.Read {
+		if r.Table == rt && r.StoreName == col.Name {
+			return
+		}
+	}
+	st.Read = append(st.Read, columnSchema(rt, col))
+}
@@ -0,0 +1,167 @@
+// Copyright 2018 solidcoredata authors.
+
+package compile
+
+import (
+	"github.com/solidcoredata/dbc/parser"
+	"github.com/solidcoredata/dbc/query"
+)
+
+// TestSuffix ends the name of the source files that declare tests.
+const TestSuffix = "_test" + SourceExt
+
+// Test is a compiled unit test of a query, see parser.Test. Values are
+// the Go values of their literals and are converted to the column type
+// when the test runs.
+type Test struct {
+	Name     string
+	FileName string
+	Query    string
+	Port     string
+	Role     []string
+	Param    []TestParam
+	Input    []TestRows
+	Output   []TestRows
+	Error    string
+}
+
+// TestParam is a named parameter value of a test.
+type TestParam struct {
+	Name  string
+	Value interface{}
+}
+
+// TestRows are rows of values for the listed columns. Table is the store
+// name of an input table, and is empty for the rows of a result.
+type TestRows struct {
+	Table  string
+	Column []string
+	Row    [][]interface{}
+}
+
+// CompileTests compiles the tests in the test files of the package. The
+// store is compiled from the package and its imports, and holds each
+// table and query a test refers to. Test files may only declare tests.
+func CompileTests(s *query.Store, pkg *Package) ([]*Test, error) {
+	c := &compiler{store: s}
+	var list []*Test
+	seen := make(map[string]bool)
+	for _, f := range pkg.TestFile {
+		c.fileName = f.Name
+		c.declare = "file"
+		if len(f.Import) > 0 || len(f.Table) > 0 || len(f.Query) > 0 {
+			c.errorf("test files may only declare tests")
+		}
+		for _, pt := range f.Test {
+			c.declare = pt.Name
+			if seen[pt.Name] {
+				c.errorf("test declared more than once")
+				continue
+			}
+			seen[pt.Name] = true
+			list = append(list, c.compileTest(pt))
+		}
+	}
+	if err := c.el.ErrNil(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (c *compiler) compileTest(pt parser.Test) *Test {
+	t := &Test{
+		Name:     pt.Name,
+		FileName: c.fileName,
+		Query:    pt.Query,
+		Port:     pt.Port,
+		Role:     pt.Role,
+		Error:    pt.Error,
+	}
+	found := false
+	for _, q := range c.store.Query {
+		if q.Name == pt.Query {
+			found = true
+			break
+		}
+	}
+	switch {
+	case len(pt.Query) == 0:
+		c.errorf("test requires a query")
+	case !found:
+		c.errorf("unknown query %q", pt.Query)
+	}
+	for _, p := range pt.Param {
+		for _, prev := range t.Param {
+			if prev.Name == p.Name {
+				c.errorf("param %q declared more than once", p.Name)
+			}
+		}
+		t.Param = append(t.Param, TestParam{Name: p.Name, Value: c.testValue(p.Value)})
+	}
+	for _, in := range pt.Input {
+		var table *query.StoreTable
+		for _, st := range c.store.Table {
+			if st.Name == in.Table {
+				table = st
+			}
+		}
+		if table == nil {
+			c.errorf("unknown input table %q", in.Table)
+			continue
+		}
+		for _, prev := range t.Input {
+			if prev.Table == in.Table {
+				c.errorf("input table %q declared more than once", in.Table)
+			}
+		}
+		for _, name := range in.Column {
+			if findColumn(table, name) == nil {
+				c.errorf("input table %q has no column %q", in.Table, name)
+			}
+		}
+		t.Input = append(t.Input, c.testRows(in))
+	}
+	for _, out := range pt.Output {
+		t.Output = append(t.Output, c.testRows(out))
+	}
+	return t
+}
+
+// testRows compiles the values of each row, which must have a value for
+// each listed column.
+func (c *compiler) testRows(pr parser.TestRows) TestRows {
+	rows := TestRows{Table: pr.Table, Column: pr.Column}
+	seen := make(map[string]bool, len(pr.Column))
+	for _, name := range pr.Column {
+		if seen[name] {
+			c.errorf("column %q listed more than once", name)
+		}
+		seen[name] = true
+	}
+	for i, r := range pr.Row {
+		if len(r.Value) != len(pr.Column) {
+			c.errorf("row %d has %d values, want %d", i+1, len(r.Value), len(pr.Column))
+			continue
+		}
+		row := make([]interface{}, len(r.Value))
+		for j, e := range r.Value {
+			row[j] = c.testValue(e)
+		}
+		rows.Row = append(rows.Row, row)
+	}
+	return rows
+}
+
+// testValue returns the value of a literal.
+func (c *compiler) testValue(e parser.Expr) interface{} {
+	lit, ok := e.(*parser.Literal)
+	if !ok {
+		c.errorf("test value must be a literal")
+		return nil
+	}
+	v, err := literalValue(lit.Value)
+	if err != nil {
+		c.errorf("%v", err)
+	}
+	return v
+}
@@ -0,0 +1,397 @@
+// Copyright 2018 solidcoredata authors.
+
+// Package dialect renders query expressions as SQL text for
+// specific database systems.
+package dialect
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/solidcoredata/dbc/query"
+)
+
+// Dialect describes how a database system spells identifiers,
+// parameters, and literal values.
+type Dialect interface {
+	Name() string
+	Quote(ident string) string
+	Placeholder(n int) string // Placeholder for the n-th parameter, starting at 1.
+	Bool(v bool) string
+}
+
+// SQL is rendered query text. Param lists the parameter name for each
+// placeholder in order.
+type SQL struct {
+	Text  string
+	Param []string
+}
+
+type postgres struct{}
+
+func (postgres) Name() string              { return "postgres" }
+func (postgres) Quote(ident string) string { return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"` }
+func (postgres) Placeholder(n int) string  { return "$" + strconv.Itoa(n) }
+func (postgres) Bool(v bool) string        { return strconv.FormatBool(v) }
+
+type sqlServer struct{}
+
+func (sqlServer) Name() string              { return "sqlserver" }
+func (sqlServer) Quote(ident string) string { return "[" + strings.ReplaceAll(ident, "]", "]]") + "]" }
+func (sqlServer) Placeholder(n int) string  { return "@p" + strconv.Itoa(n) }
+func (sqlServer) Bool(v bool) string {
+	if v {
+		return "1"
+	}
+	return "0"
+}
+
+var (
+	Postgres  Dialect = postgres{}
+	SQLServer Dialect = sqlServer{}
+)
+
+// Exp renders the expression. Anchors must already be replaced, such as
+// by query.Stmt.Condition.
+func Exp(d Dialect, e query.Exp) (SQL, error) {
+	r := &renderer{d: d}
+	r.exp(e)
+	return r.sql()
+}
+
+type renderer struct {
+	d     Dialect
+	b     strings.Builder
+	param []string
+	err   error
+}
+
+func (r *renderer) errorf(f string, v ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf("dialect %s: "+f, append([]interface{}{r.d.Name()}, v...)...)
+	}
+}
+
+var binarySQL = map[query.ExpOp]string{
+	query.ExpEqual:        "=",
+	query.ExpNotEqual:     "<>",
+	query.ExpLess:         "<",
+	query.ExpLessEqual:    "<=",
+	query.ExpGreater:      ">",
+	query.ExpGreaterEqual: ">=",
+	query.ExpLike:         "like",
+	query.ExpAdd:          "+",
+	query.ExpSub:          "-",
+	query.ExpMul:          "*",
+	query.ExpDiv:          "/",
+	query.ExpMod:          "%",
+}
+
+func (r *renderer) list(sep string, list []query.Exp) {
+	for i, e := range list {
+		if i > 0 {
+			r.b.WriteString(sep)
+		}
+		r.exp(e)
+	}
+}
+
+func (r *renderer) exp(e query.Exp) {
+	if op, ok := binarySQL[e.Op]; ok {
+		if len(e.Arg) != 2 {
+			r.errorf("%v requires two operands", e.Op)
+			return
+		}
+		r.b.WriteString("(")
+		r.exp(e.Arg[0])
+		r.b.WriteString(" " + op + " ")
+		r.exp(e.Arg[1])
+		r.b.WriteString(")")
+		return
+	}
+	switch e.Op {
+	default:
+		r.errorf("unknown expression %v", e.Op)
+	case query.ExpAnchor:
+		r.errorf("anchor %q not replaced", e.Name)
+	case query.ExpColumn:
+		r.b.WriteString(r.d.Quote(e.Alias) + "." + r.d.Quote(e.Name))
+	case query.ExpParam:
+		r.param = append(r.param, e.Name)
+		r.b.WriteString(r.d.Placeholder(len(r.param)))
+	case query.ExpLiteral:
+		r.literal(e.Value)
+	case query.ExpAnd, query.ExpOr:
+		if len(e.Arg) == 0 {
+			if e.Op == query.ExpAnd {
+				r.b.WriteString("(1=1)")
+			} else {
+				r.b.WriteString("(1=0)")
+			}
+			return
+		}
+		r.b.WriteString("(")
+		if e.Op == query.ExpAnd {
+			r.list(" and ", e.Arg)
+		} else {
+			r.list(" or ", e.Arg)
+		}
+		r.b.WriteString(")")
+	case query.ExpNot:
+		if len(e.Arg) != 1 {
+			r.errorf("%v requires one operand", e.Op)
+			return
+		}
+		r.b.WriteString("not ")
+		r.exp(e.Arg[0])
+	case query.ExpCall:
+		r.b.WriteString(e.Name + "(")
+		r.list(", ", e.Arg)
+		r.b.WriteString(")")
+	case query.ExpExists:
+		r.b.WriteString("exists (select 1")
+		r.sub(e.Sub)
+		r.b.WriteString(")")
+	case query.ExpIn:
+		if len(e.Arg) == 0 || (e.Sub == nil && len(e.Arg) < 2) || (e.Sub != nil && len(e.Arg) != 1) {
+			r.errorf("%v requires an operand and a list or sub-query", e.Op)
+			return
+		}
+		r.exp(e.Arg[0])
+		r.b.WriteString(" in (")
+		if e.Sub == nil {
+			r.list(", ", e.Arg[1:])
+			r.b.WriteString(")")
+			return
+		}
+		r.b.WriteString("select ")
+		r.list(", ", e.Sub.Select)
+		r.sub(e.Sub)
+		r.b.WriteString(")")
+	}
+}
+
+func (r *renderer) sub(s *query.SubQuery) {
+	if s == nil {
+		r.errorf("missing sub-query")
+		return
+	}
+	r.b.WriteString(" from ")
+	for i, t := range s.From {
+		if i > 0 {
+			r.b.WriteString(", ")
+		}
+		r.b.WriteString(r.d.Quote(t.Name) + " " + r.d.Quote(t.Alias))
+	}
+	if !s.Where.IsZero() {
+		r.b.WriteString(" where ")
+		r.exp(s.Where)
+	}
+}
+
+func (r *renderer) literal(v interface{}) {
+	switch v := v.(type) {
+	default:
+		r.errorf("unsupported literal type %T", v)
+	case nil:
+		r.b.WriteString("null")
+	case bool:
+		r.b.WriteString(r.d.Bool(v))
+	case string:
+		r.b.WriteString("'" + strings.ReplaceAll(v, "'", "''") + "'")
+	case int64:
+		r.b.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		r.b.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	}
+}
+
+// Update renders the statement as an update of the table of its Update
+// columns. Delete renders the statement as a delete of its Delete table.
+// Other From tables are joined by the statement condition. The condition
+// gets the lock conditions of the table from s, unless it already compares
+// the lock parameter. The caller passes the original value of each lock
+// column as the parameter and, when no row is changed, reports
+// runner.ErrConflict.
+func Update(d Dialect, s *query.Store, st *query.Stmt) (SQL, error) {
+	if len(st.Update) == 0 || len(st.Update) != len(st.Set) {
+		return SQL{}, fmt.Errorf("dialect %s: update requires a value for each column", d.Name())
+	}
+	target := st.Update[0].Table
+	for _, col := range st.Update {
+		if col.Table == nil || target == nil || col.Table.Alias != target.Alias {
+			return SQL{}, fmt.Errorf("dialect %s: update columns must be of a single table", d.Name())
+		}
+	}
+	r := &renderer{d: d}
+	table := r.d.Quote(target.Name)
+	alias := r.d.Quote(target.Alias)
+	if _, ok := d.(sqlServer); ok {
+		r.b.WriteString("update " + alias)
+	} else {
+		r.b.WriteString("update " + table + " as " + alias)
+	}
+	r.b.WriteString(" set ")
+	for i, col := range st.Update {
+		if i > 0 {
+			r.b.WriteString(", ")
+		}
+		r.b.WriteString(r.d.Quote(col.StoreName) + " = ")
+		r.exp(st.Set[i])
+	}
+	if _, ok := d.(sqlServer); ok {
+		r.from(" from ", st.From, "")
+	} else {
+		r.from(" from ", st.From, target.Alias)
+	}
+	r.where(s, st)
+	return r.sql()
+}
+
+// Delete renders the statement as a delete, see Update.
+func Delete(d Dialect, s *query.Store, st *query.Stmt) (SQL, error) {
+	if len(st.Delete) != 1 {
+		return SQL{}, fmt.Errorf("dialect %s: delete requires a single table", d.Name())
+	}
+	target := st.Delete[0]
+	r := &renderer{d: d}
+	if _, ok := d.(sqlServer); ok {
+		r.b.WriteString("delete " + r.d.Quote(target.Alias))
+		r.from(" from ", st.From, "")
+	} else {
+		r.b.WriteString("delete from " + r.d.Quote(target.Name) + " as " + r.d.Quote(target.Alias))
+		r.from(" using ", st.From, target.Alias)
+	}
+	r.where(s, st)
+	return r.sql()
+}
+
+// Select renders the statement as a select of its returned columns, with
+// its order, limit, and offset. A statement paged by key, as returned by
+// runner.Authz.Keyset, already holds the condition that continues after
+// the cursor row, so it renders like any other.
+func Select(d Dialect, st *query.Stmt) (SQL, error) {
+	if len(st.Return) == 0 {
+		return SQL{}, fmt.Errorf("dialect %s: select requires returned columns", d.Name())
+	}
+	r := &renderer{d: d}
+	r.b.WriteString("select ")
+	for i, col := range st.Return {
+		if i > 0 {
+			r.b.WriteString(", ")
+		}
+		switch {
+		case len(st.Select) == len(st.Return):
+			r.exp(st.Select[i])
+		case col.Table != nil:
+			r.exp(query.Column(col.Table.Alias, col.StoreName))
+		default:
+			r.errorf("returned column %q has no value", col.QueryName)
+		}
+		r.b.WriteString(" as " + r.d.Quote(col.QueryName))
+	}
+	r.from(" from ", st.From, "")
+	if cond := st.Condition(); !cond.IsZero() {
+		r.b.WriteString(" where ")
+		r.exp(cond)
+	}
+	_, sqlServer := d.(sqlServer)
+	paged := !st.Limit.IsZero() || !st.Offset.IsZero()
+	switch {
+	case len(st.Order) > 0:
+		r.b.WriteString(" order by ")
+		for i, o := range st.Order {
+			if i > 0 {
+				r.b.WriteString(", ")
+			}
+			r.exp(o.Exp)
+			if o.Desc {
+				r.b.WriteString(" desc")
+			}
+		}
+	case sqlServer && paged:
+		// Offset and fetch require an order.
+		r.b.WriteString(" order by (select null)")
+	}
+	switch {
+	case !paged:
+	case sqlServer:
+		r.b.WriteString(" offset ")
+		if st.Offset.IsZero() {
+			r.b.WriteString("0")
+		} else {
+			r.exp(st.Offset)
+		}
+		r.b.WriteString(" rows")
+		if !st.Limit.IsZero() {
+			r.b.WriteString(" fetch next ")
+			r.exp(st.Limit)
+			r.b.WriteString(" rows only")
+		}
+	default:
+		if !st.Limit.IsZero() {
+			r.b.WriteString(" limit ")
+			r.exp(st.Limit)
+		}
+		if !st.Offset.IsZero() {
+			r.b.WriteString(" offset ")
+			r.exp(st.Offset)
+		}
+	}
+	return r.sql()
+}
+
+func (r *renderer) sql() (SQL, error) {
+	if r.err != nil {
+		return SQL{}, r.err
+	}
+	return SQL{Text: r.b.String(), Param: r.param}, nil
+}
+
+// from writes the tables other than the skip alias, after the keyword.
+func (r *renderer) from(keyword string, from []*query.ResultTableSchema, skip string) {
+	n := 0
+	for _, t := range from {
+		if t.Alias == skip {
+			continue
+		}
+		if n == 0 {
+			r.b.WriteString(keyword)
+		} else {
+			r.b.WriteString(", ")
+		}
+		n++
+		r.b.WriteString(r.d.Quote(t.Name) + " " + r.d.Quote(t.Alias))
+	}
+}
+
+// where writes the statement condition with its lock conditions.
+func (r *renderer) where(s *query.Store, st *query.Stmt) {
+	cond := st.Condition()
+	has := make(map[string]bool)
+	query.Walk(cond, func(e query.Exp) bool {
+		if e.Op == query.ExpParam {
+			has[e.Name] = true
+		}
+		return true
+	})
+	var list []query.Exp
+	if !cond.IsZero() {
+		list = append(list, cond)
+	}
+	for _, e := range query.Lock(s, st) {
+		if len(e.Arg) != 2 || !has[e.Arg[1].Name] {
+			list = append(list, e)
+		}
+	}
+	if len(list) == 0 {
+		return
+	}
+	r.b.WriteString(" where ")
+	if len(list) == 1 {
+		r.exp(list[0])
+		return
+	}
+	r.exp(query.And(list...))
+}
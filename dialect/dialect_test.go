@@ -0,0 +1,193 @@
+// Copyright 2018 solidcoredata authors.
+
+package dialect
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/solidcoredata/dbc/query"
+)
+
+func TestExp(t *testing.T) {
+	e := query.And(
+		query.Equal(query.Column("b", "Deleted"), query.Literal(false)),
+		query.Or(
+			query.Equal(query.Column("b", "Name"), query.Literal("Bob's")),
+			query.Binary(query.ExpGreater, query.Column("b", "ID"), query.Parameter("MinID")),
+		),
+		query.Exists(&query.SubQuery{
+			From:  []*query.ResultTableSchema{{Name: "Account", Alias: "a"}},
+			Where: query.Equal(query.Column("a", "ID"), query.Parameter("Account")),
+		}),
+	)
+	list := []struct {
+		d     Dialect
+		text  string
+		param []string
+	}{
+		{
+			d:     Postgres,
+			text:  `(("b"."Deleted" = false) and (("b"."Name" = 'Bob''s') or ("b"."ID" > $1)) and exists (select 1 from "Account" "a" where ("a"."ID" = $2)))`,
+			param: []string{"MinID", "Account"},
+		},
+		{
+			d:     SQLServer,
+			text:  `(([b].[Deleted] = 0) and (([b].[Name] = 'Bob''s') or ([b].[ID] > @p1)) and exists (select 1 from [Account] [a] where ([a].[ID] = @p2)))`,
+			param: []string{"MinID", "Account"},
+		},
+	}
+	for _, item := range list {
+		t.Run(item.d.Name(), func(t *testing.T) {
+			sql, err := Exp(item.d, e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sql.Text != item.text {
+				t.Fatalf("got  %s\nwant %s", sql.Text, item.text)
+			}
+			if !reflect.DeepEqual(sql.Param, item.param) {
+				t.Fatalf("got params %q, want %q", sql.Param, item.param)
+			}
+		})
+	}
+}
+
+func TestExpAnchor(t *testing.T) {
+	_, err := Exp(Postgres, query.And(query.Exp{Op: query.ExpAnchor, Name: "where1"}))
+	if err == nil {
+		t.Fatal("expected error for anchor")
+	}
+}
+
+func TestExpMalformed(t *testing.T) {
+	list := []query.Exp{
+		{Op: query.ExpNot},
+		{Op: query.ExpIn},
+		{Op: query.ExpIn, Arg: []query.Exp{query.Column("b", "ID")}},
+		{Op: query.ExpEqual, Arg: []query.Exp{query.Column("b", "ID")}},
+	}
+	for _, e := range list {
+		if _, err := Exp(Postgres, e); err == nil {
+			t.Errorf("%v: expected error for missing operands", e.Op)
+		}
+	}
+}
+
+func TestLock(t *testing.T) {
+	s := &query.Store{Table: []*query.StoreTable{{
+		Name: "book",
+		Column: []*query.StoreColumn{
+			{Name: "id", Key: true, Type: query.TypeInteger},
+			{Name: "name", Type: query.TypeString},
+			{Name: "version", Type: query.TypeInteger, UpdateLock: true, DeleteLock: true},
+			{Name: "edited", Type: query.TypeInteger, UpdateLock: true},
+		},
+	}}}
+	book := &query.ResultTableSchema{Name: "book", Alias: "b", IsArity: true}
+	author := &query.ResultTableSchema{Name: "author", Alias: "a"}
+	update := &query.Stmt{
+		From:   []*query.ResultTableSchema{book},
+		Where:  query.Equal(query.Column("b", "id"), query.Parameter("id")),
+		Update: []*query.ColumnSchema{{Table: book, StoreName: "name"}, {Table: book, StoreName: "version"}},
+		Set:    []query.Exp{query.Parameter("name"), query.Binary(query.ExpAdd, query.Column("b", "version"), query.Literal(int64(1)))},
+	}
+	del := &query.Stmt{
+		From: []*query.ResultTableSchema{book, author},
+		Where: query.And(
+			query.Equal(query.Column("a", "id"), query.Column("b", "author")),
+			query.Equal(query.Column("a", "name"), query.Parameter("author")),
+			query.Equal(query.Column("b", "version"), query.Parameter(query.LockParam("b", "version"))),
+		),
+		Delete: []*query.ResultTableSchema{book},
+	}
+	list := []struct {
+		d     Dialect
+		st    *query.Stmt
+		text  string
+		param []string
+	}{
+		{
+			d:     Postgres,
+			st:    update,
+			text:  `update "book" as "b" set "name" = $1, "version" = ("b"."version" + 1) where (("b"."id" = $2) and ("b"."version" = $3) and ("b"."edited" = $4))`,
+			param: []string{"name", "id", "lock.b.version", "lock.b.edited"},
+		},
+		{
+			d:     SQLServer,
+			st:    update,
+			text:  `update [b] set [name] = @p1, [version] = ([b].[version] + 1) from [book] [b] where (([b].[id] = @p2) and ([b].[version] = @p3) and ([b].[edited] = @p4))`,
+			param: []string{"name", "id", "lock.b.version", "lock.b.edited"},
+		},
+		{
+			// The delete condition already compares the lock column.
+			d:     Postgres,
+			st:    del,
+			text:  `delete from "book" as "b" using "author" "a" where (("a"."id" = "b"."author") and ("a"."name" = $1) and ("b"."version" = $2))`,
+			param: []string{"author", "lock.b.version"},
+		},
+		{
+			d:     SQLServer,
+			st:    del,
+			text:  `delete [b] from [book] [b], [author] [a] where (([a].[id] = [b].[author]) and ([a].[name] = @p1) and ([b].[version] = @p2))`,
+			param: []string{"author", "lock.b.version"},
+		},
+	}
+	for _, item := range list {
+		var sql SQL
+		var err error
+		if len(item.st.Delete) > 0 {
+			sql, err = Delete(item.d, s, item.st)
+		} else {
+			sql, err = Update(item.d, s, item.st)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sql.Text != item.text {
+			t.Errorf("%s got  %s\nwant %s", item.d.Name(), sql.Text, item.text)
+		}
+		if !reflect.DeepEqual(sql.Param, item.param) {
+			t.Errorf("%s got params %q, want %q", item.d.Name(), sql.Param, item.param)
+		}
+	}
+}
+
+func TestKeyset(t *testing.T) {
+	book := &query.ResultTableSchema{Name: "book", Alias: "b", IsArity: true}
+	order := []query.Order{{Exp: query.Column("b", "pages"), Desc: true}, {Exp: query.Column("b", "id")}}
+	st := &query.Stmt{
+		From: []*query.ResultTableSchema{book},
+		Return: []*query.ColumnSchema{
+			{Table: book, StoreName: "id", QueryName: "id"},
+			{Table: book, StoreName: "name", QueryName: "title"},
+		},
+		Where: query.And(
+			query.Equal(query.Column("b", "author"), query.Parameter("author")),
+			query.After(order, func(i int) query.Exp { return query.Parameter("cursor." + strconv.Itoa(i)) }),
+		),
+		Order: order,
+		Limit: query.Literal(int64(50)),
+	}
+	list := []struct {
+		d    Dialect
+		text string
+	}{
+		{Postgres, `select "b"."id" as "id", "b"."name" as "title" from "book" "b" where (("b"."author" = $1) and (("b"."pages" < $2) or (("b"."pages" = $3) and ("b"."id" > $4)))) order by "b"."pages" desc, "b"."id" limit 50`},
+		{SQLServer, `select [b].[id] as [id], [b].[name] as [title] from [book] [b] where (([b].[author] = @p1) and (([b].[pages] < @p2) or (([b].[pages] = @p3) and ([b].[id] > @p4)))) order by [b].[pages] desc, [b].[id] offset 0 rows fetch next 50 rows only`},
+	}
+	param := []string{"author", "cursor.0", "cursor.0", "cursor.1"}
+	for _, item := range list {
+		sql, err := Select(item.d, st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sql.Text != item.text {
+			t.Errorf("%s got  %s\nwant %s", item.d.Name(), sql.Text, item.text)
+		}
+		if !reflect.DeepEqual(sql.Param, param) {
+			t.Errorf("%s got params %q, want %q", item.d.Name(), sql.Param, param)
+		}
+	}
+}
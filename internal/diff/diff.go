@@ -0,0 +1,161 @@
+// Copyright 2018 solidcoredata authors.
+
+// Package diff writes the differences between two texts as a unified diff.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// context is the number of unchanged lines shown around each change.
+const context = 3
+
+// op is a line of an edit script: kept, deleted, or inserted.
+type op struct {
+	kind byte // ' ', '-', or '+'.
+	text string
+}
+
+// Unified returns the unified diff of old and new, labeled with the names
+// of each, or nil if they are equal.
+func Unified(oldName, newName string, old, new []byte) []byte {
+	if bytes.Equal(old, new) {
+		return nil
+	}
+	ops := edits(lines(old), lines(new))
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "--- %s\n+++ %s\n", oldName, newName)
+
+	// Line of old and new before each op, counted from zero.
+	oldLine := make([]int, len(ops)+1)
+	newLine := make([]int, len(ops)+1)
+	for i, o := range ops {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if o.kind != '+' {
+			oldLine[i+1]++
+		}
+		if o.kind != '-' {
+			newLine[i+1]++
+		}
+	}
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// A hunk holds each change with no more than twice the context
+		// of unchanged lines between them.
+		last := i
+		for j := i + 1; j < len(ops); j++ {
+			if ops[j].kind == ' ' {
+				continue
+			}
+			if j-last-1 > 2*context {
+				break
+			}
+			last = j
+		}
+		start, end := i-context, last+context+1
+		if start < 0 {
+			start = 0
+		}
+		if end > len(ops) {
+			end = len(ops)
+		}
+		fmt.Fprintf(b, "@@ -%s +%s @@\n", hunkRange(oldLine[start], oldLine[end]), hunkRange(newLine[start], newLine[end]))
+		for _, o := range ops[start:end] {
+			b.WriteByte(o.kind)
+			b.WriteString(o.text)
+			if len(o.text) == 0 || o.text[len(o.text)-1] != '\n' {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return b.Bytes()
+}
+
+// hunkRange returns the range of lines from, up to to, counted from zero,
+// as a hunk header shows them.
+func hunkRange(from, to int) string {
+	switch n := to - from; n {
+	case 0:
+		return fmt.Sprintf("%d,0", from)
+	case 1:
+		return fmt.Sprintf("%d", from+1)
+	default:
+		return fmt.Sprintf("%d,%d", from+1, n)
+	}
+}
+
+// lines splits the text after each newline.
+func lines(b []byte) []string {
+	var list []string
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n') + 1
+		if i == 0 {
+			i = len(b)
+		}
+		list = append(list, string(b[:i]))
+		b = b[i:]
+	}
+	return list
+}
+
+// edits returns an edit script that turns a into b, keeping the longest
+// common subsequence of lines.
+func edits(a, b []string) []op {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	x, y := a[pre:len(a)-suf], b[pre:len(b)-suf]
+
+	// common[i][j] is the length of the longest common subsequence of
+	// x[i:] and y[j:].
+	common := make([][]int, len(x)+1)
+	for i := range common {
+		common[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			switch {
+			case x[i] == y[j]:
+				common[i][j] = common[i+1][j+1] + 1
+			case common[i+1][j] >= common[i][j+1]:
+				common[i][j] = common[i+1][j]
+			default:
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	var ops []op
+	for _, s := range a[:pre] {
+		ops = append(ops, op{' ', s})
+	}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			ops = append(ops, op{' ', x[i]})
+			i++
+			j++
+		case i < len(x) && (j == len(y) || common[i+1][j] >= common[i][j+1]):
+			ops = append(ops, op{'-', x[i]})
+			i++
+		default:
+			ops = append(ops, op{'+', y[j]})
+			j++
+		}
+	}
+	for _, s := range a[len(a)-suf:] {
+		ops = append(ops, op{' ', s})
+	}
+	return ops
+}
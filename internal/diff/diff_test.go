@@ -0,0 +1,70 @@
+// Copyright 2018 solidcoredata authors.
+
+package diff
+
+import (
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	list := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{"change", "a\nb\nc\n", "a\nB\nc\n", `--- x.orig
++++ x
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+`},
+		{"context", "1\n2\n3\n4\n5\n6\n7\n8\n9\n", "1\n2\n3\n4\nfive\n6\n7\n8\n9\n", `--- x.orig
++++ x
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+`},
+		{"two hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n", `--- x.orig
++++ x
+@@ -1,4 +1,4 @@
+-1
++one
+ 2
+ 3
+ 4
+@@ -7,4 +7,4 @@
+ 7
+ 8
+ 9
+-10
++ten
+`},
+		{"insert", "", "a\n", `--- x.orig
++++ x
+@@ -0,0 +1 @@
++a
+`},
+		{"no newline", "a\nb", "a\nb\n", `--- x.orig
++++ x
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++b
+`},
+	}
+	for _, item := range list {
+		if got := string(Unified("x.orig", "x", []byte(item.old), []byte(item.new))); got != item.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", item.name, got, item.want)
+		}
+	}
+}
@@ -1,11 +1,449 @@
 // Package pf is a parser framework.
+//
+// Declaration syntax is described as data, a tree of Rules, and interpreted
+// against a token list to produce a tree of Nodes. The caller lexes the
+// source and converts the resulting Nodes into its own AST.
+//
+//	{type: "varblock", options: [
+//		{type: "property"},
+//		{type: "line", name: "column", parts: [
+//			{type: "identifier", name: "name"},
+//			{type: "identifier", name: "type"},
+//			{type: "varblock", optional: true, options: [
+//				{type: "property"},
+//			]},
+//		]},
+//	]}
 package pf
 
 // Pass 1: lex into tokens, comments, strings, braces.
-// Pass 2:
+// Pass 2: interpret the rules against the tokens.
 
-type token interface{}
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
 
-type identifier struct {
-	Text string
+// Rule types.
+const (
+	Keyword    = "keyword"    // Identifier with the text ID.
+	Identifier = "identifier" // Any identifier.
+	Symbol     = "symbol"     // Symbol with the text ID.
+	Value      = "value"      // Identifier, number, or string.
+	Property   = "property"   // "key: value" where value is the rest of the item, possibly empty.
+	Rest       = "rest"       // One or more tokens to the end of the item or an unopened bracket. Lines within brackets are part of the item.
+	Line       = "line"       // Parts in order, followed by the end of the line.
+	Sequence   = "sequence"   // Parts in order.
+	Choice     = "choice"     // The first matching option.
+	Repeat     = "repeat"     // Parts in order, zero or more times.
+	Varblock   = "varblock"   // "{" items "}" where each item matches one of the options. Items are separated by newlines or ",". ID may be "(" for "(" items ")".
+)
+
+// Rule describes a piece of syntax.
+type Rule struct {
+	Type     string  `json:"type"`
+	ID       string  `json:"id,omitempty"`
+	Name     string  `json:"name,omitempty"` // Name of the produced node, used to find it.
+	Optional bool    `json:"optional,omitempty"`
+	Parts    []*Rule `json:"parts,omitempty"`
+	Options  []*Rule `json:"options,omitempty"`
+}
+
+// ReadRule reads a rule encoded as JSON.
+func ReadRule(r io.Reader) (*Rule, error) {
+	rule := &Rule{}
+	if err := json.NewDecoder(r).Decode(rule); err != nil {
+		return nil, err
+	}
+	return rule, rule.check()
+}
+
+func (r *Rule) check() error {
+	switch r.Type {
+	default:
+		return fmt.Errorf("pf: unknown rule type %q", r.Type)
+	case Keyword, Symbol:
+		if len(r.ID) == 0 {
+			return fmt.Errorf("pf: %s rule missing id", r.Type)
+		}
+	case Varblock:
+		switch r.ID {
+		case "", "{", "(":
+		default:
+			return fmt.Errorf("pf: varblock rule has unknown bracket %q", r.ID)
+		}
+	case Identifier, Value, Property, Rest, Choice:
+	case Line, Sequence, Repeat:
+		if len(r.Parts) == 0 {
+			return fmt.Errorf("pf: %s rule missing parts", r.Type)
+		}
+	}
+	switch r.Type {
+	case Choice, Varblock:
+		if len(r.Options) == 0 {
+			return fmt.Errorf("pf: %s rule missing options", r.Type)
+		}
+	}
+	for _, p := range r.Parts {
+		if err := p.check(); err != nil {
+			return err
+		}
+	}
+	for _, o := range r.Options {
+		if err := o.check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TokenType is the class of a token as seen by the framework.
+type TokenType int
+
+const (
+	TokenOther TokenType = iota
+	TokenIdentifier
+	TokenSymbol
+	TokenNumber
+	TokenString
+	TokenNewline
+)
+
+// Token is a lexed token. Index is the position of the token in the
+// caller's token list, used to map nodes back to source positions.
+type Token struct {
+	Type  TokenType
+	Value string
+	Index int
+}
+
+// Node is produced by a matched rule. Keyword, identifier, symbol, and
+// value nodes set Value to the token text. Property nodes set Key and Value.
+// Tokens Start through End-1 were consumed by the node.
+type Node struct {
+	Rule  *Rule
+	Key   string
+	Value string
+	Child []*Node
+	Start int
+	End   int
+}
+
+// Name of the rule that produced the node.
+func (n *Node) Name() string {
+	return n.Rule.Name
+}
+
+// Find returns the first named descendant, or nil if not found.
+func (n *Node) Find(name string) *Node {
+	for _, c := range n.Child {
+		if c.Rule.Name == name {
+			return c
+		}
+		if f := c.Find(name); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// All returns each named descendant. Descendants of a match are not searched.
+func (n *Node) All(name string) []*Node {
+	var list []*Node
+	for _, c := range n.Child {
+		if c.Rule.Name == name {
+			list = append(list, c)
+			continue
+		}
+		list = append(list, c.All(name)...)
+	}
+	return list
+}
+
+// Error is a syntax error at a token.
+type Error struct {
+	Token   Token
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Parse matches the rule starting at tok[pos]. It returns the node and
+// the position after the last consumed token.
+func Parse(rule *Rule, tok []Token, pos int) (*Node, int, error) {
+	p := &parser{tok: tok}
+	n, end, ok := p.match(rule, pos)
+	if !ok {
+		return nil, pos, p.err()
+	}
+	return n, end, nil
+}
+
+type parser struct {
+	tok []Token
+
+	// The furthest failure is reported as the error.
+	failPos    int
+	failExpect []string
+}
+
+func (p *parser) at(pos int) Token {
+	if pos >= len(p.tok) {
+		return Token{Type: TokenOther, Index: -1}
+	}
+	return p.tok[pos]
+}
+
+func (p *parser) fail(pos int, expect string) {
+	switch {
+	case pos > p.failPos:
+		p.failPos = pos
+		p.failExpect = []string{expect}
+	case pos == p.failPos:
+		for _, e := range p.failExpect {
+			if e == expect {
+				return
+			}
+		}
+		p.failExpect = append(p.failExpect, expect)
+	}
+}
+
+func (p *parser) err() error {
+	tok := p.at(p.failPos)
+	got := fmt.Sprintf("%q", tok.Value)
+	switch {
+	case tok.Index < 0:
+		got = "end of file"
+	case tok.Type == TokenNewline:
+		got = "newline"
+	}
+	return &Error{
+		Token:   tok,
+		Message: fmt.Sprintf("unexpected %s, expected %s", got, strings.Join(p.failExpect, " or ")),
+	}
+}
+
+func (p *parser) skipNewline(pos int) int {
+	for p.at(pos).Type == TokenNewline {
+		pos++
+	}
+	return pos
+}
+
+func (p *parser) isSymbol(pos int, v string) bool {
+	tok := p.at(pos)
+	return tok.Type == TokenSymbol && tok.Value == v
+}
+
+// match the rule at pos. Optional rules always match.
+func (p *parser) match(r *Rule, pos int) (*Node, int, bool) {
+	n, end, ok := p.matchRule(r, pos)
+	if !ok && r.Optional {
+		return nil, pos, true
+	}
+	return n, end, ok
+}
+
+func (p *parser) matchRule(r *Rule, pos int) (*Node, int, bool) {
+	tok := p.at(pos)
+	leaf := func(ok bool, expect string) (*Node, int, bool) {
+		if !ok {
+			p.fail(pos, expect)
+			return nil, pos, false
+		}
+		return &Node{Rule: r, Value: tok.Value, Start: pos, End: pos + 1}, pos + 1, true
+	}
+	switch r.Type {
+	default:
+		panic(fmt.Sprintf("pf: unknown rule type %q", r.Type))
+	case Keyword:
+		return leaf(tok.Type == TokenIdentifier && tok.Value == r.ID, fmt.Sprintf("%q", r.ID))
+	case Symbol:
+		return leaf(tok.Type == TokenSymbol && tok.Value == r.ID, fmt.Sprintf("%q", r.ID))
+	case Identifier:
+		return leaf(tok.Type == TokenIdentifier, "identifier")
+	case Value:
+		switch tok.Type {
+		case TokenIdentifier, TokenNumber, TokenString:
+			return leaf(true, "")
+		}
+		return leaf(false, "value")
+	case Property:
+		return p.property(r, pos)
+	case Rest:
+		return p.rest(r, pos)
+	case Line, Sequence:
+		n := &Node{Rule: r, Start: pos}
+		end, ok := p.parts(n, r.Parts, pos)
+		if !ok {
+			return nil, pos, false
+		}
+		if r.Type == Line {
+			switch {
+			case p.at(end).Type == TokenNewline:
+				end++
+			case p.at(end).Index < 0, p.isSymbol(end, "}"), p.isSymbol(end, ")"), p.isSymbol(end, ","):
+			default:
+				p.fail(end, "end of line")
+				return nil, pos, false
+			}
+		}
+		n.End = end
+		return n, end, true
+	case Repeat:
+		n := &Node{Rule: r, Start: pos}
+		end := pos
+		for {
+			next, ok := p.parts(n, r.Parts, end)
+			if !ok || next == end {
+				break
+			}
+			end = next
+		}
+		n.End = end
+		return n, end, true
+	case Choice:
+		for _, o := range r.Options {
+			if c, end, ok := p.match(o, pos); ok {
+				return &Node{Rule: r, Child: nonNil(c), Start: pos, End: end}, end, true
+			}
+		}
+		return nil, pos, false
+	case Varblock:
+		return p.varblock(r, pos)
+	}
+}
+
+func nonNil(n *Node) []*Node {
+	if n == nil {
+		return nil
+	}
+	return []*Node{n}
+}
+
+// parts matches each rule in order, adding the produced nodes to n.
+func (p *parser) parts(n *Node, parts []*Rule, pos int) (int, bool) {
+	start := len(n.Child)
+	for _, part := range parts {
+		c, end, ok := p.match(part, pos)
+		if !ok {
+			n.Child = n.Child[:start]
+			return pos, false
+		}
+		if c != nil {
+			n.Child = append(n.Child, c)
+		}
+		pos = end
+	}
+	return pos, true
+}
+
+// property matches "key:" followed by the text of each token up to the
+// end of the line, a ",", or a "}".
+func (p *parser) property(r *Rule, pos int) (*Node, int, bool) {
+	key := p.at(pos)
+	if key.Type != TokenIdentifier {
+		p.fail(pos, "property")
+		return nil, pos, false
+	}
+	if !p.isSymbol(pos+1, ":") {
+		p.fail(pos+1, `":"`)
+		return nil, pos, false
+	}
+	end := pos + 2
+	var value []string
+	for {
+		tok := p.at(end)
+		if tok.Index < 0 || tok.Type == TokenNewline || p.isSymbol(end, ",") || p.isSymbol(end, "}") {
+			break
+		}
+		value = append(value, tok.Value)
+		end++
+	}
+	if p.at(end).Type == TokenNewline {
+		end++
+	}
+	return &Node{Rule: r, Key: key.Value, Value: strings.Join(value, " "), Start: pos, End: end}, end, true
+}
+
+// rest matches the tokens up to the end of the line, a ",", or a closing
+// bracket that is not within brackets. Value is the text of each token.
+func (p *parser) rest(r *Rule, pos int) (*Node, int, bool) {
+	end := pos
+	depth := 0
+	var value []string
+	for {
+		tok := p.at(end)
+		if tok.Index < 0 {
+			break
+		}
+		if depth == 0 && (tok.Type == TokenNewline || p.isSymbol(end, ",")) {
+			break
+		}
+		if tok.Type == TokenSymbol {
+			switch tok.Value {
+			case "(", "{", "[":
+				depth++
+			case ")", "}", "]":
+				depth--
+			}
+		}
+		if depth < 0 {
+			break
+		}
+		if tok.Type != TokenNewline {
+			value = append(value, tok.Value)
+		}
+		end++
+	}
+	if end == pos {
+		p.fail(pos, "value")
+		return nil, pos, false
+	}
+	return &Node{Rule: r, Value: strings.Join(value, " "), Start: pos, End: end}, end, true
+}
+
+func (p *parser) varblock(r *Rule, pos int) (*Node, int, bool) {
+	open, close := "{", "}"
+	if r.ID == "(" {
+		open, close = "(", ")"
+	}
+	if !p.isSymbol(pos, open) {
+		p.fail(pos, fmt.Sprintf("%q", open))
+		return nil, pos, false
+	}
+	n := &Node{Rule: r, Start: pos}
+	end := pos + 1
+	for {
+		end = p.skipNewline(end)
+		if p.isSymbol(end, close) {
+			end++
+			n.End = end
+			return n, end, true
+		}
+		var item *Node
+		next := end
+		for _, o := range r.Options {
+			if c, e, ok := p.match(o, end); ok && e > end {
+				item, next = c, e
+				break
+			}
+		}
+		if next == end {
+			p.fail(end, fmt.Sprintf("%q", close))
+			return nil, pos, false
+		}
+		if item != nil {
+			n.Child = append(n.Child, item)
+		}
+		end = next
+		if p.isSymbol(end, ",") {
+			end++
+		}
+	}
 }
@@ -0,0 +1,169 @@
+package pf
+
+import (
+	"strings"
+	"testing"
+)
+
+// tokens splits src on spaces. "\n" is a newline, "{", "}", "(", ")", ":",
+// ",", "*", and "." are symbols, quoted text is a string, and digits are numbers.
+func tokens(src string) []Token {
+	var list []Token
+	for i, f := range strings.Fields(strings.ReplaceAll(src, "\n", " \\n ")) {
+		t := Token{Type: TokenIdentifier, Value: f, Index: i}
+		switch {
+		case f == `\n`:
+			t.Type = TokenNewline
+		case strings.Contains("{}():,*.", f):
+			t.Type = TokenSymbol
+		case strings.HasPrefix(f, "'"):
+			t.Type = TokenString
+		case f[0] >= '0' && f[0] <= '9':
+			t.Type = TokenNumber
+		}
+		t.Index = len(list)
+		list = append(list, t)
+	}
+	return list
+}
+
+const tableRule = `{"type": "varblock", "options": [
+	{"type": "property", "name": "property"},
+	{"type": "line", "name": "column", "parts": [
+		{"type": "identifier", "name": "name"},
+		{"type": "identifier", "name": "type"},
+		{"type": "repeat", "parts": [
+			{"type": "choice", "options": [
+				{"type": "keyword", "id": "key", "name": "attr"},
+				{"type": "sequence", "name": "default", "parts": [
+					{"type": "keyword", "id": "default"},
+					{"type": "value", "name": "value"}
+				]}
+			]}
+		]},
+		{"type": "varblock", "optional": true, "options": [
+			{"type": "property", "name": "property"}
+		]}
+	]}
+]}`
+
+func TestParse(t *testing.T) {
+	rule, err := ReadRule(strings.NewReader(tableRule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok := tokens(`{
+	alias : a
+	id int64 key
+	name text default 'Bob' { null : , display : Full Name }
+} next`)
+	n, end, err := Parse(rule, tok, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := tok[end].Value, "next"; g != w {
+		t.Fatalf("ended at %q, want %q", g, w)
+	}
+	if g, w := len(n.Child), 3; g != w {
+		t.Fatalf("got %d items, want %d", g, w)
+	}
+	if p := n.Child[0]; p.Key != "alias" || p.Value != "a" {
+		t.Fatalf("bad property %q: %q", p.Key, p.Value)
+	}
+	cols := n.All("column")
+	if g, w := len(cols), 2; g != w {
+		t.Fatalf("got %d columns, want %d", g, w)
+	}
+	if g, w := cols[0].Find("attr").Value, "key"; g != w {
+		t.Fatalf("attr got %q, want %q", g, w)
+	}
+	if g, w := cols[1].Find("default").Find("value").Value, "'Bob'"; g != w {
+		t.Fatalf("default got %q, want %q", g, w)
+	}
+	props := cols[1].All("property")
+	if len(props) != 2 || props[0].Key != "null" || props[1].Value != "Full Name" {
+		t.Fatalf("bad column properties: %+v", props)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	rule, err := ReadRule(strings.NewReader(tableRule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok := tokens("{\n\tid int64 serial\n}")
+	_, _, err = Parse(rule, tok, 0)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	e := err.(*Error)
+	if g, w := e.Token.Value, "serial"; g != w {
+		t.Fatalf("error at %q, want %q", g, w)
+	}
+	t.Log(e)
+}
+
+func TestParseRest(t *testing.T) {
+	rule, err := ReadRule(strings.NewReader(`{"type": "varblock", "options": [
+		{"type": "line", "name": "read", "parts": [
+			{"type": "keyword", "id": "read"},
+			{"type": "symbol", "id": ":"},
+			{"type": "rest", "name": "exp"}
+		]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok := tokens("{\n\tread : a = 1\n\tread : and ( a ,\n b ) , read : c }")
+	n, end, err := Parse(rule, tok, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end != len(tok) {
+		t.Fatalf("ended at %d, want %d", end, len(tok))
+	}
+	var got []string
+	for _, e := range n.All("exp") {
+		got = append(got, e.Value)
+	}
+	if g, w := strings.Join(got, "; "), "a = 1; and ( a , b ); c"; g != w {
+		t.Fatalf("got %q, want %q", g, w)
+	}
+
+	_, _, err = Parse(rule, tokens("{ read : }"), 0)
+	if err == nil {
+		t.Fatal("expected missing value error")
+	}
+}
+
+func TestParseParenBlock(t *testing.T) {
+	rule, err := ReadRule(strings.NewReader(`{"type": "varblock", "id": "(", "options": [
+		{"type": "line", "name": "spec", "parts": [{"type": "rest"}]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok := tokens("( a . b\n c d ) next")
+	n, end, err := Parse(rule, tok, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := tok[end].Value, "next"; g != w {
+		t.Fatalf("ended at %q, want %q", g, w)
+	}
+	if g, w := len(n.All("spec")), 2; g != w {
+		t.Fatalf("got %d items, want %d", g, w)
+	}
+
+	_, err = ReadRule(strings.NewReader(`{"type": "varblock", "id": "[", "options": [{"type": "rest"}]}`))
+	if err == nil {
+		t.Fatal("expected unknown bracket error")
+	}
+}
+
+func TestReadRuleError(t *testing.T) {
+	_, err := ReadRule(strings.NewReader(`{"type": "varblock"}`))
+	if err == nil {
+		t.Fatal("expected missing options error")
+	}
+}
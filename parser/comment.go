@@ -0,0 +1,92 @@
+// Copyright 2018 solidcoredata authors.
+
+package parser
+
+import (
+	"sort"
+	"strings"
+)
+
+// Comments are kept out of the token list and attached to the nodes around
+// them as the nodes are parsed. Comments are attached in source order, so a
+// comment that can't be placed above, right of, or below its nearest node
+// is attached above the next node.
+
+func newComment(tok Token, pos CommentPosition) Comment {
+	return Comment{
+		Pos:       pos,
+		MultiLine: tok.Type == TokenMultiComment,
+		Text:      strings.TrimRight(tok.Value, " \t\r\n"),
+	}
+}
+
+// commentsBefore returns the unattached comments that start before pos.
+func (p *Parser) commentsBefore(pos Position, at CommentPosition) []Comment {
+	var list []Comment
+	for p.ci < len(p.comment) && p.comment[p.ci].Start.Byte < pos.Byte {
+		list = append(list, newComment(p.comment[p.ci], at))
+		p.ci++
+	}
+	return list
+}
+
+// above returns the unattached comments before the next token.
+func (p *Parser) above() []Comment {
+	if p.eof() {
+		return nil
+	}
+	return p.commentsBefore(p.peek().Start, CommentAbove)
+}
+
+// below returns the unattached comments before the next token, which
+// closes a block.
+func (p *Parser) below() []Comment {
+	if p.eof() {
+		return nil
+	}
+	return p.commentsBefore(p.peek().Start, CommentBelow)
+}
+
+// rest returns all unattached comments.
+func (p *Parser) rest(at CommentPosition) []Comment {
+	return p.commentsBefore(Position{Byte: int(^uint(0) >> 1)}, at)
+}
+
+// right returns the unattached comments on the line of the last token read.
+func (p *Parser) right() []Comment {
+	i := p.i - 1
+	for i >= 0 && p.tok[i].Type == TokenNewline {
+		i--
+	}
+	if i < 0 {
+		return nil
+	}
+	return p.rightOf(p.tok[i].End)
+}
+
+// rightOf returns the unattached comments after end on the same line,
+// unless another token lies between end and the comments.
+func (p *Parser) rightOf(end Position) []Comment {
+	var list []Comment
+	next := sort.Search(len(p.tok), func(i int) bool {
+		return p.tok[i].Start.Byte >= end.Byte
+	})
+	for p.ci < len(p.comment) {
+		c := p.comment[p.ci]
+		if c.Start.Line != end.Line || c.Start.Byte < end.Byte {
+			break
+		}
+		if next < len(p.tok) && p.tok[next].Type != TokenNewline && p.tok[next].Start.Byte < c.Start.Byte {
+			break
+		}
+		list = append(list, newComment(c, CommentRight))
+		p.ci++
+	}
+	return list
+}
+
+// noteItem attaches the comments above an item and to the right of it.
+func noteItem(n *Notes, above, right []Comment) {
+	n.Note = append(n.Note, above...)
+	n.Note = append(n.Note, right...)
+}
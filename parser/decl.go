@@ -0,0 +1,633 @@
+// Copyright 2018 solidcoredata authors.
+
+package parser
+
+import (
+	"strings"
+
+	"github.com/solidcoredata/dbc/internal/pf"
+)
+
+// tableRule describes the body of a table declaration.
+//
+//	account table {
+//		alias: a
+//		display: Personal Account
+//		read: a.deleted = false
+//
+//		id int64 serial key
+//		name text {display: Name, tag: search}
+//		owner *role.user.id null
+//		deleted bool
+//		version int64 lock
+//
+//		port web {
+//			role owner: read return update
+//			deny update: a.owner <> user
+//			column name {
+//				role owner: read return
+//			}
+//		}
+//	}
+var tableRule = &pf.Rule{Type: pf.Varblock, Options: []*pf.Rule{
+	{Type: pf.Line, Name: "read", Parts: []*pf.Rule{
+		{Type: pf.Keyword, ID: "read"},
+		{Type: pf.Symbol, ID: ":"},
+		{Type: pf.Rest, Name: "exp"},
+	}},
+	{Type: pf.Property, Name: "property"},
+	{Type: pf.Line, Name: "column", Parts: []*pf.Rule{
+		{Type: pf.Identifier, Name: "name"},
+		{Type: pf.Choice, Options: []*pf.Rule{
+			{Type: pf.Sequence, Name: "link", Parts: []*pf.Rule{
+				{Type: pf.Symbol, ID: "*"},
+				{Type: pf.Identifier, Name: "part"},
+				{Type: pf.Repeat, Parts: []*pf.Rule{
+					{Type: pf.Symbol, ID: "."},
+					{Type: pf.Identifier, Name: "part"},
+				}},
+			}},
+			{Type: pf.Identifier, Name: "type"},
+		}},
+		{Type: pf.Repeat, Parts: []*pf.Rule{
+			{Type: pf.Choice, Options: []*pf.Rule{
+				{Type: pf.Keyword, ID: "key", Name: "attr"},
+				{Type: pf.Keyword, ID: "serial", Name: "attr"},
+				{Type: pf.Keyword, ID: "null", Name: "attr"},
+				{Type: pf.Keyword, ID: "lock", Name: "attr"},
+				{Type: pf.Keyword, ID: "readonly", Name: "attr"},
+				{Type: pf.Sequence, Name: "default", Parts: []*pf.Rule{
+					{Type: pf.Keyword, ID: "default"},
+					{Type: pf.Value, Name: "value"},
+				}},
+			}},
+		}},
+		{Type: pf.Varblock, Name: "properties", Optional: true, Options: []*pf.Rule{
+			{Type: pf.Property, Name: "property"},
+		}},
+	}},
+	{Type: pf.Line, Name: "port", Parts: []*pf.Rule{
+		{Type: pf.Keyword, ID: "port"},
+		{Type: pf.Identifier, Name: "name"},
+		{Type: pf.Varblock, Options: []*pf.Rule{
+			grantRule,
+			denyRule,
+			{Type: pf.Line, Name: "column", Parts: []*pf.Rule{
+				{Type: pf.Keyword, ID: "column"},
+				{Type: pf.Identifier, Name: "name"},
+				{Type: pf.Varblock, Options: []*pf.Rule{grantRule, denyRule}},
+			}},
+		}},
+	}},
+}}
+
+// grantRule is the access granted to a role of a port, "role reader: read".
+var grantRule = &pf.Rule{Type: pf.Line, Name: "role", Parts: []*pf.Rule{
+	{Type: pf.Keyword, ID: "role"},
+	{Type: pf.Identifier, Name: "name"},
+	{Type: pf.Symbol, ID: ":"},
+	{Type: pf.Repeat, Parts: []*pf.Rule{
+		{Type: pf.Identifier, Name: "allow"},
+	}},
+}}
+
+// denyRule is a condition that denies an operation on a row of a port,
+// "deny update: a.closed".
+var denyRule = &pf.Rule{Type: pf.Line, Name: "deny", Parts: []*pf.Rule{
+	{Type: pf.Keyword, ID: "deny"},
+	{Type: pf.Identifier, Name: "op"},
+	{Type: pf.Symbol, ID: ":"},
+	{Type: pf.Rest, Name: "exp"},
+}}
+
+// importRule describes an import declaration after the import keyword.
+//
+//	import coredata.biz/app1/role
+//	import (
+//		coredata.biz/app1/role
+//		r2 coredata.biz/app1/role-v2
+//	)
+var importRule = &pf.Rule{Type: pf.Choice, Options: []*pf.Rule{
+	{Type: pf.Line, Parts: []*pf.Rule{
+		{Type: pf.Varblock, ID: "(", Name: "list", Options: []*pf.Rule{importSpecRule}},
+	}},
+	importSpecRule,
+}}
+
+// importSpecRule is the path of an import, optionally after its name.
+var importSpecRule = &pf.Rule{Type: pf.Line, Name: "spec", Parts: []*pf.Rule{
+	{Type: pf.Choice, Options: []*pf.Rule{
+		{Type: pf.Sequence, Parts: []*pf.Rule{
+			{Type: pf.Identifier, Name: "name"},
+			pathRule,
+		}},
+		pathRule,
+	}},
+}}
+
+// pathRule describes a path such as "coredata.biz/app1/role", made of
+// identifier and number parts and ".", "/", "-" symbols. The parts must be
+// adjacent, which is checked by path.
+var pathRule = &pf.Rule{Type: pf.Sequence, Name: "path", Parts: []*pf.Rule{
+	{Type: pf.Identifier, Name: "part"},
+	{Type: pf.Repeat, Parts: []*pf.Rule{
+		{Type: pf.Choice, Options: []*pf.Rule{
+			{Type: pf.Symbol, ID: ".", Name: "part"},
+			{Type: pf.Symbol, ID: "/", Name: "part"},
+			{Type: pf.Symbol, ID: "-", Name: "part"},
+			{Type: pf.Value, Name: "part"},
+		}},
+	}},
+}}
+
+// testRule describes the body of a test declaration.
+//
+//	books test {
+//		query book.list
+//		port web
+//		role reader
+//		param limit = 10
+//		input book (id, name) {
+//			1, 'Emma'
+//		}
+//		output (name) {
+//			'Emma'
+//		}
+//		error 'text'
+//	}
+var testRule = &pf.Rule{Type: pf.Varblock, Options: []*pf.Rule{
+	{Type: pf.Line, Name: "query", Parts: []*pf.Rule{
+		{Type: pf.Keyword, ID: "query"},
+		{Type: pf.Rest, Name: "name"},
+	}},
+	{Type: pf.Line, Name: "port", Parts: []*pf.Rule{
+		{Type: pf.Keyword, ID: "port"},
+		{Type: pf.Identifier, Name: "name"},
+	}},
+	{Type: pf.Line, Name: "role", Parts: []*pf.Rule{
+		{Type: pf.Keyword, ID: "role"},
+		{Type: pf.Identifier, Name: "name"},
+		{Type: pf.Repeat, Parts: []*pf.Rule{
+			{Type: pf.Identifier, Name: "name"},
+		}},
+	}},
+	{Type: pf.Line, Name: "param", Parts: []*pf.Rule{
+		{Type: pf.Keyword, ID: "param"},
+		{Type: pf.Identifier, Name: "name"},
+		{Type: pf.Symbol, ID: "="},
+		{Type: pf.Rest, Name: "value"},
+	}},
+	{Type: pf.Line, Name: "input", Parts: []*pf.Rule{
+		{Type: pf.Keyword, ID: "input"},
+		{Type: pf.Sequence, Name: "table", Parts: []*pf.Rule{
+			{Type: pf.Identifier},
+			{Type: pf.Sequence, Optional: true, Parts: []*pf.Rule{
+				{Type: pf.Symbol, ID: "."},
+				{Type: pf.Identifier},
+			}},
+		}},
+		testRowsRule,
+	}},
+	{Type: pf.Line, Name: "output", Parts: []*pf.Rule{
+		{Type: pf.Keyword, ID: "output"},
+		testRowsRule,
+	}},
+	{Type: pf.Line, Name: "error", Parts: []*pf.Rule{
+		{Type: pf.Keyword, ID: "error"},
+		{Type: pf.Value, Name: "text"},
+	}},
+}}
+
+// testRowsRule is the column list and the block of rows of a test input or
+// output, one row of comma separated values per line.
+var testRowsRule = &pf.Rule{Type: pf.Sequence, Name: "rows", Parts: []*pf.Rule{
+	{Type: pf.Symbol, ID: "("},
+	{Type: pf.Value, Name: "column"},
+	{Type: pf.Repeat, Parts: []*pf.Rule{
+		{Type: pf.Symbol, ID: ","},
+		{Type: pf.Value, Name: "column"},
+	}},
+	{Type: pf.Symbol, ID: ")"},
+	{Type: pf.Varblock, Name: "block", Options: []*pf.Rule{
+		{Type: pf.Line, Name: "row", Parts: []*pf.Rule{
+			{Type: pf.Rest, Name: "value"},
+			{Type: pf.Repeat, Parts: []*pf.Rule{
+				{Type: pf.Symbol, ID: ","},
+				{Type: pf.Rest, Name: "value"},
+			}},
+		}},
+	}},
+}}
+
+// pfTokens converts the parser tokens for use with the parser framework.
+func (p *Parser) pfTokens() []pf.Token {
+	if len(p.pf) == len(p.tok) {
+		return p.pf
+	}
+	p.pf = make([]pf.Token, len(p.tok))
+	for i, tok := range p.tok {
+		t := pf.Token{Value: tok.Value, Index: i}
+		switch tok.Type {
+		case TokenIdentifier:
+			t.Type = pf.TokenIdentifier
+		case TokenSymbol:
+			t.Type = pf.TokenSymbol
+		case TokenNumber:
+			t.Type = pf.TokenNumber
+		case TokenString, TokenStringWithEscape, TokenIdentifierQuoted:
+			t.Type = pf.TokenString
+		case TokenNewline:
+			t.Type = pf.TokenNewline
+		}
+		p.pf[i] = t
+	}
+	return p.pf
+}
+
+// parseRule parses the rule at the current token.
+func (p *Parser) parseRule(rule *pf.Rule) *pf.Node {
+	n, end, err := pf.Parse(rule, p.pfTokens(), p.i)
+	if err != nil {
+		e := err.(*pf.Error)
+		p.i = len(p.tok)
+		if e.Token.Index >= 0 {
+			p.i = e.Token.Index
+		}
+		p.errorf(p.peek(), "%s", e.Message)
+	}
+	p.i = end
+	return n
+}
+
+// tokenAt returns the first token of the node.
+func (p *Parser) tokenAt(n *pf.Node) Token {
+	if n.Start < len(p.tok) {
+		return p.tok[n.Start]
+	}
+	return p.peekAt(len(p.tok))
+}
+
+// lastToken returns the last token of the node that is not a newline.
+func (p *Parser) lastToken(n *pf.Node) Token {
+	i := n.End - 1
+	for i > n.Start && p.tok[i].Type == TokenNewline {
+		i--
+	}
+	return p.tok[i]
+}
+
+// propertyValue returns the source text of a property value. Values are
+// made of any tokens, so the text is used to keep the original spacing.
+func (p *Parser) propertyValue(n *pf.Node) string {
+	start, end := n.Start+2, n.End
+	for end > start && (p.tok[end-1].Type == TokenNewline || isSymbol(p.tok[end-1], ",")) {
+		end--
+	}
+	if start >= end || len(p.src) == 0 {
+		return n.Value
+	}
+	from, to := p.tok[start].Start.Byte, p.tok[end-1].End.Byte
+	// A comment within the value is attached to a node instead.
+	for _, c := range p.comment[p.ci:] {
+		if c.Start.Byte >= to {
+			break
+		}
+		if c.Start.Byte > from {
+			return n.Value
+		}
+	}
+	return p.src[from:to]
+}
+
+func (p *Parser) parseTable(name Token) Table {
+	t := Table{Name: name.Value}
+	n := p.parseRule(tableRule)
+	t.Note = p.rightOf(p.tok[n.Start].End)
+	for _, c := range n.Child {
+		switch c.Name() {
+		case "property":
+			v := p.propertyValue(c)
+			switch c.Key {
+			default:
+				p.errorf(p.tokenAt(c), "unknown table property %q", c.Key)
+			case "alias":
+				t.Alias = v
+			case "display":
+				t.Display = v
+			case "comment":
+				t.Comment = v
+			case "tag":
+				t.Tag = append(t.Tag, v)
+			case "readonly":
+				switch v {
+				default:
+					p.errorf(p.tokenAt(c), "unknown readonly %q, expected true or false", v)
+				case "true":
+					t.ReadOnly = true
+				case "false":
+					t.ReadOnly = false
+				}
+			}
+		case "read":
+			t.Read = append(t.Read, p.rowRule(c, "read"))
+		case "column":
+			above := p.commentsBefore(p.tokenAt(c).Start, CommentAbove)
+			col := p.tableColumn(c)
+			noteItem(&col.Notes, above, p.rightOf(p.lastToken(c).End))
+			t.Column = append(t.Column, col)
+		case "port":
+			t.Port = append(t.Port, p.tablePort(c))
+		}
+	}
+	t.Note = append(t.Note, p.commentsBefore(p.tok[n.End-1].Start, CommentBelow)...)
+	return t
+}
+
+func (p *Parser) tableColumn(n *pf.Node) TableColumn {
+	col := TableColumn{Name: n.Find("name").Value}
+	if link := n.Find("link"); link != nil {
+		parts := link.All("part")
+		if len(parts) < 2 {
+			p.errorf(p.tokenAt(link), "link must reference a table column")
+		}
+		names := make([]string, len(parts)-1)
+		for i, part := range parts[:len(parts)-1] {
+			names[i] = part.Value
+		}
+		col.LinkTable = strings.Join(names, ".")
+		col.LinkColumn = parts[len(parts)-1].Value
+	} else {
+		col.Type = n.Find("type").Value
+	}
+	for _, attr := range n.All("attr") {
+		switch attr.Value {
+		case "key":
+			col.Key = true
+		case "serial":
+			col.Serial = true
+		case "null":
+			col.Nullable = true
+		case "lock":
+			col.UpdateLock = true
+			col.DeleteLock = true
+		case "readonly":
+			col.ReadOnly = true
+		}
+	}
+	if def := n.Find("default"); def != nil {
+		col.Default = def.Find("value").Value
+	}
+	for _, prop := range n.All("property") {
+		v := p.propertyValue(prop)
+		switch prop.Key {
+		default:
+			p.errorf(p.tokenAt(prop), "unknown column property %q", prop.Key)
+		case "key":
+			col.Key = true
+		case "serial":
+			col.Serial = true
+		case "null":
+			col.Nullable = true
+		case "readonly":
+			col.ReadOnly = true
+		case "lock":
+			switch v {
+			default:
+				p.errorf(p.tokenAt(prop), "unknown lock %q, expected update or delete", v)
+			case "update":
+				col.UpdateLock = true
+			case "delete":
+				col.DeleteLock = true
+			}
+		case "default":
+			col.Default = v
+		case "display":
+			col.Display = v
+		case "comment":
+			col.Comment = v
+		case "tag":
+			col.Tag = append(col.Tag, v)
+		}
+	}
+	return col
+}
+
+// within runs fn with the parser at the first token of the node, for the
+// parts of a declaration that are parsed by hand, such as expressions.
+// Each token of the node must be read by fn.
+func (p *Parser) within(n *pf.Node, fn func()) {
+	i := p.i
+	p.i = n.Start
+	fn()
+	if p.i < n.End {
+		p.errorf(p.peek(), "unexpected %s", describe(p.peek()))
+	}
+	p.i = i
+}
+
+// rowRule parses the condition of a read or deny rule line.
+func (p *Parser) rowRule(n *pf.Node, op string) TableRule {
+	r := TableRule{Op: op}
+	above := p.commentsBefore(p.tokenAt(n).Start, CommentAbove)
+	p.within(n.Find("exp"), func() {
+		r.Exp = p.parseItem()
+	})
+	noteItem(&r.Notes, above, p.rightOf(p.lastToken(n).End))
+	return r
+}
+
+// tablePort parses a port block of a table.
+func (p *Parser) tablePort(n *pf.Node) TablePort {
+	port := TablePort{Name: n.Find("name").Value}
+	above := p.commentsBefore(p.tokenAt(n).Start, CommentAbove)
+	noteItem(&port.Notes, above, p.rightOf(p.tok[n.Start+2].End))
+	body := n.Child[len(n.Child)-1]
+	for _, c := range body.Child {
+		switch c.Name() {
+		case "role":
+			port.Role = append(port.Role, p.roleGrant(c))
+		case "deny":
+			port.Deny = append(port.Deny, p.rowRule(c, c.Find("op").Value))
+		case "column":
+			port.Column = append(port.Column, p.columnPort(c))
+		}
+	}
+	port.Note = append(port.Note, p.commentsBefore(p.tok[body.End-1].Start, CommentBelow)...)
+	return port
+}
+
+func (p *Parser) columnPort(n *pf.Node) ColumnPort {
+	col := ColumnPort{Name: n.Find("name").Value}
+	above := p.commentsBefore(p.tokenAt(n).Start, CommentAbove)
+	noteItem(&col.Notes, above, p.rightOf(p.tok[n.Start+2].End))
+	body := n.Child[len(n.Child)-1]
+	for _, c := range body.Child {
+		switch c.Name() {
+		case "role":
+			col.Role = append(col.Role, p.roleGrant(c))
+		case "deny":
+			col.Deny = append(col.Deny, p.rowRule(c, c.Find("op").Value))
+		}
+	}
+	col.Note = append(col.Note, p.commentsBefore(p.tok[body.End-1].Start, CommentBelow)...)
+	return col
+}
+
+func (p *Parser) roleGrant(n *pf.Node) RoleGrant {
+	g := RoleGrant{Role: n.Find("name").Value}
+	above := p.commentsBefore(p.tokenAt(n).Start, CommentAbove)
+	for _, a := range n.All("allow") {
+		g.Allow = append(g.Allow, a.Value)
+	}
+	noteItem(&g.Notes, above, p.rightOf(p.lastToken(n).End))
+	return g
+}
+
+// parseImport parses a single import or a parenthesized list of imports.
+// The comments above the declaration are attached to the first import.
+// An import with an error is skipped.
+func (p *Parser) parseImport(note []Comment) {
+	n := p.parseRule(importRule)
+	list := n.Find("list")
+	if list == nil {
+		list = n
+	}
+	first := len(p.f.Import)
+	for _, c := range list.All("spec") {
+		above := append(note, p.commentsBefore(p.tokenAt(c).Start, CommentAbove)...)
+		note = nil
+		count := len(p.f.Import)
+		i := p.i
+		p.try(func() {
+			p.importSpec(c, above)
+		}, func(int) {})
+		p.i = i
+		if len(p.f.Import) > count {
+			imp := &p.f.Import[count]
+			imp.Note = append(imp.Note, p.rightOf(p.lastToken(c).End)...)
+		}
+	}
+	if last := len(p.f.Import) - 1; last >= first && list != n {
+		imp := &p.f.Import[last]
+		imp.Note = append(imp.Note, p.commentsBefore(p.tok[list.End-1].Start, CommentBelow)...)
+	}
+}
+
+// importSpec adds the import of a spec node.
+func (p *Parser) importSpec(n *pf.Node, note []Comment) {
+	start := p.tokenAt(n)
+	imp := Import{Notes: Notes{Note: note}, Path: p.path(n.Find("path")), Start: start.Start}
+	if name := n.Find("name"); name != nil {
+		imp.Name = name.Value
+	} else {
+		imp.Name = imp.Path[strings.LastIndex(imp.Path, "/")+1:]
+	}
+	for _, existing := range p.f.Import {
+		if existing.Name == imp.Name {
+			p.errorf(start, "%s imported more than once", imp.Name)
+		}
+	}
+	p.f.Import = append(p.f.Import, imp)
+}
+
+// path returns the text of a path node. A path may only be followed by
+// another part of the path without a space between them.
+func (p *Parser) path(n *pf.Node) string {
+	b := &strings.Builder{}
+	part := n.All("part")
+	for i, c := range part {
+		tok := p.tokenAt(c)
+		if i > 0 && tok.Start.Byte != p.tokenAt(part[i-1]).End.Byte {
+			p.errorf(tok, "unexpected %s", describe(tok))
+		}
+		switch tok.Type {
+		default:
+			p.errorf(tok, "unexpected %s in path", describe(tok))
+		case TokenIdentifier, TokenNumber, TokenSymbol:
+		}
+		b.WriteString(tok.Value)
+	}
+	s := b.String()
+	p.checkPath(p.tokenAt(n), s)
+	return s
+}
+
+// parseTest parses the lines of a test declaration.
+func (p *Parser) parseTest(name string) Test {
+	t := Test{Name: name}
+	n := p.parseRule(testRule)
+	t.Note = p.rightOf(p.tok[n.Start].End)
+	for _, c := range n.Child {
+		above := p.commentsBefore(p.tokenAt(c).Start, CommentAbove)
+		var note *Notes
+		switch c.Name() {
+		case "query":
+			p.within(c.Find("name"), func() {
+				t.Query = p.parseTableName()
+			})
+			note = &t.QueryNote
+		case "port":
+			t.Port = c.Find("name").Value
+			note = &t.PortNote
+		case "role":
+			for _, r := range c.All("name") {
+				t.Role = append(t.Role, r.Value)
+			}
+			note = &t.RoleNote
+		case "param":
+			tp := TestParam{Name: c.Find("name").Value}
+			p.within(c.Find("value"), func() {
+				tp.Value = p.parseValue()
+			})
+			noteItem(&tp.Notes, above, p.rightOf(p.lastToken(c).End))
+			t.Param = append(t.Param, tp)
+		case "input":
+			rows := TestRows{}
+			p.within(c.Find("table"), func() {
+				rows.Table = p.parseTableName()
+			})
+			p.testRows(&rows, c.Find("rows"), above)
+			t.Input = append(t.Input, rows)
+		case "output":
+			rows := TestRows{}
+			p.testRows(&rows, c.Find("rows"), above)
+			t.Output = append(t.Output, rows)
+		case "error":
+			msg := p.tokenAt(c.Find("text"))
+			if msg.Type != TokenString && msg.Type != TokenStringWithEscape {
+				p.errorf(msg, "expected error text, got %s", describe(msg))
+			}
+			t.Error = Unquote(msg)
+			note = &t.ErrorNote
+		}
+		if note != nil {
+			noteItem(note, above, p.rightOf(p.lastToken(c).End))
+		}
+	}
+	t.Note = append(t.Note, p.commentsBefore(p.tok[n.End-1].Start, CommentBelow)...)
+	return t
+}
+
+// testRows parses the column list and rows of a test input or output.
+func (p *Parser) testRows(rows *TestRows, n *pf.Node, above []Comment) {
+	for _, c := range n.All("column") {
+		tok := p.tokenAt(c)
+		if tok.Type != TokenIdentifier && tok.Type != TokenIdentifierQuoted {
+			p.errorf(tok, "expected column name, got %s", describe(tok))
+		}
+		rows.Column = append(rows.Column, Unquote(tok))
+	}
+	block := n.Find("block")
+	noteItem(&rows.Notes, above, p.rightOf(p.tok[block.Start].End))
+	for _, c := range block.Child {
+		row := TestRow{Notes: Notes{Note: p.commentsBefore(p.tokenAt(c).Start, CommentAbove)}}
+		for _, v := range c.All("value") {
+			p.within(v, func() {
+				row.Value = append(row.Value, p.parseValue())
+			})
+		}
+		row.Note = append(row.Note, p.rightOf(p.lastToken(c).End)...)
+		rows.Row = append(rows.Row, row)
+	}
+	rows.Note = append(rows.Note, p.commentsBefore(p.tok[block.End-1].Start, CommentBelow)...)
+}
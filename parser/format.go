@@ -0,0 +1,902 @@
+// Copyright 2018 solidcoredata authors.
+
+package parser
+
+import (
+	"bytes"
+	"strings"
+)
+
+// lineWidth is the width lists are kept within before they are split
+// across lines. Tabs count as four.
+const lineWidth = 80
+
+// Format returns the file in its canonical form. Declarations keep their
+// order and comments are kept above, to the right, or below the node they
+// are attached to. The file must parse without errors.
+//
+// Formatting a formatted file returns the same file.
+func Format(f *File) []byte {
+	pr := &printer{}
+	pr.file(f)
+	return pr.buf.Bytes()
+}
+
+type printer struct {
+	buf    bytes.Buffer
+	indent int
+	col    int // Width of the current line, zero at the start of a line.
+}
+
+// write text to the current line.
+func (pr *printer) write(s string) {
+	if len(s) == 0 {
+		return
+	}
+	if pr.col == 0 {
+		for i := 0; i < pr.indent; i++ {
+			pr.buf.WriteByte('\t')
+		}
+		pr.col = pr.indent * 4
+	}
+	pr.buf.WriteString(s)
+	pr.col += len(s)
+}
+
+// newline ends the current line.
+func (pr *printer) newline() {
+	pr.buf.WriteByte('\n')
+	pr.col = 0
+}
+
+// line writes s as a whole line.
+func (pr *printer) line(s string) {
+	pr.write(s)
+	pr.newline()
+}
+
+// blank writes an empty line.
+func (pr *printer) blank() {
+	pr.newline()
+}
+
+// fits reports if s fits on the current line.
+func (pr *printer) fits(s string) bool {
+	col := pr.col
+	if col == 0 {
+		col = pr.indent * 4
+	}
+	return col+len(s) <= lineWidth
+}
+
+func (pr *printer) comments(n *Notes, pos CommentPosition) {
+	for _, c := range n.Note {
+		if c.Pos == pos {
+			pr.line(c.Text)
+		}
+	}
+}
+
+// right writes the comments to the right of a node and ends the line.
+func (pr *printer) right(n *Notes) {
+	for _, c := range n.Note {
+		if c.Pos == CommentRight {
+			pr.write(" " + c.Text)
+		}
+	}
+	pr.newline()
+}
+
+// hasNote reports if the node has a comment in one of the positions.
+func hasNote(n *Notes, pos ...CommentPosition) bool {
+	for _, c := range n.Note {
+		for _, p := range pos {
+			if c.Pos == p {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (pr *printer) file(f *File) {
+	if len(f.Package.Name) > 0 {
+		pr.comments(&f.Package.Notes, CommentAbove)
+		pr.write("package " + f.Package.Name)
+		pr.right(&f.Package.Notes)
+	}
+	pr.imports(f.Import)
+
+	var ti, qi, xi int
+	decl := func(name string) {
+		if pr.buf.Len() > 0 {
+			pr.blank()
+		}
+		switch {
+		case ti < len(f.Table) && f.Table[ti].Name == name:
+			pr.table(&f.Table[ti])
+			ti++
+		case qi < len(f.Query) && f.Query[qi].Name == name:
+			pr.query(&f.Query[qi])
+			qi++
+		default:
+			pr.test(&f.Test[xi])
+			xi++
+		}
+	}
+	for _, name := range f.DeclareOrder {
+		switch {
+		case ti < len(f.Table) && f.Table[ti].Name == name:
+		case qi < len(f.Query) && f.Query[qi].Name == name:
+		case xi < len(f.Test) && f.Test[xi].Name == name:
+		default:
+			continue
+		}
+		decl(name)
+	}
+	for ti < len(f.Table) {
+		decl(f.Table[ti].Name)
+	}
+	for qi < len(f.Query) {
+		decl(f.Query[qi].Name)
+	}
+	for xi < len(f.Test) {
+		decl(f.Test[xi].Name)
+	}
+
+	if len(f.Note) > 0 {
+		if pr.buf.Len() > 0 {
+			pr.blank()
+		}
+		for _, c := range f.Note {
+			pr.line(c.Text)
+		}
+	}
+}
+
+func importSpec(imp *Import) string {
+	if len(imp.Name) > 0 && imp.Name != imp.Path[strings.LastIndex(imp.Path, "/")+1:] {
+		return imp.Name + " " + imp.Path
+	}
+	return imp.Path
+}
+
+func (pr *printer) imports(list []Import) {
+	if len(list) == 0 {
+		return
+	}
+	if pr.buf.Len() > 0 {
+		pr.blank()
+	}
+	if len(list) == 1 && !hasNote(&list[0].Notes, CommentBelow) {
+		imp := &list[0]
+		pr.comments(&imp.Notes, CommentAbove)
+		pr.write("import " + importSpec(imp))
+		pr.right(&imp.Notes)
+		return
+	}
+	pr.line("import (")
+	pr.indent++
+	for i := range list {
+		imp := &list[i]
+		pr.comments(&imp.Notes, CommentAbove)
+		pr.write(importSpec(imp))
+		pr.right(&imp.Notes)
+		pr.comments(&imp.Notes, CommentBelow)
+	}
+	pr.indent--
+	pr.line(")")
+}
+
+func (pr *printer) table(t *Table) {
+	pr.comments(&t.Notes, CommentAbove)
+	pr.write(t.Name + " table {")
+	pr.right(&t.Notes)
+	pr.indent++
+
+	props := 0
+	prop := func(key, value string) {
+		if len(value) > 0 {
+			pr.line(key + ": " + value)
+			props++
+		}
+	}
+	prop("alias", t.Alias)
+	prop("display", t.Display)
+	prop("comment", t.Comment)
+	for _, tag := range t.Tag {
+		prop("tag", tag)
+	}
+	if t.ReadOnly {
+		prop("readonly", "true")
+	}
+	for i := range t.Read {
+		pr.rule(&t.Read[i], "read")
+		props++
+	}
+	if props > 0 && len(t.Column) > 0 {
+		pr.blank()
+	}
+
+	var nameWidth, typeWidth int
+	for i := range t.Column {
+		col := &t.Column[i]
+		if n := len(col.Name); n > nameWidth {
+			nameWidth = n
+		}
+		if n := len(columnType(col)); n > typeWidth {
+			typeWidth = n
+		}
+	}
+	for i := range t.Column {
+		pr.column(&t.Column[i], nameWidth, typeWidth)
+	}
+	for i := range t.Port {
+		if props > 0 || len(t.Column) > 0 || i > 0 {
+			pr.blank()
+		}
+		pr.port(&t.Port[i])
+	}
+
+	pr.comments(&t.Notes, CommentBelow)
+	pr.indent--
+	pr.line("}")
+}
+
+// rule writes a read or deny rule on its own line.
+func (pr *printer) rule(r *TableRule, key string) {
+	pr.comments(&r.Notes, CommentAbove)
+	pr.write(key + ": ")
+	pr.expr(r.Exp)
+	pr.right(&r.Notes)
+	pr.comments(&r.Notes, CommentBelow)
+}
+
+// port writes a port block: the role grants, the deny rules, then the
+// column ports.
+func (pr *printer) port(p *TablePort) {
+	pr.comments(&p.Notes, CommentAbove)
+	pr.write("port " + p.Name + " {")
+	pr.right(&p.Notes)
+	pr.indent++
+	pr.grants(p.Role, p.Deny)
+	for i := range p.Column {
+		cp := &p.Column[i]
+		pr.comments(&cp.Notes, CommentAbove)
+		pr.write("column " + cp.Name + " {")
+		pr.right(&cp.Notes)
+		pr.indent++
+		pr.grants(cp.Role, cp.Deny)
+		pr.comments(&cp.Notes, CommentBelow)
+		pr.indent--
+		pr.line("}")
+	}
+	pr.comments(&p.Notes, CommentBelow)
+	pr.indent--
+	pr.line("}")
+}
+
+func (pr *printer) grants(role []RoleGrant, deny []TableRule) {
+	for i := range role {
+		g := &role[i]
+		pr.comments(&g.Notes, CommentAbove)
+		pr.write(strings.TrimSpace("role " + g.Role + ": " + strings.Join(g.Allow, " ")))
+		pr.right(&g.Notes)
+		pr.comments(&g.Notes, CommentBelow)
+	}
+	for i := range deny {
+		pr.rule(&deny[i], "deny "+deny[i].Op)
+	}
+}
+
+func columnType(col *TableColumn) string {
+	if len(col.LinkTable) > 0 {
+		return "*" + col.LinkTable + "." + col.LinkColumn
+	}
+	return col.Type
+}
+
+// singleToken reports if s lexes as a single value token.
+func singleToken(s string) bool {
+	list := NewScanner([]byte(s)).Scan(nil)
+	if len(list) != 1 {
+		return false
+	}
+	switch list[0].Type {
+	case TokenNumber, TokenString, TokenStringWithEscape, TokenIdentifier:
+		return true
+	}
+	return false
+}
+
+func pad(s string, width int) string {
+	return s + strings.Repeat(" ", width-len(s))
+}
+
+func (pr *printer) column(col *TableColumn, nameWidth, typeWidth int) {
+	var attr []string
+	if col.Serial {
+		attr = append(attr, "serial")
+	}
+	if col.Key {
+		attr = append(attr, "key")
+	}
+	if col.Nullable {
+		attr = append(attr, "null")
+	}
+	if col.ReadOnly {
+		attr = append(attr, "readonly")
+	}
+	type property struct{ key, value string }
+	var props []property
+	switch {
+	case col.UpdateLock && col.DeleteLock:
+		attr = append(attr, "lock")
+	case col.UpdateLock:
+		props = append(props, property{"lock", "update"})
+	case col.DeleteLock:
+		props = append(props, property{"lock", "delete"})
+	}
+	if len(col.Default) > 0 {
+		if singleToken(col.Default) {
+			attr = append(attr, "default "+col.Default)
+		} else {
+			props = append(props, property{"default", col.Default})
+		}
+	}
+	if len(col.Display) > 0 {
+		props = append(props, property{"display", col.Display})
+	}
+	if len(col.Comment) > 0 {
+		props = append(props, property{"comment", col.Comment})
+	}
+	for _, tag := range col.Tag {
+		props = append(props, property{"tag", tag})
+	}
+
+	pr.comments(&col.Notes, CommentAbove)
+	s := pad(col.Name, nameWidth) + " " + columnType(col)
+	if len(attr) > 0 || len(props) > 0 {
+		s = pad(col.Name, nameWidth) + " " + pad(columnType(col), typeWidth)
+		if len(attr) > 0 {
+			s += " " + strings.Join(attr, " ")
+		}
+	}
+	if len(props) == 0 {
+		pr.write(s)
+		pr.right(&col.Notes)
+		pr.comments(&col.Notes, CommentBelow)
+		return
+	}
+	inline := make([]string, len(props))
+	for i, p := range props {
+		inline[i] = p.key + ": " + p.value
+	}
+	block := "{" + strings.Join(inline, ", ") + "}"
+	if pr.fits(s + " " + block) {
+		pr.write(s + " " + block)
+		pr.right(&col.Notes)
+		pr.comments(&col.Notes, CommentBelow)
+		return
+	}
+	pr.line(s + " {")
+	pr.indent++
+	for _, p := range inline {
+		pr.line(p)
+	}
+	pr.indent--
+	pr.write("}")
+	pr.right(&col.Notes)
+	pr.comments(&col.Notes, CommentBelow)
+}
+
+func (pr *printer) query(q *Query) {
+	pr.comments(&q.Notes, CommentAbove)
+	pr.write(q.Name + " query {")
+	pr.right(&q.Notes)
+	pr.indent++
+	pr.stmtList(q.Stmt)
+	pr.comments(&q.Notes, CommentBelow)
+	pr.indent--
+	pr.line("}")
+}
+
+// test writes the lines of a test: the query, port, role, and parameters,
+// then each input and output, then the error.
+func (pr *printer) test(t *Test) {
+	pr.comments(&t.Notes, CommentAbove)
+	pr.write(t.Name + " test {")
+	pr.right(&t.Notes)
+	pr.indent++
+	head := false
+	testLine := func(n *Notes, s string) {
+		pr.comments(n, CommentAbove)
+		pr.write(s)
+		pr.right(n)
+		head = true
+	}
+	if len(t.Query) > 0 {
+		testLine(&t.QueryNote, "query "+t.Query)
+	}
+	if len(t.Port) > 0 {
+		testLine(&t.PortNote, "port "+t.Port)
+	}
+	if len(t.Role) > 0 {
+		testLine(&t.RoleNote, "role "+strings.Join(t.Role, " "))
+	}
+	for i := range t.Param {
+		tp := &t.Param[i]
+		pr.comments(&tp.Notes, CommentAbove)
+		pr.write("param " + tp.Name + " = ")
+		pr.expr(tp.Value)
+		pr.right(&tp.Notes)
+		head = true
+	}
+	for i := range t.Input {
+		if head || i > 0 {
+			pr.blank()
+		}
+		pr.testRows("input "+t.Input[i].Table+" ", &t.Input[i])
+	}
+	for i := range t.Output {
+		if head || len(t.Input) > 0 || i > 0 {
+			pr.blank()
+		}
+		pr.testRows("output ", &t.Output[i])
+	}
+	if len(t.Error) > 0 {
+		if head || len(t.Input) > 0 || len(t.Output) > 0 {
+			pr.blank()
+		}
+		pr.comments(&t.ErrorNote, CommentAbove)
+		pr.write("error '" + strings.ReplaceAll(t.Error, "'", "''") + "'")
+		pr.right(&t.ErrorNote)
+	}
+	pr.comments(&t.Notes, CommentBelow)
+	pr.indent--
+	pr.line("}")
+}
+
+// testRows writes the column list and rows of a test input or output.
+func (pr *printer) testRows(prefix string, rows *TestRows) {
+	pr.comments(&rows.Notes, CommentAbove)
+	column := make([]string, len(rows.Column))
+	for i, name := range rows.Column {
+		column[i] = name
+		if !plainName(name) {
+			column[i] = `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+		}
+	}
+	pr.write(prefix + "(" + strings.Join(column, ", ") + ") {")
+	pr.right(&rows.Notes)
+	pr.indent++
+	for i := range rows.Row {
+		row := &rows.Row[i]
+		pr.comments(&row.Notes, CommentAbove)
+		for j, v := range row.Value {
+			if j > 0 {
+				pr.write(", ")
+			}
+			pr.operand(v)
+		}
+		pr.right(&row.Notes)
+	}
+	pr.comments(&rows.Notes, CommentBelow)
+	pr.indent--
+	pr.line("}")
+}
+
+func hasOutput(st *Stmt) bool {
+	return len(st.Select) > 0 || len(st.Order) > 0 || len(st.Limit) > 0 || len(st.Offset) > 0 || len(st.Detail) > 0
+}
+
+func (pr *printer) stmtList(list []Stmt) {
+	for i := range list {
+		st := &list[i]
+		if i > 0 {
+			// Without output or a leading from, statements must be
+			// separated so they are not read as one.
+			if !hasOutput(&list[i-1]) || len(st.From) == 0 {
+				pr.line(";")
+			}
+			pr.blank()
+		}
+		pr.stmt(st)
+	}
+}
+
+func (pr *printer) stmt(st *Stmt) {
+	pr.comments(&st.Notes, CommentAbove)
+	for i := range st.From {
+		fr := &st.From[i]
+		pr.comments(&fr.Notes, CommentAbove)
+		if i == 0 {
+			pr.write("from ")
+		} else {
+			pr.write("join ")
+		}
+		pr.write(fr.Table + " " + fr.Alias)
+		pr.joinCondition(fr.And)
+		pr.right(&fr.Notes)
+		pr.comments(&fr.Notes, CommentBelow)
+	}
+	if len(st.And) > 0 {
+		pr.conditionClause(st.And, st.AndParen)
+	}
+	if len(st.Select) > 0 {
+		items := make([]listItem, len(st.Select))
+		for i := range st.Select {
+			sc := &st.Select[i]
+			items[i] = listItem{notes: &sc.Notes, exp: sc.Exp}
+			switch {
+			case len(sc.Name) == 0:
+			case plainName(sc.Name):
+				items[i].prefix = sc.Name + " = "
+			default:
+				items[i].suffix = ` "` + strings.ReplaceAll(sc.Name, `"`, `""`) + `"`
+			}
+		}
+		pr.clause("select", items)
+	}
+	if len(st.Order) > 0 {
+		items := make([]listItem, len(st.Order))
+		for i := range st.Order {
+			oc := &st.Order[i]
+			items[i] = listItem{notes: &oc.Notes, exp: oc.Exp}
+			if oc.Desc {
+				items[i].suffix = " desc"
+			}
+		}
+		pr.clause("order", items)
+	}
+	switch {
+	case len(st.Limit) > 0 && len(st.Offset) > 0:
+		pr.write("limit " + st.Limit + " offset " + st.Offset)
+		pr.right(&st.CountNote)
+	case len(st.Limit) > 0:
+		pr.write("limit " + st.Limit)
+		pr.right(&st.CountNote)
+	case len(st.Offset) > 0:
+		pr.write("offset " + st.Offset)
+		pr.right(&st.CountNote)
+	}
+	for i := range st.Detail {
+		d := &st.Detail[i]
+		pr.comments(&d.Notes, CommentAbove)
+		pr.write("detail " + d.Name + " ")
+		pr.subStmt(&d.Stmt)
+		pr.right(&d.Notes)
+	}
+	pr.comments(&st.Notes, CommentBelow)
+}
+
+// joinCondition writes the conditions of a from table on the same line.
+func (pr *printer) joinCondition(list []Expr) {
+	if len(list) == 0 {
+		return
+	}
+	if len(list) == 1 && !hasNote(list[0].notes(), CommentAbove, CommentRight, CommentBelow) {
+		if s, ok := inlineExpr(list[0]); ok && !strings.HasPrefix(s, "(") && pr.fits(" and "+s) {
+			pr.write(" and " + s)
+			return
+		}
+	}
+	pr.write(" and ")
+	pr.parenList(list, false)
+}
+
+// conditionClause writes the top level conditions of a statement, in
+// parentheses if paren is set. Comments above the first condition are
+// written above the clause.
+func (pr *printer) conditionClause(list []Expr, paren bool) {
+	pr.comments(list[0].notes(), CommentAbove)
+	if paren {
+		pr.write("and ")
+		pr.parenList(list, true)
+		pr.newline()
+		return
+	}
+	if len(list) == 1 {
+		if s, ok := inlineRight(list[0]); ok && !strings.HasPrefix(s, "(") && pr.fits("and "+s) {
+			pr.write("and " + s)
+			pr.right(list[0].notes())
+			return
+		}
+	}
+	pr.line("and")
+	pr.indent++
+	for i, e := range list {
+		if i > 0 {
+			pr.comments(e.notes(), CommentAbove)
+		}
+		pr.expr(e)
+		pr.right(e.notes())
+		pr.comments(e.notes(), CommentBelow)
+	}
+	pr.indent--
+}
+
+// listItem is an item of a select or order clause.
+type listItem struct {
+	notes  *Notes
+	exp    Expr
+	prefix string
+	suffix string
+}
+
+// plainName reports if a select name may be written as an identifier.
+func plainName(name string) bool {
+	switch name {
+	case "from", "join", "select", "insert", "update", "delete", "order", "limit", "offset", "detail",
+		"and", "or", "not", "exists", "in", "like", "asc", "desc", "true", "false", "null":
+		return false
+	}
+	l := &lexer{}
+	for i, r := range name {
+		if i == 0 && !l.isIdentiferStart(r) || !l.isIdentifer(r) {
+			return false
+		}
+	}
+	return len(name) > 0
+}
+
+// clause writes a select or order clause on one line if it fits,
+// otherwise with one item per line. Comments above the first item are
+// written above the clause, and comments right of the last item may end
+// the line.
+func (pr *printer) clause(keyword string, items []listItem) {
+	pr.comments(items[0].notes, CommentAbove)
+	inline := true
+	text := make([]string, len(items))
+	last := len(items) - 1
+	for i, it := range items {
+		if i > 0 && hasNote(it.notes, CommentAbove) || i < last && hasNote(it.notes, CommentRight) || hasNote(it.notes, CommentBelow) {
+			inline = false
+		}
+		s, ok := inlineValue(it.exp)
+		if !ok {
+			inline = false
+		}
+		text[i] = it.prefix + s + it.suffix
+	}
+	if s := keyword + " " + strings.Join(text, ", "); inline && pr.fits(s) {
+		pr.write(s)
+		pr.right(items[last].notes)
+		return
+	}
+	pr.line(keyword)
+	pr.indent++
+	for i, it := range items {
+		if i > 0 {
+			pr.comments(it.notes, CommentAbove)
+		}
+		pr.write(it.prefix)
+		pr.operand(it.exp)
+		pr.write(it.suffix)
+		pr.right(it.notes)
+		pr.comments(it.notes, CommentBelow)
+	}
+	pr.indent--
+}
+
+// parenList writes "(" items ")" on one line if it fits, otherwise with
+// one item per line. If right is set, the comments right of the last item
+// follow the list on one line.
+func (pr *printer) parenList(list []Expr, right bool) {
+	text := make([]string, len(list))
+	inline := true
+	last := len(list) - 1
+	for i, e := range list {
+		s, ok := inlineExpr(e)
+		if right && i == last {
+			s, ok = inlineRight(e)
+		}
+		if !ok {
+			inline = false
+			break
+		}
+		text[i] = s
+	}
+	if s := "(" + strings.Join(text, ", ") + ")"; inline && pr.fits(s) {
+		pr.write(s)
+		if right {
+			for _, c := range list[last].notes().Note {
+				pr.write(" " + c.Text)
+			}
+		}
+		return
+	}
+	pr.write("(")
+	pr.newline()
+	pr.indent++
+	for _, e := range list {
+		pr.comments(e.notes(), CommentAbove)
+		pr.expr(e)
+		pr.right(e.notes())
+		pr.comments(e.notes(), CommentBelow)
+	}
+	pr.indent--
+	pr.write(")")
+}
+
+// expr writes a condition, splitting lists and sub-queries across lines.
+func (pr *printer) expr(e Expr) {
+	if s, ok := inlineExpr(e); ok && pr.fits(s) {
+		pr.write(s)
+		return
+	}
+	switch e := e.(type) {
+	default:
+		s, _ := inlineExpr(e)
+		pr.write(s)
+	case *List:
+		pr.write(e.Op + " ")
+		pr.parenList(e.Item, false)
+	case *Not:
+		pr.write("not ")
+		pr.expr(e.Exp)
+	case *Exists:
+		pr.write("exists ")
+		pr.subStmt(&e.Stmt)
+	case *In:
+		pr.operand(e.Exp)
+		pr.write(" in ")
+		if e.Stmt != nil {
+			pr.subStmt(e.Stmt)
+			return
+		}
+		s, _ := inlineList(e.List, inlineValue)
+		pr.write("(" + s + ")")
+	case *Binary:
+		pr.paren(e.Left, needParen(e, e.Left, false))
+		pr.write(" " + e.Op + " ")
+		pr.paren(e.Right, needParen(e, e.Right, true))
+	}
+}
+
+func (pr *printer) paren(e Expr, paren bool) {
+	if !paren {
+		pr.expr(e)
+		return
+	}
+	pr.write("(")
+	pr.expr(e)
+	pr.write(")")
+}
+
+// operand writes a value, in parentheses if it is a condition.
+func (pr *printer) operand(e Expr) {
+	pr.paren(e, isCondition(e))
+}
+
+func (pr *printer) subStmt(st *Stmt) {
+	pr.write("(")
+	pr.newline()
+	pr.indent++
+	pr.stmt(st)
+	pr.indent--
+	pr.write(")")
+}
+
+// Operator precedence, higher binds tighter.
+func precedence(op string) int {
+	switch op {
+	case "+", "-":
+		return 2
+	case "*", "/", "%":
+		return 3
+	}
+	return 1
+}
+
+// isCondition reports if e is not a value and must be in parentheses
+// when used as a value.
+func isCondition(e Expr) bool {
+	switch e := e.(type) {
+	case *List, *Not, *Exists, *In, *Anchor:
+		return true
+	case *Binary:
+		return precedence(e.Op) == 1
+	}
+	return false
+}
+
+func inlineList(list []Expr, fn func(Expr) (string, bool)) (string, bool) {
+	text := make([]string, len(list))
+	for i, e := range list {
+		s, ok := fn(e)
+		if !ok {
+			return "", false
+		}
+		text[i] = s
+	}
+	return strings.Join(text, ", "), true
+}
+
+// inlineValue returns the single line form of a value, in parentheses if
+// it is a condition.
+func inlineValue(e Expr) (string, bool) {
+	s, ok := inlineExpr(e)
+	if ok && isCondition(e) {
+		s = "(" + s + ")"
+	}
+	return s, ok
+}
+
+// inlineExpr returns the single line form of an expression. It reports
+// false if the expression contains a sub-query or comments.
+func inlineExpr(e Expr) (string, bool) {
+	if len(e.notes().Note) > 0 {
+		return "", false
+	}
+	switch e := e.(type) {
+	case *Ident:
+		return e.Name, true
+	case *ColumnRef:
+		return e.Alias + "." + e.Column, true
+	case *Literal:
+		return e.Value, true
+	case *Anchor:
+		return "#" + e.Name, true
+	case *Binary:
+		left, ok := inlineExpr(e.Left)
+		if !ok {
+			return "", false
+		}
+		right, ok := inlineExpr(e.Right)
+		if !ok {
+			return "", false
+		}
+		if needParen(e, e.Left, false) {
+			left = "(" + left + ")"
+		}
+		if needParen(e, e.Right, true) {
+			right = "(" + right + ")"
+		}
+		return left + " " + e.Op + " " + right, true
+	case *List:
+		s, ok := inlineList(e.Item, inlineExpr)
+		return e.Op + " (" + s + ")", ok
+	case *Not:
+		s, ok := inlineExpr(e.Exp)
+		return "not " + s, ok
+	case *In:
+		if e.Stmt != nil {
+			return "", false
+		}
+		left, ok := inlineValue(e.Exp)
+		if !ok {
+			return "", false
+		}
+		s, ok := inlineList(e.List, inlineValue)
+		return left + " in (" + s + ")", ok
+	case *Call:
+		s, ok := inlineList(e.Arg, inlineExpr)
+		return e.Name + "(" + s + ")", ok
+	}
+	return "", false
+}
+
+// inlineRight returns the single line form of an expression that may
+// have comments to its right, which are not part of the form.
+func inlineRight(e Expr) (string, bool) {
+	n := e.notes()
+	if hasNote(n, CommentAbove, CommentBelow) {
+		return "", false
+	}
+	note := n.Note
+	n.Note = nil
+	s, ok := inlineExpr(e)
+	n.Note = note
+	return s, ok
+}
+
+// needParen reports if the operand of b must be in parentheses.
+func needParen(b *Binary, operand Expr, right bool) bool {
+	o, ok := operand.(*Binary)
+	if !ok {
+		return isCondition(operand)
+	}
+	p, op := precedence(b.Op), precedence(o.Op)
+	return op == 1 || op < p || right && op == p
+}
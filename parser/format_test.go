@@ -0,0 +1,373 @@
+// Copyright 2018 solidcoredata authors.
+
+package parser
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+const formatSource = `-- Package comment.
+package foo   -- right of package
+
+import (
+	-- First import.
+	coredata.biz/app1/role
+	r2   coredata.biz/app1/role-v2 -- alias
+	-- End of imports.
+)
+
+-- The book table.
+book table { -- opening
+	alias:   b
+	display: Library  Books
+	tag: x
+
+	-- Identity.
+	id int64 key serial
+	name text default 'Hello World''s' {display: Book Name, tag: search}
+	account *account.id {
+		null:
+		comment: Account that owns (the) book.
+	}
+	price decimal {default: 1 + 2, display: Price of the book in the local currency, comment: none}
+	version int64 lock
+	edited int64 {lock: update}
+	-- End of columns.
+}
+
+account table {
+	readonly:  true
+	read: account.deleted = false   -- live rows
+	id int64 key
+	deleted bool readonly
+	port web {
+		role reader:   read return
+		role admin: full
+		-- Only empty accounts.
+		deny delete: not exists (from book b and b.account = account.id)
+		column deleted {role reader: read}
+	}
+	port batch {role system: full}
+}
+
+books query {
+	-- Statement comment.
+	from
+		book b
+		join account a and b.account = a.id -- join
+	and
+		b.name = 'Robert'
+		#where1
+		or (
+			a.id = 0 -- zero
+			b.name = 'Nothing'
+		)
+		exists (from ledger l and (l.id = a.id, l.x = (1 + 2) * 3) select l.id)
+		a.id in (1, 2, -3)
+		a.x - (b.y - 1) = -5
+	select
+		b.id, NamePart = b.name, b.name "Account Name", (a.x = 1) flag
+	order
+		b.name asc, b.id desc
+	limit 50 offset 10
+	from book b2 and b2.id = 1
+	;
+	from book b3
+	select b3.id
+	-- Ledgers of the book.
+	detail ledgers (from ledger l and l.book = b3.id select l.id
+		detail lines (from line n and n.ledger = l.id select n.amount)
+	) -- nested
+}
+
+-- Books by name.
+books_by_name test { -- opening
+	role   reader editor
+	query books
+	param name = 'Robert'
+	input book (id, name) {
+		1,   'Robert' -- first
+		2, 'Ann'
+	}
+	output (id, "Account Name") {
+		-- Only the first.
+		1, 'Robert'
+	}
+	output (id) {}
+	error 'isn''t'
+}
+
+-- Trailing comment.
+`
+
+const formatWant = `-- Package comment.
+package foo -- right of package
+
+import (
+	-- First import.
+	coredata.biz/app1/role
+	r2 coredata.biz/app1/role-v2 -- alias
+	-- End of imports.
+)
+
+-- The book table.
+book table { -- opening
+	alias: b
+	display: Library  Books
+	tag: x
+
+	-- Identity.
+	id      int64       serial key
+	name    text        default 'Hello World''s' {
+		display: Book Name
+		tag: search
+	}
+	account *account.id null {comment: Account that owns (the) book.}
+	price   decimal     {
+		default: 1 + 2
+		display: Price of the book in the local currency
+		comment: none
+	}
+	version int64       lock
+	edited  int64       {lock: update}
+	-- End of columns.
+}
+
+account table {
+	readonly: true
+	read: account.deleted = false -- live rows
+
+	id      int64 key
+	deleted bool  readonly
+
+	port web {
+		role reader: read return
+		role admin: full
+		-- Only empty accounts.
+		deny delete: not exists (
+			from book b and b.account = account.id
+		)
+		column deleted {
+			role reader: read
+		}
+	}
+
+	port batch {
+		role system: full
+	}
+}
+
+books query {
+	-- Statement comment.
+	from book b
+	join account a and b.account = a.id -- join
+	and
+		b.name = 'Robert'
+		#where1
+		or (
+			a.id = 0 -- zero
+			b.name = 'Nothing'
+		)
+		exists (
+			from ledger l and (l.id = a.id, l.x = (1 + 2) * 3)
+			select l.id
+		)
+		a.id in (1, 2, -3)
+		a.x - (b.y - 1) = -5
+	select b.id, NamePart = b.name, b.name "Account Name", flag = (a.x = 1)
+	order b.name, b.id desc
+	limit 50 offset 10
+
+	from book b2 and b2.id = 1
+	;
+
+	from book b3
+	select b3.id
+	-- Ledgers of the book.
+	detail ledgers (
+		from ledger l and l.book = b3.id
+		select l.id
+		detail lines (
+			from line n and n.ledger = l.id
+			select n.amount
+		)
+	) -- nested
+}
+
+-- Books by name.
+books_by_name test { -- opening
+	query books
+	role reader editor
+	param name = 'Robert'
+
+	input book (id, name) {
+		1, 'Robert' -- first
+		2, 'Ann'
+	}
+
+	output (id, "Account Name") {
+		-- Only the first.
+		1, 'Robert'
+	}
+
+	output (id) {
+	}
+
+	error 'isn''t'
+}
+
+-- Trailing comment.
+`
+
+func parseClean(t *testing.T, src string) *File {
+	t.Helper()
+	f, err := Parse(context.Background(), "a.scd", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range f.Errors {
+		t.Fatal(e)
+	}
+	return f
+}
+
+// stripNotes removes all comments and source positions from the AST in v.
+func stripNotes(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			stripNotes(v.Elem())
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			stripNotes(v.Index(i))
+		}
+	case reflect.Struct:
+		switch v.Type() {
+		case reflect.TypeOf(Notes{}), reflect.TypeOf(Position{}):
+			v.Set(reflect.Zero(v.Type()))
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				stripNotes(v.Field(i))
+			}
+		}
+	}
+}
+
+func countNotes(v reflect.Value) int {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			return countNotes(v.Elem())
+		}
+	case reflect.Slice:
+		n := 0
+		for i := 0; i < v.Len(); i++ {
+			n += countNotes(v.Index(i))
+		}
+		return n
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(Notes{}) {
+			return v.Field(0).Len()
+		}
+		n := 0
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				n += countNotes(v.Field(i))
+			}
+		}
+		return n
+	}
+	return 0
+}
+
+func TestFormat(t *testing.T) {
+	f := parseClean(t, formatSource)
+	got := string(Format(f))
+	if got != formatWant {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, formatWant)
+	}
+
+	// Formatting is stable and does not change the meaning of the file.
+	f2 := parseClean(t, got)
+	if again := string(Format(f2)); again != got {
+		t.Fatalf("format is not stable, got:\n%s", again)
+	}
+	if g, w := countNotes(reflect.ValueOf(f2)), countNotes(reflect.ValueOf(f)); g != w {
+		t.Fatalf("got %d comments, want %d", g, w)
+	}
+	stripNotes(reflect.ValueOf(f))
+	stripNotes(reflect.ValueOf(f2))
+	if !reflect.DeepEqual(f, f2) {
+		t.Fatal("formatted file parses differently")
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	for _, src := range []string{scanSource, benchSource(2), formatWant} {
+		f := parseClean(t, src)
+		out := string(Format(f))
+		f2 := parseClean(t, out)
+		if again := string(Format(f2)); again != out {
+			t.Fatalf("format is not stable, got:\n%s\nwant:\n%s", again, out)
+		}
+		if g, w := countNotes(reflect.ValueOf(f2)), countNotes(reflect.ValueOf(f)); g != w {
+			t.Fatalf("got %d comments, want %d", g, w)
+		}
+		stripNotes(reflect.ValueOf(f))
+		stripNotes(reflect.ValueOf(f2))
+		if !reflect.DeepEqual(f, f2) {
+			t.Fatalf("formatted file parses differently:\n%s", out)
+		}
+	}
+}
+
+// Canonical files format to themselves, with trailing comments kept on
+// their line and explicit grouping kept.
+func TestFormatLossless(t *testing.T) {
+	for _, src := range []string{`package foo
+
+q1 query {
+	from book b -- books
+	and b.name = 'x' -- name
+	select b.id, b.name -- columns
+	order b.id -- order
+	limit 5 offset 2 -- page
+}
+`, `package foo
+
+q2 query {
+	from book b
+	and (b.id = 1, b.name = 'x') -- either
+	select b.id
+
+	from book b2
+	and (
+		b2.id = 1 -- one
+		or (b2.a = 1, b2.b = 2)
+	)
+	select b2.id
+}
+`, `package foo
+
+x test {
+	-- The query.
+	query q -- query
+	port web -- port
+	role a b -- roles
+	param p = 1 -- param
+
+	-- The error.
+	error 'x' -- error
+}
+`} {
+		f := parseClean(t, src)
+		if got := string(Format(f)); got != src {
+			t.Errorf("got:\n%s\nwant:\n%s", got, src)
+		}
+	}
+}
@@ -19,13 +19,18 @@ func (e ParseError) Error() string {
 }
 
 type File struct {
-	Name   string
-	Errors []ParseError
+	Notes // Comments after the last declaration.
+
+	Name    string
+	Errors  []ParseError
+	Package Package
+	Import  []Import
 
 	DeclareOrder []string
 
 	Table []Table
 	Query []Query
+	Test  []Test
 }
 
 type CommentPosition int
@@ -37,31 +42,336 @@ const (
 	CommentLeft
 )
 
+// Comment is a source comment attached to a node. Text includes the
+// comment markers, "-- text" or "/* text */".
 type Comment struct {
 	Pos       CommentPosition
 	MultiLine bool
 	Text      string
 }
 
+// Notes are the comments attached to a node. Comments above a node are
+// on the lines before it and comments to the right are on its last line.
+// Comments below a block, such as a table, are at the end of the block.
+type Notes struct {
+	Note []Comment
+}
+
+// Comments attached to the node.
+func (n *Notes) Comments() []Comment {
+	return n.Note
+}
+
+func (n *Notes) notes() *Notes {
+	return n
+}
+
 type Node interface {
 	Pos() (start Position, end Position)
 	Comments() []Comment
 }
 
 type Table struct {
-	Name string
+	Notes
+
+	Name    string
+	Alias   string
+	Display string
+	Comment string
+	Tag     []string
+
+	// ReadOnly is set by the "readonly: true" property.
+	ReadOnly bool
+
+	// Read rules are conditions a row must match to be read, "read: expr".
+	Read []TableRule
 
 	Column []TableColumn
+	Port   []TablePort
 }
-type TableColumn struct {
+
+// TableRule is a condition on a row of a table. It refers to the row by
+// the table alias, or by the table name if the table has no alias. Op is
+// "read" for a read rule, or the operation a deny rule denies.
+type TableRule struct {
+	Notes
+
+	Op  string
+	Exp Expr
+}
+
+// TablePort declares the access to the table through a port:
+//
+//	port web {
+//		role reader: read return
+//		role editor: full
+//		deny delete: a.closed = false
+//		column balance {
+//			role reader: read
+//		}
+//	}
+type TablePort struct {
+	Notes
+
+	Name   string
+	Role   []RoleGrant
+	Deny   []TableRule
+	Column []ColumnPort
+}
+
+// ColumnPort declares the access to a column through a port.
+type ColumnPort struct {
+	Notes
+
 	Name string
-	Type string
+	Role []RoleGrant
+	Deny []TableRule
+}
+
+// RoleGrant lists the access granted to a role, such as "read", "return",
+// "insert", "update", "delete", or "full".
+type RoleGrant struct {
+	Notes
+
+	Role  string
+	Allow []string
+}
+
+type TableColumn struct {
+	Notes
+
+	Name    string
+	Type    string
+	Display string
+	Comment string
+	Tag     []string
+
+	Key      bool
+	Serial   bool
+	Nullable bool
+	ReadOnly bool
+	Default  string
+
+	// UpdateLock and DeleteLock are set by the "lock" attribute, or one of
+	// them by the "lock: update" or "lock: delete" property.
+	UpdateLock bool
+	DeleteLock bool
+
+	// LinkTable and LinkColumn are set when the column is declared as
+	// a link to another column, "*account.id". Type is then empty.
+	// A table in an imported package is qualified, "*role.user.id".
+	LinkTable  string
+	LinkColumn string
 }
 type TableIndex struct {
 	Name string
 }
 
-type Query struct{}
+// Query is a named list of statements.
+type Query struct {
+	Notes
+
+	Name string
+	Stmt []Stmt
+}
+
+// Stmt is a single statement within a query.
+type Stmt struct {
+	Notes
+
+	From   []From
+	And    []Expr // Top level conditions, all must be true.
+	Select []SelectColumn
+	Order  []OrderColumn
+	Limit  string
+	Offset string
+	Detail []Detail
+
+	// AndParen is set if the conditions were declared as a parenthesized
+	// list, "and ( … )".
+	AndParen bool
+	// CountNote holds the comments to the right of the limit or offset line.
+	CountNote Notes
+}
+
+// Detail is a named statement run for each row of its parent statement,
+// with its rows returned under the parent row. It may refer to the
+// aliases of its parent.
+type Detail struct {
+	Notes
+
+	Name string
+	Stmt Stmt
+}
+
+// Test is a unit test of a query, declared in a file with a name that ends
+// in "_test.scd". The Input rows are the only rows of their tables, and need
+// only list the columns the query uses. The query runs with the Port,
+// Role, and Param, and each returned result must hold the Output rows in
+// order, or the query must fail with an error that contains Error.
+// Comments on the query, port, role, and error lines are kept with the
+// line.
+type Test struct {
+	Notes
+
+	Name   string
+	Query  string
+	Port   string
+	Role   []string
+	Param  []TestParam
+	Input  []TestRows
+	Output []TestRows
+	Error  string
+
+	QueryNote Notes
+	PortNote  Notes
+	RoleNote  Notes
+	ErrorNote Notes
+}
+
+// TestParam is a named parameter value of a test.
+type TestParam struct {
+	Notes
+
+	Name  string
+	Value Expr
+}
+
+// TestRows are rows of values for the listed columns. Table is empty for
+// the rows of a result.
+type TestRows struct {
+	Notes
+
+	Table  string
+	Column []string
+	Row    []TestRow
+}
+
+type TestRow struct {
+	Notes
+
+	Value []Expr
+}
+
+// From is a table reference in a statement. And holds the join conditions,
+// if any. Table may be qualified by an imported package name, "role.user".
+type From struct {
+	Notes
+
+	Table string
+	Alias string
+	And   []Expr
+}
+
+// SelectColumn is a returned column. Name is empty if not given.
+type SelectColumn struct {
+	Notes
+
+	Name string
+	Exp  Expr
+}
+
+type OrderColumn struct {
+	Notes
+
+	Exp  Expr
+	Desc bool
+}
+
+// Expr is a node in a condition or value expression.
+type Expr interface {
+	expr()
+	notes() *Notes
+}
+
+// Ident is a bare identifier, usually a parameter name.
+type Ident struct {
+	Notes
+
+	Name string
+}
+
+// ColumnRef refers to a column by table alias, "a.id".
+type ColumnRef struct {
+	Notes
+
+	Alias  string
+	Column string
+}
+
+// Literal is a number, string, boolean, or null value. Type is one of
+// TokenNumber, TokenString, TokenStringWithEscape, or TokenIdentifier for
+// true, false, and null.
+type Literal struct {
+	Notes
+
+	Type  TokenType
+	Value string
+}
+
+// Anchor is a named insertion point for conditions, "#where1".
+type Anchor struct {
+	Notes
+
+	Name string
+}
+
+// Binary is a comparison or arithmetic operation.
+type Binary struct {
+	Notes
+
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+// List is an "and" or "or" group of conditions.
+type List struct {
+	Notes
+
+	Op   string
+	Item []Expr
+}
+
+type Not struct {
+	Notes
+
+	Exp Expr
+}
+
+type Exists struct {
+	Notes
+
+	Stmt Stmt
+}
+
+// In tests if Exp is in the single column result of Stmt, or if Stmt is nil,
+// in List.
+type In struct {
+	Notes
+
+	Exp  Expr
+	Stmt *Stmt
+	List []Expr
+}
+
+type Call struct {
+	Notes
+
+	Name string
+	Arg  []Expr
+}
+
+func (*Ident) expr()     {}
+func (*ColumnRef) expr() {}
+func (*Literal) expr()   {}
+func (*Anchor) expr()    {}
+func (*Binary) expr()    {}
+func (*List) expr()      {}
+func (*Not) expr()       {}
+func (*Exists) expr()    {}
+func (*In) expr()        {}
+func (*Call) expr()      {}
 
 func (f *File) err(tok Token, msg string) {
 	f.Errors = append(f.Errors, ParseError{
@@ -73,53 +383,64 @@ func (f *File) err(tok Token, msg string) {
 }
 
 type Package struct {
-	Name string
+	Notes
+
+	Name  string
+	Start Position
+}
+
+// Import is a package imported by a file. Name is the name used to
+// qualify references to the package, by default the last element of Path.
+type Import struct {
+	Notes
+
+	Name  string
+	Path  string
+	Start Position
 }
 
 func Lex2(ctx context.Context, src string, f *File) error {
-	tc := make(chan Token, 100)
-	go func(ctx context.Context, tc chan Token) {
-		type lstate1 int
-		const (
-			lvRoot lstate1 = iota
-			lvPackage
-			lvImport
-			lvTable
-			lvQuery
-		)
-		var st lstate1
-		_ = st
-
-		type lexRoute struct {
+	type lstate1 int
+	const (
+		lvRoot lstate1 = iota
+		lvPackage
+		lvImport
+		lvTable
+		lvQuery
+	)
+	var st lstate1
+	_ = st
+
+	type lexRoute struct {
+	}
+
+	s := NewScanner([]byte(src))
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
+		tok := s.Next()
+		switch tok.Type {
+		default:
+			panic("unknown token type")
+		case TokenEOF:
+			return nil
+		case TokenInvalid:
+			f.err(tok, tok.Message)
+
+		case TokenNewline:
+		case TokenWS:
+		case TokenSymbol:
+		case TokenString:
+		case TokenStringWithEscape:
+		case TokenNumber:
+
+		case TokenIdentifier:
+		case TokenIdentifierQuoted:
+		case TokenAnchor:
 
-		for {
-			tok, ok := <-tc
-			if !ok {
-				return
-			}
-			switch tok.Type {
-			default:
-				panic("unknown token type")
-			case TokenInvalid:
-				f.err(tok, tok.Message)
-
-			case TokenNewline:
-			case TokenWS:
-			case TokenSymbol:
-			case TokenString:
-			case TokenStringWithEscape:
-			case TokenNumber:
-
-			case TokenIdentifier:
-			case TokenIdentifierQuoted:
-
-			case TokenLineComment:
-			case TokenMultiComment:
-			}
+		case TokenLineComment:
+		case TokenMultiComment:
 		}
-	}(ctx, tc)
-	err := Lex1(ctx, src, tc)
-	close(tc)
-	return err
+	}
 }
@@ -0,0 +1,157 @@
+// Copyright 2018 solidcoredata authors.
+
+package parser
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// ModuleFileName is the name of the file that marks the root directory
+// of a module.
+const ModuleFileName = "scd.mod"
+
+// Module is a parsed module file. The module path is the import path
+// prefix of every package within the module directory. Require lists the
+// minimum version of each module that packages in this module import.
+//
+//	module coredata.biz/app1
+//
+//	require (
+//		s/v1/time v1.0.1
+//	)
+type Module struct {
+	Name   string
+	Errors []ParseError
+
+	Path    string
+	Require []Require
+}
+
+// Require is a module dependency.
+type Require struct {
+	Path    string
+	Version string
+	Start   Position
+}
+
+// ParseModule parses the module file src.
+// The returned error is only set if the context is canceled.
+func ParseModule(ctx context.Context, name string, src string) (*Module, error) {
+	f, list, err := lexFile(ctx, name, src)
+	m := &Module{Name: name}
+	if err != nil {
+		return m, err
+	}
+	p := &Parser{f: f}
+	p.load(list)
+	p.parseModule(m)
+	p.sortErrors()
+	m.Errors = f.Errors
+	return m, nil
+}
+
+func (p *Parser) parseModule(m *Module) {
+	defer p.recoverBailout()
+	for {
+		p.skipNewline()
+		if p.eof() {
+			if len(m.Path) == 0 {
+				p.errorf(p.peek(), "missing module declaration")
+			}
+			return
+		}
+		p.try(func() { p.parseDirective(m) }, p.syncDecl)
+	}
+}
+
+// parseDirective parses a single module directive.
+func (p *Parser) parseDirective(m *Module) {
+	tok := p.expectIdent()
+	switch tok.Value {
+	default:
+		p.errorf(tok, "unknown module directive %q", tok.Value)
+	case "module":
+		if len(m.Path) > 0 {
+			p.errorf(tok, "module declared more than once")
+		}
+		m.Path = p.parsePath()
+		p.expectEndOfLine()
+	case "require":
+		if !isSymbol(p.peek(), "(") {
+			p.parseRequire(m)
+			return
+		}
+		p.next()
+		for {
+			p.skipNewline()
+			if isSymbol(p.peek(), ")") || p.eof() {
+				p.expectSymbol(")")
+				p.expectEndOfLine()
+				return
+			}
+			p.try(func() { p.parseRequire(m) }, func(int) { p.syncLine(")") })
+		}
+	}
+}
+
+func (p *Parser) parseRequire(m *Module) {
+	start := p.peek()
+	r := Require{Path: p.parsePath(), Start: start.Start}
+	vtok := p.peek()
+	r.Version = p.parsePath()
+	if !ValidVersion(r.Version) {
+		p.errorf(vtok, "invalid version %q, expected vMAJOR.MINOR.PATCH", r.Version)
+	}
+	for _, existing := range m.Require {
+		if existing.Path == r.Path {
+			p.errorf(start, "module %q required more than once", r.Path)
+		}
+	}
+	m.Require = append(m.Require, r)
+	if !isSymbol(p.peek(), ")") {
+		p.expectEndOfLine()
+	}
+}
+
+// ValidVersion reports if v is of the form vMAJOR.MINOR.PATCH.
+func ValidVersion(v string) bool {
+	_, ok := parseVersion(v)
+	return ok
+}
+
+// CompareVersion returns -1, 0, or 1 if a is less than, equal to, or
+// greater than b. Both versions must be valid.
+func CompareVersion(a, b string) int {
+	va, _ := parseVersion(a)
+	vb, _ := parseVersion(b)
+	for i := range va {
+		switch {
+		case va[i] < vb[i]:
+			return -1
+		case va[i] > vb[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+func parseVersion(v string) ([3]int, bool) {
+	var n [3]int
+	if !strings.HasPrefix(v, "v") {
+		return n, false
+	}
+	parts := strings.Split(v[1:], ".")
+	if len(parts) != len(n) {
+		return n, false
+	}
+	for i, part := range parts {
+		x, err := strconv.Atoi(part)
+		if err != nil || x < 0 || (len(part) > 1 && part[0] == '0') {
+			return n, false
+		}
+		n[i] = x
+	}
+	return n, true
+}
@@ -2,6 +2,16 @@
 
 package parser
 
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/solidcoredata/dbc/internal/pf"
+)
+
 // Parser reads in source files and builds an AST tree from it. It may also verify
 // the AST.
 //
@@ -14,4 +24,866 @@ package parser
 // require () but nested ones do.
 //
 // For select, insert, update statements: "name = t.name" is the same as "t.name".
-type Parser struct{}
+type Parser struct {
+	f   *File
+	src string
+	tok []Token
+	pf  []pf.Token
+	i   int
+
+	comment []Token
+	ci      int // Index of the first comment not yet attached to a node.
+}
+
+// bailout is used to unwind the parser to the nearest point it can
+// resynchronize at after a syntax error.
+type bailout struct{}
+
+// Parse src into a File. Syntax errors are recorded in File.Errors.
+// After an error the parser resumes at the next declaration or statement
+// clause, so all errors in the file are reported.
+// The returned error is only set if the context is canceled.
+func Parse(ctx context.Context, name string, src string) (*File, error) {
+	f, list, err := lexFile(ctx, name, src)
+	if err != nil {
+		return f, err
+	}
+
+	p := &Parser{f: f, src: src}
+	p.load(list)
+	p.parseFile()
+	p.f.Note = append(p.f.Note, p.rest(CommentBelow)...)
+	p.sortErrors()
+	return f, nil
+}
+
+func lexFile(ctx context.Context, name string, src string) (*File, []Token, error) {
+	f := &File{Name: name}
+	s := NewScanner([]byte(src))
+	var list []Token
+	for tok := s.Next(); tok.Type != TokenEOF; tok = s.Next() {
+		if err := ctx.Err(); err != nil {
+			return f, list, err
+		}
+		list = append(list, tok)
+	}
+	return f, list, nil
+}
+
+// load filters the lexed tokens down to what the parser uses.
+func (p *Parser) load(list []Token) {
+	for _, tok := range list {
+		switch tok.Type {
+		case TokenWS:
+			continue
+		case TokenLineComment, TokenMultiComment:
+			p.comment = append(p.comment, tok)
+			continue
+		case TokenInvalid:
+			p.f.err(tok, tok.Message)
+			continue
+		}
+		p.tok = append(p.tok, tok)
+	}
+}
+
+func (p *Parser) peekAt(n int) Token {
+	if p.i+n >= len(p.tok) {
+		var end Position
+		if len(p.tok) > 0 {
+			end = p.tok[len(p.tok)-1].End
+		}
+		return Token{Type: TokenInvalid, Start: end, End: end}
+	}
+	return p.tok[p.i+n]
+}
+
+func (p *Parser) peek() Token {
+	return p.peekAt(0)
+}
+
+func (p *Parser) next() Token {
+	tok := p.peek()
+	if p.i < len(p.tok) {
+		p.i++
+	}
+	return tok
+}
+
+func (p *Parser) eof() bool {
+	return p.i >= len(p.tok)
+}
+
+// sortErrors orders lexer and parser errors by position.
+func (p *Parser) sortErrors() {
+	sort.SliceStable(p.f.Errors, func(i, j int) bool {
+		return p.f.Errors[i].Start.Byte < p.f.Errors[j].Start.Byte
+	})
+}
+
+// errorf records a syntax error and unwinds to the nearest try.
+// Only the first error on a line is recorded, as later errors on the
+// same line are usually caused by the first.
+func (p *Parser) errorf(tok Token, f string, v ...interface{}) {
+	line := false
+	for _, e := range p.f.Errors {
+		if e.Start.Line == tok.Start.Line {
+			line = true
+			break
+		}
+	}
+	if !line {
+		p.f.err(tok, fmt.Sprintf(f, v...))
+	}
+	panic(bailout{})
+}
+
+func (p *Parser) skipNewline() {
+	for p.peek().Type == TokenNewline {
+		p.i++
+	}
+}
+
+// describe a token for use in error messages.
+func describe(tok Token) string {
+	switch {
+	case tok.Type == TokenNewline:
+		return "newline"
+	case len(tok.Value) == 0:
+		return "end of file"
+	}
+	return strconv.Quote(tok.Value)
+}
+
+func isSymbol(tok Token, v string) bool {
+	return tok.Type == TokenSymbol && tok.Value == v
+}
+
+func isKeyword(tok Token, v string) bool {
+	return tok.Type == TokenIdentifier && tok.Value == v
+}
+
+func (p *Parser) expectSymbol(v string) Token {
+	tok := p.next()
+	if !isSymbol(tok, v) {
+		p.errorf(tok, "expected %q, got %s", v, describe(tok))
+	}
+	return tok
+}
+
+func (p *Parser) expectIdent() Token {
+	tok := p.next()
+	if tok.Type != TokenIdentifier {
+		p.errorf(tok, "expected identifier, got %s", describe(tok))
+	}
+	return tok
+}
+
+func (p *Parser) expectEndOfLine() {
+	tok := p.peek()
+	switch {
+	case tok.Type == TokenNewline:
+		p.next()
+	case p.eof(), isSymbol(tok, "}"):
+	default:
+		p.errorf(tok, "unexpected %s, expected end of line", describe(tok))
+	}
+}
+
+func (p *Parser) recoverBailout() {
+	if r := recover(); r != nil {
+		if _, ok := r.(bailout); !ok {
+			panic(r)
+		}
+	}
+}
+
+// try calls fn. If fn reports a syntax error, sync is called with the
+// token index fn started at to skip to where parsing may resume.
+// Reports if fn returned without error.
+func (p *Parser) try(fn func(), sync func(start int)) (ok bool) {
+	start := p.i
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if _, isBailout := r.(bailout); !isBailout {
+			panic(r)
+		}
+		sync(start)
+		if p.i == start {
+			p.next()
+		}
+		ok = false
+	}()
+	fn()
+	return true
+}
+
+// syncDecl skips past the end of the declaration that started at start.
+// The declaration ends at the first newline after the error outside of
+// any block, or before a line that looks like the start of a declaration
+// when a block was left unclosed.
+func (p *Parser) syncDecl(start int) {
+	at := p.i
+	depth := 0
+	for i := start; i < len(p.tok); i++ {
+		tok := p.tok[i]
+		switch {
+		case isSymbol(tok, "{"), isSymbol(tok, "("):
+			depth++
+		case isSymbol(tok, "}"), isSymbol(tok, ")"):
+			if depth > 0 {
+				depth--
+			}
+		case tok.Type == TokenNewline:
+			if i >= at && (depth == 0 || p.declStart(i+1)) {
+				p.i = i + 1
+				return
+			}
+		}
+	}
+	p.i = len(p.tok)
+}
+
+// declStart reports if the token at index i, after any newlines, starts
+// a declaration.
+func (p *Parser) declStart(i int) bool {
+	for i < len(p.tok) && p.tok[i].Type == TokenNewline {
+		i++
+	}
+	if i+1 >= len(p.tok) || p.tok[i].Type != TokenIdentifier {
+		return false
+	}
+	switch p.tok[i].Value {
+	case "package", "import":
+		return true
+	}
+	return isKeyword(p.tok[i+1], "table") || isKeyword(p.tok[i+1], "query") || isKeyword(p.tok[i+1], "test")
+}
+
+// syncLine skips to the start of the next line, stopping early at a
+// closing symbol.
+func (p *Parser) syncLine(closer string) {
+	for !p.eof() {
+		tok := p.peek()
+		if isSymbol(tok, closer) {
+			return
+		}
+		p.next()
+		if tok.Type == TokenNewline {
+			return
+		}
+	}
+}
+
+// syncClause skips to the next statement clause, ";", or the closing
+// symbol of the statement list.
+func (p *Parser) syncClause(closer string) {
+	depth := 0
+	for !p.eof() {
+		tok := p.peek()
+		switch {
+		case isSymbol(tok, "("), isSymbol(tok, "{"):
+			depth++
+		case isSymbol(tok, ")"), isSymbol(tok, "}"):
+			if depth > 0 {
+				depth--
+				break
+			}
+			if isSymbol(tok, closer) {
+				return
+			}
+		case depth == 0 && p.clauseKeyword():
+			return
+		}
+		p.next()
+	}
+}
+
+func (p *Parser) parseFile() {
+	for {
+		p.skipNewline()
+		if p.eof() {
+			return
+		}
+		p.try(p.parseDecl, p.syncDecl)
+	}
+}
+
+// parseDecl parses a single top level declaration.
+func (p *Parser) parseDecl() {
+	note := p.above()
+	tok := p.expectIdent()
+	switch tok.Value {
+	case "package":
+		if len(p.f.Package.Name) > 0 {
+			p.errorf(tok, "package declared more than once")
+		}
+		if len(p.f.Table) > 0 || len(p.f.Query) > 0 || len(p.f.Test) > 0 || len(p.f.Import) > 0 {
+			p.errorf(tok, "package must be declared first")
+		}
+		p.f.Package.Name = p.expectIdent().Value
+		p.f.Package.Start = tok.Start
+		p.expectEndOfLine()
+		noteItem(&p.f.Package.Notes, note, p.right())
+		return
+	case "import":
+		if len(p.f.Table) > 0 || len(p.f.Query) > 0 || len(p.f.Test) > 0 {
+			p.errorf(tok, "imports must be declared before tables and queries")
+		}
+		p.parseImport(note)
+		return
+	}
+	kind := p.expectIdent()
+	switch kind.Value {
+	default:
+		p.errorf(kind, "unknown declaration %q", kind.Value)
+	case "table":
+		t := p.parseTable(tok)
+		t.Note = append(note, t.Note...)
+		p.f.Table = append(p.f.Table, t)
+	case "query":
+		q := p.parseQuery(tok.Value)
+		q.Note = append(note, q.Note...)
+		p.f.Query = append(p.f.Query, q)
+	case "test":
+		t := p.parseTest(tok.Value)
+		t.Note = append(note, t.Note...)
+		p.f.Test = append(p.f.Test, t)
+	}
+	p.f.DeclareOrder = append(p.f.DeclareOrder, tok.Value)
+}
+
+// parsePath parses a path such as "coredata.biz/app1/role". A path is
+// made of adjacent identifier, number, and ".", "/", "-" symbol tokens.
+func (p *Parser) parsePath() string {
+	first := p.expectIdent()
+	b := &strings.Builder{}
+	b.WriteString(first.Value)
+	end := first.End
+loop:
+	for {
+		tok := p.peek()
+		if tok.Start.Byte != end.Byte {
+			break
+		}
+		switch {
+		default:
+			break loop
+		case tok.Type == TokenIdentifier, tok.Type == TokenNumber:
+		case isSymbol(tok, "."), isSymbol(tok, "/"), isSymbol(tok, "-"):
+		}
+		p.next()
+		b.WriteString(tok.Value)
+		end = tok.End
+	}
+	s := b.String()
+	p.checkPath(first, s)
+	return s
+}
+
+// checkPath reports an invalid path that starts at tok. Paths name
+// directories within a module, so each element of a path must be a name,
+// not empty, ".", or "..".
+func (p *Parser) checkPath(tok Token, s string) {
+	for _, elem := range strings.Split(s, "/") {
+		switch elem {
+		case "", ".", "..":
+			p.errorf(tok, "invalid path %q", s)
+		}
+	}
+}
+
+// parseTableName parses a table name, optionally qualified by a package name.
+func (p *Parser) parseTableName() string {
+	name := p.expectIdent().Value
+	if isSymbol(p.peek(), ".") {
+		p.next()
+		name += "." + p.expectIdent().Value
+	}
+	return name
+}
+
+func (p *Parser) parseQuery(name string) Query {
+	q := Query{Name: name}
+	p.expectSymbol("{")
+	q.Note = p.right()
+	q.Stmt = p.parseStmtList("}")
+	q.Note = append(q.Note, p.below()...)
+	p.expectSymbol("}")
+	return q
+}
+
+// clauseKeyword reports if the current token starts a new statement clause.
+func (p *Parser) clauseKeyword() bool {
+	tok := p.peek()
+	if isSymbol(tok, ";") {
+		return true
+	}
+	if tok.Type != TokenIdentifier {
+		return false
+	}
+	switch tok.Value {
+	case "from", "join", "select", "insert", "update", "delete", "order", "limit", "offset", "detail":
+		return true
+	case "and":
+		return !isSymbol(p.peekAt(1), "(")
+	}
+	return false
+}
+
+// parseStmtList parses statements until the closing symbol, which is not consumed.
+// After an error in a clause, parsing resumes at the next clause.
+func (p *Parser) parseStmtList(closer string) []Stmt {
+	var list []Stmt
+	var st Stmt
+	hasOutput := false
+	empty := true
+	finish := func() {
+		if !empty {
+			list = append(list, st)
+		}
+		st = Stmt{}
+		hasOutput = false
+		empty = true
+	}
+	for {
+		p.skipNewline()
+		tok := p.peek()
+		if isSymbol(tok, closer) || p.eof() {
+			if !empty {
+				st.Note = append(st.Note, p.below()...)
+			}
+			finish()
+			return list
+		}
+		if isSymbol(tok, ";") {
+			p.next()
+			finish()
+			continue
+		}
+		if isKeyword(tok, "from") && hasOutput {
+			finish()
+		}
+		if empty {
+			st.Note = p.above()
+		}
+		p.try(func() {
+			if p.parseClause(&st) {
+				hasOutput = true
+			}
+		}, func(int) { p.syncClause(closer) })
+		empty = false
+	}
+}
+
+// parseClause parses a single statement clause into st.
+// Reports if the clause is an output clause, such as select.
+func (p *Parser) parseClause(st *Stmt) bool {
+	tok := p.peek()
+	if tok.Type != TokenIdentifier {
+		p.errorf(tok, "unexpected %s, expected statement clause", describe(tok))
+	}
+	p.next()
+	switch tok.Value {
+	default:
+		p.errorf(tok, "unknown statement clause %q", tok.Value)
+	case "from":
+		st.From = append(st.From, p.parseFromList()...)
+	case "join":
+		if len(st.From) == 0 {
+			p.errorf(tok, "join without from")
+		}
+		st.From = append(st.From, p.parseFromItem())
+	case "and":
+		if isSymbol(p.peek(), "(") {
+			list := p.parseParenList()
+			if len(list) > 0 {
+				n := list[len(list)-1].notes()
+				n.Note = append(n.Note, p.right()...)
+			}
+			st.And = append(st.And, list...)
+			st.AndParen = true
+		} else {
+			st.And = append(st.And, p.parseBlock()...)
+		}
+	case "select":
+		st.Select = append(st.Select, p.parseSelectList()...)
+		return true
+	case "order":
+		st.Order = append(st.Order, p.parseOrderList()...)
+		return true
+	case "limit":
+		st.Limit = p.parseCount()
+		if isKeyword(p.peek(), "offset") {
+			p.next()
+			st.Offset = p.parseCount()
+		}
+		st.CountNote.Note = append(st.CountNote.Note, p.right()...)
+		return true
+	case "offset":
+		st.Offset = p.parseCount()
+		st.CountNote.Note = append(st.CountNote.Note, p.right()...)
+		return true
+	case "detail":
+		st.Detail = append(st.Detail, p.parseDetail())
+		return true
+	}
+	return false
+}
+
+// parseDetail parses the name and statement after "detail".
+func (p *Parser) parseDetail() Detail {
+	d := Detail{Notes: Notes{Note: p.above()}}
+	d.Name = p.expectIdent().Value
+	p.expectSymbol("(")
+	list := p.parseStmtList(")")
+	end := p.expectSymbol(")")
+	if len(list) != 1 || len(list[0].Select) == 0 {
+		p.errorf(end, "detail must contain a single statement with a select")
+	}
+	if len(list) > 0 {
+		d.Stmt = list[0]
+	}
+	d.Note = append(d.Note, p.right()...)
+	return d
+}
+
+func (p *Parser) parseCount() string {
+	tok := p.next()
+	if tok.Type != TokenNumber {
+		p.errorf(tok, "expected number, got %s", describe(tok))
+	}
+	return tok.Value
+}
+
+// parseFromList parses the table list after "from". It may be on the same
+// line, a list of indented lines, or a parenthesized list.
+func (p *Parser) parseFromList() []From {
+	if isSymbol(p.peek(), "(") {
+		p.next()
+		var list []From
+		for {
+			p.skipNewline()
+			if isSymbol(p.peek(), ")") {
+				if len(list) > 0 {
+					fr := &list[len(list)-1]
+					fr.Note = append(fr.Note, p.below()...)
+				}
+				p.next()
+				return list
+			}
+			list = append(list, p.parseFromItem())
+		}
+	}
+	var list []From
+	for {
+		p.skipNewline()
+		tok := p.peek()
+		if p.clauseKeyword() || tok.Type != TokenIdentifier || isKeyword(tok, "and") || isKeyword(tok, "or") {
+			if len(list) == 0 {
+				p.errorf(p.peek(), "from missing table")
+			}
+			return list
+		}
+		list = append(list, p.parseFromItem())
+	}
+}
+
+// parseFromItem parses a table reference in a list along with its comments.
+func (p *Parser) parseFromItem() From {
+	above := p.above()
+	fr := p.parseFrom()
+	if isSymbol(p.peek(), ",") {
+		p.next()
+	}
+	noteItem(&fr.Notes, above, p.right())
+	return fr
+}
+
+func (p *Parser) parseFrom() From {
+	fr := From{
+		Table: p.parseTableName(),
+		Alias: p.expectIdent().Value,
+	}
+	if !isKeyword(p.peek(), "and") {
+		return fr
+	}
+	p.next()
+	if isSymbol(p.peek(), "(") {
+		fr.And = p.parseParenList()
+		return fr
+	}
+	for {
+		fr.And = append(fr.And, p.parseItem())
+		if !isSymbol(p.peek(), ",") {
+			return fr
+		}
+		p.next()
+	}
+}
+
+// parseBlock parses condition items after a bare "and" until the next clause.
+func (p *Parser) parseBlock() []Expr {
+	var list []Expr
+	for {
+		p.skipNewline()
+		tok := p.peek()
+		if p.eof() || p.clauseKeyword() || isSymbol(tok, ")") || isSymbol(tok, "}") {
+			if len(list) == 0 {
+				p.errorf(tok, "missing condition")
+			}
+			return list
+		}
+		list = append(list, p.parseListItem())
+	}
+}
+
+// parseParenList parses "(" item, item ")" where items may also be separated
+// by newlines.
+func (p *Parser) parseParenList() []Expr {
+	p.expectSymbol("(")
+	var list []Expr
+	for {
+		p.skipNewline()
+		if isSymbol(p.peek(), ")") {
+			if len(list) > 0 {
+				n := list[len(list)-1].notes()
+				n.Note = append(n.Note, p.below()...)
+			}
+			p.next()
+			return list
+		}
+		list = append(list, p.parseListItem())
+	}
+}
+
+// parseListItem parses a condition in a list along with its comments.
+func (p *Parser) parseListItem() Expr {
+	above := p.above()
+	e := p.parseItem()
+	if isSymbol(p.peek(), ",") {
+		p.next()
+	}
+	noteItem(e.notes(), above, p.right())
+	return e
+}
+
+// parseItem parses a single condition.
+func (p *Parser) parseItem() Expr {
+	tok := p.peek()
+	switch {
+	case tok.Type == TokenAnchor:
+		p.next()
+		return &Anchor{Name: strings.TrimPrefix(tok.Value, "#")}
+	case isSymbol(tok, "#"):
+		p.errorf(tok, "anchor missing name")
+	case isKeyword(tok, "and"), isKeyword(tok, "or"):
+		if isSymbol(p.peekAt(1), "(") {
+			p.next()
+			return &List{Op: tok.Value, Item: p.parseParenList()}
+		}
+	case isKeyword(tok, "not"):
+		p.next()
+		return &Not{Exp: p.parseItem()}
+	case isKeyword(tok, "exists"):
+		p.next()
+		p.expectSymbol("(")
+		list := p.parseStmtList(")")
+		end := p.expectSymbol(")")
+		if len(list) != 1 {
+			p.errorf(end, "exists must contain a single statement")
+		}
+		return &Exists{Stmt: list[0]}
+	}
+	left := p.parseValue()
+	op := p.peek()
+	if isKeyword(op, "in") {
+		p.next()
+		return p.parseIn(left)
+	}
+	switch {
+	case op.Type == TokenSymbol:
+		switch op.Value {
+		default:
+			return left
+		case "=", "<>", "!=", "<", "<=", ">", ">=":
+		}
+	case isKeyword(op, "like"):
+	default:
+		return left
+	}
+	p.next()
+	return &Binary{Op: op.Value, Left: left, Right: p.parseValue()}
+}
+
+// parseIn parses the sub-query or value list after "in".
+func (p *Parser) parseIn(left Expr) Expr {
+	in := &In{Exp: left}
+	p.expectSymbol("(")
+	p.skipNewline()
+	if isKeyword(p.peek(), "from") {
+		list := p.parseStmtList(")")
+		end := p.expectSymbol(")")
+		if len(list) != 1 || len(list[0].Select) != 1 {
+			p.errorf(end, "in must contain a single statement with a single select column")
+		}
+		in.Stmt = &list[0]
+		return in
+	}
+	for {
+		p.skipNewline()
+		if isSymbol(p.peek(), ")") {
+			p.next()
+			if len(in.List) == 0 {
+				p.errorf(p.peek(), "in missing value")
+			}
+			return in
+		}
+		in.List = append(in.List, p.parseValue())
+		if isSymbol(p.peek(), ",") {
+			p.next()
+		}
+	}
+}
+
+// parseValue parses additive arithmetic.
+func (p *Parser) parseValue() Expr {
+	left := p.parseTerm()
+	for {
+		op := p.peek()
+		if !isSymbol(op, "+") && !isSymbol(op, "-") {
+			return left
+		}
+		p.next()
+		left = &Binary{Op: op.Value, Left: left, Right: p.parseTerm()}
+	}
+}
+
+// parseTerm parses multiplicative arithmetic.
+func (p *Parser) parseTerm() Expr {
+	left := p.parseOperand()
+	for {
+		op := p.peek()
+		if !isSymbol(op, "*") && !isSymbol(op, "/") && !isSymbol(op, "%") {
+			return left
+		}
+		p.next()
+		left = &Binary{Op: op.Value, Left: left, Right: p.parseOperand()}
+	}
+}
+
+func (p *Parser) parseOperand() Expr {
+	tok := p.next()
+	switch tok.Type {
+	case TokenNumber, TokenString, TokenStringWithEscape:
+		return &Literal{Type: tok.Type, Value: tok.Value}
+	case TokenIdentifier:
+		switch tok.Value {
+		case "true", "false", "null":
+			return &Literal{Type: tok.Type, Value: tok.Value}
+		}
+		next := p.peek()
+		switch {
+		case isSymbol(next, "."):
+			p.next()
+			return &ColumnRef{Alias: tok.Value, Column: p.expectIdent().Value}
+		case isSymbol(next, "("):
+			p.next()
+			c := &Call{Name: tok.Value}
+			for !isSymbol(p.peek(), ")") {
+				c.Arg = append(c.Arg, p.parseItem())
+				if !isSymbol(p.peek(), ",") {
+					break
+				}
+				p.next()
+			}
+			p.expectSymbol(")")
+			return c
+		}
+		return &Ident{Name: tok.Value}
+	case TokenSymbol:
+		switch tok.Value {
+		case "(":
+			e := p.parseItem()
+			p.expectSymbol(")")
+			return e
+		case "-":
+			num := p.next()
+			if num.Type != TokenNumber {
+				p.errorf(num, "expected number after \"-\", got %s", describe(num))
+			}
+			return &Literal{Type: TokenNumber, Value: "-" + num.Value}
+		}
+	}
+	p.errorf(tok, "unexpected %s, expected value", describe(tok))
+	return nil
+}
+
+func (p *Parser) parseSelectList() []SelectColumn {
+	var list []SelectColumn
+	for {
+		p.skipNewline()
+		tok := p.peek()
+		if p.eof() || p.clauseKeyword() || isSymbol(tok, ")") || isSymbol(tok, "}") {
+			if len(list) == 0 {
+				p.errorf(tok, "select missing column")
+			}
+			return list
+		}
+		sc := SelectColumn{Notes: Notes{Note: p.above()}}
+		if tok.Type == TokenIdentifier && isSymbol(p.peekAt(1), "=") {
+			p.next()
+			p.next()
+			sc.Name = tok.Value
+			sc.Exp = p.parseValue()
+		} else {
+			sc.Exp = p.parseValue()
+			switch name := p.peek(); name.Type {
+			case TokenIdentifierQuoted:
+				p.next()
+				sc.Name = Unquote(name)
+			case TokenIdentifier:
+				if !p.clauseKeyword() {
+					p.next()
+					sc.Name = name.Value
+				}
+			}
+		}
+		if isSymbol(p.peek(), ",") {
+			p.next()
+		}
+		sc.Note = append(sc.Note, p.right()...)
+		list = append(list, sc)
+	}
+}
+
+func (p *Parser) parseOrderList() []OrderColumn {
+	var list []OrderColumn
+	for {
+		p.skipNewline()
+		tok := p.peek()
+		if p.eof() || p.clauseKeyword() || isSymbol(tok, ")") || isSymbol(tok, "}") {
+			if len(list) == 0 {
+				p.errorf(tok, "order missing column")
+			}
+			return list
+		}
+		oc := OrderColumn{Notes: Notes{Note: p.above()}}
+		oc.Exp = p.parseValue()
+		switch {
+		case isKeyword(p.peek(), "asc"):
+			p.next()
+		case isKeyword(p.peek(), "desc"):
+			p.next()
+			oc.Desc = true
+		}
+		if isSymbol(p.peek(), ",") {
+			p.next()
+		}
+		oc.Note = append(oc.Note, p.right()...)
+		list = append(list, oc)
+	}
+}
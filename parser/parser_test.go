@@ -0,0 +1,441 @@
+// Copyright 2018 solidcoredata authors.
+
+package parser
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	src := `package foo
+
+book table {
+	alias: b
+	display: Library Books
+
+	id int64 serial key
+	name text default 'Hello World''s' {display: Book Name, tag: search}
+	account *account.id {
+		null:
+		comment: Account that owns the book.
+	}
+}
+
+books query {
+	from
+		book b
+		join account a and b.account = a.id
+	and
+		b.name = 'Robert'
+		#where1
+		or (
+			a.id = 0
+			b.name = 'Nothing'
+		)
+	select
+		b.id, NamePart = b.name,
+	order
+		b.name asc
+	limit 50 offset 10
+}
+`
+	f, err := Parse(context.Background(), "foo.scd", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range f.Errors {
+		t.Fatal(e)
+	}
+	if g, w := f.Package.Name, "foo"; g != w {
+		t.Fatalf("package got %q, want %q", g, w)
+	}
+	if g, w := len(f.Table), 1; g != w {
+		t.Fatalf("got %d tables, want %d", g, w)
+	}
+	tb := f.Table[0]
+	if tb.Alias != "b" || tb.Display != "Library Books" {
+		t.Fatalf("bad table properties: %+v", tb)
+	}
+	if col := tb.Column[1]; col.Display != "Book Name" || col.Default != "'Hello World''s'" || len(col.Tag) != 1 {
+		t.Fatalf("bad column: %+v", col)
+	}
+	col := tb.Column[2]
+	if col.LinkTable != "account" || col.LinkColumn != "id" || !col.Nullable {
+		t.Fatalf("bad link column: %+v", col)
+	}
+	if g, w := len(f.Query), 1; g != w {
+		t.Fatalf("got %d queries, want %d", g, w)
+	}
+	st := f.Query[0].Stmt
+	if g, w := len(st), 1; g != w {
+		t.Fatalf("got %d statements, want %d", g, w)
+	}
+	s := st[0]
+	if g, w := len(s.From), 2; g != w {
+		t.Fatalf("got %d from, want %d", g, w)
+	}
+	if g, w := len(s.And), 3; g != w {
+		t.Fatalf("got %d conditions, want %d", g, w)
+	}
+	if a, ok := s.And[1].(*Anchor); !ok || a.Name != "where1" {
+		t.Fatalf("expected anchor where1, got %#v", s.And[1])
+	}
+	if l, ok := s.And[2].(*List); !ok || l.Op != "or" || len(l.Item) != 2 {
+		t.Fatalf("expected or list, got %#v", s.And[2])
+	}
+	if g, w := s.Select[1].Name, "NamePart"; g != w {
+		t.Fatalf("select name got %q, want %q", g, w)
+	}
+	if s.Limit != "50" || s.Offset != "10" {
+		t.Fatalf("limit %q offset %q", s.Limit, s.Offset)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	f, err := Parse(context.Background(), "bad.scd", "package foo\n\nbook table {\n\tid\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := len(f.Errors), 1; g != w {
+		t.Fatalf("got %d errors, want %d", g, w)
+	}
+	if g, w := f.Errors[0].Start.Line, 4; g != w {
+		t.Fatalf("error line got %d, want %d: %v", g, w, f.Errors[0])
+	}
+}
+
+func TestParseRecover(t *testing.T) {
+	src := `package foo
+
+import (
+	a/b
+	c//d
+	e/f
+)
+
+book table {
+	id int64 serial key
+	name ~ text
+}
+
+bad table {
+	id
+}
+
+books query {
+	from book b
+	and
+		b.id = 
+	select b.id, b.name
+	order b.name ~
+	limit x
+}
+
+after query {
+	from book b and b.id = ?
+	select b.id
+}
+
+last query {
+	from book b
+	select b.name
+}
+`
+	f, err := Parse(context.Background(), "bad.scd", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLine := []int{5, 11, 15, 21, 23, 24, 28}
+	if g, w := len(f.Errors), len(wantLine); g != w {
+		for _, e := range f.Errors {
+			t.Log(e)
+		}
+		t.Fatalf("got %d errors, want %d", g, w)
+	}
+	for i, e := range f.Errors {
+		if g, w := e.Start.Line, wantLine[i]; g != w {
+			t.Errorf("error %d line got %d, want %d: %v", i, g, w, e)
+		}
+	}
+	if g, w := len(f.Import), 2; g != w {
+		t.Fatalf("got %d imports, want %d", g, w)
+	}
+	if g, w := len(f.Query), 3; g != w {
+		t.Fatalf("got %d queries, want %d", g, w)
+	}
+	if g, w := len(f.Query[0].Stmt[0].Select), 2; g != w {
+		t.Fatalf("got %d select columns after error, want %d", g, w)
+	}
+	if g, w := f.Query[2].Name, "last"; g != w {
+		t.Fatalf("last query got %q, want %q", g, w)
+	}
+}
+
+func TestParseImport(t *testing.T) {
+	src := `package ar
+
+import (
+	coredata.biz/app1/role
+	r2 coredata.biz/app1/role-v2
+)
+import s/v1/time
+
+account table {
+	id int64 serial key
+	owner *role.user.id
+}
+`
+	f, err := Parse(context.Background(), "ar.scd", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range f.Errors {
+		t.Fatal(e)
+	}
+	want := []Import{
+		{Name: "role", Path: "coredata.biz/app1/role"},
+		{Name: "r2", Path: "coredata.biz/app1/role-v2"},
+		{Name: "time", Path: "s/v1/time"},
+	}
+	if g, w := len(f.Import), len(want); g != w {
+		t.Fatalf("got %d imports, want %d", g, w)
+	}
+	for i, imp := range f.Import {
+		if imp.Name != want[i].Name || imp.Path != want[i].Path {
+			t.Fatalf("import %d got %s %s, want %s %s", i, imp.Name, imp.Path, want[i].Name, want[i].Path)
+		}
+	}
+	col := f.Table[0].Column[1]
+	if col.LinkTable != "role.user" || col.LinkColumn != "id" {
+		t.Fatalf("bad qualified link: %+v", col)
+	}
+
+	list := []struct {
+		src string
+		err string
+	}{
+		{"import a. b", `unexpected "b"`},
+		{"import a/'b'", "in path"},
+		{"import a//b", `invalid path "a//b"`},
+		{"import (\n\ta/b\n\tb c/d\n)", "b imported more than once"},
+	}
+	for _, item := range list {
+		f, err := Parse(context.Background(), "ar.scd", "package ar\n\n"+item.src+"\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(f.Errors) != 1 || !strings.Contains(f.Errors[0].Error(), item.err) {
+			t.Errorf("%q: got errors %v, want %q", item.src, f.Errors, item.err)
+		}
+	}
+}
+
+func TestParseModule(t *testing.T) {
+	m, err := ParseModule(context.Background(), ModuleFileName, "module coredata.biz/app1\n\nrequire (\n\ts/v1/time v1.0.1\n)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Errors) > 0 {
+		t.Fatal(m.Errors)
+	}
+	if len(m.Require) != 1 || m.Require[0].Path != "s/v1/time" || m.Require[0].Version != "v1.0.1" {
+		t.Fatalf("got require %+v, want s/v1/time v1.0.1", m.Require)
+	}
+
+	list := []struct {
+		src string
+		err string
+	}{
+		{"require s/../time v1.0.1", `invalid path "s/../time"`},
+		{"require s/./time v1.0.1", `invalid path "s/./time"`},
+		{"require ../time v1.0.1", `expected identifier, got "."`},
+		{"require /s/time v1.0.1", `expected identifier, got "/"`},
+		{"require s//time v1.0.1", `invalid path "s//time"`},
+		{"require s/time/ v1.0.1", `invalid path "s/time/"`},
+		{"module a/..", `invalid path "a/.."`},
+	}
+	for _, item := range list {
+		src := "module m\n" + item.src + "\n"
+		if strings.HasPrefix(item.src, "module") {
+			src = item.src + "\n"
+		}
+		m, err := ParseModule(context.Background(), ModuleFileName, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Errors) == 0 || !strings.Contains(m.Errors[0].Error(), item.err) {
+			t.Errorf("%q: got errors %v, want %q", item.src, m.Errors, item.err)
+		}
+	}
+}
+
+func TestParseDetail(t *testing.T) {
+	src := `package foo
+
+accounts query {
+	from account a
+	select a.id, a.name
+	detail ledgers (
+		from ledger l
+		and l.account = a.id
+		select l.id, l.amount
+		order l.id
+	)
+	detail owner (from person p and p.id = a.owner select p.name)
+	from account b
+	select b.id
+}
+
+bad query {
+	from account a
+	select a.id
+	detail x (from ledger l)
+}
+`
+	f, err := Parse(context.Background(), "foo.scd", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := len(f.Errors), 1; g != w {
+		t.Fatalf("got errors %v, want %d", f.Errors, w)
+	}
+	if g, w := f.Errors[0].Start.Line, 20; g != w {
+		t.Fatalf("error line got %d, want %d: %v", g, w, f.Errors[0])
+	}
+	st := f.Query[0].Stmt
+	if g, w := len(st), 2; g != w {
+		t.Fatalf("got %d statements, want %d", g, w)
+	}
+	d := st[0].Detail
+	if len(d) != 2 || d[0].Name != "ledgers" || d[1].Name != "owner" {
+		t.Fatalf("got details %+v", d)
+	}
+	if s := d[0].Stmt; len(s.From) != 1 || len(s.And) != 1 || len(s.Select) != 2 || len(s.Order) != 1 {
+		t.Fatalf("bad detail statement: %+v", s)
+	}
+}
+
+func TestParseLock(t *testing.T) {
+	f, err := Parse(context.Background(), "lock.scd", "package foo\n\nbook table {\n\tid int64 key\n\tversion int64 {lock: insert}\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := len(f.Errors), 1; g != w {
+		t.Fatalf("got %d errors, want %d", g, w)
+	}
+	if g, w := f.Errors[0].Start.Line, 5; g != w {
+		t.Fatalf("error line got %d, want %d: %v", g, w, f.Errors[0])
+	}
+}
+
+func TestParseTablePort(t *testing.T) {
+	src := `package foo
+
+book table {
+	alias: b
+	read: and (b.deleted = false,
+		b.shelf in (1, 2))
+	id int64 key
+	deleted bool
+	port web {
+		role reader: read return
+		role none:
+		deny update: b.id = user
+		column deleted {
+			deny read: true
+		}
+	}
+}
+
+bad table {
+	read: a.id = 1 2
+	id int64 key
+}
+`
+	f, err := Parse(context.Background(), "port.scd", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := len(f.Errors), 1; g != w {
+		t.Fatalf("got %d errors, want %d: %v", g, w, f.Errors)
+	}
+	if g, w := f.Errors[0].Start.Line, 20; g != w {
+		t.Fatalf("error line got %d, want %d: %v", g, w, f.Errors[0])
+	}
+	tb := f.Table[0]
+	if len(tb.Read) != 1 || len(tb.Column) != 2 || len(tb.Port) != 1 {
+		t.Fatalf("bad table %+v", tb)
+	}
+	if l, ok := tb.Read[0].Exp.(*List); !ok || len(l.Item) != 2 {
+		t.Fatalf("bad read rule %#v", tb.Read[0].Exp)
+	}
+	p := tb.Port[0]
+	if p.Name != "web" || len(p.Role) != 2 || len(p.Deny) != 1 || len(p.Column) != 1 {
+		t.Fatalf("bad port %+v", p)
+	}
+	if g := p.Role[0]; g.Role != "reader" || !reflect.DeepEqual(g.Allow, []string{"read", "return"}) {
+		t.Fatalf("bad grant %+v", g)
+	}
+	if g := p.Role[1]; g.Role != "none" || len(g.Allow) != 0 {
+		t.Fatalf("bad grant %+v", g)
+	}
+	if p.Deny[0].Op != "update" {
+		t.Fatalf("bad deny %+v", p.Deny[0])
+	}
+	if c := p.Column[0]; c.Name != "deleted" || len(c.Deny) != 1 || c.Deny[0].Op != "read" {
+		t.Fatalf("bad column port %+v", c)
+	}
+}
+
+func TestParseTest(t *testing.T) {
+	src := `package foo
+
+books test {
+	query book.list
+	port web
+	role reader
+	param limit = 10
+	input book (id, name) {
+		1, 'Emma'
+		2, -3
+	}
+	output (name) {
+		'Emma'
+	}
+}
+
+bad test {
+	query books
+	expect (name) {}
+}
+`
+	f, err := Parse(context.Background(), "foo_test.scd", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := len(f.Errors), 1; g != w {
+		t.Fatalf("got errors %v, want %d", f.Errors, w)
+	}
+	if g, w := f.Errors[0].Start.Line, 19; g != w {
+		t.Fatalf("error line got %d, want %d: %v", g, w, f.Errors[0])
+	}
+	if g, w := len(f.Test), 1; g != w {
+		t.Fatalf("got %d tests, want %d", g, w)
+	}
+	x := f.Test[0]
+	if x.Query != "book.list" || x.Port != "web" || !reflect.DeepEqual(x.Role, []string{"reader"}) || len(x.Param) != 1 {
+		t.Fatalf("bad test: %+v", x)
+	}
+	if len(x.Input) != 1 || x.Input[0].Table != "book" || len(x.Input[0].Row) != 2 || len(x.Input[0].Row[1].Value) != 2 {
+		t.Fatalf("bad input: %+v", x.Input)
+	}
+	if v := x.Input[0].Row[1].Value[1].(*Literal).Value; v != "-3" {
+		t.Fatalf("got value %q, want -3", v)
+	}
+	if len(x.Output) != 1 || x.Output[0].Table != "" || !reflect.DeepEqual(x.Output[0].Column, []string{"name"}) {
+		t.Fatalf("bad output: %+v", x.Output)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"reflect"
 	"runtime"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -28,6 +29,8 @@ const (
 	TokenIdentifierQuoted
 	TokenLineComment
 	TokenMultiComment
+	TokenAnchor
+	TokenEOF // Returned by Scanner.Next at the end of the source.
 )
 
 // Position of a byte within a file.
@@ -77,6 +80,7 @@ func Lex1(ctx context.Context, src string, t chan Token) error {
 type lexer struct {
 	source string
 	next   chan Token
+	out    []Token // Tokens sent when next is nil.
 
 	start Position
 	end   Position
@@ -84,6 +88,10 @@ type lexer struct {
 	runeSize     int
 	currentRune  rune
 	previousRune rune
+
+	// operand is set when the last token sent may end an operand, so a
+	// following "-" is an operator rather than the sign of a number.
+	operand bool
 }
 
 type stateFn func(context.Context, *lexer) stateFn
@@ -94,26 +102,67 @@ const (
 	rightComment = "*/"
 )
 
+// operators are the symbols made of more than one character.
+// All other symbols are a single character.
+var operators = []string{
+	"::", // Cast.
+	":?", // Test cast.
+	"<=",
+	">=",
+	"<>",
+	"!=",
+}
+
+// operandWords are the keywords that are followed by an operand, as in
+// "default -1" or "limit -1".
+var operandWords = map[string]bool{
+	"and":     true,
+	"default": true,
+	"in":      true,
+	"limit":   true,
+	"not":     true,
+	"offset":  true,
+	"or":      true,
+	"select":  true,
+}
+
 func (l *lexer) send(t TokenType) {
 	l.sendMessage(t, "")
 }
 
 func (l *lexer) sendMessage(t TokenType, msg string) {
-	if l.next == nil {
-		return
-	}
 	start, end := l.start, l.end
 	v := l.valueSync()
 	if len(v) == 0 {
 		return
 	}
-	l.next <- Token{
+	switch t {
+	case TokenWS, TokenLineComment, TokenMultiComment:
+	case TokenSymbol:
+		l.operand = v == ")" || v == "]"
+	case TokenIdentifier:
+		l.operand = !operandWords[v]
+	default:
+		l.operand = t != TokenNewline && t != TokenInvalid
+	}
+	tok := Token{
 		Type:    t,
 		Value:   v,
 		Message: msg,
 		Start:   start,
 		End:     end,
 	}
+	if l.next != nil {
+		l.next <- tok
+		return
+	}
+	l.out = append(l.out, tok)
+}
+
+// errorf sends the current value as an invalid token and reports the
+// error at the start of the value.
+func (l *lexer) errorf(f string, v ...interface{}) {
+	l.sendMessage(TokenInvalid, fmt.Sprintf(f, v...))
 }
 
 func (l *lexer) runeAt() rune {
@@ -122,6 +171,17 @@ func (l *lexer) runeAt() rune {
 	return l.currentRune
 }
 
+// runeAfter returns the rune after the current rune without consuming either.
+func (l *lexer) runeAfter() rune {
+	r, _ := utf8.DecodeRuneInString(l.source[l.end.Byte+l.runeSize:])
+	return r
+}
+
+// eof reports if the current rune is past the end of the source.
+func (l *lexer) eof() bool {
+	return l.end.Byte >= len(l.source)
+}
+
 func (l *lexer) nextRune() {
 	l.end.Byte += l.runeSize
 	if l.currentRune == '\n' {
@@ -132,6 +192,19 @@ func (l *lexer) nextRune() {
 	}
 }
 
+// hasPrefix reports if the source at the current rune starts with s.
+func (l *lexer) hasPrefix(s string) bool {
+	return strings.HasPrefix(l.source[l.end.Byte:], s)
+}
+
+// skip consumes the runes of s, which must be at the current rune.
+func (l *lexer) skip(s string) {
+	for range s {
+		l.runeAt()
+		l.nextRune()
+	}
+}
+
 func (l *lexer) value() string {
 	return l.source[l.start.Byte:l.end.Byte]
 }
@@ -175,23 +248,36 @@ func (*lexer) isSymbol(r rune) bool {
 	switch r {
 	default:
 		return false
-	case '{', '}', '-', '/', '*', '(', ')', '+', '%', '<', '>', '=', '.', ',', ';':
+	case '{', '}', '(', ')', '[', ']',
+		'-', '+', '*', '/', '%',
+		'<', '>', '=', '!',
+		'.', ',', ';', ':', '?', '@', '#':
 		return true
 	}
 }
-func (*lexer) isNumberStart(r rune) bool {
+func (*lexer) isAnchorStart(r rune) bool {
+	return r == '#'
+}
+func (l *lexer) isNumberStart(r rune) bool {
 	switch {
 	default:
 		return false
-	case unicode.IsNumber(r):
+	case isDigit(r):
 		return true
+	case r == '-':
+		return !l.operand && isDigit(l.runeAfter())
 	}
 }
-func (*lexer) isNumber(r rune) bool {
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isHexDigit(r rune) bool {
 	switch {
 	default:
 		return false
-	case unicode.IsNumber(r), r == '.', r == ',':
+	case isDigit(r), r >= 'a' && r <= 'f', r >= 'A' && r <= 'F':
 		return true
 	}
 }
@@ -210,6 +296,9 @@ func stIdentifier(ctx context.Context, l *lexer) stateFn {
 	}
 	return nil
 }
+
+// stQuoteIdentifier reads a quoted identifier, such as "Account Name".
+// A double quote within the identifier is written twice.
 func stQuoteIdentifier(ctx context.Context, l *lexer) stateFn {
 	r := l.runeAt()
 	if r != '"' {
@@ -219,50 +308,83 @@ func stQuoteIdentifier(ctx context.Context, l *lexer) stateFn {
 	for ctx.Err() == nil {
 		r := l.runeAt()
 
-		switch r {
+		switch {
 		default:
 			l.nextRune()
-		case '"':
+		case r == '"':
 			l.nextRune()
+			if l.runeAt() == '"' {
+				l.nextRune()
+				continue
+			}
 			l.send(TokenIdentifierQuoted)
 			return stWhitespace
-		case '\n', '\r':
-			l.sendMessage(TokenInvalid, "quoted identifier not closed before newline")
+		case r == '\n', r == '\r', l.eof():
+			l.errorf("quoted identifier not terminated")
 			return stWhitespace
 		}
 	}
 	return nil
 }
 
-func stSymbol(ctx context.Context, l *lexer) stateFn {
+// stAnchor reads a named condition anchor, such as "#where1".
+// A "#" not followed by a name is a symbol.
+func stAnchor(ctx context.Context, l *lexer) stateFn {
+	r := l.runeAt()
+	if r != '#' {
+		panic("not starting an anchor")
+	}
+	l.nextRune()
+	if !l.isIdentiferStart(l.runeAt()) {
+		l.send(TokenSymbol)
+		return stWhitespace
+	}
 	for ctx.Err() == nil {
 		r := l.runeAt()
 
 		switch {
 		default:
-			l.send(TokenSymbol)
+			l.send(TokenAnchor)
 			return stWhitespace
-		case l.isSymbol(r):
-			l.nextRune()
-		case l.value() == lineComment:
-			l.nextRune()
-			return stLineComment
-		case l.value() == leftComment:
+		case l.isIdentifer(r):
 			l.nextRune()
-			return stMultiComment
 		}
 	}
 	return nil
 }
 
+// stSymbol reads a single symbol or operator, or starts a comment.
+func stSymbol(ctx context.Context, l *lexer) stateFn {
+	switch {
+	case l.hasPrefix(lineComment):
+		l.skip(lineComment)
+		return stLineComment
+	case l.hasPrefix(leftComment):
+		l.skip(leftComment)
+		return stMultiComment
+	}
+	for _, op := range operators {
+		if l.hasPrefix(op) {
+			l.skip(op)
+			l.send(TokenSymbol)
+			return stWhitespace
+		}
+	}
+	l.runeAt()
+	l.nextRune()
+	l.send(TokenSymbol)
+	return stWhitespace
+}
+
+// stLineComment reads to the end of the line or source.
 func stLineComment(ctx context.Context, l *lexer) stateFn {
 	for ctx.Err() == nil {
 		r := l.runeAt()
 
-		switch r {
+		switch {
 		default:
 			l.nextRune()
-		case '\n', '\r':
+		case r == '\n', r == '\r', l.eof():
 			l.send(TokenLineComment)
 			return stWhitespace
 		}
@@ -270,35 +392,74 @@ func stLineComment(ctx context.Context, l *lexer) stateFn {
 	return nil
 }
 
+// stMultiComment reads to the end of the comment. Comments do not nest.
 func stMultiComment(ctx context.Context, l *lexer) stateFn {
 	for ctx.Err() == nil {
-		l.runeAt()
-		l.nextRune()
-
-		end := l.source[l.end.Byte-2 : l.end.Byte]
-
-		if end == rightComment {
+		switch {
+		default:
+			l.runeAt()
+			l.nextRune()
+		case l.hasPrefix(rightComment):
+			l.skip(rightComment)
 			l.send(TokenMultiComment)
 			return stWhitespace
+		case l.eof():
+			l.errorf("comment not terminated")
+			return stWhitespace
 		}
 	}
 	return nil
 }
 
+// stNumber reads a number. Numbers may be negative, have a fraction
+// and an exponent, such as -1.5e-3, or be hexadecimal, such as 0x1F.
 func stNumber(ctx context.Context, l *lexer) stateFn {
-	for ctx.Err() == nil {
-		r := l.runeAt()
-
-		switch {
-		default:
-			l.send(TokenNumber)
+	digits := func(is func(rune) bool) int {
+		n := 0
+		for ctx.Err() == nil && is(l.runeAt()) {
+			l.nextRune()
+			n++
+		}
+		return n
+	}
+	if l.runeAt() == '-' {
+		l.nextRune()
+	}
+	switch {
+	case l.hasPrefix("0x"), l.hasPrefix("0X"):
+		l.skip("0x")
+		if digits(isHexDigit) == 0 {
+			l.errorf("hexadecimal number has no digits")
 			return stWhitespace
-		case l.isNumber(r):
+		}
+	default:
+		digits(isDigit)
+		if l.runeAt() == '.' && isDigit(l.runeAfter()) {
+			l.nextRune()
+			digits(isDigit)
+		}
+		if r := l.runeAt(); r == 'e' || r == 'E' {
 			l.nextRune()
+			if r := l.runeAt(); r == '+' || r == '-' {
+				l.nextRune()
+			}
+			if digits(isDigit) == 0 {
+				l.errorf("exponent has no digits")
+				return stWhitespace
+			}
 		}
 	}
-	return nil
+	if l.isIdentifer(l.runeAt()) {
+		digits(l.isIdentifer)
+		l.errorf("invalid number %q", l.value())
+		return stWhitespace
+	}
+	l.send(TokenNumber)
+	return stWhitespace
 }
+
+// stString reads a string literal. A single quote within the string is
+// written twice. Strings may span lines.
 func stString(ctx context.Context, l *lexer) stateFn {
 	r := l.runeAt()
 	if r != '\'' {
@@ -312,6 +473,9 @@ func stString(ctx context.Context, l *lexer) stateFn {
 		switch {
 		default:
 			l.nextRune()
+		case l.eof():
+			l.errorf("string not terminated")
+			return stWhitespace
 		case r == '\'':
 			l.nextRune()
 			if l.runeAt() == '\'' {
@@ -331,7 +495,8 @@ func stString(ctx context.Context, l *lexer) stateFn {
 }
 
 func stWhitespace(ctx context.Context, l *lexer) stateFn {
-	if l.end.Byte >= len(l.source) {
+	if l.eof() {
+		l.send(TokenWS)
 		return nil
 	}
 
@@ -340,13 +505,17 @@ func stWhitespace(ctx context.Context, l *lexer) stateFn {
 
 		switch {
 		default:
+			// Report the character and continue so later errors are also found.
 			l.send(TokenWS)
 			l.nextRune()
-			l.sendMessage(TokenInvalid, "unknown token")
-			l.nextRune()
+			l.errorf("unexpected character %q", r)
+		case l.eof():
+			l.send(TokenWS)
 			return nil
 		case r == utf8.RuneError:
-			return nil
+			l.send(TokenWS)
+			l.nextRune()
+			l.errorf("invalid UTF-8 encoding")
 		case r == '\n' || r == '\r':
 			l.send(TokenWS)
 			l.nextRune()
@@ -354,16 +523,20 @@ func stWhitespace(ctx context.Context, l *lexer) stateFn {
 		case l.isWhiteSpace(r):
 			l.nextRune()
 		case l.isQuoteIdentiferStart(r):
+			l.send(TokenWS)
 			return stQuoteIdentifier
 		case l.isIdentiferStart(r):
 			l.send(TokenWS)
 			return stIdentifier
-		case l.isSymbol(r):
-			l.send(TokenWS)
-			return stSymbol
 		case l.isNumberStart(r):
 			l.send(TokenWS)
 			return stNumber
+		case l.isAnchorStart(r):
+			l.send(TokenWS)
+			return stAnchor
+		case l.isSymbol(r):
+			l.send(TokenWS)
+			return stSymbol
 		case r == '\'':
 			l.send(TokenWS)
 			return stString
@@ -371,3 +544,18 @@ func stWhitespace(ctx context.Context, l *lexer) stateFn {
 	}
 	return nil
 }
+
+// Unquote returns the value of a string or quoted identifier token with
+// the quotes removed and escaped quotes replaced.
+func Unquote(tok Token) string {
+	switch tok.Type {
+	default:
+		return tok.Value
+	case TokenString, TokenStringWithEscape:
+		v := strings.TrimSuffix(strings.TrimPrefix(tok.Value, "'"), "'")
+		return strings.ReplaceAll(v, "''", "'")
+	case TokenIdentifierQuoted:
+		v := strings.TrimSuffix(strings.TrimPrefix(tok.Value, `"`), `"`)
+		return strings.ReplaceAll(v, `""`, `"`)
+	}
+}
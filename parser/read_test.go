@@ -82,3 +82,91 @@ define "dancing" query {
 		})
 	}
 }
+
+func lexAll(t *testing.T, src string) []Token {
+	t.Helper()
+	tc := make(chan Token, 100)
+	var list []Token
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for tok := range tc {
+			switch tok.Type {
+			case TokenWS, TokenNewline:
+				continue
+			}
+			list = append(list, tok)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := Lex1(ctx, src, tc)
+	close(tc)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestLexToken(t *testing.T) {
+	list := []struct {
+		src  string
+		want []string // Type:Value of each token.
+	}{
+		{`a=(b)`, []string{"Identifier:a", "Symbol:=", "Symbol:(", "Identifier:b", "Symbol:)"}},
+		{`x::int64 y:?int64`, []string{"Identifier:x", "Symbol:::", "Identifier:int64", "Identifier:y", "Symbol::?", "Identifier:int64"}},
+		{`a<=b<>c!=d>=e<f`, []string{"Identifier:a", "Symbol:<=", "Identifier:b", "Symbol:<>", "Identifier:c", "Symbol:!=", "Identifier:d", "Symbol:>=", "Identifier:e", "Symbol:<", "Identifier:f"}},
+		{`[unique] @p ! ? *account.id #`, []string{"Symbol:[", "Identifier:unique", "Symbol:]", "Symbol:@", "Identifier:p", "Symbol:!", "Symbol:?", "Symbol:*", "Identifier:account", "Symbol:.", "Identifier:id", "Symbol:#"}},
+		{`#where1 and`, []string{"Anchor:#where1", "Identifier:and"}},
+		{`1,2 1.5 1.5e10 2E-3 0x1F`, []string{"Number:1", "Symbol:,", "Number:2", "Number:1.5", "Number:1.5e10", "Number:2E-3", "Number:0x1F"}},
+		{`a = -5 b-5 (c)-1 - 2`, []string{"Identifier:a", "Symbol:=", "Number:-5", "Identifier:b", "Symbol:-", "Number:5", "Symbol:(", "Identifier:c", "Symbol:)", "Symbol:-", "Number:1", "Symbol:-", "Number:2"}},
+		{`default -1 limit -2 x -3`, []string{"Identifier:default", "Number:-1", "Identifier:limit", "Number:-2", "Identifier:x", "Symbol:-", "Number:3"}},
+		{`v1.0.1`, []string{"Identifier:v1", "Symbol:.", "Number:0.1"}},
+		{`'it''s' "Account ""A"""`, []string{"StringWithEscape:'it''s'", `IdentifierQuoted:"Account ""A"""`}},
+		{"a -- comment", []string{"Identifier:a", "LineComment:-- comment"}},
+		{"a /* b */ c /*/ d */", []string{"Identifier:a", "MultiComment:/* b */", "Identifier:c", "MultiComment:/*/ d */"}},
+		{"1e 0x 12ab", []string{"Invalid:1e", "Invalid:0x", "Invalid:12ab"}},
+		{"a 'open\nstring", []string{"Identifier:a", "Invalid:'open\nstring"}},
+		{"a /* open", []string{"Identifier:a", "Invalid:/* open"}},
+		{"\"open\nb", []string{`Invalid:"open`, "Identifier:b"}},
+		{"a ~ b", []string{"Identifier:a", "Invalid:~", "Identifier:b"}},
+	}
+	for _, item := range list {
+		t.Run(item.src, func(t *testing.T) {
+			got := lexAll(t, item.src)
+			if len(got) != len(item.want) {
+				t.Fatalf("got %d tokens %v, want %d", len(got), got, len(item.want))
+			}
+			for i, tok := range got {
+				if g, w := tok.Type.String()+":"+tok.Value, item.want[i]; g != w {
+					t.Errorf("token %d got %q, want %q", i, g, w)
+				}
+			}
+		})
+	}
+}
+
+func TestLexErrorPosition(t *testing.T) {
+	got := lexAll(t, "a\n  'never closed\n")
+	if g, w := len(got), 2; g != w {
+		t.Fatalf("got %d tokens, want %d", g, w)
+	}
+	e := got[1]
+	if e.Type != TokenInvalid || e.Message != "string not terminated" {
+		t.Fatalf("got %v, want unterminated string", e)
+	}
+	if e.Start.Line != 2 || e.Start.LineRune != 3 {
+		t.Fatalf("error at %d:%d, want 2:3", e.Start.Line, e.Start.LineRune)
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	got := lexAll(t, `'it''s' "a ""b"""`)
+	if g, w := Unquote(got[0]), "it's"; g != w {
+		t.Fatalf("got %q, want %q", g, w)
+	}
+	if g, w := Unquote(got[1]), `a "b"`; g != w {
+		t.Fatalf("got %q, want %q", g, w)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2018 solidcoredata authors.
+
+package parser
+
+import (
+	"context"
+	"sort"
+)
+
+// Scanner reads the tokens of a source one at a time. Unlike Lex1 it does
+// not require a goroutine or channel to read tokens, and a Scanner may be
+// reset and reused to lex many sources without re-allocating its buffers.
+//
+//	s := NewScanner(src)
+//	for tok := s.Next(); tok.Type != TokenEOF; tok = s.Next() {
+//		...
+//	}
+type Scanner struct {
+	l     lexer
+	state stateFn
+	i     int // Index of the next token in l.out.
+}
+
+// NewScanner returns a Scanner reading src.
+func NewScanner(src []byte) *Scanner {
+	s := &Scanner{}
+	s.Reset(src)
+	return s
+}
+
+// Reset the scanner to read src from the start, keeping its buffers.
+// The source is copied once so token values do not need to be allocated.
+func (s *Scanner) Reset(src []byte) {
+	s.resetAt(string(src), newPos(), false)
+}
+
+func (s *Scanner) resetAt(src string, pos Position, operand bool) {
+	s.l = lexer{
+		source:  src,
+		out:     s.l.out[:0],
+		start:   pos,
+		end:     pos,
+		operand: operand,
+	}
+	s.state = stWhitespace
+	s.i = 0
+}
+
+// Next returns the next token, including whitespace and comments.
+// At the end of the source it returns a token of type TokenEOF.
+func (s *Scanner) Next() Token {
+	ctx := context.Background()
+	for s.i >= len(s.l.out) {
+		if s.state == nil {
+			return Token{Type: TokenEOF, Start: s.l.end, End: s.l.end}
+		}
+		s.l.out = s.l.out[:0]
+		s.i = 0
+		s.state = s.state(ctx, &s.l)
+	}
+	tok := s.l.out[s.i]
+	s.i++
+	return tok
+}
+
+// Scan appends each remaining token to dst and returns the extended slice.
+func (s *Scanner) Scan(dst []Token) []Token {
+	for {
+		tok := s.Next()
+		if tok.Type == TokenEOF {
+			return dst
+		}
+		dst = append(dst, tok)
+	}
+}
+
+// Edit describes a change to a source: the bytes Start through End-1 of
+// the old source were replaced by Size bytes.
+type Edit struct {
+	Start int
+	End   int
+	Size  int
+}
+
+// Relex returns the tokens of src, which is the old source changed by e,
+// appended to dst. The old tokens are the tokens of the old source, as
+// returned by Scan. Only the lines touched by the edit are lexed again.
+// Tokens after the edit are reused from old with their positions moved.
+// The old slice must not share memory with dst.
+func (s *Scanner) Relex(dst, old []Token, src []byte, e Edit) []Token {
+	// Lexing restarts after the last newline before the edit. Tokens never
+	// span a newline token, so the lexer state is known there.
+	first := sort.Search(len(old), func(i int) bool {
+		return old[i].End.Byte >= e.Start
+	})
+	restart := 0
+	for i := first - 1; i >= 0; i-- {
+		if old[i].Type == TokenNewline {
+			restart = i + 1
+			break
+		}
+	}
+	dst = append(dst, old[:restart]...)
+	pos := newPos()
+	if restart > 0 {
+		pos = old[restart-1].End
+	}
+	s.resetAt(string(src), pos, false)
+
+	delta := e.Size - (e.End - e.Start)
+	editEnd := e.Start + e.Size
+	for {
+		tok := s.Next()
+		if tok.Type == TokenEOF {
+			return dst
+		}
+		dst = append(dst, tok)
+		if tok.Type != TokenNewline || tok.Start.Byte < editEnd {
+			continue
+		}
+		// Past the edit a newline that was also in the old source
+		// means the remaining old tokens are unchanged.
+		oldByte := tok.Start.Byte - delta
+		j := sort.Search(len(old), func(i int) bool {
+			return old[i].Start.Byte >= oldByte
+		})
+		if j >= len(old) || old[j].Start.Byte != oldByte || old[j].Type != TokenNewline {
+			continue
+		}
+		lines := tok.Start.Line - old[j].Start.Line
+		for _, t := range old[j+1:] {
+			t.Start.Byte += delta
+			t.End.Byte += delta
+			t.Start.Line += lines
+			t.End.Line += lines
+			dst = append(dst, t)
+		}
+		return dst
+	}
+}
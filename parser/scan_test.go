@@ -0,0 +1,171 @@
+// Copyright 2018 solidcoredata authors.
+
+package parser
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const scanSource = `package foo
+
+-- Accounts.
+account table {
+	id int64 serial key
+	name text default 'it''s' /* multi
+line */
+	owner *role.user.id {null:, display: "Owner ""Name"""}
+}
+
+books query {
+	from account a
+	and
+		a.id = -5
+		a.id <> 0x1F
+		#where1
+	select a.name, n = a.id - 1
+}
+`
+
+// lexChan returns every token from Lex1.
+func lexChan(t testing.TB, src string) []Token {
+	tc := make(chan Token, 100)
+	var list []Token
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for tok := range tc {
+			list = append(list, tok)
+		}
+	}()
+	err := Lex1(context.Background(), src, tc)
+	close(tc)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestScanner(t *testing.T) {
+	want := lexChan(t, scanSource)
+	s := NewScanner([]byte(scanSource))
+	got := s.Scan(nil)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("scanner tokens differ from Lex1:\ngot  %v\nwant %v", got, want)
+	}
+	if tok := s.Next(); tok.Type != TokenEOF {
+		t.Fatalf("got %v after end, want EOF", tok)
+	}
+
+	// Reset reuses the scanner for another source.
+	s.Reset([]byte("a b"))
+	if got := s.Scan(got[:0]); len(got) != 3 || got[2].Value != "b" {
+		t.Fatalf("after reset got %v", got)
+	}
+}
+
+func TestRelex(t *testing.T) {
+	list := []struct {
+		name string
+		old  string
+		new  string
+	}{
+		{"rename", "account", "ledger"},
+		{"insert line", "\tname text default", "\tcreated timestamp\n\tname text default"},
+		{"remove line", "\tid int64 serial key\n", ""},
+		{"open comment", "-- Accounts.", "/* Accounts."},
+		{"close string", "'it''s'", "'it''s"},
+		{"negative", "a.id = -5", "a.id -5"},
+		{"end", "select a.name, n = a.id - 1\n}", "select a.name\n}\n\nmore query {}"},
+	}
+	for _, item := range list {
+		t.Run(item.name, func(t *testing.T) {
+			i := strings.Index(scanSource, item.old)
+			if i < 0 {
+				t.Fatalf("%q not in source", item.old)
+			}
+			src := scanSource[:i] + item.new + scanSource[i+len(item.old):]
+			s := NewScanner([]byte(scanSource))
+			old := s.Scan(nil)
+
+			got := s.Relex(nil, old, []byte(src), Edit{Start: i, End: i + len(item.old), Size: len(item.new)})
+			want := NewScanner([]byte(src)).Scan(nil)
+			if !reflect.DeepEqual(got, want) {
+				for j := range want {
+					if j >= len(got) || got[j] != want[j] {
+						t.Fatalf("token %d differs", j)
+					}
+				}
+				t.Fatalf("got %d tokens, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+// benchSource returns a schema with n tables and a query for each.
+func benchSource(n int) string {
+	b := &strings.Builder{}
+	b.WriteString("package bench\n\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(b, `-- Table %[1]d.
+t%[1]d table {
+	alias: t%[1]d
+	id int64 serial key
+	name text default 'name''s' {display: "Name %[1]d"}
+	amount decimal default -1.5e3
+	parent *t%[1]d.id {null:}
+}
+
+q%[1]d query {
+	from t%[1]d a
+	join t%[1]d b and b.parent = a.id
+	and
+		a.amount >= 0x10
+		#where1
+		or (a.name <> 'x', b.id != -1)
+	select a.id, a.name /* name */, total = a.amount + b.amount
+}
+
+`, i)
+	}
+	return b.String()
+}
+
+func BenchmarkLex1(b *testing.B) {
+	src := benchSource(1000)
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		lexChan(b, src)
+	}
+}
+
+func BenchmarkScanner(b *testing.B) {
+	src := []byte(benchSource(1000))
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	s := &Scanner{}
+	var list []Token
+	for i := 0; i < b.N; i++ {
+		s.Reset(src)
+		list = s.Scan(list[:0])
+	}
+}
+
+func BenchmarkRelex(b *testing.B) {
+	old := benchSource(1000)
+	s := NewScanner([]byte(old))
+	tok := s.Scan(nil)
+	i := strings.Index(old, "t500 table")
+	src := []byte(old[:i] + "renamed" + old[i+len("t500"):])
+	e := Edit{Start: i, End: i + len("t500"), Size: len("renamed")}
+	b.ReportAllocs()
+	var list []Token
+	for n := 0; n < b.N; n++ {
+		list = s.Relex(list[:0], tok, src, e)
+	}
+}
@@ -4,9 +4,9 @@ package parser
 
 import "strconv"
 
-const _TokenType_name = "InvalidNewlineWSSymbolStringStringWithEscapeNumberIdentifierIdentifierQuotedLineCommentMultiComment"
+const _TokenType_name = "InvalidNewlineWSSymbolStringStringWithEscapeNumberIdentifierIdentifierQuotedLineCommentMultiCommentAnchorEOF"
 
-var _TokenType_index = [...]uint8{0, 7, 14, 16, 22, 28, 44, 50, 60, 76, 87, 99}
+var _TokenType_index = [...]uint8{0, 7, 14, 16, 22, 28, 44, 50, 60, 76, 87, 99, 105, 108}
 
 func (i TokenType) String() string {
 	if i < 0 || i >= TokenType(len(_TokenType_index)-1) {
@@ -0,0 +1,117 @@
+package query
+
+import (
+	"fmt"
+)
+
+// Anchor is a named point in a statement condition where additional
+// conditions may be inserted, declared in source as "#where1".
+// Conditions added to an anchor are combined with "and" and take the place
+// of the anchor within the enclosing condition.
+type Anchor struct {
+	Name string
+	From []*ResultTableSchema // Tables visible at the anchor, outer most first.
+	Exp  []Exp                // Added conditions.
+}
+
+var _ ExpList = &Anchor{}
+
+// AddCondition adds a condition to the anchor without checking it.
+func (a *Anchor) AddCondition(exp Exp) {
+	a.Exp = append(a.Exp, exp)
+}
+
+func (a *Anchor) visible(alias string) bool {
+	for _, rt := range a.From {
+		if rt.Alias == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// FindAnchor returns the named anchor, or nil if not found.
+// The top level of the statement is the anchor with an empty name.
+func (s *Stmt) FindAnchor(name string) *Anchor {
+	for _, a := range s.Anchor {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// AddConditionAt adds a condition at the named anchor. Each table alias
+// the condition references must be visible at the anchor, and each table
+// and column it references must be in the store. The condition may not
+// declare anchors of its own.
+func (s *Stmt) AddConditionAt(store *Store, name string, exp Exp) error {
+	a := s.FindAnchor(name)
+	if a == nil {
+		return fmt.Errorf("query: anchor %q not found", name)
+	}
+	var err error
+	Walk(exp, func(e Exp) bool {
+		if e.Op == ExpAnchor && err == nil {
+			err = fmt.Errorf("query: condition may not declare anchor %q", e.Name)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, v := range freeAlias(exp) {
+		if !a.visible(v) {
+			return fmt.Errorf("query: alias %q not visible at anchor %q", v, name)
+		}
+	}
+	if _, err := ConditionInterface(store, a.From, exp); err != nil {
+		return err
+	}
+	a.AddCondition(exp)
+	return nil
+}
+
+// Condition returns the statement condition with anchors replaced by
+// their added conditions. Anchors without conditions are removed.
+func (s *Stmt) Condition() Exp {
+	where := Rewrite(s.Where, func(e Exp) Exp {
+		switch e.Op {
+		case ExpAnchor:
+			if a := s.FindAnchor(e.Name); a != nil {
+				return And(a.Exp...)
+			}
+		case ExpAnd:
+			// Flatten nested and lists, which also removes empty anchors.
+			var list []Exp
+			for _, a := range e.Arg {
+				if a.Op == ExpAnd {
+					list = append(list, a.Arg...)
+					continue
+				}
+				list = append(list, a)
+			}
+			e.Arg = list
+		case ExpOr:
+			// An empty anchor adds no condition, so it is true, as is any
+			// or list that holds one. The empty and list is then removed.
+			for _, a := range e.Arg {
+				if a.Op == ExpAnd && len(a.Arg) == 0 {
+					return And()
+				}
+			}
+		}
+		return e
+	})
+	top := s.FindAnchor("")
+	if top == nil || len(top.Exp) == 0 {
+		return where
+	}
+	if where.IsZero() {
+		return And(top.Exp...)
+	}
+	if where.Op == ExpAnd {
+		return And(append(where.Arg[:len(where.Arg):len(where.Arg)], top.Exp...)...)
+	}
+	return And(append([]Exp{where}, top.Exp...)...)
+}
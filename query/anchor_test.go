@@ -0,0 +1,37 @@
+// Copyright 2018 solidcoredata authors.
+
+package query
+
+import (
+	"testing"
+)
+
+func TestConditionOrAnchor(t *testing.T) {
+	anchor := func(name string) Exp { return Exp{Op: ExpAnchor, Name: name} }
+	deleted := Equal(Column("b", "deleted"), Literal(false))
+	list := []struct {
+		name string
+		add  map[string]Exp
+		want string
+	}{
+		{"both empty", nil, "and (b.deleted = false)"},
+		{"one empty", map[string]Exp{"a": Equal(Column("b", "id"), Literal(int64(1)))}, "and (b.deleted = false)"},
+		{"both", map[string]Exp{
+			"a": Equal(Column("b", "id"), Literal(int64(1))),
+			"b": Equal(Column("b", "id"), Literal(int64(2))),
+		}, "and (b.deleted = false, or (and (b.id = 1), and (b.id = 2)))"},
+	}
+	for _, item := range list {
+		st := &Stmt{
+			From:   []*ResultTableSchema{{Name: "book", Alias: "b"}},
+			Where:  And(deleted, Or(anchor("a"), anchor("b"))),
+			Anchor: []*Anchor{{Name: "a"}, {Name: "b"}},
+		}
+		for name, e := range item.add {
+			st.FindAnchor(name).AddCondition(e)
+		}
+		if g := st.Condition(); g.String() != item.want {
+			t.Errorf("%s: got %q, want %q", item.name, g, item.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2018 solidcoredata authors.
+
+package query
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Access is granted per table and per column, to each role of a port. The
+// access of a caller is computed as follows:
+//
+//	grant of a role        the Closure of the declared bits
+//	grant of the caller    the Union of the grant of each caller role
+//	grant of a column      the column grant limited to the table grant
+//
+// A grant satisfies the access an operation needs if it holds the Closure of
+// the needed bits.
+
+var authnName = []struct {
+	a    Authn
+	name string
+}{
+	{AllowRead, "AllowRead"},
+	{AllowReturn, "AllowReturn"},
+	{AllowInsert, "AllowInsert"},
+	{AllowUpdate, "AllowUpdate"},
+	{AllowDelete, "AllowDelete"},
+}
+
+// String returns the names of the bits of a, separated by "|".
+func (a Authn) String() string {
+	switch a {
+	case AllowNone:
+		return "AllowNone"
+	case AllowFull:
+		return "AllowFull"
+	}
+	var list []string
+	for _, n := range authnName {
+		if a&n.a != 0 {
+			list = append(list, n.name)
+			a &^= n.a
+		}
+	}
+	if a != 0 {
+		list = append(list, fmt.Sprintf("Authn(%d)", uint8(a)))
+	}
+	return strings.Join(list, "|")
+}
+
+// Closure returns a with each bit implied by a bit of a.
+// AllowReturn implies AllowRead.
+func (a Authn) Closure() Authn {
+	if a&AllowReturn != 0 {
+		a |= AllowRead
+	}
+	return a & AllowFull
+}
+
+// Union returns the access granted by either a or b.
+func (a Authn) Union(b Authn) Authn {
+	return (a | b).Closure()
+}
+
+// Intersect returns the access granted by both a and b.
+func (a Authn) Intersect(b Authn) Authn {
+	return a.Closure() & b.Closure()
+}
+
+// Satisfies reports if a grants all access needed by need.
+func (a Authn) Satisfies(need Authn) bool {
+	need = need.Closure()
+	return a.Closure()&need == need
+}
+
+// RoleAuthn returns the union of the access granted to each role.
+func RoleAuthn(grant map[string]Authn, role []string) Authn {
+	var a Authn
+	for _, r := range role {
+		a = a.Union(grant[r])
+	}
+	return a
+}
+
+// ColumnAuthn returns the access to a column with the column grant in a
+// table with the table grant. A column never grants more than its table.
+func ColumnAuthn(table, column Authn) Authn {
+	return table.Intersect(column)
+}
+
+// AuthnWarning describes a column grant that exceeds the table grant of
+// the same port and role. The column is limited to the table grant, so the
+// excess access is never granted.
+type AuthnWarning struct {
+	Table  string
+	Column string
+	Port   string
+	Role   string
+	Grant  Authn // Column grant.
+	Limit  Authn // Table grant.
+}
+
+func (w AuthnWarning) String() string {
+	return fmt.Sprintf("table %q column %q port %q role %q: column grant %v exceeds table grant %v", w.Table, w.Column, w.Port, w.Role, w.Grant, w.Limit)
+}
+
+// CheckAuthn returns a warning for each column grant of the store that
+// exceeds the table grant of the same port and role.
+func CheckAuthn(s *Store) []AuthnWarning {
+	var list []AuthnWarning
+	for _, t := range s.Table {
+		port := make([]string, 0, len(t.Port))
+		for name := range t.Port {
+			port = append(port, name)
+		}
+		sort.Strings(port)
+		for _, name := range port {
+			p := t.Port[name]
+			for i, cp := range p.Column {
+				if i >= len(t.Column) {
+					break
+				}
+				role := make([]string, 0, len(cp.RoleAuthn))
+				for r := range cp.RoleAuthn {
+					role = append(role, r)
+				}
+				sort.Strings(role)
+				for _, r := range role {
+					grant, limit := cp.RoleAuthn[r].Closure(), p.RoleAuthn[r].Closure()
+					if limit.Satisfies(grant) {
+						continue
+					}
+					list = append(list, AuthnWarning{
+						Table:  t.Name,
+						Column: t.Column[i].Name,
+						Port:   name,
+						Role:   r,
+						Grant:  grant,
+						Limit:  limit,
+					})
+				}
+			}
+		}
+	}
+	return list
+}
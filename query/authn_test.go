@@ -0,0 +1,164 @@
+// Copyright 2018 solidcoredata authors.
+
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+// eachAuthn calls fn with every combination of access bits.
+func eachAuthn(fn func(a Authn)) {
+	for a := AllowNone; a <= AllowFull; a++ {
+		fn(a)
+	}
+}
+
+func TestAuthnConst(t *testing.T) {
+	bits := []Authn{AllowRead, AllowReturn, AllowInsert, AllowUpdate, AllowDelete}
+	var all Authn
+	for _, b := range bits {
+		if b&(b-1) != 0 {
+			t.Errorf("%v is not a single bit", b)
+		}
+		if all&b != 0 {
+			t.Errorf("%v overlaps another bit", b)
+		}
+		all |= b
+	}
+	if all != AllowFull {
+		t.Errorf("AllowFull is %d, want %d", AllowFull, all)
+	}
+}
+
+func TestAuthnString(t *testing.T) {
+	list := []struct {
+		a    Authn
+		want string
+	}{
+		{AllowNone, "AllowNone"},
+		{AllowReturn, "AllowReturn"},
+		{AllowRead | AllowReturn, "AllowRead|AllowReturn"},
+		{AllowFull, "AllowFull"},
+		{AllowDelete | 64, "AllowDelete|Authn(64)"},
+	}
+	for _, item := range list {
+		if got := item.a.String(); got != item.want {
+			t.Errorf("got %q, want %q", got, item.want)
+		}
+	}
+}
+
+func TestAuthnClosure(t *testing.T) {
+	eachAuthn(func(a Authn) {
+		c := a.Closure()
+		if c&a != a {
+			t.Errorf("%v closure %v lost bits", a, c)
+		}
+		if c.Closure() != c {
+			t.Errorf("%v closure is not idempotent", a)
+		}
+		if c&AllowReturn != 0 && c&AllowRead == 0 {
+			t.Errorf("%v closure %v has return without read", a, c)
+		}
+		if extra := c &^ a; extra != 0 && extra != AllowRead {
+			t.Errorf("%v closure %v added %v", a, c, extra)
+		}
+	})
+	if got := (AllowFull + 1).Closure(); got != AllowNone {
+		t.Errorf("unknown bits closure got %v, want none", got)
+	}
+}
+
+func TestAuthnAlgebra(t *testing.T) {
+	eachAuthn(func(a Authn) {
+		if !a.Satisfies(a) || !a.Satisfies(AllowNone) || !AllowFull.Satisfies(a) {
+			t.Errorf("%v satisfies identity", a)
+		}
+		if a.Closure() != AllowNone && AllowNone.Satisfies(a) {
+			t.Errorf("none satisfies %v", a)
+		}
+		eachAuthn(func(b Authn) {
+			u, i := a.Union(b), a.Intersect(b)
+			if u != b.Union(a) || i != b.Intersect(a) {
+				t.Errorf("%v, %v: not commutative", a, b)
+			}
+			if !u.Satisfies(a) || !u.Satisfies(b) || !a.Satisfies(i) || !b.Satisfies(i) {
+				t.Errorf("%v, %v: union %v or intersection %v not bounded", a, b, u, i)
+			}
+			if u.Closure() != u || i.Closure() != i {
+				t.Errorf("%v, %v: result not closed", a, b)
+			}
+			if got := ColumnAuthn(a, b); !a.Satisfies(got) {
+				t.Errorf("column %v exceeds table %v", got, a)
+			}
+			// Satisfies is the order of closed grants.
+			want := a.Closure()&b.Closure() == b.Closure()
+			if a.Satisfies(b) != want {
+				t.Errorf("%v satisfies %v got %t, want %t", a, b, !want, want)
+			}
+			eachAuthn(func(c Authn) {
+				if a.Satisfies(b) && b.Satisfies(c) && !a.Satisfies(c) {
+					t.Errorf("%v, %v, %v: satisfies not transitive", a, b, c)
+				}
+			})
+		})
+	})
+}
+
+func TestAuthnRole(t *testing.T) {
+	grant := map[string]Authn{
+		"reader": AllowReturn,
+		"editor": AllowUpdate,
+	}
+	list := []struct {
+		role []string
+		want Authn
+	}{
+		{nil, AllowNone},
+		{[]string{"other"}, AllowNone},
+		{[]string{"reader"}, AllowRead | AllowReturn},
+		{[]string{"reader", "editor"}, AllowRead | AllowReturn | AllowUpdate},
+	}
+	for _, item := range list {
+		if got := RoleAuthn(grant, item.role); got != item.want {
+			t.Errorf("%v: got %v, want %v", item.role, got, item.want)
+		}
+	}
+	if !RoleAuthn(grant, []string{"reader"}).Satisfies(AllowRead) {
+		t.Error("return grant does not satisfy read")
+	}
+	if AllowRead.Satisfies(AllowReturn) {
+		t.Error("read grant satisfies return")
+	}
+}
+
+func TestCheckAuthn(t *testing.T) {
+	s := &Store{
+		Table: []*StoreTable{{
+			Name:   "book",
+			Column: []*StoreColumn{{Name: "id"}, {Name: "name"}, {Name: "price"}},
+			Port: map[string]StoreTablePort{
+				"web": {
+					RoleAuthn: map[string]Authn{"reader": AllowRead | AllowReturn, "editor": AllowReturn | AllowUpdate},
+					Column: []StoreColumnPort{
+						{},
+						{RoleAuthn: map[string]Authn{"reader": AllowReturn, "editor": AllowFull}},
+						{RoleAuthn: map[string]Authn{"reader": AllowReturn | AllowUpdate}},
+					},
+				},
+			},
+		}},
+	}
+	want := []AuthnWarning{
+		{Table: "book", Column: "name", Port: "web", Role: "editor", Grant: AllowFull, Limit: AllowRead | AllowReturn | AllowUpdate},
+		{Table: "book", Column: "price", Port: "web", Role: "reader", Grant: AllowRead | AllowReturn | AllowUpdate, Limit: AllowRead | AllowReturn},
+	}
+	got := CheckAuthn(s)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if g, w := got[1].String(), `table "book" column "price" port "web" role "reader": column grant AllowRead|AllowReturn|AllowUpdate exceeds table grant AllowRead|AllowReturn`; g != w {
+		t.Fatalf("got %s, want %s", g, w)
+	}
+}
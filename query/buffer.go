@@ -0,0 +1,332 @@
+// Copyright 2018 solidcoredata authors.
+
+package query
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// A stream holds the results of a ResultSetSchema. Each result starts with
+// a StreamItemResult and ends with a StreamItemEndOfResult. A result that
+// starts before the current result ends is interleaved: it belongs to the
+// last row of the current result, and is held in RowBuffer.Interleave.
+
+// ReadAll reads each item of the stream into a buffer, decoding each field
+// by the type of its column. Each top level result is held in the Set at
+// its schema index. A StreamItemError ends the stream with its error.
+func ReadAll(stream StreamingResultSet) (*ResultSetBuffer, error) {
+	r := &bufferReader{}
+	if err := r.read(stream); err != nil {
+		return nil, err
+	}
+	return r.set, nil
+}
+
+// ApplyDelta reads a row delta stream into b, which holds the results of the
+// version the delta was requested from. Inserted rows are added to the end
+// of their result, and updated and deleted rows are found by the Key columns
+// of the result. The result set schema of the stream must equal the schema
+// of b. If an error is returned b may be partly changed and should be read
+// again in full.
+func ApplyDelta(b *ResultSetBuffer, stream StreamingResultSet) error {
+	if b == nil {
+		return errors.New("query: apply delta to nil buffer")
+	}
+	r := &bufferReader{set: b, delta: true}
+	return r.read(stream)
+}
+
+type bufferReader struct {
+	set    *ResultSetBuffer
+	delta  bool            // Apply the stream to the rows of set.
+	schema bool            // The result set schema has been read.
+	open   []*ResultBuffer // Results that have started and not ended.
+}
+
+func (r *bufferReader) read(stream StreamingResultSet) error {
+	for {
+		item, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.item(item); err != nil {
+			return err
+		}
+	}
+	if !r.schema {
+		return errors.New("query: stream has no result set schema")
+	}
+	if len(r.open) > 0 {
+		return errors.New("query: stream ended before end of result")
+	}
+	return nil
+}
+
+func (r *bufferReader) item(item StreamItem) error {
+	if _, ok := item.(StreamItemResultSetSchema); !ok && !r.schema {
+		return fmt.Errorf("query: stream %v before the result set schema", item.StreamState())
+	}
+	switch v := item.(type) {
+	default:
+		return fmt.Errorf("query: unknown stream state %v", item.StreamState())
+	case StreamItemResultSetSchema:
+		switch {
+		case r.schema:
+			return errors.New("query: stream has more than one result set schema")
+		case r.delta:
+			if !reflect.DeepEqual(v.Schema, r.set.Schema) {
+				return errors.New("query: delta result set schema differs from the buffer")
+			}
+		default:
+			r.set = &ResultSetBuffer{Schema: v.Schema, Set: make([]ResultBuffer, len(v.Schema.Set))}
+		}
+		r.schema = true
+	case StreamItemResult:
+		if v.SchemaIndex < 0 || v.SchemaIndex >= int64(len(r.set.Schema.Set)) {
+			return fmt.Errorf("query: result schema index %d out of range", v.SchemaIndex)
+		}
+		schema := r.set.Schema.Set[v.SchemaIndex]
+		if len(r.open) == 0 {
+			res := &r.set.Set[v.SchemaIndex]
+			res.Schema = schema
+			r.open = append(r.open, res)
+			return nil
+		}
+		parent := r.open[len(r.open)-1]
+		if len(parent.Row) == 0 {
+			return errors.New("query: interleaved result before the first row")
+		}
+		row := &parent.Row[len(parent.Row)-1]
+		row.Interleave = append(row.Interleave, ResultBuffer{Schema: schema})
+		r.open = append(r.open, &row.Interleave[len(row.Interleave)-1])
+	case StreamItemRow:
+		return r.row(v, StreamRow)
+	case StreamItemRowInsert:
+		return r.row(StreamItemRow(v), StreamRowInsert)
+	case StreamItemRowUpdate:
+		return r.row(StreamItemRow(v), StreamRowUpdate)
+	case StreamItemRowDelete:
+		return r.row(StreamItemRow(v), StreamRowDelete)
+	case StreamItemEndOfResult:
+		if len(r.open) == 0 {
+			return errors.New("query: end of result outside of a result")
+		}
+		r.open = r.open[:len(r.open)-1]
+	case StreamItemEndOfSet:
+		if len(r.open) > 0 {
+			return errors.New("query: end of set before end of result")
+		}
+	case StreamItemVersion:
+		r.set.Version = v.Token
+	case StreamItemCursor:
+		r.set.Cursor = v.Token
+	case StreamItemError:
+		if v.Error == nil {
+			return errors.New("query: stream error")
+		}
+		return v.Error
+	}
+	return nil
+}
+
+// row reads a row or a row delta into the open result.
+func (r *bufferReader) row(item StreamItemRow, state StreamState) error {
+	if len(r.open) == 0 {
+		return errors.New("query: row outside of a result")
+	}
+	res := r.open[len(r.open)-1]
+	row, err := readRow(res.Schema, item)
+	if err != nil {
+		return err
+	}
+	if state == StreamRow || state == StreamRowInsert {
+		res.Row = append(res.Row, row)
+		return nil
+	}
+	op := "update"
+	if state == StreamRowDelete {
+		op = "delete"
+	}
+	i, err := findRow(res, row)
+	if err != nil {
+		return fmt.Errorf("query: row %s: %w", op, err)
+	}
+	if state == StreamRowUpdate {
+		res.Row[i].Column = row.Column
+		return nil
+	}
+	res.Row = append(res.Row[:i], res.Row[i+1:]...)
+	return nil
+}
+
+// findRow returns the index of the row of the result with the same key as row.
+func findRow(res *ResultBuffer, row RowBuffer) (int, error) {
+	key, err := bufferKey(row)
+	if err != nil {
+		return 0, err
+	}
+	for i, other := range res.Row {
+		k, err := bufferKey(other)
+		if err != nil {
+			return 0, err
+		}
+		if k == key {
+			return i, nil
+		}
+	}
+	return 0, errors.New("row key not found")
+}
+
+// bufferKey returns the encoded values of the key columns of the row.
+func bufferKey(row RowBuffer) (string, error) {
+	var b []byte
+	for _, v := range row.Column {
+		if v.Schema == nil || !v.Schema.Key {
+			continue
+		}
+		f, err := EncodeField(v.Schema.Type, v.Value)
+		if err != nil {
+			return "", fmt.Errorf("key column %q: %w", v.Schema.QueryName, err)
+		}
+		b = appendBytes(b, f)
+	}
+	if b == nil {
+		return "", errors.New("result has no key columns")
+	}
+	return string(b), nil
+}
+
+func readRow(schema *ResultSchema, item StreamItemRow) (RowBuffer, error) {
+	if len(item.Row) != len(schema.Column) {
+		return RowBuffer{}, fmt.Errorf("query: row has %d fields, want %d", len(item.Row), len(schema.Column))
+	}
+	if item.Allow != nil && len(item.Allow) != len(item.Row) {
+		return RowBuffer{}, fmt.Errorf("query: row has %d allow values for %d fields", len(item.Allow), len(item.Row))
+	}
+	row := RowBuffer{Schema: schema, Column: make([]ValueBuffer, len(item.Row))}
+	for i, f := range item.Row {
+		col := schema.Column[i]
+		v, err := DecodeField(col.Type, f)
+		if err != nil {
+			return RowBuffer{}, fmt.Errorf("query: column %q: %w", col.QueryName, err)
+		}
+		allow := col.Allow
+		if item.Allow != nil {
+			allow = item.Allow[i]
+		}
+		row.Column[i] = ValueBuffer{Schema: col, Allow: allow, Value: v}
+	}
+	return row, nil
+}
+
+// Replay returns a stream of the results of the buffer in Set order,
+// skipping results without a schema, followed by the Version and Cursor
+// if set. A row holds the Allow of each value only if a value differs
+// from the Allow of its column.
+func Replay(b *ResultSetBuffer) StreamingResultSet {
+	return &replay{buf: b}
+}
+
+type replay struct {
+	buf  *ResultSetBuffer
+	item []StreamItem
+	done bool
+	err  error
+}
+
+func (r *replay) Next() (StreamItem, error) {
+	if !r.done {
+		r.done = true
+		r.err = r.build()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.item) == 0 {
+		return nil, io.EOF
+	}
+	item := r.item[0]
+	r.item = r.item[1:]
+	return item, nil
+}
+
+func (r *replay) build() error {
+	r.item = append(r.item, StreamItemResultSetSchema{Schema: r.buf.Schema})
+	for i := range r.buf.Set {
+		res := &r.buf.Set[i]
+		if res.Schema == nil {
+			continue
+		}
+		if err := r.result(res, int64(i)); err != nil {
+			r.item = nil
+			return err
+		}
+	}
+	if len(r.buf.Version) > 0 {
+		r.item = append(r.item, StreamItemVersion{Token: r.buf.Version})
+	}
+	if len(r.buf.Cursor) > 0 {
+		r.item = append(r.item, StreamItemCursor{Token: r.buf.Cursor})
+	}
+	r.item = append(r.item, StreamItemEndOfSet{})
+	return nil
+}
+
+// index returns the schema index of the result schema.
+func (r *replay) index(schema *ResultSchema) (int64, error) {
+	for i, s := range r.buf.Schema.Set {
+		if s == schema {
+			return int64(i), nil
+		}
+	}
+	return 0, errors.New("query: interleaved result schema not in the result set schema")
+}
+
+func (r *replay) result(res *ResultBuffer, index int64) error {
+	r.item = append(r.item, StreamItemResult{SchemaIndex: index})
+	for _, row := range res.Row {
+		item := StreamItemRow{Row: make([]StreamField, len(row.Column))}
+		for i, v := range row.Column {
+			col := v.Schema
+			if col == nil && i < len(res.Schema.Column) {
+				col = res.Schema.Column[i]
+			}
+			if col == nil {
+				return fmt.Errorf("query: value %d has no column schema", i)
+			}
+			f, err := EncodeField(col.Type, v.Value)
+			if err != nil {
+				return fmt.Errorf("query: column %q: %w", col.QueryName, err)
+			}
+			item.Row[i] = f
+			if v.Allow != col.Allow && item.Allow == nil {
+				item.Allow = make([]Authn, len(row.Column))
+				for j, u := range row.Column[:i] {
+					item.Allow[j] = u.Allow
+				}
+			}
+			if item.Allow != nil {
+				item.Allow[i] = v.Allow
+			}
+		}
+		r.item = append(r.item, item)
+		for j := range row.Interleave {
+			sub := &row.Interleave[j]
+			idx, err := r.index(sub.Schema)
+			if err != nil {
+				return err
+			}
+			if err := r.result(sub, idx); err != nil {
+				return err
+			}
+		}
+	}
+	r.item = append(r.item, StreamItemEndOfResult{})
+	return nil
+}
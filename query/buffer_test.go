@@ -0,0 +1,141 @@
+// Copyright 2018 solidcoredata authors.
+
+package query
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func bufferItems(t *testing.T) ([]StreamItem, *ResultSetBuffer) {
+	author := &ResultSchema{Column: []*ColumnSchema{
+		{QueryName: "id", Type: TypeInteger, Allow: AllowRead | AllowReturn},
+		{QueryName: "name", Type: TypeString, Allow: AllowFull},
+	}}
+	book := &ResultSchema{Column: []*ColumnSchema{
+		{QueryName: "title", Type: TypeString, Allow: AllowFull},
+	}}
+	schema := ResultSetSchema{Set: []*ResultSchema{author, book}}
+	field := func(dt DataType, v interface{}) StreamField {
+		f, err := EncodeField(dt, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	items := []StreamItem{
+		StreamItemResultSetSchema{Schema: schema},
+		StreamItemResult{SchemaIndex: 0},
+		StreamItemRow{Row: []StreamField{field(TypeInteger, int64(1)), field(TypeString, "Austen")}},
+		StreamItemResult{SchemaIndex: 1},
+		StreamItemRow{Row: []StreamField{field(TypeString, "Emma")}},
+		StreamItemRow{Row: []StreamField{field(TypeString, nil)}, Allow: []Authn{AllowRead}},
+		StreamItemEndOfResult{},
+		StreamItemRow{Row: []StreamField{field(TypeInteger, int64(2)), field(TypeString, nil)}, Allow: []Authn{AllowRead | AllowReturn, AllowRead}},
+		StreamItemEndOfResult{},
+		StreamItemResult{SchemaIndex: 1},
+		StreamItemRow{Row: []StreamField{field(TypeString, "Sanditon")}},
+		StreamItemEndOfResult{},
+		StreamItemEndOfSet{},
+	}
+	value := func(col *ColumnSchema, allow Authn, v interface{}) ValueBuffer {
+		return ValueBuffer{Schema: col, Allow: allow, Value: v}
+	}
+	want := &ResultSetBuffer{
+		Schema: schema,
+		Set: []ResultBuffer{
+			{Schema: author, Row: []RowBuffer{
+				{
+					Schema: author,
+					Column: []ValueBuffer{value(author.Column[0], AllowRead|AllowReturn, int64(1)), value(author.Column[1], AllowFull, "Austen")},
+					Interleave: []ResultBuffer{{Schema: book, Row: []RowBuffer{
+						{Schema: book, Column: []ValueBuffer{value(book.Column[0], AllowFull, "Emma")}},
+						{Schema: book, Column: []ValueBuffer{value(book.Column[0], AllowRead, nil)}},
+					}}},
+				},
+				{
+					Schema: author,
+					Column: []ValueBuffer{value(author.Column[0], AllowRead|AllowReturn, int64(2)), value(author.Column[1], AllowRead, nil)},
+				},
+			}},
+			{Schema: book, Row: []RowBuffer{
+				{Schema: book, Column: []ValueBuffer{value(book.Column[0], AllowFull, "Sanditon")}},
+			}},
+		},
+	}
+	return items, want
+}
+
+func TestReadAll(t *testing.T) {
+	items, want := bufferItems(t)
+	list := itemList(append([]StreamItem(nil), items...))
+	got, err := ReadAll(&list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	items, buf := bufferItems(t)
+	stream := Replay(buf)
+	for i, want := range items {
+		got, err := stream.Next()
+		if err != nil {
+			t.Fatalf("item %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("item %d: got %#v, want %#v", i, got, want)
+		}
+	}
+	if item, err := stream.Next(); err == nil {
+		t.Fatalf("got extra item %#v", item)
+	}
+
+	// A buffer read from a replay is the same.
+	again, err := ReadAll(Replay(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, buf) {
+		t.Fatal("buffer changed by replay")
+	}
+
+	buf.Set[1].Row[0].Column[0].Value = 5
+	if _, err := ReadAll(Replay(buf)); err == nil || !strings.Contains(err.Error(), `column "title"`) {
+		t.Fatalf("got %v, want column encoding error", err)
+	}
+}
+
+func TestReadAllError(t *testing.T) {
+	items, _ := bufferItems(t)
+	schema := items[0]
+	row := items[2]
+	fail := errors.New("runner failed")
+	list := []struct {
+		name  string
+		items []StreamItem
+		err   string
+	}{
+		{"no schema", nil, "no result set schema"},
+		{"row first", []StreamItem{row}, "before the result set schema"},
+		{"row outside", []StreamItem{schema, row}, "row outside of a result"},
+		{"index", []StreamItem{schema, StreamItemResult{SchemaIndex: 2}}, "index 2 out of range"},
+		{"interleave", []StreamItem{schema, StreamItemResult{}, StreamItemResult{SchemaIndex: 1}}, "before the first row"},
+		{"fields", []StreamItem{schema, StreamItemResult{SchemaIndex: 1}, row}, "2 fields, want 1"},
+		{"unended", []StreamItem{schema, StreamItemResult{}, row}, "ended before end of result"},
+		{"end of set", []StreamItem{schema, StreamItemResult{}, StreamItemEndOfSet{}}, "end of set before end of result"},
+		{"error", []StreamItem{schema, StreamItemError{Error: fail}}, "runner failed"},
+	}
+	for _, item := range list {
+		l := itemList(item.items)
+		_, err := ReadAll(&l)
+		if err == nil || !strings.Contains(err.Error(), item.err) {
+			t.Errorf("%s: got %v, want %q", item.name, err, item.err)
+		}
+	}
+}
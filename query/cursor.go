@@ -0,0 +1,141 @@
+// Copyright 2018 solidcoredata authors.
+
+package query
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+)
+
+// A cursor holds the values of the order expressions of the last row of a
+// keyset page, so the next page continues after that row. The values are a
+// 4 byte hash of the order, then for each value the uint length and bytes
+// of its StreamField. They are sealed with AES-GCM under the key of the
+// runner, with the scope as additional data, so the cursor may be neither
+// read nor forged by the caller, nor used outside the scope. The cursor is
+// the URL safe base64 of the nonce and the sealed values.
+
+// EncodeCursor returns the cursor of the values of each order expression,
+// encoded as the data type of each expression. The key is an AES key of
+// 16, 24, or 32 bytes, and the scope names the query and caller that may
+// decode the cursor.
+func EncodeCursor(key []byte, scope string, order []Order, dt []DataType, value []interface{}) (string, error) {
+	if len(order) != len(dt) || len(order) != len(value) {
+		return "", errors.New("query: cursor needs a type and value for each order")
+	}
+	aead, err := cursorAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	b := appendUint32(nil, orderHash(order))
+	for i, v := range value {
+		f, err := EncodeField(dt[i], v)
+		if err != nil {
+			return "", fmt.Errorf("query: cursor order %d: %w", i, err)
+		}
+		b = appendBytes(b, f)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, b, []byte(scope))), nil
+}
+
+// DecodeCursor returns the values of a cursor for the order, decoded as
+// the data type of each expression. The key and scope must be those the
+// cursor was encoded with. A cursor of another order is an error.
+func DecodeCursor(key []byte, scope string, cursor string, order []Order, dt []DataType) ([]interface{}, error) {
+	aead, err := cursorAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(b) < aead.NonceSize() {
+		return nil, errors.New("query: invalid cursor")
+	}
+	n := aead.NonceSize()
+	b, err = aead.Open(nil, b[:n], b[n:], []byte(scope))
+	if err != nil || len(b) < 4 {
+		return nil, errors.New("query: invalid cursor")
+	}
+	if binary.BigEndian.Uint32(b) != orderHash(order) {
+		return nil, errors.New("query: cursor is not of the query order")
+	}
+	r := bytes.NewReader(b[4:])
+	value := make([]interface{}, len(dt))
+	for i := range value {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return nil, errors.New("query: invalid cursor")
+		}
+		f := make(StreamField, n)
+		r.Read(f)
+		if value[i], err = DecodeField(dt[i], f); err != nil {
+			return nil, fmt.Errorf("query: cursor order %d: %w", i, err)
+		}
+	}
+	if r.Len() > 0 {
+		return nil, errors.New("query: invalid cursor")
+	}
+	return value, nil
+}
+
+// After returns the condition that a row orders after the cursor row of a
+// keyset page, given the cursor value of each order expression:
+//
+//	o0 > v0 or (o0 = v0 and o1 > v1) or (o0 = v0 and o1 = v1 and o2 > v2)
+//
+// where > is < for a descending order. No order expression may be null.
+func After(order []Order, value func(i int) Exp) Exp {
+	or := make([]Exp, len(order))
+	for i, o := range order {
+		and := make([]Exp, 0, i+1)
+		for j := 0; j < i; j++ {
+			and = append(and, Equal(order[j].Exp, value(j)))
+		}
+		op := ExpGreater
+		if o.Desc {
+			op = ExpLess
+		}
+		and = append(and, Binary(op, o.Exp, value(i)))
+		or[i] = and[0]
+		if len(and) > 1 {
+			or[i] = And(and...)
+		}
+	}
+	if len(or) == 1 {
+		return or[0]
+	}
+	return Or(or...)
+}
+
+func cursorAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("query: cursor key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// orderHash returns a hash of the order expressions and directions.
+func orderHash(order []Order) uint32 {
+	h := fnv.New32a()
+	for _, o := range order {
+		fmt.Fprintf(h, "%s %t;", o.Exp, o.Desc)
+	}
+	return h.Sum32()
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,62 @@
+// Copyright 2018 solidcoredata authors.
+
+package query
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+	order := []Order{{Exp: Column("b", "name"), Desc: true}, {Exp: Column("b", "published")}, {Exp: Column("b", "id")}}
+	dt := []DataType{TypeString, TypeTime, TypeInteger}
+	value := []interface{}{"Emma", time.Date(1815, 12, 23, 0, 0, 0, 0, time.UTC), int64(3)}
+	key := []byte("0123456789abcdef")
+	cursor, err := EncodeCursor(key, "q", order, dt, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := base64.RawURLEncoding.DecodeString(cursor); bytes.Contains(raw, []byte("Emma")) {
+		t.Errorf("cursor %q holds a plain value", cursor)
+	}
+	got, err := DecodeCursor(key, "q", cursor, order, dt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, value) {
+		t.Fatalf("got %#v, want %#v", got, value)
+	}
+
+	other := append([]Order{}, order...)
+	other[0].Desc = false
+	if _, err := DecodeCursor(key, "q", cursor, other, dt); err == nil || !strings.Contains(err.Error(), "not of the query order") {
+		t.Errorf("got %v, want order error", err)
+	}
+	if _, err := DecodeCursor(key, "other", cursor, order, dt); err == nil || !strings.Contains(err.Error(), "invalid cursor") {
+		t.Errorf("other scope got %v, want invalid cursor", err)
+	}
+	if _, err := DecodeCursor([]byte("fedcba9876543210"), "q", cursor, order, dt); err == nil || !strings.Contains(err.Error(), "invalid cursor") {
+		t.Errorf("other key got %v, want invalid cursor", err)
+	}
+	raw, _ := base64.RawURLEncoding.DecodeString(cursor)
+	raw[len(raw)-1] ^= 1
+	forged := base64.RawURLEncoding.EncodeToString(raw)
+	for _, bad := range []string{"", "!", cursor[:len(cursor)-2], cursor + "AA", forged} {
+		if _, err := DecodeCursor(key, "q", bad, order, dt); err == nil {
+			t.Errorf("cursor %q: expected error", bad)
+		}
+	}
+	if _, err := EncodeCursor([]byte("short"), "q", order, dt, value); err == nil || !strings.Contains(err.Error(), "cursor key") {
+		t.Errorf("got %v, want key error", err)
+	}
+
+	after := After(order, func(i int) Exp { return Parameter("v" + string(rune('0'+i))) })
+	want := "or (b.name < v0, and (b.name = v0, b.published > v1), and (b.name = v0, b.published = v1, b.id > v2))"
+	if g := after.String(); g != want {
+		t.Errorf("after got %q, want %q", g, want)
+	}
+}
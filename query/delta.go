@@ -0,0 +1,89 @@
+// Copyright 2018 solidcoredata authors.
+
+package query
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// RowDelta returns the row delta items that change the prior rows of a
+// result into the current rows. Rows are matched by the Key columns of the
+// schema. Deleted rows come first in prior order, followed by inserted and
+// updated rows in current order. Unchanged rows are left out.
+func RowDelta(schema *ResultSchema, prior, current []StreamItemRow) ([]StreamItem, error) {
+	var key []int
+	for i, col := range schema.Column {
+		if col.Key {
+			key = append(key, i)
+		}
+	}
+	if len(key) == 0 {
+		return nil, errors.New("query: row delta result has no key columns")
+	}
+	rowKey := func(row StreamItemRow) (string, error) {
+		if len(row.Row) != len(schema.Column) {
+			return "", fmt.Errorf("query: row has %d fields, want %d", len(row.Row), len(schema.Column))
+		}
+		var b []byte
+		for _, i := range key {
+			b = appendBytes(b, row.Row[i])
+		}
+		return string(b), nil
+	}
+
+	before := make(map[string]StreamItemRow, len(prior))
+	for _, row := range prior {
+		k, err := rowKey(row)
+		if err != nil {
+			return nil, err
+		}
+		before[k] = row
+	}
+	after := make(map[string]bool, len(current))
+	var change []StreamItem
+	for _, row := range current {
+		k, err := rowKey(row)
+		if err != nil {
+			return nil, err
+		}
+		if after[k] {
+			return nil, errors.New("query: row delta result has more than one row with the same key")
+		}
+		after[k] = true
+		old, ok := before[k]
+		switch {
+		case !ok:
+			change = append(change, StreamItemRowInsert(row))
+		case !sameRow(old, row):
+			change = append(change, StreamItemRowUpdate(row))
+		}
+	}
+	var del []StreamItem
+	for _, row := range prior {
+		k, _ := rowKey(row)
+		if !after[k] {
+			del = append(del, StreamItemRowDelete(row))
+		}
+	}
+	return append(del, change...), nil
+}
+
+// sameRow reports if the rows have the same fields and access.
+func sameRow(a, b StreamItemRow) bool {
+	if len(a.Row) != len(b.Row) || len(a.Allow) != len(b.Allow) {
+		return false
+	}
+	for i := range a.Row {
+		if !bytes.Equal(a.Row[i], b.Row[i]) {
+			return false
+		}
+	}
+	for i := range a.Allow {
+		if a.Allow[i] != b.Allow[i] {
+			return false
+		}
+	}
+	return true
+}
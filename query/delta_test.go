@@ -0,0 +1,101 @@
+// Copyright 2018 solidcoredata authors.
+
+package query
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func deltaRows(t *testing.T, row ...[]interface{}) []StreamItemRow {
+	list := make([]StreamItemRow, len(row))
+	for i, r := range row {
+		id, err := EncodeField(TypeInteger, r[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, err := EncodeField(TypeString, r[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		list[i] = StreamItemRow{Row: []StreamField{id, name}}
+	}
+	return list
+}
+
+func TestRowDelta(t *testing.T) {
+	schema := &ResultSchema{Column: []*ColumnSchema{
+		{QueryName: "id", Key: true, Type: TypeInteger, Allow: AllowFull},
+		{QueryName: "name", Type: TypeString, Allow: AllowFull},
+	}}
+	prior := deltaRows(t,
+		[]interface{}{int64(1), "Emma"},
+		[]interface{}{int64(2), "Persuasion"},
+		[]interface{}{int64(3), "Lady Susan"},
+	)
+	current := deltaRows(t,
+		[]interface{}{int64(4), "Sanditon"},
+		[]interface{}{int64(1), "Emma"},
+		[]interface{}{int64(3), "Lady Susan Vernon"},
+	)
+	got, err := RowDelta(schema, prior, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []StreamItem{
+		StreamItemRowDelete(prior[1]),
+		StreamItemRowInsert(current[0]),
+		StreamItemRowUpdate(current[2]),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	// Applying the delta to a buffer of the prior rows gives the current rows.
+	items := []StreamItem{StreamItemResultSetSchema{Schema: ResultSetSchema{Set: []*ResultSchema{schema}}}, StreamItemResult{}}
+	for _, row := range prior {
+		items = append(items, row)
+	}
+	items = append(items, StreamItemEndOfResult{}, StreamItemVersion{Token: "1"}, StreamItemEndOfSet{})
+	list := itemList(items)
+	buf, err := ReadAll(&list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Version != "1" {
+		t.Fatalf("got version %q, want 1", buf.Version)
+	}
+	items = []StreamItem{StreamItemResultSetSchema{Schema: ResultSetSchema{Set: []*ResultSchema{schema}}}, StreamItemResult{}}
+	items = append(items, got...)
+	items = append(items, StreamItemEndOfResult{}, StreamItemVersion{Token: "2"}, StreamItemEndOfSet{})
+	list = itemList(items)
+	if err := ApplyDelta(buf, &list); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, row := range buf.Set[0].Row {
+		names = append(names, row.Column[1].Value.(string))
+	}
+	if g, w := strings.Join(names, ", "), "Emma, Lady Susan Vernon, Sanditon"; g != w || buf.Version != "2" {
+		t.Fatalf("got %q version %q, want %q version 2", g, buf.Version, w)
+	}
+
+	// A delta of a row that is not in the buffer fails.
+	list = itemList([]StreamItem{StreamItemResultSetSchema{Schema: ResultSetSchema{Set: []*ResultSchema{schema}}}, StreamItemResult{}, StreamItemRowDelete(prior[1])})
+	if err := ApplyDelta(buf, &list); err == nil || !strings.Contains(err.Error(), "row delete: row key not found") {
+		t.Fatalf("got %v, want key not found error", err)
+	}
+}
+
+func TestRowDeltaError(t *testing.T) {
+	noKey := &ResultSchema{Column: []*ColumnSchema{{QueryName: "id", Type: TypeInteger}, {QueryName: "name", Type: TypeString}}}
+	if _, err := RowDelta(noKey, nil, nil); err == nil || !strings.Contains(err.Error(), "no key columns") {
+		t.Errorf("got %v, want no key columns error", err)
+	}
+	key := &ResultSchema{Column: []*ColumnSchema{{QueryName: "id", Key: true, Type: TypeInteger}, {QueryName: "name", Type: TypeString}}}
+	rows := deltaRows(t, []interface{}{int64(1), "a"}, []interface{}{int64(1), "b"})
+	if _, err := RowDelta(key, nil, rows); err == nil || !strings.Contains(err.Error(), "same key") {
+		t.Errorf("got %v, want duplicate key error", err)
+	}
+}
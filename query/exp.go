@@ -0,0 +1,345 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//go:generate stringer -type=ExpOp -trimprefix Exp
+
+// ExpOp is the operation of an expression node.
+type ExpOp int8
+
+const (
+	ExpUnknown ExpOp = iota
+
+	ExpColumn  // Alias.Name column reference.
+	ExpLiteral // Value, nil for NULL.
+	ExpParam   // Name of the caller supplied parameter.
+	ExpAnchor  // Name of the anchor.
+
+	ExpEqual        // Arg[0] = Arg[1]
+	ExpNotEqual     // Arg[0] <> Arg[1]
+	ExpLess         // Arg[0] < Arg[1]
+	ExpLessEqual    // Arg[0] <= Arg[1]
+	ExpGreater      // Arg[0] > Arg[1]
+	ExpGreaterEqual // Arg[0] >= Arg[1]
+	ExpLike         // Arg[0] like Arg[1]
+
+	ExpAdd // Arg[0] + Arg[1]
+	ExpSub // Arg[0] - Arg[1]
+	ExpMul // Arg[0] * Arg[1]
+	ExpDiv // Arg[0] / Arg[1]
+	ExpMod // Arg[0] % Arg[1]
+
+	ExpAnd // All Arg are true. True if no Arg.
+	ExpOr  // Any Arg is true. False if no Arg.
+	ExpNot // Arg[0] is false.
+
+	ExpExists // Sub returns a row.
+	ExpIn     // Arg[0] is in Sub, or Arg[0] is in Arg[1:] if Sub is nil.
+	ExpCall   // Function Name called with Arg.
+)
+
+// Exp is a node in an expression tree. Conditions, computed values,
+// and deny predicates are all expressed as trees of Exp.
+// Exp only contains plain data so it may be serialized.
+type Exp struct {
+	Op    ExpOp
+	Alias string      // Table alias of a column.
+	Name  string      // Column, parameter, anchor, or function name.
+	Value interface{} // Literal value.
+	Arg   []Exp
+	Sub   *SubQuery // Sub-query of exists and in.
+}
+
+// SubQuery is a query nested within an expression. Aliases declared in
+// From are only visible within the sub-query, but the sub-query may
+// reference aliases of the enclosing statement.
+type SubQuery struct {
+	From   []*ResultTableSchema
+	Where  Exp
+	Select []Exp // A single column for use with in.
+}
+
+// Column returns a column reference.
+func Column(alias, name string) Exp {
+	return Exp{Op: ExpColumn, Alias: alias, Name: name}
+}
+
+// Literal returns a literal value, or NULL if v is nil.
+func Literal(v interface{}) Exp {
+	return Exp{Op: ExpLiteral, Value: v}
+}
+
+// Parameter returns a reference to a named parameter.
+func Parameter(name string) Exp {
+	return Exp{Op: ExpParam, Name: name}
+}
+
+// Binary returns an operation on two operands, such as ExpEqual or ExpAdd.
+func Binary(op ExpOp, left, right Exp) Exp {
+	return Exp{Op: op, Arg: []Exp{left, right}}
+}
+
+// Equal returns left = right.
+func Equal(left, right Exp) Exp {
+	return Binary(ExpEqual, left, right)
+}
+
+// And returns a condition where all conditions must be true.
+func And(list ...Exp) Exp {
+	return Exp{Op: ExpAnd, Arg: list}
+}
+
+// Or returns a condition where one condition must be true.
+func Or(list ...Exp) Exp {
+	return Exp{Op: ExpOr, Arg: list}
+}
+
+// Not negates a condition.
+func Not(e Exp) Exp {
+	return Exp{Op: ExpNot, Arg: []Exp{e}}
+}
+
+// Exists returns a condition that is true when the sub-query returns a row.
+func Exists(sub *SubQuery) Exp {
+	return Exp{Op: ExpExists, Sub: sub}
+}
+
+// Call returns a function call.
+func Call(name string, arg ...Exp) Exp {
+	return Exp{Op: ExpCall, Name: name, Arg: arg}
+}
+
+// IsZero reports if the expression is unset.
+func (e Exp) IsZero() bool {
+	return e.Op == ExpUnknown
+}
+
+// Walk the expression depth first, including sub-queries. If fn returns
+// false the children of e are not visited.
+func Walk(e Exp, fn func(e Exp) bool) {
+	if !fn(e) {
+		return
+	}
+	for _, a := range e.Arg {
+		Walk(a, fn)
+	}
+	if e.Sub != nil {
+		Walk(e.Sub.Where, fn)
+		for _, s := range e.Sub.Select {
+			Walk(s, fn)
+		}
+	}
+}
+
+// Rewrite returns a copy of the expression with each node replaced by the
+// result of fn. Children are rewritten before their parent.
+func Rewrite(e Exp, fn func(e Exp) Exp) Exp {
+	if len(e.Arg) > 0 {
+		arg := make([]Exp, len(e.Arg))
+		for i, a := range e.Arg {
+			arg[i] = Rewrite(a, fn)
+		}
+		e.Arg = arg
+	}
+	if e.Sub != nil {
+		sub := *e.Sub
+		sub.Where = Rewrite(sub.Where, fn)
+		if len(sub.Select) > 0 {
+			sel := make([]Exp, len(sub.Select))
+			for i, s := range sub.Select {
+				sel[i] = Rewrite(s, fn)
+			}
+			sub.Select = sel
+		}
+		e.Sub = &sub
+	}
+	return fn(e)
+}
+
+// Columns returns each column referenced in the expression, including
+// columns within sub-queries.
+func (e Exp) Columns() []Exp {
+	var list []Exp
+	Walk(e, func(e Exp) bool {
+		if e.Op == ExpColumn {
+			list = append(list, e)
+		}
+		return true
+	})
+	return list
+}
+
+// freeAlias returns the aliases referenced in the expression that are not
+// declared by a sub-query within the expression.
+func freeAlias(e Exp) []string {
+	var list []string
+	seen := map[string]bool{}
+	var walk func(e Exp, local map[string]bool)
+	walk = func(e Exp, local map[string]bool) {
+		if e.Op == ExpColumn && !local[e.Alias] && !seen[e.Alias] {
+			seen[e.Alias] = true
+			list = append(list, e.Alias)
+		}
+		for _, a := range e.Arg {
+			walk(a, local)
+		}
+		if e.Sub == nil {
+			return
+		}
+		inner := make(map[string]bool, len(local)+len(e.Sub.From))
+		for k := range local {
+			inner[k] = true
+		}
+		for _, t := range e.Sub.From {
+			inner[t.Alias] = true
+		}
+		walk(e.Sub.Where, inner)
+		for _, s := range e.Sub.Select {
+			walk(s, inner)
+		}
+	}
+	walk(e, nil)
+	return list
+}
+
+var binaryText = map[ExpOp]string{
+	ExpEqual:        "=",
+	ExpNotEqual:     "<>",
+	ExpLess:         "<",
+	ExpLessEqual:    "<=",
+	ExpGreater:      ">",
+	ExpGreaterEqual: ">=",
+	ExpLike:         "like",
+	ExpAdd:          "+",
+	ExpSub:          "-",
+	ExpMul:          "*",
+	ExpDiv:          "/",
+	ExpMod:          "%",
+}
+
+// BinaryOp returns the operation for the source operator text, such as "<=".
+func BinaryOp(text string) (ExpOp, bool) {
+	if text == "!=" {
+		return ExpNotEqual, true
+	}
+	for op, t := range binaryText {
+		if t == text {
+			return op, true
+		}
+	}
+	return ExpUnknown, false
+}
+
+// String returns the expression in source form.
+func (e Exp) String() string {
+	b := &strings.Builder{}
+	e.write(b)
+	return b.String()
+}
+
+func (e Exp) write(b *strings.Builder) {
+	writeList := func(list []Exp) {
+		for i, a := range list {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			a.write(b)
+		}
+	}
+	if t, ok := binaryText[e.Op]; ok && len(e.Arg) == 2 {
+		e.Arg[0].write(b)
+		b.WriteString(" " + t + " ")
+		e.Arg[1].write(b)
+		return
+	}
+	switch e.Op {
+	default:
+		fmt.Fprintf(b, "<%v>", e.Op)
+	case ExpColumn:
+		b.WriteString(e.Alias + "." + e.Name)
+	case ExpLiteral:
+		b.WriteString(LiteralString(e.Value))
+	case ExpParam:
+		b.WriteString(e.Name)
+	case ExpAnchor:
+		b.WriteString("#" + e.Name)
+	case ExpAnd, ExpOr:
+		if e.Op == ExpAnd {
+			b.WriteString("and (")
+		} else {
+			b.WriteString("or (")
+		}
+		writeList(e.Arg)
+		b.WriteString(")")
+	case ExpNot:
+		b.WriteString("not ")
+		writeList(e.Arg)
+	case ExpCall:
+		b.WriteString(e.Name + "(")
+		writeList(e.Arg)
+		b.WriteString(")")
+	case ExpExists:
+		b.WriteString("exists (")
+		e.Sub.write(b)
+		b.WriteString(")")
+	case ExpIn:
+		e.Arg[0].write(b)
+		b.WriteString(" in (")
+		if e.Sub != nil {
+			e.Sub.write(b)
+		} else {
+			writeList(e.Arg[1:])
+		}
+		b.WriteString(")")
+	}
+}
+
+func (s *SubQuery) write(b *strings.Builder) {
+	b.WriteString("from ")
+	for i, t := range s.From {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(t.Name + " " + t.Alias)
+	}
+	switch {
+	case s.Where.IsZero():
+	case s.Where.Op == ExpAnd:
+		b.WriteString(" ")
+		s.Where.write(b)
+	default:
+		b.WriteString(" and ")
+		s.Where.write(b)
+	}
+	if len(s.Select) > 0 {
+		b.WriteString(" select ")
+		for i, e := range s.Select {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			e.write(b)
+		}
+	}
+}
+
+// LiteralString returns the source form of a literal value.
+func LiteralString(v interface{}) string {
+	switch v := v.(type) {
+	default:
+		return fmt.Sprintf("%v", v)
+	case nil:
+		return "null"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
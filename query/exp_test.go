@@ -0,0 +1,56 @@
+package query
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"testing"
+)
+
+func TestExpRewrite(t *testing.T) {
+	e := And(
+		Equal(Column("b", "Name"), Literal("Robert")),
+		Exp{Op: ExpAnchor, Name: "where1"},
+		Exists(&SubQuery{
+			From:  []*ResultTableSchema{{Name: "Account", Alias: "a"}},
+			Where: Equal(Column("a", "ID"), Column("b", "Account")),
+		}),
+	)
+	if g, w := e.String(), "and (b.Name = 'Robert', #where1, exists (from Account a and a.ID = b.Account))"; g != w {
+		t.Fatalf("got  %s\nwant %s", g, w)
+	}
+	if g, w := freeAlias(e), []string{"b"}; !reflect.DeepEqual(g, w) {
+		t.Fatalf("free alias got %q, want %q", g, w)
+	}
+	r := Rewrite(e, func(e Exp) Exp {
+		if e.Op == ExpColumn && e.Alias == "b" {
+			e.Alias = "x"
+		}
+		return e
+	})
+	if g, w := r.String(), "and (x.Name = 'Robert', #where1, exists (from Account a and a.ID = x.Account))"; g != w {
+		t.Fatalf("got  %s\nwant %s", g, w)
+	}
+	if g := e.Arg[2].Sub.Where.Arg[1].Alias; g != "b" {
+		t.Fatal("rewrite modified the original expression")
+	}
+}
+
+func TestExpSerialize(t *testing.T) {
+	e := And(
+		Equal(Column("b", "Deleted"), Literal(false)),
+		Binary(ExpGreater, Column("b", "ID"), Literal(int64(4))),
+		Exp{Op: ExpIn, Arg: []Exp{Column("b", "Name"), Literal("a"), Literal("b")}},
+	)
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(e); err != nil {
+		t.Fatal(err)
+	}
+	var got Exp
+	if err := gob.NewDecoder(buf).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, e) {
+		t.Fatalf("got %v, want %v", got, e)
+	}
+}
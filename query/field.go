@@ -0,0 +1,220 @@
+// Copyright 2018 solidcoredata authors.
+
+package query
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+)
+
+// Values of each data type are held in memory as a single Go type:
+//
+//	TypeString                         string
+//	TypeBinary                         []byte
+//	TypeBoolean                        bool
+//	TypeInteger                        int64
+//	TypeFloat                          float64
+//	TypeDecimal, TypeRational          *big.Rat
+//	TypeTime, TypeDate, TypeDatez,
+//	TypeTimestamp, TypeTimestampZ      time.Time
+//	TypeUUID                           [16]byte
+//	TypeJSON                           json.RawMessage
+//	TypeArray                          []interface{}
+//
+// NULL is always nil.
+
+// A StreamField is a flag byte followed by the value. A NULL field is the
+// single flag byte 0. Otherwise the flag is 1 and the value is encoded by
+// data type, with multi-byte integers in big-endian order:
+//
+//	TypeString, TypeJSON               UTF-8 bytes
+//	TypeBinary                         bytes
+//	TypeBoolean                        1 byte, 0 is false, 1 is true
+//	TypeInteger                        8 byte two's complement
+//	TypeFloat                          8 byte IEEE 754 binary64
+//	TypeDecimal, TypeRational          "a/b" or "a" in base 10, as big.Rat.RatString
+//	TypeTime, TypeDate, TypeDatez,
+//	TypeTimestamp, TypeTimestampZ      8 byte Unix seconds, 4 byte nanoseconds,
+//	                                   2 byte zone offset east of UTC in minutes
+//	TypeUUID                           16 bytes
+//	TypeArray                          JSON array
+//
+// Field encoding flags. The first byte of each StreamField is a flag.
+const (
+	fieldNull  = 0
+	fieldValue = 1
+)
+
+// EncodeField encodes a value of type t as a StreamField.
+func EncodeField(t DataType, v interface{}) (StreamField, error) {
+	if v == nil {
+		return StreamField{fieldNull}, nil
+	}
+	b := StreamField{fieldValue}
+	bad := func() (StreamField, error) {
+		return nil, fmt.Errorf("query: %T is not a %v value", v, t)
+	}
+	switch t {
+	default:
+		return nil, fmt.Errorf("query: unknown data type %v", t)
+	case TypeString:
+		s, ok := v.(string)
+		if !ok {
+			return bad()
+		}
+		return append(b, s...), nil
+	case TypeBinary:
+		s, ok := v.([]byte)
+		if !ok {
+			return bad()
+		}
+		return append(b, s...), nil
+	case TypeBoolean:
+		x, ok := v.(bool)
+		if !ok {
+			return bad()
+		}
+		if x {
+			return append(b, 1), nil
+		}
+		return append(b, 0), nil
+	case TypeInteger:
+		x, ok := v.(int64)
+		if !ok {
+			return bad()
+		}
+		return appendUint64(b, uint64(x)), nil
+	case TypeFloat:
+		x, ok := v.(float64)
+		if !ok {
+			return bad()
+		}
+		return appendUint64(b, math.Float64bits(x)), nil
+	case TypeDecimal, TypeRational:
+		x, ok := v.(*big.Rat)
+		if !ok {
+			return bad()
+		}
+		return append(b, x.RatString()...), nil
+	case TypeTime, TypeDate, TypeDatez, TypeTimestamp, TypeTimestampZ:
+		x, ok := v.(time.Time)
+		if !ok {
+			return bad()
+		}
+		_, offset := x.Zone()
+		if offset%60 != 0 {
+			return nil, fmt.Errorf("query: zone offset %ds is not whole minutes", offset)
+		}
+		b = appendUint64(b, uint64(x.Unix()))
+		b = append(b, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[len(b)-6:], uint32(x.Nanosecond()))
+		binary.BigEndian.PutUint16(b[len(b)-2:], uint16(int16(offset/60)))
+		return b, nil
+	case TypeUUID:
+		x, ok := v.([16]byte)
+		if !ok {
+			return bad()
+		}
+		return append(b, x[:]...), nil
+	case TypeJSON:
+		x, ok := v.(json.RawMessage)
+		if !ok {
+			return bad()
+		}
+		return append(b, x...), nil
+	case TypeArray:
+		x, ok := v.([]interface{})
+		if !ok {
+			return bad()
+		}
+		jb, err := json.Marshal(x)
+		if err != nil {
+			return nil, err
+		}
+		return append(b, jb...), nil
+	}
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// DecodeField decodes a StreamField of type t as encoded by EncodeField.
+func DecodeField(t DataType, f StreamField) (interface{}, error) {
+	if len(f) == 0 {
+		return nil, fmt.Errorf("query: empty field")
+	}
+	switch f[0] {
+	default:
+		return nil, fmt.Errorf("query: invalid field flag %d", f[0])
+	case fieldNull:
+		return nil, nil
+	case fieldValue:
+	}
+	b := f[1:]
+	size := func(n int) error {
+		if len(b) != n {
+			return fmt.Errorf("query: %v field has %d bytes, want %d", t, len(b), n)
+		}
+		return nil
+	}
+	switch t {
+	default:
+		return nil, fmt.Errorf("query: unknown data type %v", t)
+	case TypeString:
+		return string(b), nil
+	case TypeBinary:
+		return append([]byte{}, b...), nil
+	case TypeBoolean:
+		if err := size(1); err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case TypeInteger:
+		if err := size(8); err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case TypeFloat:
+		if err := size(8); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case TypeDecimal, TypeRational:
+		r, ok := new(big.Rat).SetString(string(b))
+		if !ok {
+			return nil, fmt.Errorf("query: invalid %v field %q", t, b)
+		}
+		return r, nil
+	case TypeTime, TypeDate, TypeDatez, TypeTimestamp, TypeTimestampZ:
+		if err := size(14); err != nil {
+			return nil, err
+		}
+		x := time.Unix(int64(binary.BigEndian.Uint64(b)), int64(binary.BigEndian.Uint32(b[8:])))
+		if offset := int(int16(binary.BigEndian.Uint16(b[12:]))); offset != 0 {
+			return x.In(time.FixedZone("", offset*60)), nil
+		}
+		return x.UTC(), nil
+	case TypeUUID:
+		if err := size(16); err != nil {
+			return nil, err
+		}
+		var x [16]byte
+		copy(x[:], b)
+		return x, nil
+	case TypeJSON:
+		return json.RawMessage(append([]byte{}, b...)), nil
+	case TypeArray:
+		var x []interface{}
+		if err := json.Unmarshal(b, &x); err != nil {
+			return nil, fmt.Errorf("query: invalid %v field: %v", t, err)
+		}
+		return x, nil
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 solidcoredata authors.
+
+package query
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestField(t *testing.T) {
+	list := []struct {
+		t DataType
+		v interface{}
+	}{
+		{TypeString, "hello"},
+		{TypeString, ""},
+		{TypeString, nil},
+		{TypeBinary, []byte{1, 2}},
+		{TypeBoolean, true},
+		{TypeInteger, int64(-42)},
+		{TypeFloat, 1.5},
+		{TypeDecimal, big.NewRat(3, 2)},
+		{TypeTimestampZ, time.Date(2018, 3, 4, 5, 6, 7, 8, time.UTC)},
+		{TypeTimestampZ, time.Date(1918, 3, 4, 5, 6, 7, 8, time.FixedZone("", -(7*60+30)*60))},
+		{TypeUUID, [16]byte{1, 2, 3}},
+		{TypeJSON, json.RawMessage(`{"a":1}`)},
+		{TypeArray, []interface{}{"a", 1.0}},
+	}
+	for _, item := range list {
+		f, err := EncodeField(item.t, item.v)
+		if err != nil {
+			t.Fatalf("%v %v: %v", item.t, item.v, err)
+		}
+		v, err := DecodeField(item.t, f)
+		if err != nil {
+			t.Fatalf("%v %v: %v", item.t, item.v, err)
+		}
+		if r, ok := v.(*big.Rat); ok {
+			if r.Cmp(item.v.(*big.Rat)) != 0 {
+				t.Errorf("%v got %v, want %v", item.t, v, item.v)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(v, item.v) {
+			t.Errorf("%v got %#v, want %#v", item.t, v, item.v)
+		}
+	}
+	if _, err := EncodeField(TypeInteger, "x"); err == nil {
+		t.Error("expected type error")
+	}
+	if _, err := DecodeField(TypeInteger, StreamField{fieldValue, 1}); err == nil {
+		t.Error("expected size error")
+	}
+}
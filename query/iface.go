@@ -0,0 +1,283 @@
+// Copyright 2018 solidcoredata authors.
+
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The interface of a query is the part of the store it depends on: each
+// table it reads or modifies, with only the columns it uses. Tests of the
+// query need only data for the interface, and a schema that satisfies the
+// interface runs the query as before, whatever else changed in it.
+
+// Interface returns the interface of the statements as a store of the used
+// tables, in store order, each with only the used columns, in table order.
+// Columns keep their store definition and tables keep their Read rules.
+// Tables have no ports, and the store has no queries.
+//
+// A statement uses each table of From, Insert and Delete, each column
+// referenced by its expressions, sub-queries and interleaved statements,
+// each column it reads, returns, inserts, or updates, and the lock columns
+// of each table it updates or deletes. As the runner applies the row rules
+// of each used table, the columns and tables of its Read rules and of the
+// Deny rules of each port are used too.
+func Interface(s *Store, st ...Stmt) (*Store, error) {
+	u := newUses(s)
+	for i := range st {
+		u.stmt(&st[i], nil)
+	}
+	return u.store()
+}
+
+// ConditionInterface returns the interface of a condition on the tables of
+// from, as Interface does for a statement.
+func ConditionInterface(s *Store, from []*ResultTableSchema, e Exp) (*Store, error) {
+	u := newUses(s)
+	u.exp(e, u.scope(nil, from))
+	return u.store()
+}
+
+// Satisfies returns an error that lists each table and column of the
+// interface the store lacks or declares differently. A store column
+// satisfies an interface column if it has the same type and key, serial
+// and lock properties, and may only be null if the interface column may.
+func Satisfies(s *Store, iface *Store) error {
+	var list []string
+	for _, it := range iface.Table {
+		t := storeTable(s, it.Name)
+		if t == nil {
+			list = append(list, fmt.Sprintf("table %q not found", it.Name))
+			continue
+		}
+		for _, ic := range it.Column {
+			c := tableColumn(t, ic.Name)
+			if c == nil {
+				list = append(list, fmt.Sprintf("column %s.%s not found", it.Name, ic.Name))
+				continue
+			}
+			for _, diff := range columnDiff(ic, c) {
+				list = append(list, fmt.Sprintf("column %s.%s %s", it.Name, ic.Name, diff))
+			}
+		}
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return errors.New("query: store does not satisfy interface: " + strings.Join(list, "; "))
+}
+
+// columnDiff describes how the store column c fails to satisfy the
+// interface column ic.
+func columnDiff(ic, c *StoreColumn) []string {
+	var list []string
+	if c.Type != ic.Type {
+		list = append(list, fmt.Sprintf("is %v, want %v", c.Type, ic.Type))
+	}
+	if c.Nullable && !ic.Nullable {
+		list = append(list, "may be null")
+	}
+	flag := func(name string, got, want bool) {
+		if got != want {
+			list = append(list, fmt.Sprintf("%s is %t, want %t", name, got, want))
+		}
+	}
+	flag("key", c.Key, ic.Key)
+	flag("serial", c.Serial, ic.Serial)
+	flag("update lock", c.UpdateLock, ic.UpdateLock)
+	flag("delete lock", c.DeleteLock, ic.DeleteLock)
+	return list
+}
+
+func storeTable(s *Store, name string) *StoreTable {
+	for _, t := range s.Table {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+func tableColumn(t *StoreTable, name string) *StoreColumn {
+	for _, c := range t.Column {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// uses records the tables and columns used by statements and expressions.
+type uses struct {
+	s      *Store
+	table  map[string]bool
+	column map[string]map[string]bool
+	err    error
+}
+
+func newUses(s *Store) *uses {
+	return &uses{
+		s:      s,
+		table:  make(map[string]bool),
+		column: make(map[string]map[string]bool),
+	}
+}
+
+// alias maps the visible aliases to their table names.
+type alias map[string]string
+
+// scope returns the aliases of the outer scope plus the tables of from,
+// each of which is used.
+func (u *uses) scope(outer alias, from []*ResultTableSchema) alias {
+	sc := make(alias, len(outer)+len(from))
+	for k, v := range outer {
+		sc[k] = v
+	}
+	for _, rt := range from {
+		sc[rt.Alias] = rt.Name
+		u.useTable(rt.Name)
+	}
+	return sc
+}
+
+func (u *uses) useTable(name string) {
+	if u.err != nil || u.table[name] {
+		return
+	}
+	t := storeTable(u.s, name)
+	if t == nil {
+		u.err = fmt.Errorf("query: table %q not found", name)
+		return
+	}
+	u.table[name] = true
+	u.rules(t)
+}
+
+// rules uses the columns of the row rules of the table, which refer to the
+// row by the table alias, or by the table name if it has no alias.
+func (u *uses) rules(t *StoreTable) {
+	from := t.Alias
+	if len(from) == 0 {
+		from = t.Name
+	}
+	sc := alias{from: t.Name}
+	for _, p := range t.Read {
+		u.exp(p.Q, sc)
+	}
+	for _, port := range t.Port {
+		for _, p := range []Param{port.DenyRead, port.DenyInsert, port.DenyUpdate, port.DenyDelete} {
+			u.exp(p.Q, sc)
+		}
+		for _, c := range port.Column {
+			u.exp(c.DenyRead.Q, sc)
+			u.exp(c.DenyUpdate.Q, sc)
+		}
+	}
+}
+
+func (u *uses) useColumn(table, name string) {
+	u.useTable(table)
+	if u.err != nil {
+		return
+	}
+	if tableColumn(storeTable(u.s, table), name) == nil {
+		u.err = fmt.Errorf("query: column %s.%s not found", table, name)
+		return
+	}
+	if u.column[table] == nil {
+		u.column[table] = make(map[string]bool)
+	}
+	u.column[table][name] = true
+}
+
+func (u *uses) schema(list []*ColumnSchema) {
+	for _, c := range list {
+		if c.Table != nil && len(c.StoreName) > 0 {
+			u.useColumn(c.Table.Name, c.StoreName)
+		}
+	}
+}
+
+func (u *uses) stmt(st *Stmt, outer alias) {
+	// Select may return the inserted row by the alias of its table.
+	from := append([]*ResultTableSchema(nil), st.From...)
+	for _, c := range st.Insert {
+		if c.Table != nil {
+			from = append(from, c.Table)
+		}
+	}
+	from = append(from, st.Delete...)
+	sc := u.scope(outer, from)
+	u.exp(st.Condition(), sc)
+	for _, e := range st.Select {
+		u.exp(e, sc)
+	}
+	for _, o := range st.Order {
+		u.exp(o.Exp, sc)
+	}
+	u.exp(st.Limit, sc)
+	u.exp(st.Offset, sc)
+	for _, e := range st.Set {
+		u.exp(e, sc)
+	}
+	u.schema(st.Read)
+	u.schema(st.Return)
+	u.schema(st.Insert)
+	u.schema(st.Update)
+	for _, e := range Lock(u.s, st) {
+		u.exp(e, sc)
+	}
+	for i := range st.Interleave {
+		u.stmt(&st.Interleave[i].Stmt, sc)
+	}
+}
+
+func (u *uses) exp(e Exp, sc alias) {
+	switch {
+	case u.err != nil:
+		return
+	case e.Op == ExpColumn:
+		table, ok := sc[e.Alias]
+		if !ok {
+			u.err = fmt.Errorf("query: alias %q of column %s not found", e.Alias, e)
+			return
+		}
+		u.useColumn(table, e.Name)
+	}
+	for _, a := range e.Arg {
+		u.exp(a, sc)
+	}
+	if e.Sub != nil {
+		sub := u.scope(sc, e.Sub.From)
+		u.exp(e.Sub.Where, sub)
+		for _, s := range e.Sub.Select {
+			u.exp(s, sub)
+		}
+	}
+}
+
+// store returns the used tables and columns, in store order.
+func (u *uses) store() (*Store, error) {
+	if u.err != nil {
+		return nil, u.err
+	}
+	out := &Store{}
+	for _, t := range u.s.Table {
+		if !u.table[t.Name] {
+			continue
+		}
+		nt := *t
+		nt.Column = nil
+		nt.Port = nil
+		for _, c := range t.Column {
+			if u.column[t.Name][c.Name] {
+				nc := *c
+				nt.Column = append(nt.Column, &nc)
+			}
+		}
+		out.Table = append(out.Table, &nt)
+	}
+	return out, nil
+}
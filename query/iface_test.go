@@ -0,0 +1,187 @@
+// Copyright 2018 solidcoredata authors.
+
+package query
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func ifaceStore() *Store {
+	return &Store{Table: []*StoreTable{
+		{Name: "account", Column: []*StoreColumn{
+			{Name: "id", Key: true, Type: TypeInteger},
+			{Name: "name", Type: TypeString},
+			{Name: "closed", Type: TypeBoolean},
+			{Name: "note", Type: TypeString, Nullable: true},
+		}},
+		{Name: "ledger", Column: []*StoreColumn{
+			{Name: "id", Key: true, Type: TypeInteger},
+			{Name: "account", Type: TypeInteger},
+			{Name: "amount", Type: TypeDecimal},
+			{Name: "version", Type: TypeInteger, UpdateLock: true},
+			{Name: "memo", Type: TypeString, Nullable: true},
+		}},
+		{Name: "audit", Column: []*StoreColumn{
+			{Name: "id", Key: true, Type: TypeInteger},
+		}},
+	}}
+}
+
+// ifaceNames lists each table of the store with its column names.
+func ifaceNames(s *Store) []string {
+	var list []string
+	for _, t := range s.Table {
+		var col []string
+		for _, c := range t.Column {
+			col = append(col, c.Name)
+		}
+		list = append(list, t.Name+"("+strings.Join(col, ", ")+")")
+	}
+	return list
+}
+
+func TestInterface(t *testing.T) {
+	s := ifaceStore()
+	a := &ResultTableSchema{Name: "account", Alias: "a", IsArity: true}
+	l := &ResultTableSchema{Name: "ledger", Alias: "l", IsArity: true}
+	st := Stmt{
+		From: []*ResultTableSchema{a},
+		Where: Exists(&SubQuery{
+			From:  []*ResultTableSchema{{Name: "ledger", Alias: "x"}},
+			Where: Equal(Column("x", "account"), Column("a", "id")),
+		}),
+		Return: []*ColumnSchema{{Table: a, StoreName: "name", QueryName: "name"}},
+		Order:  []Order{{Exp: Column("a", "name")}},
+		Interleave: []Interleave{{Name: "ledger", Stmt: Stmt{
+			From:   []*ResultTableSchema{l},
+			Where:  Equal(Column("l", "account"), Column("a", "id")),
+			Select: []Exp{Column("l", "amount")},
+			Return: []*ColumnSchema{{QueryName: "amount"}},
+		}}},
+	}
+	got, err := Interface(s, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"account(id, name)", "ledger(account, amount)"}
+	if g := ifaceNames(got); !reflect.DeepEqual(g, want) {
+		t.Fatalf("got %q, want %q", g, want)
+	}
+	if got.Table[0].Column[0] == s.Table[0].Column[0] {
+		t.Fatal("interface shares columns with the store")
+	}
+	if err := Satisfies(s, got); err != nil {
+		t.Fatal(err)
+	}
+
+	// An update uses the lock columns of the table.
+	up := Stmt{
+		From:   []*ResultTableSchema{l},
+		Where:  Equal(Column("l", "id"), Parameter("id")),
+		Update: []*ColumnSchema{{Table: l, StoreName: "amount"}},
+		Set:    []Exp{Parameter("amount")},
+	}
+	got, err = Interface(s, up)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := ifaceNames(got), []string{"ledger(id, amount, version)"}; !reflect.DeepEqual(g, w) {
+		t.Fatalf("got %q, want %q", g, w)
+	}
+
+	got, err = ConditionInterface(s, []*ResultTableSchema{a}, Equal(Column("a", "closed"), Literal(false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := ifaceNames(got), []string{"account(closed)"}; !reflect.DeepEqual(g, w) {
+		t.Fatalf("got %q, want %q", g, w)
+	}
+
+	_, err = ConditionInterface(s, []*ResultTableSchema{a}, Column("b", "id"))
+	if err == nil || !strings.Contains(err.Error(), `alias "b"`) {
+		t.Fatalf("got %v, want alias not found error", err)
+	}
+	_, err = ConditionInterface(s, []*ResultTableSchema{a}, Column("a", "missing"))
+	if err == nil || !strings.Contains(err.Error(), "column account.missing not found") {
+		t.Fatalf("got %v, want column not found error", err)
+	}
+}
+
+func TestInterfaceRules(t *testing.T) {
+	s := ifaceStore()
+	s.Table[0].Read = []Param{{Q: Equal(Column("account", "closed"), Literal(false))}}
+	s.Table[1].Port = map[string]StoreTablePort{
+		"web": {DenyDelete: Param{Q: Exists(&SubQuery{
+			From:  []*ResultTableSchema{{Name: "audit", Alias: "x"}},
+			Where: Equal(Column("x", "id"), Column("ledger", "id")),
+		})}},
+	}
+	l := &ResultTableSchema{Name: "ledger", Alias: "l", IsArity: true}
+	got, err := Interface(s,
+		Stmt{
+			From:  []*ResultTableSchema{{Name: "account", Alias: "a", IsArity: true}},
+			Where: Equal(Column("a", "name"), Parameter("name")),
+		},
+		Stmt{
+			From:   []*ResultTableSchema{l},
+			Where:  Equal(Column("l", "account"), Parameter("account")),
+			Delete: []*ResultTableSchema{l},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"account(name, closed)", "ledger(id, account)", "audit(id)"}
+	if g := ifaceNames(got); !reflect.DeepEqual(g, want) {
+		t.Fatalf("got %q, want %q", g, want)
+	}
+	if len(got.Table[0].Read) != 1 || got.Table[1].Port != nil {
+		t.Fatal("interface tables must keep read rules and drop ports")
+	}
+
+	// A store without the column of the read rule cannot run the query.
+	s.Table[0].Column = append(s.Table[0].Column[:2], s.Table[0].Column[3])
+	if err := Satisfies(s, got); err == nil || !strings.Contains(err.Error(), "column account.closed not found") {
+		t.Fatalf("got %v, want rule column not found error", err)
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	iface := &Store{Table: []*StoreTable{
+		{Name: "account", Column: []*StoreColumn{
+			{Name: "id", Key: true, Type: TypeInteger},
+			{Name: "note", Type: TypeString, Nullable: true},
+		}},
+	}}
+
+	// Unrelated changes, and a column that may no longer be null.
+	s := ifaceStore()
+	s.Table[0].Column[3].Nullable = false
+	s.Table[0].Column = append(s.Table[0].Column, &StoreColumn{Name: "opened", Type: TypeDate})
+	s.Table = s.Table[:1]
+	if err := Satisfies(s, iface); err != nil {
+		t.Fatal(err)
+	}
+
+	s = ifaceStore()
+	s.Table[0].Column[0].Type = TypeString
+	s.Table[0].Column[3].Name = "comment"
+	err := Satisfies(s, iface)
+	want := "query: store does not satisfy interface: column account.id is String, want Integer; column account.note not found"
+	if err == nil || err.Error() != want {
+		t.Fatalf("got %v\nwant %s", err, want)
+	}
+
+	s = ifaceStore()
+	s.Table[0].Name = "acct"
+	if err := Satisfies(s, iface); err == nil || !strings.Contains(err.Error(), `table "account" not found`) {
+		t.Fatalf("got %v, want table not found error", err)
+	}
+
+	iface.Table[0].Column[1].Nullable = false
+	if err := Satisfies(ifaceStore(), iface); err == nil || !strings.Contains(err.Error(), "column account.note may be null") {
+		t.Fatalf("got %v, want may be null error", err)
+	}
+}
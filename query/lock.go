@@ -0,0 +1,54 @@
+// Copyright 2018 solidcoredata authors.
+
+package query
+
+// Lock columns give optimistic concurrency. A caller that updates a row of
+// a table with UpdateLock columns, or deletes a row of a table with
+// DeleteLock columns, passes the value of each lock column as it read the
+// row. The statement only changes the row if the values are unchanged;
+// otherwise it conflicts with a change made since, and fails.
+
+// LockParam returns the name of the parameter that holds the original
+// value of a lock column of the table alias, such as "lock.b.version".
+func LockParam(alias, column string) string {
+	return "lock." + alias + "." + column
+}
+
+// Lock returns the conditions that compare each lock column of the tables
+// the statement updates or deletes with the parameter named by LockParam.
+// Updated tables come first, in the order of the Update columns, then the
+// deleted tables.
+func Lock(s *Store, st *Stmt) []Exp {
+	table := func(name string) *StoreTable {
+		for _, t := range s.Table {
+			if t.Name == name {
+				return t
+			}
+		}
+		return nil
+	}
+	var list []Exp
+	seen := make(map[string]bool)
+	add := func(rt *ResultTableSchema, update bool) {
+		if rt == nil || seen[rt.Alias] {
+			return
+		}
+		seen[rt.Alias] = true
+		t := table(rt.Name)
+		if t == nil {
+			return
+		}
+		for _, col := range t.Column {
+			if (update && col.UpdateLock) || (!update && col.DeleteLock) {
+				list = append(list, Equal(Column(rt.Alias, col.Name), Parameter(LockParam(rt.Alias, col.Name))))
+			}
+		}
+	}
+	for _, col := range st.Update {
+		add(col.Table, true)
+	}
+	for _, rt := range st.Delete {
+		add(rt, false)
+	}
+	return list
+}
@@ -6,21 +6,23 @@ import (
 
 // Begin Schema
 
-//go:generate stringer -type=Authn
-
+// Authn is a set of access bits granted to a caller or needed by an
+// operation. Some bits imply others; see Closure.
 type Authn uint8
 
 const (
-	AllowRead   Authn = 1             // Read allows fields to be used in where clauses.
-	AllowReturn Authn = AllowRead + 2 // Return allows fields to be used in select, update, or insert clauses. Implies AllowRead.
-	AllowInsert Authn = 4             // Insert allows fields to be set to none default values per columns, or allows rows to be inserted for tables.
-	AllowUpdate Authn = 8             // Update allows fields to be updated per columns, or allows rows to be updated for tables.
-	AllowDelete Authn = 16            // Delete allows fields to be reset to their default values per column, or allows rows to be deleted for tables.
-
-	AllowNone Authn = 0                                                     // Allow no reads and no writes.
-	AllowFull Authn = AllowReturn | AllowInsert | AllowUpdate | AllowDelete // Allow any operation to the field, column, or table.
+	AllowRead   Authn = 1  // Read allows fields to be used in where clauses.
+	AllowReturn Authn = 2  // Return allows fields to be used in select, update, or insert clauses. Implies AllowRead.
+	AllowInsert Authn = 4  // Insert allows fields to be set to none default values per columns, or allows rows to be inserted for tables.
+	AllowUpdate Authn = 8  // Update allows fields to be updated per columns, or allows rows to be updated for tables.
+	AllowDelete Authn = 16 // Delete allows fields to be reset to their default values per column, or allows rows to be deleted for tables.
+
+	AllowNone Authn = 0                                                                 // Allow no reads and no writes.
+	AllowFull Authn = AllowRead | AllowReturn | AllowInsert | AllowUpdate | AllowDelete // Allow any operation to the field, column, or table.
 )
 
+//go:generate stringer -type=DataType -trimprefix Type
+
 type DataType int32
 
 const (
@@ -73,24 +75,52 @@ type ExpList interface {
 	AddCondition(exp Exp)
 }
 
-type Exp struct {
-	// Body contains the actual expression, like:
-	//
-	//    and (a.Foo = b.Foo, a.Deleted = 0)
-	Body string
-}
-
 type Stmt struct {
+	// ExpList adds conditions to the top level of the statement.
 	ExpList ExpList
 
+	From   []*ResultTableSchema // Tables referenced by the statement, first is the arity.
+	Where  Exp                  // Statement condition, may contain anchors such as "#where1".
+	Anchor []*Anchor            // Named anchors within Where, plus the top level anchor "".
+
 	Read   []*ColumnSchema
 	Return []*ColumnSchema
+	Select []Exp   // Value of each Return column. If unset, each Return column is read from its table.
+	Order  []Order // Order of the returned rows.
+	Limit  Exp     // Maximum number of rows returned. No limit if unset.
+	Offset Exp     // Number of rows skipped before the first returned row.
+
+	// Insert adds a row to the table of the Insert columns, once for each
+	// row matched by From, or once if From is empty. Update sets the
+	// Update columns of each matched row. Set holds the value of each Insert
+	// or Update column. Delete removes the matched rows of each table.
+	// Return columns of a modified table return the new values.
 	Insert []*ColumnSchema
 	Update []*ColumnSchema
+	Set    []Exp
 	Delete []*ResultTableSchema
+
+	// Interleave holds statements run for each returned row. The result
+	// of each follows the row in the stream, nested within the result of
+	// the statement. They may refer to the aliases of the statement.
+	Interleave []Interleave
+}
+
+// Interleave is a named statement whose rows are returned under each row
+// of its parent statement, such as the ledgers of each account.
+type Interleave struct {
+	Name string
+	Stmt Stmt
+}
+
+// Order is a sort expression of a statement.
+type Order struct {
+	Exp  Exp
+	Desc bool
 }
 
 type Query struct {
+	Name string
 	Stmt []Stmt
 }
 
@@ -116,6 +146,8 @@ type StoreTable struct {
 	Column  []*StoreColumn
 	Read    []Param
 
+	ReadOnly bool // Rows may not be inserted, updated, or deleted, such as a view.
+
 	Port map[string]StoreTablePort
 }
 
@@ -146,8 +178,11 @@ type Input struct {
 	Name string
 }
 
+// Param is a predicate that depends on caller supplied input, such as:
+//
+//	exists (from account_org ao and (ao.account = Account, ao.org = b.org))
 type Param struct {
-	Q     string
+	Q     Exp
 	Input []Input
 }
 
@@ -169,6 +204,7 @@ type StoreColumn struct {
 
 	UpdateLock bool // True if column should be compared prior to update, only allow if same.
 	DeleteLock bool // True if the column should be compared prior to delete, only allow if same.
+	ReadOnly   bool // Column may not be inserted or updated, such as a computed column.
 }
 
 // Begin Result Schema
@@ -180,6 +216,7 @@ type ResultSetSchema struct {
 
 type ResultSchema struct {
 	Role   string
+	Name   string // Name of an interleaved result, empty otherwise.
 	Column []*ColumnSchema
 }
 
@@ -221,6 +258,11 @@ const (
 	StreamEndOfResult                 // No value.
 	StreamEndOfSet                    // No value.
 	StreamError                       // Value is an error, signalling termination of the stream.
+	StreamVersion                     // Value is the version token of the result set.
+	StreamRowInsert                   // Value is a row inserted since the prior version.
+	StreamRowUpdate                   // Value is the new values of a row updated since the prior version.
+	StreamRowDelete                   // Value is the prior values of a row deleted since the prior version.
+	StreamCursor                      // Value is the cursor that continues after the last row of a keyset page.
 )
 
 type StreamItem interface {
@@ -231,17 +273,45 @@ type StreamField []byte
 
 type StreamItemResultSetSchema struct{ Schema ResultSetSchema }
 type StreamItemResult struct{ SchemaIndex int64 }
-type StreamItemRow struct{ Row []StreamField }
+type StreamItemRow struct {
+	Row []StreamField
+
+	// Allow is the access to each field of the row, set only when some
+	// field differs from the Allow of its column, such as when a row rule
+	// denies reading the value. It is the ValueBuffer.Allow of each field.
+	Allow []Authn
+}
 type StreamItemEndOfResult struct{}
 type StreamItemEndOfSet struct{}
 type StreamItemError struct{ Error error }
 
+// StreamItemVersion is sent before the end of a set when the runner is asked
+// for a row delta. Passing the token back to the runner returns only the rows
+// changed since this result set.
+type StreamItemVersion struct{ Token string }
+
+// StreamItemCursor is sent before the end of a set when the runner is asked
+// for a keyset page and the page is full. Passing the token back to the
+// runner returns the page of rows that follow the last row of this page.
+type StreamItemCursor struct{ Token string }
+
+// Row delta items take the place of rows in a result that holds the changes
+// since a prior version. Rows are identified by the Key columns of the result.
+type StreamItemRowInsert StreamItemRow
+type StreamItemRowUpdate StreamItemRow
+type StreamItemRowDelete StreamItemRow
+
 func (StreamItemResultSetSchema) StreamState() StreamState { return StreamResultSetSchema }
 func (StreamItemResult) StreamState() StreamState          { return StreamResult }
 func (StreamItemRow) StreamState() StreamState             { return StreamRow }
 func (StreamItemEndOfResult) StreamState() StreamState     { return StreamEndOfResult }
 func (StreamItemEndOfSet) StreamState() StreamState        { return StreamEndOfSet }
 func (StreamItemError) StreamState() StreamState           { return StreamError }
+func (StreamItemVersion) StreamState() StreamState         { return StreamVersion }
+func (StreamItemRowInsert) StreamState() StreamState       { return StreamRowInsert }
+func (StreamItemRowUpdate) StreamState() StreamState       { return StreamRowUpdate }
+func (StreamItemRowDelete) StreamState() StreamState       { return StreamRowDelete }
+func (StreamItemCursor) StreamState() StreamState          { return StreamCursor }
 
 type StreamingResultSet interface {
 	// returns io.EOF when done.
@@ -251,8 +321,10 @@ type StreamingResultSet interface {
 // Begin Buffer
 
 type ResultSetBuffer struct {
-	Schema ResultSetSchema
-	Set    []ResultBuffer
+	Schema  ResultSetSchema
+	Set     []ResultBuffer
+	Version string // Version token of the results, if the runner sent one.
+	Cursor  string // Cursor of the next keyset page, if the runner sent one.
 }
 
 type ResultBuffer struct {
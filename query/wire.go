@@ -0,0 +1,610 @@
+// Copyright 2018 solidcoredata authors.
+
+package query
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// A wire stream encodes the items of a StreamingResultSet as bytes, so a
+// result stream may be sent between processes.
+//
+// The stream starts with the four bytes "DBCS" and the version byte, which
+// is WireVersion. Each item follows as its StreamState byte and payload,
+// until the end of the stream. A decoder reads each version up to
+// WireVersion. Version 1 has no result Name, and no StreamVersion,
+// StreamRowInsert, StreamRowUpdate, StreamRowDelete, or StreamCursor items.
+// Within a payload:
+//
+//	uint      unsigned varint, as encoding/binary.PutUvarint
+//	int       signed zig-zag varint, as encoding/binary.PutVarint
+//	bytes     uint length, then the bytes
+//	string    bytes of UTF-8 text
+//	bool      1 byte, 0 is false, 1 is true
+//
+// The payload of each stream state is:
+//
+//	StreamResultSetSchema   uint result count, then for each result:
+//	                          string Role, string Name, uint column count,
+//	                          then each column
+//	StreamResult            int SchemaIndex
+//	StreamRow               uint field count, then the bytes of each StreamField,
+//	                        uint allow count, 0 or the field count, then
+//	                        1 byte Authn of each field
+//	StreamEndOfResult       none
+//	StreamEndOfSet          none
+//	StreamError             string error message
+//	StreamVersion           string Token
+//	StreamRowInsert         as StreamRow
+//	StreamRowUpdate         as StreamRow
+//	StreamRowDelete         as StreamRow
+//	StreamCursor            string Token
+//
+// A column is encoded as:
+//
+//	bool      has a table, if true followed by string Name, string Alias, bool IsArity
+//	string    StoreName, QueryName, UIBindName, Display
+//	1 byte    Allow
+//	1 byte    flags: 1 Key, 2 Serial, 4 Nullable, 8 UpdateLock, 16 DeleteLock
+//	int       Length
+//	uint      Type
+//	default   Default value
+//	string    LinkToTable, LinkToColumn
+//
+// A default value is a kind byte followed by the value: 0 NULL, 1 bool,
+// 2 int, 3 8 byte IEEE 754 float, 4 string, or 5 bytes of a StreamField
+// of the column Type. Columns of a result that share a table share the
+// decoded *ResultTableSchema.
+
+// WireVersion is the version of the wire stream encoding.
+const WireVersion = 2
+
+var wireMagic = []byte("DBCS")
+
+// maxWireLen limits the length of bytes and the count of lists read from
+// a wire stream.
+const maxWireLen = 1 << 30
+
+// wireChunk is the most a decoder allocates for a length before the bytes
+// arrive, so a corrupt or hostile length fails at the end of the stream
+// rather than on the allocation.
+const wireChunk = 64 << 10
+
+const (
+	wireKey = 1 << iota
+	wireSerial
+	wireNullable
+	wireUpdateLock
+	wireDeleteLock
+)
+
+const (
+	defaultNull = iota
+	defaultBool
+	defaultInt
+	defaultFloat
+	defaultString
+	defaultField
+)
+
+// Encoder writes stream items to a wire stream.
+type Encoder struct {
+	w      io.Writer
+	buf    []byte
+	header bool
+}
+
+// NewEncoder returns an encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the item to the stream. The stream header is written
+// before the first item. Each item is written with a single Write call.
+func (e *Encoder) Encode(item StreamItem) error {
+	b := e.buf[:0]
+	if !e.header {
+		b = append(append(b, wireMagic...), WireVersion)
+	}
+	b = append(b, byte(item.StreamState()))
+	var err error
+	switch v := item.(type) {
+	default:
+		return fmt.Errorf("query: unsupported stream item %T", item)
+	case StreamItemResultSetSchema:
+		b, err = appendSetSchema(b, v.Schema)
+	case StreamItemResult:
+		b = appendVarint(b, v.SchemaIndex)
+	case StreamItemRow:
+		b, err = appendRow(b, v)
+	case StreamItemRowInsert:
+		b, err = appendRow(b, StreamItemRow(v))
+	case StreamItemRowUpdate:
+		b, err = appendRow(b, StreamItemRow(v))
+	case StreamItemRowDelete:
+		b, err = appendRow(b, StreamItemRow(v))
+	case StreamItemVersion:
+		b = appendString(b, v.Token)
+	case StreamItemCursor:
+		b = appendString(b, v.Token)
+	case StreamItemEndOfResult, StreamItemEndOfSet:
+	case StreamItemError:
+		msg := "<nil>"
+		if v.Error != nil {
+			msg = v.Error.Error()
+		}
+		b = appendString(b, msg)
+	}
+	if err != nil {
+		return err
+	}
+	e.buf = b
+	if _, err = e.w.Write(b); err != nil {
+		return err
+	}
+	e.header = true
+	return nil
+}
+
+// EncodeStream writes each item of the stream to w until the stream
+// returns io.EOF.
+func EncodeStream(w io.Writer, stream StreamingResultSet) error {
+	e := NewEncoder(w)
+	for {
+		item, err := stream.Next()
+		if err == io.EOF {
+			if !e.header {
+				// An empty stream still has a header.
+				_, err = w.Write(append(append([]byte(nil), wireMagic...), WireVersion))
+				return err
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := e.Encode(item); err != nil {
+			return err
+		}
+	}
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func appendBytes(b, v []byte) []byte {
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+func appendSetSchema(b []byte, s ResultSetSchema) ([]byte, error) {
+	b = appendUvarint(b, uint64(len(s.Set)))
+	for _, rs := range s.Set {
+		if rs == nil {
+			return nil, errors.New("query: nil result schema")
+		}
+		b = appendString(b, rs.Role)
+		b = appendString(b, rs.Name)
+		b = appendUvarint(b, uint64(len(rs.Column)))
+		for _, col := range rs.Column {
+			var err error
+			b, err = appendColumn(b, col)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+func appendColumn(b []byte, col *ColumnSchema) ([]byte, error) {
+	b = appendBool(b, col.Table != nil)
+	if col.Table != nil {
+		b = appendString(b, col.Table.Name)
+		b = appendString(b, col.Table.Alias)
+		b = appendBool(b, col.Table.IsArity)
+	}
+	for _, s := range []string{col.StoreName, col.QueryName, col.UIBindName, col.Display} {
+		b = appendString(b, s)
+	}
+	var flags byte
+	for _, f := range []struct {
+		set  bool
+		flag byte
+	}{
+		{col.Key, wireKey},
+		{col.Serial, wireSerial},
+		{col.Nullable, wireNullable},
+		{col.UpdateLock, wireUpdateLock},
+		{col.DeleteLock, wireDeleteLock},
+	} {
+		if f.set {
+			flags |= f.flag
+		}
+	}
+	b = append(b, byte(col.Allow), flags)
+	b = appendVarint(b, int64(col.Length))
+	b = appendUvarint(b, uint64(col.Type))
+	switch v := col.Default.(type) {
+	case nil:
+		b = append(b, defaultNull)
+	case bool:
+		b = appendBool(append(b, defaultBool), v)
+	case int64:
+		b = appendVarint(append(b, defaultInt), v)
+	case float64:
+		b = appendUint64(append(b, defaultFloat), math.Float64bits(v))
+	case string:
+		b = appendString(append(b, defaultString), v)
+	default:
+		f, err := EncodeField(col.Type, v)
+		if err != nil {
+			return nil, fmt.Errorf("query: column %q default: %w", col.QueryName, err)
+		}
+		b = appendBytes(append(b, defaultField), f)
+	}
+	b = appendString(b, col.LinkToTable)
+	b = appendString(b, col.LinkToColumn)
+	return b, nil
+}
+
+func appendRow(b []byte, row StreamItemRow) ([]byte, error) {
+	if len(row.Allow) > 0 && len(row.Allow) != len(row.Row) {
+		return nil, fmt.Errorf("query: row has %d allow values for %d fields", len(row.Allow), len(row.Row))
+	}
+	b = appendUvarint(b, uint64(len(row.Row)))
+	for _, f := range row.Row {
+		b = appendBytes(b, f)
+	}
+	b = appendUvarint(b, uint64(len(row.Allow)))
+	for _, a := range row.Allow {
+		b = append(b, byte(a))
+	}
+	return b, nil
+}
+
+// Decoder reads stream items from a wire stream. It is a
+// StreamingResultSet.
+type Decoder struct {
+	r       *bufio.Reader
+	header  bool
+	version byte // Version of the stream, set by the header.
+	err     error
+}
+
+// NewDecoder returns a decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Next returns the next item of the stream, or io.EOF at the end of the
+// stream. After an error, Next returns the same error.
+func (d *Decoder) Next() (StreamItem, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	item, err := d.next()
+	if err != nil {
+		if err == io.ErrUnexpectedEOF || (err == io.EOF && !d.header) {
+			err = errors.New("query: truncated wire stream")
+		}
+		d.err = err
+	}
+	return item, err
+}
+
+func (d *Decoder) next() (StreamItem, error) {
+	if !d.header {
+		var h [5]byte
+		if _, err := io.ReadFull(d.r, h[:]); err != nil {
+			return nil, err
+		}
+		if string(h[:4]) != string(wireMagic) {
+			return nil, errors.New("query: not a wire stream")
+		}
+		if h[4] < 1 || h[4] > WireVersion {
+			return nil, fmt.Errorf("query: unsupported wire stream version %d", h[4])
+		}
+		d.header = true
+		d.version = h[4]
+	}
+	state, err := d.r.ReadByte()
+	if err != nil {
+		// The stream may only end between items.
+		return nil, err
+	}
+	item, err := d.item(StreamState(state))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return item, err
+}
+
+func (d *Decoder) item(state StreamState) (StreamItem, error) {
+	if d.version < 2 && state > StreamError {
+		return nil, fmt.Errorf("query: invalid stream state %d in wire stream version %d", state, d.version)
+	}
+	switch state {
+	default:
+		return nil, fmt.Errorf("query: invalid stream state %d", state)
+	case StreamResultSetSchema:
+		s, err := d.setSchema()
+		return StreamItemResultSetSchema{Schema: s}, err
+	case StreamResult:
+		n, err := binary.ReadVarint(d.r)
+		return StreamItemResult{SchemaIndex: n}, err
+	case StreamRow:
+		return d.row()
+	case StreamRowInsert:
+		row, err := d.row()
+		return StreamItemRowInsert(row), err
+	case StreamRowUpdate:
+		row, err := d.row()
+		return StreamItemRowUpdate(row), err
+	case StreamRowDelete:
+		row, err := d.row()
+		return StreamItemRowDelete(row), err
+	case StreamVersion:
+		token, err := d.string()
+		return StreamItemVersion{Token: token}, err
+	case StreamCursor:
+		token, err := d.string()
+		return StreamItemCursor{Token: token}, err
+	case StreamEndOfResult:
+		return StreamItemEndOfResult{}, nil
+	case StreamEndOfSet:
+		return StreamItemEndOfSet{}, nil
+	case StreamError:
+		msg, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		return StreamItemError{Error: errors.New(msg)}, nil
+	}
+}
+
+func (d *Decoder) count() (int, error) {
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return 0, err
+	}
+	if n > maxWireLen {
+		return 0, fmt.Errorf("query: wire length %d too large", n)
+	}
+	return int(n), nil
+}
+
+func (d *Decoder) bytes() ([]byte, error) {
+	n, err := d.count()
+	if err != nil {
+		return nil, err
+	}
+	return d.read(n)
+}
+
+// read reads n bytes, growing the buffer as the bytes arrive.
+func (d *Decoder) read(n int) ([]byte, error) {
+	if n <= wireChunk {
+		b := make([]byte, n)
+		_, err := io.ReadFull(d.r, b)
+		return b, err
+	}
+	buf := &bytes.Buffer{}
+	buf.Grow(wireChunk)
+	if _, err := io.CopyN(buf, d.r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *Decoder) string() (string, error) {
+	b, err := d.bytes()
+	return string(b), err
+}
+
+func (d *Decoder) bool() (bool, error) {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return false, err
+	}
+	switch c {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	}
+	return false, fmt.Errorf("query: invalid wire bool %d", c)
+}
+
+func (d *Decoder) setSchema() (ResultSetSchema, error) {
+	var s ResultSetSchema
+	n, err := d.count()
+	if err != nil {
+		return s, err
+	}
+	for i := 0; i < n; i++ {
+		rs := &ResultSchema{}
+		if rs.Role, err = d.string(); err != nil {
+			return s, err
+		}
+		if d.version >= 2 {
+			if rs.Name, err = d.string(); err != nil {
+				return s, err
+			}
+		}
+		nc, err := d.count()
+		if err != nil {
+			return s, err
+		}
+		table := make(map[ResultTableSchema]*ResultTableSchema)
+		for j := 0; j < nc; j++ {
+			col, err := d.column(table)
+			if err != nil {
+				return s, err
+			}
+			rs.Column = append(rs.Column, col)
+		}
+		s.Set = append(s.Set, rs)
+	}
+	return s, nil
+}
+
+func (d *Decoder) column(table map[ResultTableSchema]*ResultTableSchema) (*ColumnSchema, error) {
+	col := &ColumnSchema{}
+	hasTable, err := d.bool()
+	if err != nil {
+		return nil, err
+	}
+	if hasTable {
+		var t ResultTableSchema
+		if t.Name, err = d.string(); err != nil {
+			return nil, err
+		}
+		if t.Alias, err = d.string(); err != nil {
+			return nil, err
+		}
+		if t.IsArity, err = d.bool(); err != nil {
+			return nil, err
+		}
+		col.Table = table[t]
+		if col.Table == nil {
+			col.Table = &t
+			table[t] = &t
+		}
+	}
+	for _, s := range []*string{&col.StoreName, &col.QueryName, &col.UIBindName, &col.Display} {
+		if *s, err = d.string(); err != nil {
+			return nil, err
+		}
+	}
+	var ab [2]byte
+	if _, err := io.ReadFull(d.r, ab[:]); err != nil {
+		return nil, err
+	}
+	col.Allow = Authn(ab[0])
+	flags := ab[1]
+	col.Key = flags&wireKey != 0
+	col.Serial = flags&wireSerial != 0
+	col.Nullable = flags&wireNullable != 0
+	col.UpdateLock = flags&wireUpdateLock != 0
+	col.DeleteLock = flags&wireDeleteLock != 0
+	length, err := binary.ReadVarint(d.r)
+	if err != nil {
+		return nil, err
+	}
+	col.Length = int32(length)
+	t, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, err
+	}
+	col.Type = DataType(t)
+	if col.Default, err = d.defaultValue(col.Type); err != nil {
+		return nil, err
+	}
+	if col.LinkToTable, err = d.string(); err != nil {
+		return nil, err
+	}
+	if col.LinkToColumn, err = d.string(); err != nil {
+		return nil, err
+	}
+	return col, nil
+}
+
+func (d *Decoder) defaultValue(t DataType) (interface{}, error) {
+	kind, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	default:
+		return nil, fmt.Errorf("query: invalid default kind %d", kind)
+	case defaultNull:
+		return nil, nil
+	case defaultBool:
+		return d.bool()
+	case defaultInt:
+		return binary.ReadVarint(d.r)
+	case defaultFloat:
+		var b [8]byte
+		if _, err := io.ReadFull(d.r, b[:]); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b[:])), nil
+	case defaultString:
+		return d.string()
+	case defaultField:
+		f, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return DecodeField(t, f)
+	}
+}
+
+func (d *Decoder) row() (StreamItemRow, error) {
+	var row StreamItemRow
+	n, err := d.count()
+	if err != nil {
+		return row, err
+	}
+	// Each field is at least one byte, so grow the row as fields arrive.
+	size := n
+	if size > 1024 {
+		size = 1024
+	}
+	row.Row = make([]StreamField, 0, size)
+	for i := 0; i < n; i++ {
+		f, err := d.bytes()
+		if err != nil {
+			return row, err
+		}
+		row.Row = append(row.Row, f)
+	}
+	na, err := d.count()
+	if err != nil {
+		return row, err
+	}
+	if na == 0 {
+		return row, nil
+	}
+	if na != n {
+		return row, fmt.Errorf("query: row has %d allow values for %d fields", na, n)
+	}
+	allow, err := d.read(na)
+	if err != nil {
+		return row, err
+	}
+	row.Allow = make([]Authn, na)
+	for i, a := range allow {
+		row.Allow[i] = Authn(a)
+	}
+	return row, nil
+}
@@ -0,0 +1,29 @@
+// Copyright 2018 solidcoredata authors.
+
+//go:build go1.18
+// +build go1.18
+
+package query
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzDecoder(f *testing.F) {
+	list := itemList(wireItems(f))
+	buf := &bytes.Buffer{}
+	if err := EncodeStream(buf, &list); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf.Bytes())
+	f.Add([]byte("DBCS\x02\x03\x80\x80\x80\x80\x04"))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		d := NewDecoder(bytes.NewReader(b))
+		for {
+			if _, err := d.Next(); err != nil {
+				return
+			}
+		}
+	})
+}
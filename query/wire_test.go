@@ -0,0 +1,274 @@
+// Copyright 2018 solidcoredata authors.
+
+package query
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// itemList is a StreamingResultSet of a fixed list of items.
+type itemList []StreamItem
+
+func (l *itemList) Next() (StreamItem, error) {
+	if len(*l) == 0 {
+		return nil, io.EOF
+	}
+	item := (*l)[0]
+	*l = (*l)[1:]
+	return item, nil
+}
+
+func wireItems(t testing.TB) []StreamItem {
+	book := &ResultTableSchema{Name: "book", Alias: "b", IsArity: true}
+	field := func(dt DataType, v interface{}) StreamField {
+		f, err := EncodeField(dt, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	null := field(TypeInteger, nil)
+	return []StreamItem{
+		StreamItemResultSetSchema{Schema: ResultSetSchema{Set: []*ResultSchema{
+			{
+				Role: "reader",
+				Column: []*ColumnSchema{
+					{Table: book, StoreName: "id", QueryName: "id", Allow: AllowRead | AllowReturn, Key: true, Serial: true, Type: TypeInteger},
+					{Table: book, StoreName: "name", QueryName: "Name", UIBindName: "name", Display: "Book Name", Allow: AllowFull, Length: 200, Type: TypeString, Default: "untitled", UpdateLock: true},
+					{Table: book, StoreName: "price", QueryName: "price", Nullable: true, DeleteLock: true, Type: TypeDecimal, Default: big.NewRat(5, 2), LinkToTable: "price", LinkToColumn: "id"},
+					{QueryName: "half", Type: TypeFloat, Default: -1.5},
+					{QueryName: "count", Type: TypeInteger, Default: int64(-3)},
+					{QueryName: "ok", Type: TypeBoolean, Default: true},
+				},
+			},
+			{Role: "empty", Name: "detail"},
+		}}},
+		StreamItemResult{SchemaIndex: 0},
+		StreamItemRow{Row: []StreamField{
+			field(TypeInteger, int64(1)),
+			field(TypeString, "Emma"),
+			field(TypeDecimal, big.NewRat(7, 4)),
+			field(TypeFloat, 0.5),
+			field(TypeInteger, nil),
+			field(TypeBoolean, false),
+		}},
+		StreamItemRow{
+			Row: []StreamField{
+				field(TypeInteger, int64(2)),
+				field(TypeString, nil),
+				field(TypeDecimal, nil),
+				field(TypeFloat, nil),
+				field(TypeInteger, int64(1)),
+				field(TypeBoolean, true),
+			},
+			Allow: []Authn{AllowRead | AllowReturn, AllowRead, AllowNone, AllowReturn, AllowFull, AllowFull},
+		},
+		StreamItemEndOfResult{},
+		StreamItemResult{SchemaIndex: 1},
+		StreamItemEndOfResult{},
+		StreamItemResult{SchemaIndex: 0},
+		StreamItemRowDelete{Row: []StreamField{field(TypeInteger, int64(3)), null, null, null, null, null}},
+		StreamItemRowInsert{Row: []StreamField{field(TypeInteger, int64(4)), null, null, null, null, null}},
+		StreamItemRowUpdate{
+			Row:   []StreamField{field(TypeInteger, int64(1)), null, null, null, null, null},
+			Allow: []Authn{AllowRead, AllowNone, AllowNone, AllowNone, AllowNone, AllowNone},
+		},
+		StreamItemEndOfResult{},
+		StreamItemVersion{Token: "v12"},
+		StreamItemCursor{Token: "AAAAAQ"},
+		StreamItemEndOfSet{},
+		StreamItemError{Error: errors.New("query failed")},
+	}
+}
+
+func TestWireRoundTrip(t *testing.T) {
+	items := wireItems(t)
+	list := itemList(append([]StreamItem(nil), items...))
+	buf := &bytes.Buffer{}
+	if err := EncodeStream(buf, &list); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("DBCS\x02")) {
+		t.Fatalf("missing header: %q", buf.Bytes()[:5])
+	}
+	d := NewDecoder(bytes.NewReader(buf.Bytes()))
+	for i, want := range items {
+		got, err := d.Next()
+		if err != nil {
+			t.Fatalf("item %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("item %d: got %#v, want %#v", i, got, want)
+		}
+	}
+	if _, err := d.Next(); err != io.EOF {
+		t.Fatalf("got %v at end, want EOF", err)
+	}
+
+	// Columns of the same table share the table.
+	d = NewDecoder(bytes.NewReader(buf.Bytes()))
+	item, _ := d.Next()
+	cols := item.(StreamItemResultSetSchema).Schema.Set[0].Column
+	if cols[0].Table != cols[1].Table {
+		t.Error("columns of the same table do not share the table")
+	}
+}
+
+func TestWireVersion1(t *testing.T) {
+	// A version 1 result schema has no Name.
+	in := "DBCS\x01" +
+		"\x01\x01\x06reader\x01" +
+		"\x00\x00\x02id\x00\x00\x00\x01\x00\x04\x00\x00\x00" +
+		"\x02\x00\x05"
+	d := NewDecoder(strings.NewReader(in))
+	var got []StreamItem
+	for {
+		item, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, item)
+	}
+	want := []StreamItem{
+		StreamItemResultSetSchema{Schema: ResultSetSchema{Set: []*ResultSchema{{
+			Role:   "reader",
+			Column: []*ColumnSchema{{QueryName: "id", Key: true, Type: TypeInteger}},
+		}}}},
+		StreamItemResult{},
+		StreamItemEndOfSet{},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestWireEmpty(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := EncodeStream(buf, &itemList{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDecoder(buf).Next(); err != io.EOF {
+		t.Fatalf("got %v, want EOF", err)
+	}
+}
+
+func TestWireTruncated(t *testing.T) {
+	list := itemList(wireItems(t))
+	buf := &bytes.Buffer{}
+	if err := EncodeStream(buf, &list); err != nil {
+		t.Fatal(err)
+	}
+	full := buf.Bytes()
+
+	// Find the offset of the end of the header and each item.
+	boundary := map[int]bool{5: true}
+	e := &Encoder{w: io.Discard}
+	n := 0
+	for _, item := range wireItems(t) {
+		if err := e.Encode(item); err != nil {
+			t.Fatal(err)
+		}
+		n += len(e.buf)
+		boundary[n] = true
+	}
+	for size := 0; size < len(full); size++ {
+		d := NewDecoder(bytes.NewReader(full[:size]))
+		var err error
+		for err == nil {
+			_, err = d.Next()
+		}
+		if boundary[size] {
+			if err != io.EOF {
+				t.Fatalf("size %d at item boundary: got %v, want EOF", size, err)
+			}
+			continue
+		}
+		if err == io.EOF || !strings.Contains(err.Error(), "truncated") {
+			t.Fatalf("size %d: got %v, want truncated error", size, err)
+		}
+	}
+}
+
+func TestWireError(t *testing.T) {
+	list := []struct {
+		name string
+		in   string
+		err  string
+	}{
+		{"magic", "DBCX\x03", "not a wire stream"},
+		{"version", "DBCS\x09", "unsupported wire stream version 9"},
+		{"version 0", "DBCS\x00", "unsupported wire stream version 0"},
+		{"version 1 state", "DBCS\x01\x07\x00", "invalid stream state 7 in wire stream version 1"},
+		{"state", "DBCS\x02\x42", "invalid stream state 66"},
+		{"allow", "DBCS\x02\x03\x01\x02\x01\x01\x02\x03\x03", "2 allow values for 1 fields"},
+	}
+	for _, item := range list {
+		_, err := NewDecoder(strings.NewReader(item.in)).Next()
+		if err == nil || !strings.Contains(err.Error(), item.err) {
+			t.Errorf("%s: got %v, want %q", item.name, err, item.err)
+		}
+	}
+
+	e := NewEncoder(io.Discard)
+	err := e.Encode(StreamItemRow{Row: []StreamField{{0}}, Allow: []Authn{AllowFull, AllowFull}})
+	if err == nil {
+		t.Error("expected allow length error")
+	}
+	err = e.Encode(StreamItemResultSetSchema{Schema: ResultSetSchema{Set: []*ResultSchema{{Column: []*ColumnSchema{{QueryName: "x", Type: TypeInteger, Default: 1}}}}}})
+	if err == nil || !strings.Contains(err.Error(), `column "x" default`) {
+		t.Errorf("got %v, want default error", err)
+	}
+}
+
+func TestWireHugeLength(t *testing.T) {
+	// 1<<30 as a uvarint, the largest length a decoder accepts.
+	const huge = "\x80\x80\x80\x80\x04"
+	list := []struct {
+		name string
+		in   string
+		err  string
+	}{
+		{"row fields", "DBCS\x02\x03" + huge, "truncated"},
+		{"field", "DBCS\x02\x03\x01" + huge + "abc", "truncated"},
+		{"allow", "DBCS\x02\x03\x01\x01a" + huge, "allow values for 1 fields"},
+		{"error", "DBCS\x02\x06" + huge, "truncated"},
+		{"schema", "DBCS\x02\x01" + huge, "truncated"},
+		{"too large", "DBCS\x02\x07\x81\x80\x80\x80\x04", "wire length 1073741825 too large"},
+	}
+	for _, item := range list {
+		_, err := NewDecoder(strings.NewReader(item.in)).Next()
+		if err == nil || !strings.Contains(err.Error(), item.err) {
+			t.Errorf("%s: got %v, want %q", item.name, err, item.err)
+		}
+	}
+}
+
+func TestWireCorrupt(t *testing.T) {
+	list := itemList(wireItems(t))
+	buf := &bytes.Buffer{}
+	if err := EncodeStream(buf, &list); err != nil {
+		t.Fatal(err)
+	}
+	full := buf.Bytes()
+	for i := range full {
+		for _, c := range []byte{0x00, 0x7f, 0xff} {
+			b := append([]byte(nil), full...)
+			b[i] = c
+			d := NewDecoder(bytes.NewReader(b))
+			for {
+				if _, err := d.Next(); err != nil {
+					break
+				}
+			}
+		}
+	}
+}
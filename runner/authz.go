@@ -0,0 +1,256 @@
+// Copyright 2018 solidcoredata authors.
+
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/solidcoredata/dbc/query"
+)
+
+// AuthzError is returned when a statement needs access the caller is not granted.
+type AuthzError struct {
+	Port   string
+	Role   []string
+	Table  string
+	Column string // Empty if the table access is denied.
+	Row    bool   // Denied by a row rule of the table.
+	Need   query.Authn
+}
+
+func (e *AuthzError) Error() string {
+	on := fmt.Sprintf("table %q", e.Table)
+	switch {
+	case len(e.Column) > 0:
+		on = fmt.Sprintf("column %q of table %q", e.Column, e.Table)
+	case e.Row:
+		on = fmt.Sprintf("a row of table %q", e.Table)
+	}
+	return fmt.Sprintf("runner: port %q roles [%s] not allowed %v on %s", e.Port, strings.Join(e.Role, ", "), e.Need, on)
+}
+
+// Authz computes the access granted to a caller by the port and roles of
+// the run option.
+//
+// A table that declares no ports is not restricted. Otherwise the table
+// grants the union of the access of each caller role in the caller port.
+// Grants are returned with the bits they imply, see query.Authn.Closure.
+// Column ports are listed in the order of the table columns. A column
+// with a column port that lists roles grants the union of the access of
+// each caller role, limited to the table access. Any other column grants
+// the table access.
+type Authz struct {
+	store *query.Store
+	query string
+	port  string
+	role  []string
+	param map[string]bool
+}
+
+// NewAuthz returns the access granted to the caller of opt for tables in s.
+func NewAuthz(s *query.Store, opt Option) *Authz {
+	a := &Authz{store: s, query: opt.QueryName, port: opt.Port, role: opt.Role, param: make(map[string]bool, len(opt.Param))}
+	for _, p := range opt.Param {
+		a.param[p.Name] = true
+	}
+	return a
+}
+
+// Port returns the caller port.
+func (a *Authz) Port() string { return a.port }
+
+// Role returns the caller roles.
+func (a *Authz) Role() []string { return a.role }
+
+func (a *Authz) table(name string) *query.StoreTable {
+	for _, t := range a.store.Table {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// Table returns the access granted to the named table.
+func (a *Authz) Table(name string) query.Authn {
+	t := a.table(name)
+	if t == nil {
+		return query.AllowNone
+	}
+	if len(t.Port) == 0 {
+		return query.AllowFull
+	}
+	p, ok := t.Port[a.port]
+	if !ok {
+		return query.AllowNone
+	}
+	return query.RoleAuthn(p.RoleAuthn, a.role)
+}
+
+// Column returns the access granted to the named column of the named table.
+func (a *Authz) Column(table, column string) query.Authn {
+	allow := a.Table(table)
+	t := a.table(table)
+	if t == nil || len(t.Port) == 0 {
+		return allow
+	}
+	p := t.Port[a.port]
+	for i, col := range t.Column {
+		if col.Name != column {
+			continue
+		}
+		if i < len(p.Column) && p.Column[i].RoleAuthn != nil {
+			allow = query.ColumnAuthn(allow, query.RoleAuthn(p.Column[i].RoleAuthn, a.role))
+		}
+		return allow
+	}
+	return query.AllowNone
+}
+
+func (a *Authz) deny(table, column string, need query.Authn) error {
+	return &AuthzError{Port: a.port, Role: a.role, Table: table, Column: column, Need: need}
+}
+
+func (a *Authz) needTable(table string, need query.Authn) error {
+	if !a.Table(table).Satisfies(need) {
+		return a.deny(table, "", need)
+	}
+	return nil
+}
+
+func (a *Authz) needColumn(table, column string, need query.Authn) error {
+	if !a.Column(table, column).Satisfies(need) {
+		return a.deny(table, column, need)
+	}
+	return nil
+}
+
+// Check returns an AuthzError if the statement needs access the caller is
+// not granted. Tables of the statement and its sub-queries must allow read.
+// Columns used in conditions, ordering, and values must allow read, returned
+// columns must allow return, and changed tables and columns must allow
+// insert, update, or delete. Interleaved statements are checked with the
+// aliases of their parent statement.
+func (a *Authz) Check(st *query.Stmt) error {
+	return a.check(st, nil)
+}
+
+func (a *Authz) check(st *query.Stmt, parent map[string]string) error {
+	alias := make(map[string]string, len(parent)+len(st.From))
+	for k, v := range parent {
+		alias[k] = v
+	}
+	for _, rt := range st.From {
+		alias[rt.Alias] = rt.Name
+		if err := a.needTable(rt.Name, query.AllowRead); err != nil {
+			return err
+		}
+	}
+	for _, col := range st.Read {
+		if col.Table == nil {
+			continue
+		}
+		if err := a.needColumn(col.Table.Name, col.StoreName, query.AllowRead); err != nil {
+			return err
+		}
+	}
+	read := []query.Exp{st.Condition(), st.Limit, st.Offset}
+	for _, o := range st.Order {
+		read = append(read, o.Exp)
+	}
+	read = append(read, st.Set...)
+	for _, e := range read {
+		if err := a.exp(e, alias, query.AllowRead); err != nil {
+			return err
+		}
+	}
+
+	if len(st.Insert) > 0 && st.Insert[0].Table != nil {
+		// Returned columns may refer to the inserted row.
+		alias[st.Insert[0].Table.Alias] = st.Insert[0].Table.Name
+	}
+	for i, col := range st.Return {
+		switch {
+		case i < len(st.Select):
+			if err := a.exp(st.Select[i], alias, query.AllowReturn); err != nil {
+				return err
+			}
+		case col.Table != nil:
+			if err := a.needColumn(col.Table.Name, col.StoreName, query.AllowReturn); err != nil {
+				return err
+			}
+		}
+	}
+
+	change := []struct {
+		col  []*query.ColumnSchema
+		need query.Authn
+	}{
+		{st.Insert, query.AllowInsert},
+		{st.Update, query.AllowUpdate},
+	}
+	for _, c := range change {
+		for _, col := range c.col {
+			if col.Table == nil {
+				continue
+			}
+			if err := a.needTable(col.Table.Name, c.need); err != nil {
+				return err
+			}
+			if err := a.needColumn(col.Table.Name, col.StoreName, c.need); err != nil {
+				return err
+			}
+		}
+	}
+	for _, rt := range st.Delete {
+		if err := a.needTable(rt.Name, query.AllowDelete); err != nil {
+			return err
+		}
+	}
+	for i := range st.Interleave {
+		if err := a.check(&st.Interleave[i].Stmt, alias); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exp checks the access to each column of the expression. Columns of
+// sub-queries only need read access.
+func (a *Authz) exp(e query.Exp, alias map[string]string, need query.Authn) error {
+	if e.Op == query.ExpColumn {
+		table, ok := alias[e.Alias]
+		if !ok {
+			return nil
+		}
+		return a.needColumn(table, e.Name, need)
+	}
+	for _, arg := range e.Arg {
+		if err := a.exp(arg, alias, need); err != nil {
+			return err
+		}
+	}
+	if e.Sub == nil {
+		return nil
+	}
+	inner := make(map[string]string, len(alias)+len(e.Sub.From))
+	for k, v := range alias {
+		inner[k] = v
+	}
+	for _, rt := range e.Sub.From {
+		inner[rt.Alias] = rt.Name
+		if err := a.needTable(rt.Name, query.AllowRead); err != nil {
+			return err
+		}
+	}
+	if err := a.exp(e.Sub.Where, inner, query.AllowRead); err != nil {
+		return err
+	}
+	for _, s := range e.Sub.Select {
+		if err := a.exp(s, inner, query.AllowRead); err != nil {
+			return err
+		}
+	}
+	return nil
+}
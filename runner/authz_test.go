@@ -0,0 +1,209 @@
+// Copyright 2018 solidcoredata authors.
+
+package runner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/solidcoredata/dbc/query"
+)
+
+func authzStore() *query.Store {
+	return &query.Store{
+		Table: []*query.StoreTable{
+			{
+				Name: "book",
+				Column: []*query.StoreColumn{
+					{Name: "id", Type: query.TypeInteger, Key: true},
+					{Name: "name", Type: query.TypeString},
+					{Name: "price", Type: query.TypeDecimal},
+				},
+				Port: map[string]query.StoreTablePort{
+					"web": {
+						RoleAuthn: map[string]query.Authn{
+							"reader": query.AllowReturn,
+							"editor": query.AllowReturn | query.AllowUpdate,
+						},
+						Column: []query.StoreColumnPort{
+							{},
+							{},
+							{RoleAuthn: map[string]query.Authn{
+								"reader": query.AllowRead,
+								"editor": query.AllowFull,
+							}},
+						},
+					},
+				},
+			},
+			{
+				Name:   "open",
+				Column: []*query.StoreColumn{{Name: "id", Type: query.TypeInteger}},
+			},
+		},
+	}
+}
+
+func TestAuthzGrant(t *testing.T) {
+	s := authzStore()
+	list := []struct {
+		port   string
+		role   []string
+		table  string
+		column string
+		want   query.Authn
+	}{
+		{"web", []string{"reader"}, "book", "", query.AllowReturn},
+		{"web", []string{"reader", "editor"}, "book", "", query.AllowReturn | query.AllowUpdate},
+		{"web", []string{"other"}, "book", "", query.AllowNone},
+		{"api", []string{"editor"}, "book", "", query.AllowNone},
+		{"web", []string{"reader"}, "book", "name", query.AllowReturn},
+		{"web", []string{"reader"}, "book", "price", query.AllowRead},
+		{"web", []string{"editor"}, "book", "price", query.AllowReturn | query.AllowUpdate},
+		{"web", []string{"editor"}, "book", "missing", query.AllowNone},
+		{"", nil, "open", "id", query.AllowFull},
+		{"", nil, "missing", "", query.AllowNone},
+	}
+	for _, item := range list {
+		a := NewAuthz(s, Option{Port: item.port, Role: item.role})
+		var got query.Authn
+		if len(item.column) == 0 {
+			got = a.Table(item.table)
+		} else {
+			got = a.Column(item.table, item.column)
+		}
+		// Grants hold the bits they imply.
+		if got != item.want.Closure() {
+			t.Errorf("%s %v %s.%s: got %v, want %v", item.port, item.role, item.table, item.column, got, item.want)
+		}
+	}
+}
+
+func TestAuthzCheck(t *testing.T) {
+	s := authzStore()
+	b := &query.ResultTableSchema{Name: "book", Alias: "b", IsArity: true}
+	col := func(name string) *query.ColumnSchema {
+		return &query.ColumnSchema{Table: b, StoreName: name, QueryName: name}
+	}
+	from := []*query.ResultTableSchema{b}
+	list := []struct {
+		name string
+		role string
+		st   query.Stmt
+		deny *AuthzError // Nil if allowed.
+	}{
+		{
+			name: "return",
+			role: "reader",
+			st:   query.Stmt{From: from, Return: []*query.ColumnSchema{col("id"), col("name")}},
+		},
+		{
+			name: "read price",
+			role: "reader",
+			st: query.Stmt{
+				From:   from,
+				Where:  query.Binary(query.ExpGreater, query.Column("b", "price"), query.Literal(int64(10))),
+				Return: []*query.ColumnSchema{col("name")},
+			},
+		},
+		{
+			name: "return price",
+			role: "reader",
+			st:   query.Stmt{From: from, Return: []*query.ColumnSchema{col("price")}},
+			deny: &AuthzError{Table: "book", Column: "price", Need: query.AllowReturn},
+		},
+		{
+			name: "computed price",
+			role: "reader",
+			st: query.Stmt{
+				From:   from,
+				Return: []*query.ColumnSchema{{QueryName: "double"}},
+				Select: []query.Exp{query.Binary(query.ExpMul, query.Column("b", "price"), query.Literal(int64(2)))},
+			},
+			deny: &AuthzError{Table: "book", Column: "price", Need: query.AllowReturn},
+		},
+		{
+			name: "update",
+			role: "reader",
+			st:   query.Stmt{From: from, Update: []*query.ColumnSchema{col("name")}, Set: []query.Exp{query.Literal("x")}},
+			deny: &AuthzError{Table: "book", Need: query.AllowUpdate},
+		},
+		{
+			name: "editor update",
+			role: "editor",
+			st:   query.Stmt{From: from, Update: []*query.ColumnSchema{col("price")}, Set: []query.Exp{query.Literal(int64(1))}},
+		},
+		{
+			name: "insert",
+			role: "editor",
+			st:   query.Stmt{Insert: []*query.ColumnSchema{col("name")}, Set: []query.Exp{query.Literal("x")}},
+			deny: &AuthzError{Table: "book", Need: query.AllowInsert},
+		},
+		{
+			name: "delete",
+			role: "editor",
+			st:   query.Stmt{From: from, Delete: from},
+			deny: &AuthzError{Table: "book", Need: query.AllowDelete},
+		},
+	}
+	for _, item := range list {
+		t.Run(item.name, func(t *testing.T) {
+			err := NewAuthz(s, Option{Port: "web", Role: []string{item.role}}).Check(&item.st)
+			if item.deny == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var ae *AuthzError
+			if !errors.As(err, &ae) {
+				t.Fatalf("got error %v, want authorization error", err)
+			}
+			if ae.Table != item.deny.Table || ae.Column != item.deny.Column || ae.Need != item.deny.Need {
+				t.Fatalf("got %v, want %s.%s %v", ae, item.deny.Table, item.deny.Column, item.deny.Need)
+			}
+		})
+	}
+}
+
+func TestRebind(t *testing.T) {
+	inner := &query.ResultTableSchema{Name: "book", Alias: "t"}
+	e := query.And(
+		query.Equal(query.Column("t", "id"), query.Parameter("id")),
+		query.Exists(&query.SubQuery{
+			From:  []*query.ResultTableSchema{inner},
+			Where: query.Equal(query.Column("t", "id"), query.Literal(int64(1))),
+		}),
+	)
+	got := rebind(e, "t", "b")
+	if a := got.Arg[0].Arg[0].Alias; a != "b" {
+		t.Errorf("got column alias %q, want b", a)
+	}
+	if a := got.Arg[1].Sub.Where.Arg[0].Alias; a != "t" {
+		t.Errorf("got shadowed column alias %q, want t", a)
+	}
+	if a := e.Arg[0].Arg[0].Alias; a != "t" {
+		t.Errorf("rebind changed the original expression")
+	}
+
+	// The statement alias is the alias of a rule sub-query table.
+	ao := &query.ResultTableSchema{Name: "account_org", Alias: "ao"}
+	rule := query.Exists(&query.SubQuery{
+		From: []*query.ResultTableSchema{ao},
+		Where: query.And(
+			query.Equal(query.Column("ao", "account"), query.Parameter("Account")),
+			query.Equal(query.Column("ao", "org"), query.Column("b", "org")),
+		),
+	})
+	got = rebind(rule, "b", "ao")
+	sub := got.Sub
+	if a := sub.From[0].Alias; a == "ao" {
+		t.Fatalf("sub-query alias not renamed")
+	}
+	if ao.Alias != "ao" {
+		t.Fatal("rebind changed the original table")
+	}
+	if g, w := sub.Where.String(), "and ("+sub.From[0].Alias+".account = Account, "+sub.From[0].Alias+".org = ao.org)"; g != w {
+		t.Fatalf("got %s, want %s", g, w)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2018 solidcoredata authors.
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/solidcoredata/dbc/query"
+)
+
+// CursorParam returns the name of the parameter that holds the cursor value
+// of an order expression of a keyset page, such as "cursor.0".
+func CursorParam(order int) string {
+	return "cursor." + strconv.Itoa(order)
+}
+
+// Keyset returns a copy of the statement that pages by key, along with the
+// parameters that hold the cursor values. The order of the statement is
+// followed by each key column of the arity table that is not yet ordered,
+// so the order is unique. If the option has a Cursor, only rows that
+// order after the cursor row are kept. The page size is the Page Limit, as
+// set by Search, and the statement offset is dropped.
+//
+// Keyset pages stay fast on large tables, as the database seeks to the
+// cursor rather than skipping the offset rows, and rows changed between
+// pages are neither skipped nor repeated. Each order expression must be a
+// column of a table of the statement that may not be null, that the caller
+// may return, and that has no read deny rule, as the cursor holds its
+// value. The cursor is built by Cursor from the values of the last row of
+// a page, and sealed with the key of the runner to the query and caller.
+func (a *Authz) Keyset(st *query.Stmt, opt Option, key []byte) (*query.Stmt, []Param, error) {
+	if !opt.Keyset {
+		if len(opt.Cursor) > 0 {
+			return nil, nil, errors.New("runner: cursor without keyset paging")
+		}
+		return st, nil, nil
+	}
+	if opt.Page.Offset != 0 {
+		return nil, nil, errors.New("runner: keyset page may not have an offset")
+	}
+	var arity *query.ResultTableSchema
+	for _, rt := range st.From {
+		if arity == nil || rt.IsArity {
+			arity = rt
+		}
+		if rt.IsArity {
+			break
+		}
+	}
+	if arity == nil || len(st.Return) == 0 {
+		return nil, nil, errors.New("runner: keyset of a statement that returns no table rows")
+	}
+	t := a.table(arity.Name)
+	if t == nil {
+		return nil, nil, fmt.Errorf("runner: keyset table %q not found", arity.Name)
+	}
+
+	out := *st
+	out.Anchor = nil
+	out.ExpList = nil
+	out.Offset = query.Exp{}
+	out.Order = append([]query.Order(nil), st.Order...)
+	keys := 0
+	for _, col := range t.Column {
+		if !col.Key {
+			continue
+		}
+		keys++
+		e := query.Column(arity.Alias, col.Name)
+		ordered := false
+		for _, o := range out.Order {
+			if o.Exp.Op == query.ExpColumn && o.Exp.Alias == e.Alias && o.Exp.Name == e.Name {
+				ordered = true
+				break
+			}
+		}
+		if !ordered {
+			out.Order = append(out.Order, query.Order{Exp: e})
+		}
+	}
+	if keys == 0 {
+		return nil, nil, fmt.Errorf("runner: keyset table %q has no key columns", t.Name)
+	}
+	dt, err := a.keysetType(&out)
+	if err != nil {
+		return nil, nil, err
+	}
+	if where := st.Condition(); !where.IsZero() {
+		out.Where = where
+	}
+	if len(opt.Cursor) == 0 {
+		return &out, nil, nil
+	}
+
+	value, err := query.DecodeCursor(key, a.cursorScope(), opt.Cursor, out.Order, dt)
+	if err != nil {
+		return nil, nil, err
+	}
+	param := make([]Param, len(value))
+	for i, v := range value {
+		param[i] = Param{Name: CursorParam(i), Value: v}
+	}
+	after := query.After(out.Order, func(i int) query.Exp { return query.Parameter(CursorParam(i)) })
+	if out.Where.IsZero() {
+		out.Where = after
+	} else {
+		out.Where = query.And(out.Where, after)
+	}
+	return &out, param, nil
+}
+
+// Cursor returns the cursor of a keyset page from the value of each order
+// expression of the last row of the page. The statement is the one
+// returned by Keyset, and the key the one passed to it.
+func (a *Authz) Cursor(st *query.Stmt, value []interface{}, key []byte) (string, error) {
+	dt, err := a.keysetType(st)
+	if err != nil {
+		return "", err
+	}
+	return query.EncodeCursor(key, a.cursorScope(), st.Order, dt, value)
+}
+
+// cursorScope returns the query and caller a cursor is sealed to.
+func (a *Authz) cursorScope() string {
+	role := append([]string(nil), a.role...)
+	sort.Strings(role)
+	return fmt.Sprintf("%q %q %q", a.query, a.port, role)
+}
+
+// keysetType returns the data type of each order expression of the
+// statement, which must be a column that may not be null, that the caller
+// may return, and that has no read deny rule.
+func (a *Authz) keysetType(st *query.Stmt) ([]query.DataType, error) {
+	dt := make([]query.DataType, len(st.Order))
+	for i, o := range st.Order {
+		var t *query.StoreTable
+		var col *query.StoreColumn
+		index := -1
+		if o.Exp.Op == query.ExpColumn {
+			for _, rt := range st.From {
+				if rt.Alias != o.Exp.Alias {
+					continue
+				}
+				if t = a.table(rt.Name); t != nil {
+					for n, c := range t.Column {
+						if c.Name == o.Exp.Name {
+							col, index = c, n
+						}
+					}
+				}
+				break
+			}
+		}
+		if col == nil {
+			return nil, fmt.Errorf("runner: keyset order %s is not a table column", o.Exp)
+		}
+		if col.Nullable {
+			return nil, fmt.Errorf("runner: keyset order column %s may be null", o.Exp)
+		}
+		if err := a.needColumn(t.Name, col.Name, query.AllowReturn); err != nil {
+			return nil, err
+		}
+		if p := t.Port[a.port]; index < len(p.Column) && !p.Column[index].DenyRead.Q.IsZero() {
+			return nil, fmt.Errorf("runner: keyset order column %s has a read deny rule", o.Exp)
+		}
+		dt[i] = col.Type
+	}
+	return dt, nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 solidcoredata authors.
+
+package runner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/solidcoredata/dbc/query"
+)
+
+func TestKeyset(t *testing.T) {
+	s := authzStore()
+	book := &query.ResultTableSchema{Name: "book", Alias: "b", IsArity: true}
+	st := &query.Stmt{
+		From:   []*query.ResultTableSchema{book},
+		Where:  query.Binary(query.ExpGreater, query.Column("b", "id"), query.Literal(int64(0))),
+		Return: []*query.ColumnSchema{{Table: book, StoreName: "name", QueryName: "name"}},
+		Order:  []query.Order{{Exp: query.Column("b", "name"), Desc: true}},
+		Offset: query.Literal(int64(5)),
+	}
+	key := []byte("0123456789abcdef")
+	a := NewAuthz(s, Option{QueryName: "books", Port: "web", Role: []string{"reader"}})
+	if got, param, err := a.Keyset(st, Option{}, key); got != st || param != nil || err != nil {
+		t.Fatalf("no keyset got %v %v %v, want the statement", got, param, err)
+	}
+
+	first, param, err := a.Keyset(st, Option{Keyset: true}, key)
+	if err != nil || param != nil {
+		t.Fatalf("got %v %v, want no params", param, err)
+	}
+	if len(first.Order) != 2 || first.Order[1].Exp.Name != "id" || first.Order[1].Desc || !first.Offset.IsZero() {
+		t.Fatalf("got order %v offset %v, want name desc then id, no offset", first.Order, first.Offset)
+	}
+	cursor, err := a.Cursor(first, []interface{}{"Emma", int64(3)}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, param, err := a.Keyset(st, Option{Keyset: true, Cursor: cursor}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := next.Condition().String(), "and (b.id > 0, or (b.name < cursor.0, and (b.name = cursor.0, b.id > cursor.1)))"; g != w {
+		t.Errorf("condition got %q, want %q", g, w)
+	}
+	if w := []Param{{"cursor.0", "Emma"}, {"cursor.1", int64(3)}}; !reflect.DeepEqual(param, w) {
+		t.Errorf("params got %v, want %v", param, w)
+	}
+
+	list := []struct {
+		st  *query.Stmt
+		opt Option
+		err string
+	}{
+		{st, Option{Cursor: cursor}, "cursor without keyset"},
+		{st, Option{Keyset: true, Page: Page{Limit: 2, Offset: 2}}, "may not have an offset"},
+		{st, Option{Keyset: true, Cursor: "AAAA"}, "invalid cursor"},
+		{&query.Stmt{From: []*query.ResultTableSchema{{Name: "open", Alias: "o"}}, Return: st.Return}, Option{Keyset: true}, "has no key columns"},
+		{&query.Stmt{From: st.From, Return: st.Return, Order: []query.Order{{Exp: query.Literal(int64(1))}}}, Option{Keyset: true}, "is not a table column"},
+		{&query.Stmt{From: st.From, Return: st.Return, Order: []query.Order{{Exp: query.Column("b", "price")}}}, Option{Keyset: true}, `not allowed AllowReturn on column "price"`},
+	}
+	for _, item := range list {
+		if _, _, err := a.Keyset(item.st, item.opt, key); err == nil || !strings.Contains(err.Error(), item.err) {
+			t.Errorf("got %v, want %q", err, item.err)
+		}
+	}
+
+	// The cursor is sealed to the query and caller by the key.
+	other := []struct {
+		a   *Authz
+		key []byte
+	}{
+		{NewAuthz(s, Option{QueryName: "other", Port: "web", Role: []string{"reader"}}), key},
+		{NewAuthz(s, Option{QueryName: "books", Port: "web", Role: []string{"editor"}}), key},
+		{a, []byte("fedcba9876543210")},
+	}
+	for i, item := range other {
+		if _, _, err := item.a.Keyset(st, Option{Keyset: true, Cursor: cursor}, item.key); err == nil || !strings.Contains(err.Error(), "invalid cursor") {
+			t.Errorf("other %d: got %v, want invalid cursor", i, err)
+		}
+	}
+
+	// A column with a read deny rule may not order a page.
+	deny := authzStore()
+	deny.Table[0].Port["web"].Column[1] = query.StoreColumnPort{DenyRead: query.Param{Q: query.Equal(query.Column("book", "id"), query.Literal(int64(1)))}}
+	_, _, err = NewAuthz(deny, Option{Port: "web", Role: []string{"reader"}}).Keyset(st, Option{Keyset: true}, key)
+	if err == nil || !strings.Contains(err.Error(), "keyset order column b.name has a read deny rule") {
+		t.Errorf("got %v, want read deny error", err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 solidcoredata authors.
+
+package memrunner
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/solidcoredata/dbc/query"
+	"github.com/solidcoredata/dbc/runner"
+)
+
+// maxVersion is the number of result versions the store keeps. A row delta
+// from an older version returns runner.ErrVersion.
+const maxVersion = 100
+
+// resultVersion is the rows of each result of a query run.
+type resultVersion struct {
+	token  string
+	query  string
+	caller string // Port, roles, and parameters of the run, see callerKey.
+	row    [][]query.StreamItemRow
+}
+
+// callerKey returns the port, roles, and parameters of a run as text. A
+// prior version is only used by a run with the same key, as the rows the
+// run returned may depend on each of them.
+func callerKey(opt runner.Option, param map[string]interface{}) string {
+	role := append([]string(nil), opt.Role...)
+	sort.Strings(role)
+	name := make([]string, 0, len(param))
+	for n := range param {
+		name = append(name, n)
+	}
+	sort.Strings(name)
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%q %q", opt.Port, role)
+	for _, n := range name {
+		fmt.Fprintf(b, " %q=%T:%v", n, param[n], param[n])
+	}
+	return b.String()
+}
+
+// newToken returns a random version token, so a caller may not guess the
+// token of another run.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// cursorKey returns the random key that seals the cursors of keyset pages,
+// so a caller may neither read nor forge a cursor.
+func (ms *MemoryStore) cursorKey() ([]byte, error) {
+	ms.keyOnce.Do(func() {
+		ms.key = make([]byte, 32)
+		_, ms.keyErr = rand.Read(ms.key)
+	})
+	return ms.key, ms.keyErr
+}
+
+// delta returns the result items of a row delta run of the named query, and
+// keeps the rows under a new version token, sent at the end of the items.
+// Without a prior token each row of a result is sent as inserted. With a
+// prior token each result holds the row changes since that run, which must
+// be of the same query and caller key. The store lock must be held.
+func (ms *MemoryStore) delta(name, caller, prior string, schema query.ResultSetSchema, list []*result) ([]query.StreamItem, error) {
+	rv := resultVersion{query: name, caller: caller, row: make([][]query.StreamItemRow, len(list))}
+	for i, res := range list {
+		rows, err := encodeRows(schema.Set[i], res)
+		if err != nil {
+			return nil, err
+		}
+		rv.row[i] = rows
+	}
+
+	var old *resultVersion
+	if len(prior) > 0 {
+		for i := range ms.version {
+			if ms.version[i].token == prior {
+				old = &ms.version[i]
+				break
+			}
+		}
+		if old == nil || old.query != name || old.caller != caller || len(old.row) != len(rv.row) {
+			return nil, fmt.Errorf("version %q: %w", prior, runner.ErrVersion)
+		}
+	}
+
+	var item []query.StreamItem
+	for i, rows := range rv.row {
+		var before []query.StreamItemRow
+		if old != nil {
+			before = old.row[i]
+		}
+		change, err := query.RowDelta(schema.Set[i], before, rows)
+		if err != nil {
+			return nil, err
+		}
+		item = append(item, query.StreamItemResult{SchemaIndex: int64(i)})
+		item = append(item, change...)
+		item = append(item, query.StreamItemEndOfResult{})
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	rv.token = token
+	ms.version = append(ms.version, rv)
+	if n := len(ms.version) - maxVersion; n > 0 {
+		ms.version = append(ms.version[:0], ms.version[n:]...)
+	}
+	return append(item, query.StreamItemVersion{Token: rv.token}), nil
+}
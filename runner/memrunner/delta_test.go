@@ -0,0 +1,164 @@
+// Copyright 2018 solidcoredata authors.
+
+package memrunner
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/solidcoredata/dbc/query"
+	"github.com/solidcoredata/dbc/runner"
+)
+
+func TestRunDelta(t *testing.T) {
+	ms := execStore(t)
+	r := NewMemoryStoreRunner(ms)
+	opt := runner.Option{QueryName: "book_pages", Delta: true}
+	stream, err := r.Run(nil, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := query.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf.Version) == 0 || len(buf.Set[0].Row) != 4 {
+		t.Fatalf("got version %q with %d rows, want a version with 4 rows", buf.Version, len(buf.Set[0].Row))
+	}
+
+	// Change the books: one inserted, one updated, one that now matches,
+	// and one that no longer matches.
+	book := ms.lookup("book")
+	for _, row := range [][]interface{}{{7, "Sanditon", 2, 300}} {
+		if err := book.insert(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, row := range map[int][]interface{}{
+		0: {1, "The Old Man and the Sea!", 1, 127},
+		3: {4, "Lady Susan", 2, 180},
+		4: {5, "Persuasion", 2, 50},
+	} {
+		if err := book.update(i, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opt.Version = buf.Version
+	stream, err = r.Run(nil, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state []query.StreamState
+	var items []query.StreamItem
+	for {
+		item, err := stream.Next()
+		if err != nil {
+			break
+		}
+		items = append(items, item)
+		switch item.(type) {
+		case query.StreamItemRowInsert, query.StreamItemRowUpdate, query.StreamItemRowDelete:
+			state = append(state, item.StreamState())
+		}
+	}
+	want := []query.StreamState{query.StreamRowDelete, query.StreamRowUpdate, query.StreamRowInsert, query.StreamRowInsert}
+	if !reflect.DeepEqual(state, want) {
+		t.Fatalf("got row changes %v, want %v", state, want)
+	}
+	list := &StreamingResultSet{item: items}
+	prior := buf.Version
+	if err := query.ApplyDelta(buf, list); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Version == prior {
+		t.Fatal("version not changed by the delta")
+	}
+
+	// The buffer with the delta applied holds the current rows.
+	stream, err = r.Run(nil, runner.Option{QueryName: "book_pages"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := query.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[int64]string)
+	for _, row := range buf.Set[0].Row {
+		got[row.Column[0].Value.(int64)] = row.Column[1].Value.(string)
+	}
+	wantRow := make(map[int64]string)
+	for _, row := range full.Set[0].Row {
+		wantRow[row.Column[0].Value.(int64)] = row.Column[1].Value.(string)
+	}
+	if !reflect.DeepEqual(got, wantRow) {
+		t.Fatalf("got rows %v, want %v", got, wantRow)
+	}
+}
+
+func TestRunDeltaError(t *testing.T) {
+	ms := execStore(t)
+	r := NewMemoryStoreRunner(ms)
+	_, err := r.Run(nil, runner.Option{QueryName: "book_pages", Delta: true, Version: "42"})
+	if !errors.Is(err, runner.ErrVersion) {
+		t.Errorf("got %v, want ErrVersion", err)
+	}
+	_, err = r.Run(nil, runner.Option{QueryName: "all_books", Delta: true})
+	if err == nil || !strings.Contains(err.Error(), "no key columns") {
+		t.Errorf("got %v, want no key columns error", err)
+	}
+	_, err = r.Run(nil, runner.Option{QueryName: "author_books", Delta: true})
+	if err == nil || !strings.Contains(err.Error(), "interleaved") {
+		t.Errorf("got %v, want interleaved error", err)
+	}
+
+	// A version of another query is unknown.
+	stream, err := r.Run(nil, runner.Option{QueryName: "long_books", Param: []runner.Param{{Name: "min_pages", Value: 100}}, Delta: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := query.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Run(nil, runner.Option{QueryName: "book_pages", Delta: true, Version: buf.Version})
+	if !errors.Is(err, runner.ErrVersion) {
+		t.Errorf("got %v, want ErrVersion", err)
+	}
+
+	// A version of another caller is unknown, as its rows may be hidden
+	// from this caller.
+	caller := runner.Option{QueryName: "long_books", Port: "web", Role: []string{"a", "b"}, Param: []runner.Param{{Name: "min_pages", Value: 100}}, Delta: true}
+	stream, err = r.Run(nil, caller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err = query.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf.Version) != 32 {
+		t.Errorf("got version %q, want a random token", buf.Version)
+	}
+	for _, change := range []func(o *runner.Option){
+		func(o *runner.Option) { o.Param = []runner.Param{{Name: "min_pages", Value: 200}} },
+		func(o *runner.Option) { o.Role = []string{"a"} },
+		func(o *runner.Option) { o.Port = "admin" },
+	} {
+		other := caller
+		other.Version = buf.Version
+		change(&other)
+		if _, err := r.Run(nil, other); !errors.Is(err, runner.ErrVersion) {
+			t.Errorf("%v %q %v: got %v, want ErrVersion", other.Port, other.Role, other.Param, err)
+		}
+	}
+	same := caller
+	same.Version = buf.Version
+	same.Role = []string{"b", "a"}
+	if _, err := r.Run(nil, same); err != nil {
+		t.Errorf("same caller: %v", err)
+	}
+}
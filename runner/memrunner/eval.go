@@ -0,0 +1,613 @@
+// Copyright 2018 solidcoredata authors.
+
+package memrunner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/solidcoredata/dbc/query"
+)
+
+// binding is a table row bound to an alias.
+type binding struct {
+	alias string
+	table *memTable
+	index int // Index of the row in the table.
+	row   []interface{}
+}
+
+// scope holds the rows bound while evaluating a statement. Sub-queries
+// may see the rows of their parent.
+type scope struct {
+	parent *scope
+	bind   []binding
+}
+
+func (s *scope) lookup(alias string) *binding {
+	for ; s != nil; s = s.parent {
+		for i := range s.bind {
+			if s.bind[i].alias == alias {
+				return &s.bind[i]
+			}
+		}
+	}
+	return nil
+}
+
+// copy returns a copy of the scope that is not changed as rows are matched.
+func (s *scope) copy() *scope {
+	return &scope{parent: s.parent, bind: append([]binding(nil), s.bind...)}
+}
+
+// normalize returns a caller supplied value as the Go type used for its
+// data type, such as int64 for int.
+func normalize(v interface{}) interface{} {
+	if i, ok := toInt64(v); ok {
+		return i
+	}
+	if f, ok := v.(float32); ok {
+		return float64(f)
+	}
+	return v
+}
+
+// valueType returns the data type of a value, or TypeUnknown.
+func valueType(v interface{}) query.DataType {
+	switch v.(type) {
+	case string:
+		return query.TypeString
+	case []byte:
+		return query.TypeBinary
+	case bool:
+		return query.TypeBoolean
+	case int64:
+		return query.TypeInteger
+	case float64:
+		return query.TypeFloat
+	case *big.Rat:
+		return query.TypeDecimal
+	case time.Time:
+		return query.TypeTimestampZ
+	case [16]byte:
+		return query.TypeUUID
+	case json.RawMessage:
+		return query.TypeJSON
+	case []interface{}:
+		return query.TypeArray
+	}
+	return query.TypeUnknown
+}
+
+// match calls fn for each combination of rows of the from tables for which
+// the condition is true. Each condition of a top level "and" is tested as
+// soon as the tables it references are bound. Matching stops when fn
+// returns false.
+func (x *exec) match(from []*query.ResultTableSchema, where query.Exp, parent *scope, fn func(sc *scope) (bool, error)) error {
+	tables := make([]*memTable, len(from))
+	level := make(map[string]int, len(from))
+	for i, rt := range from {
+		tables[i] = x.tx.table(rt.Name)
+		if tables[i] == nil {
+			return fmt.Errorf("unknown table %q", rt.Name)
+		}
+		level[rt.Alias] = i + 1
+	}
+	var list []query.Exp
+	switch {
+	case where.IsZero():
+	case where.Op == query.ExpAnd:
+		list = where.Arg
+	default:
+		list = []query.Exp{where}
+	}
+	cond := make([][]query.Exp, len(from)+1)
+	for _, c := range list {
+		at := 0
+		for _, col := range c.Columns() {
+			if l := level[col.Alias]; l > at {
+				at = l
+			}
+		}
+		cond[at] = append(cond[at], c)
+	}
+
+	sc := &scope{parent: parent, bind: make([]binding, 0, len(from))}
+	var walk func(at int) (bool, error)
+	walk = func(at int) (bool, error) {
+		for _, c := range cond[at] {
+			ok, err := x.test(c, sc)
+			if err != nil || !ok {
+				return true, err
+			}
+		}
+		if at == len(from) {
+			return fn(sc)
+		}
+		mt := tables[at]
+		for i, row := range mt.row {
+			sc.bind = append(sc.bind[:at], binding{alias: from[at].Alias, table: mt, index: i, row: row})
+			more, err := walk(at + 1)
+			if err != nil || !more {
+				return more, err
+			}
+		}
+		return true, nil
+	}
+	_, err := walk(0)
+	return err
+}
+
+// test reports if the condition is true. An unset condition is true.
+func (x *exec) test(e query.Exp, sc *scope) (bool, error) {
+	if e.IsZero() {
+		return true, nil
+	}
+	v, err := x.eval(e, sc)
+	if err != nil {
+		return false, err
+	}
+	switch v := v.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	}
+	return false, fmt.Errorf("condition %v is %T, not a boolean", e, v)
+}
+
+// eval returns the value of the expression. Conditions use three valued
+// logic, where NULL is unknown.
+func (x *exec) eval(e query.Exp, sc *scope) (interface{}, error) {
+	switch e.Op {
+	default:
+		return nil, fmt.Errorf("unknown expression %v", e.Op)
+	case query.ExpColumn:
+		b := sc.lookup(e.Alias)
+		if b == nil {
+			return nil, fmt.Errorf("unknown alias %q", e.Alias)
+		}
+		i := b.table.column(e.Name)
+		if i < 0 {
+			return nil, fmt.Errorf("table %q has no column %q", b.table.def.Name, e.Name)
+		}
+		return b.row[i], nil
+	case query.ExpLiteral:
+		return normalize(e.Value), nil
+	case query.ExpParam:
+		v, ok := x.param[e.Name]
+		if !ok {
+			return nil, fmt.Errorf("missing parameter %q", e.Name)
+		}
+		return v, nil
+	case query.ExpAnchor:
+		return nil, fmt.Errorf("anchor %q not replaced", e.Name)
+	case query.ExpEqual, query.ExpNotEqual, query.ExpLess, query.ExpLessEqual, query.ExpGreater, query.ExpGreaterEqual:
+		a, b, err := x.eval2(e, sc)
+		if err != nil || a == nil || b == nil {
+			return nil, err
+		}
+		c, err := compare(a, b)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case query.ExpEqual:
+			return c == 0, nil
+		case query.ExpNotEqual:
+			return c != 0, nil
+		case query.ExpLess:
+			return c < 0, nil
+		case query.ExpLessEqual:
+			return c <= 0, nil
+		case query.ExpGreater:
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	case query.ExpLike:
+		a, b, err := x.eval2(e, sc)
+		if err != nil || a == nil || b == nil {
+			return nil, err
+		}
+		s, ok1 := a.(string)
+		p, ok2 := b.(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("like requires text, got %T and %T", a, b)
+		}
+		return like([]rune(s), []rune(p)), nil
+	case query.ExpAdd, query.ExpSub, query.ExpMul, query.ExpDiv, query.ExpMod:
+		a, b, err := x.eval2(e, sc)
+		if err != nil || a == nil || b == nil {
+			return nil, err
+		}
+		return arith(e.Op, a, b)
+	case query.ExpAnd, query.ExpOr:
+		// And is false if any item is false, Or is true if any item is true.
+		stop := e.Op == query.ExpOr
+		var result interface{} = !stop
+		for _, a := range e.Arg {
+			v, err := x.eval(a, sc)
+			if err != nil {
+				return nil, err
+			}
+			switch v := v.(type) {
+			default:
+				return nil, fmt.Errorf("condition %v is %T, not a boolean", a, v)
+			case nil:
+				result = nil
+			case bool:
+				if v == stop {
+					return stop, nil
+				}
+			}
+		}
+		return result, nil
+	case query.ExpNot:
+		if len(e.Arg) != 1 {
+			return nil, fmt.Errorf("not requires one argument")
+		}
+		v, err := x.eval(e.Arg[0], sc)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("condition %v is %T, not a boolean", e.Arg[0], v)
+		}
+		return !b, nil
+	case query.ExpExists:
+		found := false
+		err := x.match(e.Sub.From, e.Sub.Where, sc, func(*scope) (bool, error) {
+			found = true
+			return false, nil
+		})
+		return found, err
+	case query.ExpIn:
+		return x.in(e, sc)
+	case query.ExpCall:
+		return x.call(e, sc)
+	}
+}
+
+func (x *exec) eval2(e query.Exp, sc *scope) (interface{}, interface{}, error) {
+	if len(e.Arg) != 2 {
+		return nil, nil, fmt.Errorf("%v requires two arguments", e.Op)
+	}
+	a, err := x.eval(e.Arg[0], sc)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := x.eval(e.Arg[1], sc)
+	return a, b, err
+}
+
+// in reports if the first argument is equal to any value of the list or
+// sub-query. If there is no match but the list has a NULL, the result is NULL.
+func (x *exec) in(e query.Exp, sc *scope) (interface{}, error) {
+	if len(e.Arg) == 0 {
+		return nil, fmt.Errorf("in requires a value")
+	}
+	v, err := x.eval(e.Arg[0], sc)
+	if err != nil {
+		return nil, err
+	}
+	var list []interface{}
+	if e.Sub != nil {
+		if len(e.Sub.Select) != 1 {
+			return nil, fmt.Errorf("in sub-query must select one column")
+		}
+		err = x.match(e.Sub.From, e.Sub.Where, sc, func(inner *scope) (bool, error) {
+			item, err := x.eval(e.Sub.Select[0], inner)
+			list = append(list, item)
+			return true, err
+		})
+	} else {
+		for _, a := range e.Arg[1:] {
+			var item interface{}
+			item, err = x.eval(a, sc)
+			if err != nil {
+				break
+			}
+			list = append(list, item)
+		}
+	}
+	if err != nil || v == nil {
+		return nil, err
+	}
+	var result interface{} = false
+	for _, item := range list {
+		if item == nil {
+			result = nil
+			continue
+		}
+		c, err := compare(v, item)
+		if err != nil {
+			return nil, err
+		}
+		if c == 0 {
+			return true, nil
+		}
+	}
+	return result, nil
+}
+
+func (x *exec) call(e query.Exp, sc *scope) (interface{}, error) {
+	arg := make([]interface{}, len(e.Arg))
+	for i, a := range e.Arg {
+		v, err := x.eval(a, sc)
+		if err != nil {
+			return nil, err
+		}
+		arg[i] = v
+	}
+	switch e.Name {
+	default:
+		return nil, fmt.Errorf("unknown function %q", e.Name)
+	case "coalesce":
+		for _, v := range arg {
+			if v != nil {
+				return v, nil
+			}
+		}
+		return nil, nil
+	case "lower", "upper":
+		if len(arg) != 1 {
+			return nil, fmt.Errorf("%s requires one argument", e.Name)
+		}
+		if arg[0] == nil {
+			return nil, nil
+		}
+		s, ok := arg[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("%s requires text, got %T", e.Name, arg[0])
+		}
+		if e.Name == "lower" {
+			return strings.ToLower(s), nil
+		}
+		return strings.ToUpper(s), nil
+	}
+}
+
+// expType returns the data type of the expression, or TypeUnknown.
+// Table holds the table of each alias in scope.
+func (x *exec) expType(e query.Exp, table map[string]*memTable) query.DataType {
+	switch e.Op {
+	case query.ExpColumn:
+		if mt := table[e.Alias]; mt != nil {
+			if i := mt.column(e.Name); i >= 0 {
+				return mt.def.Column[i].Type
+			}
+		}
+	case query.ExpLiteral:
+		return valueType(normalize(e.Value))
+	case query.ExpParam:
+		return valueType(x.param[e.Name])
+	case query.ExpEqual, query.ExpNotEqual, query.ExpLess, query.ExpLessEqual, query.ExpGreater, query.ExpGreaterEqual,
+		query.ExpLike, query.ExpAnd, query.ExpOr, query.ExpNot, query.ExpExists, query.ExpIn:
+		return query.TypeBoolean
+	case query.ExpAdd, query.ExpSub, query.ExpMul, query.ExpDiv, query.ExpMod:
+		if len(e.Arg) != 2 {
+			break
+		}
+		a, b := x.expType(e.Arg[0], table), x.expType(e.Arg[1], table)
+		switch {
+		case a == query.TypeDecimal || a == query.TypeRational:
+			return a
+		case b == query.TypeDecimal || b == query.TypeRational:
+			return b
+		case a == query.TypeFloat || b == query.TypeFloat:
+			return query.TypeFloat
+		}
+		return a
+	case query.ExpCall:
+		switch e.Name {
+		case "lower", "upper":
+			return query.TypeString
+		case "coalesce":
+			for _, a := range e.Arg {
+				if t := x.expType(a, table); t != query.TypeUnknown {
+					return t
+				}
+			}
+		}
+	}
+	return query.TypeUnknown
+}
+
+// compare returns -1, 0, or 1 if a is less than, equal to, or greater than b.
+// Numbers of different types are compared by value.
+func compare(a, b interface{}) (int, error) {
+	switch av := a.(type) {
+	case int64, float64, *big.Rat:
+		return compareNumber(a, b)
+	case string:
+		if bv, ok := b.(string); ok {
+			return strings.Compare(av, bv), nil
+		}
+	case bool:
+		if bv, ok := b.(bool); ok {
+			switch {
+			case av == bv:
+				return 0, nil
+			case bv:
+				return -1, nil
+			}
+			return 1, nil
+		}
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			switch {
+			case av.Before(bv):
+				return -1, nil
+			case av.After(bv):
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case [16]byte:
+		if bv, ok := b.([16]byte); ok {
+			return bytes.Compare(av[:], bv[:]), nil
+		}
+	case []byte:
+		if bv, ok := b.([]byte); ok {
+			return bytes.Compare(av, bv), nil
+		}
+	case json.RawMessage:
+		if bv, ok := b.(json.RawMessage); ok {
+			return bytes.Compare(av, bv), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %T and %T", a, b)
+}
+
+func compareNumber(a, b interface{}) (int, error) {
+	if ai, ok := a.(int64); ok {
+		if bi, ok := b.(int64); ok {
+			switch {
+			case ai < bi:
+				return -1, nil
+			case ai > bi:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	_, ar := a.(*big.Rat)
+	_, br := b.(*big.Rat)
+	if ar || br {
+		x, y := toRat(a), toRat(b)
+		if x == nil || y == nil {
+			return 0, fmt.Errorf("cannot compare %T and %T", a, b)
+		}
+		return x.Cmp(y), nil
+	}
+	x, ok1 := toFloat(a)
+	y, ok2 := toFloat(b)
+	if !ok1 || !ok2 {
+		return 0, fmt.Errorf("cannot compare %T and %T", a, b)
+	}
+	switch {
+	case x < y:
+		return -1, nil
+	case x > y:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func toRat(v interface{}) *big.Rat {
+	switch v := v.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(v)
+	case float64:
+		return new(big.Rat).SetFloat64(v)
+	case *big.Rat:
+		return v
+	}
+	return nil
+}
+
+// arith applies an arithmetic operation. Integer division truncates.
+func arith(op query.ExpOp, a, b interface{}) (interface{}, error) {
+	if ai, ok := a.(int64); ok {
+		if bi, ok := b.(int64); ok {
+			switch op {
+			case query.ExpAdd:
+				return ai + bi, nil
+			case query.ExpSub:
+				return ai - bi, nil
+			case query.ExpMul:
+				return ai * bi, nil
+			}
+			if bi == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if op == query.ExpDiv {
+				return ai / bi, nil
+			}
+			return ai % bi, nil
+		}
+	}
+	_, ar := a.(*big.Rat)
+	_, br := b.(*big.Rat)
+	if ar || br {
+		x, y := toRat(a), toRat(b)
+		if x == nil || y == nil {
+			return nil, fmt.Errorf("cannot apply %v to %T and %T", op, a, b)
+		}
+		z := new(big.Rat)
+		switch op {
+		case query.ExpAdd:
+			return z.Add(x, y), nil
+		case query.ExpSub:
+			return z.Sub(x, y), nil
+		case query.ExpMul:
+			return z.Mul(x, y), nil
+		case query.ExpDiv:
+			if y.Sign() == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return z.Quo(x, y), nil
+		}
+		return nil, fmt.Errorf("cannot apply %v to %T and %T", op, a, b)
+	}
+	x, ok1 := toFloat(a)
+	y, ok2 := toFloat(b)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("cannot apply %v to %T and %T", op, a, b)
+	}
+	switch op {
+	case query.ExpAdd:
+		return x + y, nil
+	case query.ExpSub:
+		return x - y, nil
+	case query.ExpMul:
+		return x * y, nil
+	case query.ExpDiv:
+		return x / y, nil
+	}
+	return math.Mod(x, y), nil
+}
+
+// like reports if s matches the pattern p, where "%" matches any text and
+// "_" matches a single character.
+func like(s, p []rune) bool {
+	for len(p) > 0 {
+		switch p[0] {
+		case '%':
+			p = p[1:]
+			for i := 0; i <= len(s); i++ {
+				if like(s[i:], p) {
+					return true
+				}
+			}
+			return false
+		case '_':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != p[0] {
+				return false
+			}
+		}
+		s, p = s[1:], p[1:]
+	}
+	return len(s) == 0
+}
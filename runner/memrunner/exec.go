@@ -0,0 +1,500 @@
+// Copyright 2018 solidcoredata authors.
+
+package memrunner
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/solidcoredata/dbc/query"
+	"github.com/solidcoredata/dbc/runner"
+)
+
+// tx holds the tables changed by a query. Changes are made to copies of
+// the store tables, which replace the store tables when the query succeeds.
+type tx struct {
+	ms      *MemoryStore
+	changed map[string]*memTable
+}
+
+func newTx(ms *MemoryStore) *tx {
+	return &tx{ms: ms, changed: make(map[string]*memTable)}
+}
+
+// table returns the named table as changed by the query so far.
+func (t *tx) table(name string) *memTable {
+	if mt, ok := t.changed[name]; ok {
+		return mt
+	}
+	return t.ms.lookup(name)
+}
+
+// write returns the named table for changing.
+func (t *tx) write(name string) *memTable {
+	if mt, ok := t.changed[name]; ok {
+		return mt
+	}
+	mt := t.ms.lookup(name)
+	if mt == nil {
+		return nil
+	}
+	mt = mt.clone()
+	t.changed[name] = mt
+	return mt
+}
+
+func (t *tx) commit() {
+	for i, mt := range t.ms.table {
+		if c, ok := t.changed[mt.def.Name]; ok {
+			t.ms.table[i] = c
+		}
+	}
+}
+
+// exec runs the statements of a single query.
+type exec struct {
+	tx    *tx
+	param map[string]interface{}
+	authz *runner.Authz
+
+	// keyset is the statement paged by key. If it returns a full page,
+	// cursor holds the value of each order expression of the last row.
+	keyset *query.Stmt
+	cursor []interface{}
+}
+
+// result is the returned rows of a statement.
+type result struct {
+	stmt       *query.Stmt
+	schema     *query.ResultSchema
+	row        [][]interface{}
+	allow      [][]query.Authn // Access to each value of a row, nil if the same as the column.
+	interleave [][]*result     // Result of each interleaved statement, for each row.
+}
+
+// stmt runs the statement. If the statement returns columns, the result
+// holds the returned rows, otherwise it is nil. An interleaved statement
+// runs with the row of its parent statement as the parent scope, and the
+// tables of the parent aliases as outer.
+func (x *exec) stmt(st *query.Stmt, rules *runner.Rules, parent *scope, outer map[string]*memTable) (*result, error) {
+	var matched []*scope
+	err := x.match(st.From, st.Condition(), parent, func(sc *scope) (bool, error) {
+		matched = append(matched, sc.copy())
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	matched, err = x.order(st, matched)
+	if err != nil {
+		return nil, err
+	}
+	matched, full, err := x.limit(st, matched)
+	if err != nil {
+		return nil, err
+	}
+	if st == x.keyset && full && len(matched) > 0 {
+		// Keyset only orders by columns the caller may return that have
+		// no read deny rule, so the cursor values need no mask.
+		last := matched[len(matched)-1]
+		x.cursor = make([]interface{}, len(st.Order))
+		for i, o := range st.Order {
+			if x.cursor[i], err = x.eval(o.Exp, last); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(rules.Lock) > 0 {
+		// A SQL backend adds the lock conditions to the statement
+		// condition, and only sees that no row changed, so matching no
+		// row is a conflict too.
+		if len(matched) == 0 {
+			return nil, runner.ErrConflict
+		}
+		lock := query.And(rules.Lock...)
+		for _, sc := range matched {
+			ok, err := x.test(lock, sc)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, runner.ErrConflict
+			}
+		}
+	}
+	switch {
+	case len(st.Insert) > 0:
+		matched, err = x.insert(st, rules, matched)
+	case len(st.Update) > 0:
+		err = x.update(st, matched)
+	case len(st.Delete) > 0:
+		err = x.delete(st, matched)
+	}
+	if err != nil || len(st.Return) == 0 {
+		return nil, err
+	}
+	table := x.tables(st, outer)
+	res, err := x.returning(st, rules, matched, table)
+	if err != nil || len(st.Interleave) == 0 {
+		return res, err
+	}
+	for _, il := range st.Interleave {
+		sub := &il.Stmt
+		if len(sub.Return) == 0 || len(sub.Insert) > 0 || len(sub.Update) > 0 || len(sub.Delete) > 0 {
+			return nil, fmt.Errorf("interleaved statement %q must only return rows", il.Name)
+		}
+	}
+	res.interleave = make([][]*result, len(matched))
+	for n, sc := range matched {
+		res.interleave[n] = make([]*result, len(st.Interleave))
+		for i := range st.Interleave {
+			sub, err := x.stmt(&st.Interleave[i].Stmt, rules.Interleave[i], sc, table)
+			if err != nil {
+				return nil, fmt.Errorf("interleaved statement %q: %w", st.Interleave[i].Name, err)
+			}
+			res.interleave[n][i] = sub
+		}
+	}
+	return res, nil
+}
+
+// order sorts the matched rows by the statement order.
+func (x *exec) order(st *query.Stmt, matched []*scope) ([]*scope, error) {
+	if len(st.Order) == 0 {
+		return matched, nil
+	}
+	key := make(map[*scope][]interface{}, len(matched))
+	for _, sc := range matched {
+		k := make([]interface{}, len(st.Order))
+		for i, o := range st.Order {
+			v, err := x.eval(o.Exp, sc)
+			if err != nil {
+				return nil, err
+			}
+			k[i] = v
+		}
+		key[sc] = k
+	}
+	var err error
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := key[matched[i]], key[matched[j]]
+		for n, o := range st.Order {
+			// NULL sorts before any value.
+			var c int
+			switch {
+			case a[n] == nil && b[n] == nil:
+			case a[n] == nil:
+				c = -1
+			case b[n] == nil:
+				c = 1
+			default:
+				var cerr error
+				c, cerr = compare(a[n], b[n])
+				if cerr != nil && err == nil {
+					err = cerr
+				}
+			}
+			if o.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	return matched, err
+}
+
+// limit applies the statement offset and limit to the matched rows. It
+// reports if the statement has a limit and the limit rows were kept.
+func (x *exec) limit(st *query.Stmt, matched []*scope) ([]*scope, bool, error) {
+	count := func(name string, e query.Exp) (int, bool, error) {
+		if e.IsZero() {
+			return 0, false, nil
+		}
+		v, err := x.eval(e, &scope{})
+		if err != nil {
+			return 0, false, err
+		}
+		n, ok := v.(int64)
+		if !ok || n < 0 {
+			return 0, false, fmt.Errorf("%s must be a positive integer, got %v", name, v)
+		}
+		return int(n), true, nil
+	}
+	offset, ok, err := count("offset", st.Offset)
+	if err != nil {
+		return nil, false, err
+	}
+	if ok {
+		if offset > len(matched) {
+			offset = len(matched)
+		}
+		matched = matched[offset:]
+	}
+	n, ok, err := count("limit", st.Limit)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		return matched, false, nil
+	}
+	full := n <= len(matched)
+	if full {
+		matched = matched[:n]
+	}
+	return matched, full, nil
+}
+
+// insert adds a row for each matched row. It returns the matched rows
+// with the inserted row bound to the alias of the insert table.
+func (x *exec) insert(st *query.Stmt, rules *runner.Rules, matched []*scope) ([]*scope, error) {
+	if len(st.Set) != len(st.Insert) {
+		return nil, fmt.Errorf("got %d insert values, want %d", len(st.Set), len(st.Insert))
+	}
+	target := st.Insert[0].Table
+	if target == nil {
+		return nil, fmt.Errorf("insert column %q missing table", st.Insert[0].StoreName)
+	}
+	mt := x.tx.write(target.Name)
+	if mt == nil {
+		return nil, fmt.Errorf("unknown table %q", target.Name)
+	}
+	index := make([]int, len(st.Insert))
+	for i, col := range st.Insert {
+		if col.Table == nil || col.Table.Name != target.Name {
+			return nil, fmt.Errorf("insert column %q is not in table %q", col.StoreName, target.Name)
+		}
+		index[i] = mt.column(col.StoreName)
+		if index[i] < 0 {
+			return nil, fmt.Errorf("table %q has no column %q", target.Name, col.StoreName)
+		}
+	}
+	out := make([]*scope, len(matched))
+	for n, sc := range matched {
+		row := make([]interface{}, len(mt.def.Column))
+		for i, col := range mt.def.Column {
+			row[i] = col.Default
+		}
+		for i, e := range st.Set {
+			v, err := x.eval(e, sc)
+			if err != nil {
+				return nil, err
+			}
+			row[index[i]] = v
+		}
+		if err := mt.insert(row); err != nil {
+			return nil, fmt.Errorf("insert into %q: %v", target.Name, err)
+		}
+		i := len(mt.row) - 1
+		out[n] = &scope{bind: append(append([]binding(nil), sc.bind...), binding{alias: target.Alias, table: mt, index: i, row: mt.row[i]})}
+		denied, err := x.denied(rules.Insert, out[n])
+		if err != nil {
+			return nil, err
+		}
+		if denied {
+			return nil, &runner.AuthzError{Port: x.authz.Port(), Role: x.authz.Role(), Table: target.Name, Row: true, Need: query.AllowInsert}
+		}
+	}
+	return out, nil
+}
+
+// update sets the update columns of each matched row. Values are computed
+// from the matched rows before any row is changed.
+func (x *exec) update(st *query.Stmt, matched []*scope) error {
+	if len(st.Set) != len(st.Update) {
+		return fmt.Errorf("got %d update values, want %d", len(st.Set), len(st.Update))
+	}
+	value := make([][]interface{}, len(matched))
+	for n, sc := range matched {
+		value[n] = make([]interface{}, len(st.Set))
+		for i, e := range st.Set {
+			v, err := x.eval(e, sc)
+			if err != nil {
+				return err
+			}
+			value[n][i] = v
+		}
+	}
+	for n, sc := range matched {
+		for i, col := range st.Update {
+			if col.Table == nil {
+				return fmt.Errorf("update column %q missing table", col.StoreName)
+			}
+			b := sc.lookup(col.Table.Alias)
+			if b == nil {
+				return fmt.Errorf("update alias %q not in from", col.Table.Alias)
+			}
+			mt := x.tx.write(b.table.def.Name)
+			ci := mt.column(col.StoreName)
+			if ci < 0 {
+				return fmt.Errorf("table %q has no column %q", mt.def.Name, col.StoreName)
+			}
+			// A row may be matched more than once, so change the current row.
+			row := append([]interface{}(nil), mt.row[b.index]...)
+			row[ci] = value[n][i]
+			if err := mt.update(b.index, row); err != nil {
+				return fmt.Errorf("update %q: %v", mt.def.Name, err)
+			}
+			b.table, b.row = mt, mt.row[b.index]
+		}
+	}
+	// Return the final value of each row.
+	for _, sc := range matched {
+		for i := range sc.bind {
+			b := &sc.bind[i]
+			if c := x.tx.changed[b.table.def.Name]; c != nil {
+				b.table, b.row = c, c.row[b.index]
+			}
+		}
+	}
+	return nil
+}
+
+// delete removes the matched rows of each delete table.
+func (x *exec) delete(st *query.Stmt, matched []*scope) error {
+	index := make(map[*memTable]map[int]bool, len(st.Delete))
+	for _, rt := range st.Delete {
+		mt := x.tx.write(rt.Name)
+		if mt == nil {
+			return fmt.Errorf("unknown table %q", rt.Name)
+		}
+		set := index[mt]
+		if set == nil {
+			set = make(map[int]bool, len(matched))
+			index[mt] = set
+		}
+		for _, sc := range matched {
+			b := sc.lookup(rt.Alias)
+			if b == nil {
+				return fmt.Errorf("delete alias %q not in from", rt.Alias)
+			}
+			set[b.index] = true
+		}
+	}
+	for mt, set := range index {
+		mt.remove(set)
+	}
+	return nil
+}
+
+// denied reports if the deny condition of a row rule is not false.
+// An unset condition never denies.
+func (x *exec) denied(e query.Exp, sc *scope) (bool, error) {
+	if e.IsZero() {
+		return false, nil
+	}
+	v, err := x.eval(e, sc)
+	return v != false, err
+}
+
+// tables returns the table of each alias of the statement, along with
+// the outer aliases.
+func (x *exec) tables(st *query.Stmt, outer map[string]*memTable) map[string]*memTable {
+	table := make(map[string]*memTable, len(outer)+len(st.From)+1)
+	for alias, mt := range outer {
+		table[alias] = mt
+	}
+	for _, rt := range st.From {
+		table[rt.Alias] = x.tx.table(rt.Name)
+	}
+	if len(st.Insert) > 0 && st.Insert[0].Table != nil {
+		table[st.Insert[0].Table.Alias] = x.tx.table(st.Insert[0].Table.Name)
+	}
+	return table
+}
+
+// values returns the expression of each returned column.
+func values(st *query.Stmt) ([]query.Exp, error) {
+	if len(st.Select) == len(st.Return) {
+		return st.Select, nil
+	}
+	value := make([]query.Exp, len(st.Return))
+	for i, col := range st.Return {
+		if col.Table == nil {
+			return nil, fmt.Errorf("return column %q missing table", col.QueryName)
+		}
+		value[i] = query.Column(col.Table.Alias, col.StoreName)
+	}
+	return value, nil
+}
+
+// schema returns the result schema of the statement, given the tables of
+// its aliases. Computed columns take the type of their expression.
+func (x *exec) schema(st *query.Stmt, table map[string]*memTable) (*query.ResultSchema, error) {
+	value, err := values(st)
+	if err != nil {
+		return nil, err
+	}
+	rs := &query.ResultSchema{Column: make([]*query.ColumnSchema, len(st.Return))}
+	for i, col := range st.Return {
+		c := *col
+		if c.Type == query.TypeUnknown {
+			c.Type = x.expType(value[i], table)
+		}
+		c.Allow = query.AllowReturn.Closure()
+		if value[i].Op == query.ExpColumn && c.Table != nil {
+			c.Allow = x.authz.Column(c.Table.Name, c.StoreName)
+		}
+		rs.Column[i] = &c
+	}
+	return rs, nil
+}
+
+// returning computes the returned columns of each matched row. Values the
+// column rules deny reading are returned as NULL.
+func (x *exec) returning(st *query.Stmt, rules *runner.Rules, matched []*scope, table map[string]*memTable) (*result, error) {
+	value, err := values(st)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := x.schema(st, table)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &result{
+		stmt:   st,
+		schema: rs,
+		row:    make([][]interface{}, len(matched)),
+		allow:  make([][]query.Authn, len(matched)),
+	}
+	for n, sc := range matched {
+		row := make([]interface{}, len(value))
+		var allow []query.Authn
+		for i, e := range value {
+			v, err := x.eval(e, sc)
+			if err != nil {
+				return nil, err
+			}
+			a := rs.Column[i].Allow
+			deny := rules.Column[i]
+			if d, err := x.denied(deny.Read, sc); err != nil || d {
+				v, a = nil, a&^query.AllowReturn
+				if err != nil {
+					return nil, err
+				}
+			}
+			if d, err := x.denied(deny.Update, sc); err != nil || d {
+				a &^= query.AllowUpdate
+				if err != nil {
+					return nil, err
+				}
+			}
+			if a != rs.Column[i].Allow && allow == nil {
+				allow = make([]query.Authn, len(value))
+				for j := range allow {
+					allow[j] = rs.Column[j].Allow
+				}
+			}
+			if allow != nil {
+				allow[i] = a
+			}
+			row[i] = v
+		}
+		res.row[n] = row
+		res.allow[n] = allow
+	}
+	return res, nil
+}
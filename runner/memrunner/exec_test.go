@@ -0,0 +1,851 @@
+// Copyright 2018 solidcoredata authors.
+
+package memrunner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/solidcoredata/dbc/compile"
+	"github.com/solidcoredata/dbc/dialect"
+	"github.com/solidcoredata/dbc/parser"
+	"github.com/solidcoredata/dbc/query"
+	"github.com/solidcoredata/dbc/runner"
+)
+
+const execSource = `package lib
+
+author table {
+	id int64 serial key
+	name text
+}
+
+book table {
+	id int64 serial key
+	name text
+	author *author.id
+	pages int64 null
+}
+
+all_books query {
+	from book b
+	join author a and a.id = b.author
+	select b.name, a.name "Author"
+	order a.name, b.name desc
+}
+
+long_books query {
+	from book b
+	and
+		b.pages > 100
+		or (b.name like '%Sea%', b.pages = min_pages, b.pages > 400)
+	select b.id, b.name, half = b.pages / 2
+	order b.pages desc
+	limit 2 offset 1
+}
+
+prolific query {
+	from author a
+	and exists (
+		from book b
+		and (b.author = a.id, b.pages > 100)
+	)
+	and a.id in (1, 2)
+	select a.name
+	order a.name
+}
+
+book_pages query {
+	from book b
+	and b.pages > 100
+	select b.id, b.name, b.pages
+	order b.id
+}
+
+author_books query {
+	from author a
+	and a.id < 3
+	select a.name
+	order a.name
+	detail books (
+		from book b
+		and (b.author = a.id, b.pages > 100)
+		select b.name, b.pages
+		order b.pages
+	)
+}
+`
+
+var execData = map[string][][]interface{}{
+	"author": {
+		{1, "Hemingway"},
+		{2, "Austen"},
+		{3, "Unknown"},
+	},
+	"book": {
+		{1, "The Old Man and the Sea", 1, 127},
+		{2, "For Whom the Bell Tolls", 1, 471},
+		{3, "Emma", 2, 474},
+		{4, "Lady Susan", 2, 80},
+		{5, "Persuasion", 2, 249},
+		{6, "Anonymous Notes", 3, nil},
+	},
+}
+
+// execStore compiles the source and loads it with the data.
+func execStore(t *testing.T) *MemoryStore {
+	t.Helper()
+	f, err := parser.Parse(context.Background(), "lib.scd", execSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range f.Errors {
+		t.Fatal(e)
+	}
+	st, err := compile.Compile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := &MemoryStore{}
+	for _, table := range st.Table {
+		if err := ms.AddTable(table, execData[table.Name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range st.Query {
+		if err := ms.AddQuery(&st.Query[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ms
+}
+
+// readResults returns the rows of each result as text, one line per row.
+func readResults(t *testing.T, stream query.StreamingResultSet) []string {
+	t.Helper()
+	var schema query.ResultSetSchema
+	var list []string
+	var cols []*query.ColumnSchema
+	b := &strings.Builder{}
+	for {
+		item, err := stream.Next()
+		if err == io.EOF {
+			return list
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch v := item.(type) {
+		default:
+			t.Fatalf("unexpected stream item %v", v.StreamState())
+		case query.StreamItemResultSetSchema:
+			schema = v.Schema
+		case query.StreamItemResult:
+			cols = schema.Set[v.SchemaIndex].Column
+			b.Reset()
+		case query.StreamItemRow:
+			for i, f := range v.Row {
+				val, err := query.DecodeField(cols[i].Type, f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if i > 0 {
+					b.WriteString(", ")
+				}
+				fmt.Fprintf(b, "%s=%v", cols[i].QueryName, val)
+			}
+			b.WriteString("\n")
+		case query.StreamItemEndOfResult:
+			list = append(list, b.String())
+		case query.StreamItemEndOfSet:
+		}
+	}
+}
+
+func TestRunQuery(t *testing.T) {
+	list := []struct {
+		name  string
+		param []runner.Param
+		want  string
+	}{
+		{"all_books", nil, `name=Persuasion, Author=Austen
+name=Lady Susan, Author=Austen
+name=Emma, Author=Austen
+name=The Old Man and the Sea, Author=Hemingway
+name=For Whom the Bell Tolls, Author=Hemingway
+name=Anonymous Notes, Author=Unknown
+`},
+		{"long_books", []runner.Param{{Name: "min_pages", Value: 249}}, `id=2, name=For Whom the Bell Tolls, half=235
+id=5, name=Persuasion, half=124
+`},
+		{"prolific", nil, `name=Austen
+name=Hemingway
+`},
+	}
+	ms := execStore(t)
+	r := NewMemoryStoreRunner(ms)
+	for _, item := range list {
+		t.Run(item.name, func(t *testing.T) {
+			stream, err := r.Run(nil, runner.Option{QueryName: item.name, Param: item.param})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := readResults(t, stream)
+			if len(got) != 1 || got[0] != item.want {
+				t.Fatalf("got:\n%s\nwant:\n%s", strings.Join(got, "--\n"), item.want)
+			}
+		})
+	}
+}
+
+func TestRunError(t *testing.T) {
+	ms := execStore(t)
+	r := NewMemoryStoreRunner(ms)
+	list := []struct {
+		query string
+		err   string
+	}{
+		{"missing", `query "missing" not found`},
+		{"long_books", `missing parameter "min_pages"`},
+	}
+	for _, item := range list {
+		_, err := r.Run(nil, runner.Option{QueryName: item.query})
+		if err == nil || !strings.Contains(err.Error(), item.err) {
+			t.Errorf("got error %v, want %q", err, item.err)
+		}
+	}
+}
+
+func TestRunChange(t *testing.T) {
+	ms := execStore(t)
+	book := &query.ResultTableSchema{Name: "book", Alias: "b", IsArity: true}
+	col := func(name string) *query.ColumnSchema {
+		return &query.ColumnSchema{Table: book, StoreName: name, QueryName: name}
+	}
+	q := query.Query{
+		Name: "change",
+		Stmt: []query.Stmt{
+			// Insert a book, returning the new serial id.
+			{
+				Insert: []*query.ColumnSchema{col("name"), col("author"), col("pages")},
+				Set:    []query.Exp{query.Parameter("name"), query.Literal(int64(2)), query.Literal(nil)},
+				Return: []*query.ColumnSchema{col("id"), col("name")},
+			},
+			// Update the pages of books without pages.
+			{
+				From:   []*query.ResultTableSchema{book},
+				Where:  query.Equal(query.Call("coalesce", query.Column("b", "pages"), query.Literal(int64(0))), query.Literal(int64(0))),
+				Update: []*query.ColumnSchema{col("pages")},
+				Set:    []query.Exp{query.Literal(int64(1))},
+			},
+			{
+				From:   []*query.ResultTableSchema{book},
+				Where:  query.Binary(query.ExpLess, query.Column("b", "pages"), query.Literal(int64(100))),
+				Update: []*query.ColumnSchema{col("pages")},
+				Set:    []query.Exp{query.Binary(query.ExpAdd, query.Column("b", "pages"), query.Literal(int64(10)))},
+				Return: []*query.ColumnSchema{col("id"), col("pages")},
+				Order:  []query.Order{{Exp: query.Column("b", "id")}},
+			},
+			// Delete the books of the first author.
+			{
+				From:   []*query.ResultTableSchema{book},
+				Where:  query.Equal(query.Column("b", "author"), query.Literal(int64(1))),
+				Delete: []*query.ResultTableSchema{book},
+				Return: []*query.ColumnSchema{col("id")},
+			},
+			{
+				From:   []*query.ResultTableSchema{book},
+				Return: []*query.ColumnSchema{{QueryName: "id", Table: book, StoreName: "id"}},
+				Select: []query.Exp{query.Column("b", "id")},
+			},
+		},
+	}
+	if err := ms.AddQuery(&q); err != nil {
+		t.Fatal(err)
+	}
+	r := NewMemoryStoreRunner(ms)
+	stream, err := r.Run(nil, runner.Option{QueryName: "change", Param: []runner.Param{{Name: "name", Value: "Sanditon"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(readResults(t, stream), "--\n")
+	want := `id=7, name=Sanditon
+--
+id=4, pages=90
+id=6, pages=11
+id=7, pages=11
+--
+id=1
+id=2
+--
+id=3
+id=4
+id=5
+id=6
+id=7
+`
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// A failed statement keeps no changes.
+	q = query.Query{
+		Name: "fail",
+		Stmt: []query.Stmt{
+			{
+				From:   []*query.ResultTableSchema{book},
+				Delete: []*query.ResultTableSchema{book},
+			},
+			{
+				Insert: []*query.ColumnSchema{col("id"), col("name"), col("author")},
+				Set:    []query.Exp{query.Literal(int64(1)), query.Literal(nil), query.Literal(int64(1))},
+			},
+		},
+	}
+	if err := ms.AddQuery(&q); err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Run(nil, runner.Option{QueryName: "fail"})
+	if err == nil || !strings.Contains(err.Error(), `column "name" may not be null`) {
+		t.Fatalf("got error %v, want null error", err)
+	}
+	if n := len(ms.lookup("book").row); n != 5 {
+		t.Fatalf("got %d books after failed query, want 5", n)
+	}
+}
+
+func TestLike(t *testing.T) {
+	list := []struct {
+		s, p string
+		want bool
+	}{
+		{"abc", "abc", true},
+		{"abc", "a%", true},
+		{"abc", "%c", true},
+		{"abc", "a_c", true},
+		{"abc", "%b%", true},
+		{"abc", "a_", false},
+		{"abc", "%d%", false},
+		{"", "%", true},
+	}
+	for _, item := range list {
+		if got := like([]rune(item.s), []rune(item.p)); got != item.want {
+			t.Errorf("like(%q, %q) got %t, want %t", item.s, item.p, got, item.want)
+		}
+	}
+}
+
+func TestRunAuthz(t *testing.T) {
+	ms := execStore(t)
+	ms.lookup("book").def.Port = map[string]query.StoreTablePort{
+		"web": {
+			RoleAuthn: map[string]query.Authn{"reader": query.AllowReturn},
+			Column: []query.StoreColumnPort{
+				{},
+				{RoleAuthn: map[string]query.Authn{"reader": query.AllowFull}},
+			},
+		},
+	}
+	r := NewMemoryStoreRunner(ms)
+
+	stream, err := r.Run(nil, runner.Option{QueryName: "all_books", Port: "web", Role: []string{"reader"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := stream.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols := item.(query.StreamItemResultSetSchema).Schema.Set[0].Column
+	if g, w := cols[0].Allow, query.AllowRead|query.AllowReturn; g != w {
+		t.Errorf("book name got %v, want %v", g, w)
+	}
+	if g, w := cols[1].Allow, query.AllowFull; g != w {
+		t.Errorf("author name got %v, want %v", g, w)
+	}
+
+	_, err = r.Run(nil, runner.Option{QueryName: "all_books", Port: "web", Role: []string{"writer"}})
+	var ae *runner.AuthzError
+	if !errors.As(err, &ae) || ae.Table != "book" || ae.Need != query.AllowRead {
+		t.Fatalf("got error %v, want book read denied", err)
+	}
+}
+
+func TestRunRules(t *testing.T) {
+	ms := execStore(t)
+	def := ms.lookup("book").def
+	def.Alias = "bk"
+	user := []query.Input{{Type: query.TypeInteger, Name: "user"}}
+	def.Port = map[string]query.StoreTablePort{
+		"web": {
+			RoleAuthn: map[string]query.Authn{"reader": query.AllowFull},
+			// Short books are hidden, and books without pages too.
+			DenyRead:   query.Param{Q: query.Binary(query.ExpLess, query.Column("bk", "pages"), query.Literal(int64(100)))},
+			DenyInsert: query.Param{Q: query.Binary(query.ExpGreater, query.Column("bk", "pages"), query.Literal(int64(1000)))},
+			DenyDelete: query.Param{Q: query.Equal(query.Column("bk", "author"), query.Literal(int64(2)))},
+			Column: []query.StoreColumnPort{
+				{},
+				{DenyRead: query.Param{Q: query.Equal(query.Column("bk", "author"), query.Parameter("user")), Input: user}},
+			},
+		},
+	}
+	book := &query.ResultTableSchema{Name: "book", Alias: "b", IsArity: true}
+	col := func(name string) *query.ColumnSchema {
+		return &query.ColumnSchema{Table: book, StoreName: name, QueryName: name}
+	}
+	for _, q := range []query.Query{
+		{
+			Name: "remove",
+			Stmt: []query.Stmt{{
+				From:   []*query.ResultTableSchema{book},
+				Delete: []*query.ResultTableSchema{book},
+				Return: []*query.ColumnSchema{col("id")},
+			}},
+		},
+		{
+			Name: "add",
+			Stmt: []query.Stmt{{
+				Insert: []*query.ColumnSchema{col("name"), col("author"), col("pages")},
+				Set:    []query.Exp{query.Literal("Long"), query.Literal(int64(1)), query.Parameter("pages")},
+				Return: []*query.ColumnSchema{col("id")},
+			}},
+		},
+	} {
+		q := q
+		if err := ms.AddQuery(&q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewMemoryStoreRunner(ms)
+	opt := func(name string, param ...runner.Param) runner.Option {
+		return runner.Option{QueryName: name, Port: "web", Role: []string{"reader"}, Param: param}
+	}
+	userParam := runner.Param{Name: "user", Value: 1}
+
+	// Names of the books of the user are denied.
+	stream, err := r.Run(nil, opt("all_books", userParam))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var masked int
+	var rows []string
+	for {
+		item, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		row, ok := item.(query.StreamItemRow)
+		if !ok {
+			continue
+		}
+		v, err := query.DecodeField(query.TypeString, row.Row[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, fmt.Sprint(v))
+		if row.Allow != nil {
+			if row.Allow[0]&query.AllowReturn != 0 || row.Allow[1] != query.AllowFull {
+				t.Errorf("row %d got allow %v", len(rows), row.Allow)
+			}
+			masked++
+		}
+	}
+	if g, w := strings.Join(rows, ", "), "Persuasion, Emma, <nil>, <nil>"; g != w {
+		t.Fatalf("got rows %s, want %s", g, w)
+	}
+	if masked != 2 {
+		t.Fatalf("got %d masked rows, want 2", masked)
+	}
+
+	_, err = r.Run(nil, opt("all_books"))
+	if err == nil || !strings.Contains(err.Error(), `requires parameter "user"`) {
+		t.Fatalf("got error %v, want missing user parameter", err)
+	}
+
+	_, err = r.Run(nil, opt("add", userParam, runner.Param{Name: "pages", Value: 2000}))
+	var ae *runner.AuthzError
+	if !errors.As(err, &ae) || !ae.Row || ae.Need != query.AllowInsert {
+		t.Fatalf("got error %v, want row insert denied", err)
+	}
+
+	// Only readable books of other authors are deleted.
+	stream, err = r.Run(nil, opt("remove", userParam))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readResults(t, stream), "id=1\nid=2\n"; len(got) != 1 || got[0] != want {
+		t.Fatalf("got deleted %q, want %q", got, want)
+	}
+	if n := len(ms.lookup("book").row); n != 4 {
+		t.Fatalf("got %d books after delete, want 4", n)
+	}
+}
+
+func TestRunWire(t *testing.T) {
+	r := NewMemoryStoreRunner(execStore(t))
+	opt := runner.Option{QueryName: "long_books", Param: []runner.Param{{Name: "min_pages", Value: 249}}}
+	stream, err := r.Run(nil, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := readResults(t, stream)
+
+	stream, err = r.Run(nil, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := query.EncodeStream(buf, stream); err != nil {
+		t.Fatal(err)
+	}
+	got := readResults(t, query.NewDecoder(buf))
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestRunDetail(t *testing.T) {
+	ms := execStore(t)
+	r := NewMemoryStoreRunner(ms)
+	stream, err := r.Run(nil, runner.Option{QueryName: "author_books"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := query.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf.Schema.Set) != 2 || buf.Schema.Set[1].Name != "books" {
+		t.Fatalf("got schema %+v, want author and books results", buf.Schema.Set)
+	}
+	if len(buf.Set[1].Row) != 0 {
+		t.Fatalf("got %d top level detail rows, want none", len(buf.Set[1].Row))
+	}
+	b := &strings.Builder{}
+	for _, row := range buf.Set[0].Row {
+		fmt.Fprintf(b, "%v:", row.Column[0].Value)
+		for _, sub := range row.Interleave {
+			for _, d := range sub.Row {
+				fmt.Fprintf(b, " %v (%v)", d.Column[0].Value, d.Column[1].Value)
+			}
+		}
+		b.WriteString("\n")
+	}
+	want := `Austen: Persuasion (249) Emma (474)
+Hemingway: The Old Man and the Sea (127) For Whom the Bell Tolls (471)
+`
+	if got := b.String(); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRunLock(t *testing.T) {
+	ms := &MemoryStore{}
+	err := ms.AddTable(&query.StoreTable{
+		Name: "book",
+		Column: []*query.StoreColumn{
+			{Name: "id", Type: query.TypeInteger, Key: true},
+			{Name: "name", Type: query.TypeString},
+			{Name: "version", Type: query.TypeInteger, UpdateLock: true, DeleteLock: true},
+		},
+		Read: []query.Param{{Q: query.Binary(query.ExpNotEqual, query.Column("book", "name"), query.Literal("Hidden"))}},
+	}, [][]interface{}{{1, "Emma", 1}, {2, "Persuasion", 1}, {3, "Hidden", 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := &query.ResultTableSchema{Name: "book", Alias: "b", IsArity: true}
+	col := func(name string) *query.ColumnSchema {
+		return &query.ColumnSchema{Table: book, StoreName: name, QueryName: name}
+	}
+	byID := query.Equal(query.Column("b", "id"), query.Parameter("id"))
+	for _, q := range []query.Query{
+		{Name: "rename", Stmt: []query.Stmt{{
+			From:   []*query.ResultTableSchema{book},
+			Where:  byID,
+			Update: []*query.ColumnSchema{col("name"), col("version")},
+			Set:    []query.Exp{query.Parameter("name"), query.Binary(query.ExpAdd, query.Column("b", "version"), query.Literal(int64(1)))},
+			Return: []*query.ColumnSchema{col("name"), col("version")},
+		}}},
+		{Name: "remove", Stmt: []query.Stmt{{
+			From:   []*query.ResultTableSchema{book},
+			Where:  byID,
+			Delete: []*query.ResultTableSchema{book},
+		}}},
+	} {
+		q := q
+		if err := ms.AddQuery(&q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewMemoryStoreRunner(ms)
+	run := func(name string, id, version interface{}) (string, error) {
+		param := []runner.Param{{Name: "id", Value: id}, {Name: "name", Value: "Emma!"}}
+		if version != nil {
+			param = append(param, runner.Param{Name: query.LockParam("b", "version"), Value: version})
+		}
+		stream, err := r.Run(nil, runner.Option{QueryName: name, Param: param})
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(readResults(t, stream), "--\n"), nil
+	}
+
+	if _, err := run("rename", 1, nil); err == nil || !strings.Contains(err.Error(), `requires lock parameter "lock.b.version"`) {
+		t.Fatalf("got %v, want missing lock parameter error", err)
+	}
+	got, err := run("rename", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "name=Emma!, version=2\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	// The row changed since version 1 was read.
+	if _, err := run("rename", 1, 1); !errors.Is(err, runner.ErrConflict) {
+		t.Fatalf("got %v, want ErrConflict", err)
+	}
+	if _, err := run("remove", 2, 3); !errors.Is(err, runner.ErrConflict) {
+		t.Fatalf("got %v, want ErrConflict", err)
+	}
+	if _, err := run("remove", 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	// A row the read rule hides is not matched, so it is not changed and,
+	// as no row is, the change conflicts whatever the lock values.
+	for _, version := range []interface{}{1, 5} {
+		if _, err := run("rename", 3, version); !errors.Is(err, runner.ErrConflict) {
+			t.Fatalf("version %v: got %v, want ErrConflict", version, err)
+		}
+	}
+	if rows := ms.lookup("book").row; len(rows) != 2 || rows[0][1] != "Emma!" || rows[1][1] != "Hidden" {
+		t.Fatalf("got rows %v, want the renamed and the hidden book", rows)
+	}
+}
+
+// TestRunLockSQL checks that the memory runner conflicts on the same
+// changes as a SQL backend, which renders the lock conditions into the
+// statement condition and conflicts when no row changes.
+func TestRunLockSQL(t *testing.T) {
+	ms := &MemoryStore{}
+	err := ms.AddTable(&query.StoreTable{
+		Name: "book",
+		Column: []*query.StoreColumn{
+			{Name: "id", Type: query.TypeInteger, Key: true},
+			{Name: "name", Type: query.TypeString},
+			{Name: "version", Type: query.TypeInteger, UpdateLock: true, DeleteLock: true},
+		},
+	}, [][]interface{}{{1, "Emma", 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := &query.ResultTableSchema{Name: "book", Alias: "b", IsArity: true}
+	col := func(name string) *query.ColumnSchema {
+		return &query.ColumnSchema{Table: book, StoreName: name, QueryName: name}
+	}
+	byID := query.Equal(query.Column("b", "id"), query.Parameter("id"))
+	update := query.Stmt{From: []*query.ResultTableSchema{book}, Where: byID, Update: []*query.ColumnSchema{col("name")}, Set: []query.Exp{query.Parameter("name")}}
+	del := query.Stmt{From: []*query.ResultTableSchema{book}, Where: byID, Delete: []*query.ResultTableSchema{book}}
+	lock := query.Lock(ms.Store(), &update)
+	for _, st := range []*query.Stmt{&update, &del} {
+		render := dialect.Update
+		if len(st.Delete) > 0 {
+			render = dialect.Delete
+		}
+		sql, err := render(dialect.Postgres, ms.Store(), st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w := []string{"id", "lock.b.version"}; !reflect.DeepEqual(sql.Param[len(sql.Param)-2:], w) {
+			t.Fatalf("%s: got params %q, want to end with %q", sql.Text, sql.Param, w)
+		}
+	}
+	// The rows the rendered SQL changes.
+	changed := query.Stmt{From: []*query.ResultTableSchema{book}, Where: query.And(append([]query.Exp{byID}, lock...)...), Return: []*query.ColumnSchema{col("id")}}
+	for _, q := range []query.Query{
+		{Name: "update", Stmt: []query.Stmt{update}},
+		{Name: "delete", Stmt: []query.Stmt{del}},
+		{Name: "changed", Stmt: []query.Stmt{changed}},
+	} {
+		q := q
+		if err := ms.AddQuery(&q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewMemoryStoreRunner(ms)
+	// A missing row, a changed row, then the row as read.
+	for _, item := range []struct{ id, version int64 }{{2, 2}, {1, 1}, {1, 2}} {
+		opt := runner.Option{Param: []runner.Param{
+			{Name: "id", Value: item.id},
+			{Name: "name", Value: "Emma!"},
+			{Name: query.LockParam("b", "version"), Value: item.version},
+		}}
+		opt.QueryName = "changed"
+		stream, err := r.Run(nil, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := query.ReadAll(stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conflict := len(buf.Set[0].Row) == 0
+		for _, name := range []string{"update", "delete"} {
+			opt.QueryName = name
+			_, err := r.Run(nil, opt)
+			if got := errors.Is(err, runner.ErrConflict); got != conflict || (!got && err != nil) {
+				t.Errorf("%s id %d version %d: got %v, want conflict %t", name, item.id, item.version, err, conflict)
+			}
+		}
+	}
+}
+
+func TestRunCRUD(t *testing.T) {
+	f, err := parser.Parse(context.Background(), "crud.scd", `package lib
+
+author table {
+	id int64 serial key
+	name text
+	version int64 lock
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := compile.Compile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := &MemoryStore{}
+	if err := ms.AddTable(st.Table[0], [][]interface{}{{1, "Austen", 1}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := range st.Query {
+		if err := ms.AddQuery(&st.Query[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewMemoryStoreRunner(ms)
+	runPage := func(op string, page runner.Page, param ...runner.Param) string {
+		t.Helper()
+		stream, err := r.Run(nil, runner.Option{QueryName: compile.CRUDName("author", op), Page: page, Param: param})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(readResults(t, stream), "--\n")
+	}
+	run := func(op string, param ...runner.Param) string {
+		t.Helper()
+		return runPage(op, runner.Page{}, param...)
+	}
+	p := func(name string, v interface{}) runner.Param {
+		return runner.Param{Name: name, Value: v}
+	}
+
+	list := []struct {
+		got, want string
+	}{
+		{run(compile.CRUDInsert, p("name", "Hemingway"), p("version", 1)), "id=2, name=Hemingway, version=1\n"},
+		{run(compile.CRUDUpdate, p("id", 2), p("name", "Ernest Hemingway"), p(query.LockParam("author", "version"), 1)), "id=2, name=Ernest Hemingway, version=2\n"},
+		{run(compile.CRUDGet, p("id", 2)), "id=2, name=Ernest Hemingway, version=2\n"},
+		{run(compile.CRUDDelete, p("id", 1), p(query.LockParam("author", "version"), 1)), ""},
+		{run(compile.CRUDList), "id=2, name=Ernest Hemingway, version=2\n"},
+		{run(compile.CRUDInsert, p("name", "Woolf"), p("version", 1)), "id=3, name=Woolf, version=1\n"},
+		{run(compile.CRUDList), "id=2, name=Ernest Hemingway, version=2\nid=3, name=Woolf, version=1\n"},
+		{runPage(compile.CRUDList, runner.Page{Limit: 1, Offset: 1}), "id=3, name=Woolf, version=1\n"},
+	}
+	for i, item := range list {
+		if item.got != item.want {
+			t.Errorf("%d: got %q, want %q", i, item.got, item.want)
+		}
+	}
+}
+
+func TestRunSearch(t *testing.T) {
+	ms := execStore(t)
+	r := NewMemoryStoreRunner(ms)
+	stream, err := r.Run(nil, runner.Option{
+		QueryName: "all_books",
+		Filter: []runner.Filter{
+			{Column: "Author", Op: "in", Value: []interface{}{"Austen", "Hemingway"}},
+			{Column: "name", Op: "like", Value: "%e%"},
+		},
+		Sort: []runner.Sort{{Column: "name"}},
+		Page: runner.Page{Limit: 1, Offset: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(readResults(t, stream), "--\n")
+	// Of For Whom the Bell Tolls, Persuasion, and The Old Man and the Sea,
+	// the page holds the second.
+	if want := "name=Persuasion, Author=Austen\n"; got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	_, err = r.Run(nil, runner.Option{QueryName: "all_books", Sort: []runner.Sort{{Column: "pages"}}})
+	if err == nil || !strings.Contains(err.Error(), `search column "pages" is not returned`) {
+		t.Fatalf("got %v, want not returned error", err)
+	}
+}
+
+func TestRunKeyset(t *testing.T) {
+	ms := execStore(t)
+	r := NewMemoryStoreRunner(ms)
+	opt := runner.Option{QueryName: "all_books", Keyset: true, Page: runner.Page{Limit: 2}}
+	var pages []string
+	for n := 0; n < 5; n++ {
+		stream, err := r.Run(nil, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := query.ReadAll(stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, row := range buf.Set[0].Row {
+			names = append(names, row.Column[0].Value.(string))
+		}
+		pages = append(pages, strings.Join(names, ", "))
+		if len(buf.Cursor) == 0 {
+			break
+		}
+		opt.Cursor = buf.Cursor
+	}
+	// Ordered by author, then book name descending, then book id. The last
+	// page is full, so one more empty page ends without a cursor.
+	want := []string{
+		"Persuasion, Lady Susan",
+		"Emma, The Old Man and the Sea",
+		"For Whom the Bell Tolls, Anonymous Notes",
+		"",
+	}
+	if !reflect.DeepEqual(pages, want) {
+		t.Fatalf("got pages %q, want %q", pages, want)
+	}
+
+	// A cursor only continues the order it was made for.
+	opt.Sort = []runner.Sort{{Column: "name"}}
+	if _, err := r.Run(nil, opt); err == nil || !strings.Contains(err.Error(), "cursor is not of the query order") {
+		t.Fatalf("got %v, want order error", err)
+	}
+	_, err := r.Run(nil, runner.Option{QueryName: "all_books", Cursor: opt.Cursor})
+	if err == nil || !strings.Contains(err.Error(), "cursor without keyset") {
+		t.Fatalf("got %v, want cursor without keyset error", err)
+	}
+	// Pages is nullable, so it may not order a keyset page.
+	_, err = r.Run(nil, runner.Option{QueryName: "long_books", Keyset: true, Param: []runner.Param{{Name: "min_pages", Value: 0}}})
+	if err == nil || !strings.Contains(err.Error(), "may be null") {
+		t.Fatalf("got %v, want nullable order error", err)
+	}
+}
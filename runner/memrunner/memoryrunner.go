@@ -1,6 +1,7 @@
 package memrunner
 
 import (
+	"fmt"
 	"io"
 
 	"github.com/solidcoredata/dbc/query"
@@ -19,28 +20,205 @@ func NewMemoryStoreRunner(st *MemoryStore) *MemoryStoreRunner {
 	}
 }
 
+// Run the query named by opt.QueryName from s, or from the runner store if
+// s is nil, against the data of the runner store. Statements run in order,
+// each seeing the changes of the statements before it, and no changes are
+// kept if any fails. Access, row rules, search, and paging are applied as
+// described by runner.Authz and runner.Option.
 func (r *MemoryStoreRunner) Run(s *query.Store, opt runner.Option) (query.StreamingResultSet, error) {
-	return StreamingResultSet{}, nil
-}
+	if s == nil {
+		s = r.store.Store()
+	}
+	var q *query.Query
+	for i := range s.Query {
+		if s.Query[i].Name == opt.QueryName {
+			q = &s.Query[i]
+			break
+		}
+	}
+	if q == nil {
+		return nil, fmt.Errorf("memrunner: query %q not found", opt.QueryName)
+	}
+	param := make(map[string]interface{}, len(opt.Param))
+	for _, p := range opt.Param {
+		param[p.Name] = normalize(p.Value)
+	}
 
-type MemoryStore struct {
-	Version int64
-}
+	authz := runner.NewAuthz(s, opt)
 
-func (ms *MemoryStore) Store() *query.Store {
-	return nil
-}
+	// A search applies to the first statement that returns columns.
+	source := make([]*query.Stmt, len(q.Stmt))
+	searched := -1
+	for i := range q.Stmt {
+		source[i] = &q.Stmt[i]
+		if searched < 0 && len(q.Stmt[i].Return) > 0 {
+			searched = i
+		}
+	}
+	if searched < 0 && len(source) > 0 {
+		searched = 0
+	}
+	key, err := r.store.cursorKey()
+	if err != nil {
+		return nil, fmt.Errorf("memrunner: query %q: %w", q.Name, err)
+	}
+	if searched >= 0 {
+		st, sp, err := authz.Search(source[searched], opt)
+		if err != nil {
+			return nil, fmt.Errorf("memrunner: query %q statement %d: %w", q.Name, searched, err)
+		}
+		st, kp, err := authz.Keyset(st, opt, key)
+		if err != nil {
+			return nil, fmt.Errorf("memrunner: query %q statement %d: %w", q.Name, searched, err)
+		}
+		source[searched] = st
+		for _, p := range append(sp, kp...) {
+			param[p.Name] = normalize(p.Value)
+		}
+	}
+
+	stmt := make([]*query.Stmt, len(q.Stmt))
+	rules := make([]*runner.Rules, len(q.Stmt))
+	for i, st := range source {
+		err := authz.Check(st)
+		if err == nil {
+			stmt[i], rules[i], err = authz.Bind(st)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("memrunner: query %q statement %d: %w", q.Name, i, err)
+		}
+	}
 
-func (ms *MemoryStore) AddTable(t *query.StoreTable, data [][]interface{}) error {
-	return nil
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	x := &exec{tx: newTx(r.store), param: param, authz: authz}
+	if opt.Keyset && searched >= 0 {
+		x.keyset = stmt[searched]
+	}
+	var list []*result
+	for i, st := range stmt {
+		res, err := x.stmt(st, rules[i], nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("memrunner: query %q statement %d: %w", q.Name, i, err)
+		}
+		if res != nil {
+			list = append(list, res)
+		}
+	}
+
+	// Each returning statement has a result schema, followed by the
+	// schemas of its interleaved statements.
+	var schema query.ResultSetSchema
+	index := make(map[*query.Stmt]int64)
+	var addSchema func(st *query.Stmt, name string, rs *query.ResultSchema, outer map[string]*memTable) error
+	addSchema = func(st *query.Stmt, name string, rs *query.ResultSchema, outer map[string]*memTable) error {
+		table := x.tables(st, outer)
+		if rs == nil {
+			var err error
+			rs, err = x.schema(st, table)
+			if err != nil {
+				return err
+			}
+		}
+		rs.Name = name
+		index[st] = int64(len(schema.Set))
+		schema.Set = append(schema.Set, rs)
+		for i := range st.Interleave {
+			if err := addSchema(&st.Interleave[i].Stmt, st.Interleave[i].Name, nil, table); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, res := range list {
+		if err := addSchema(res.stmt, "", res.schema, nil); err != nil {
+			return nil, fmt.Errorf("memrunner: query %q: %w", q.Name, err)
+		}
+	}
+
+	set := &StreamingResultSet{}
+	set.item = append(set.item, query.StreamItemResultSetSchema{Schema: schema})
+	var emit func(res *result) error
+	emit = func(res *result) error {
+		rs := schema.Set[index[res.stmt]]
+		rows, err := encodeRows(rs, res)
+		if err != nil {
+			return fmt.Errorf("memrunner: query %q: %w", q.Name, err)
+		}
+		set.item = append(set.item, query.StreamItemResult{SchemaIndex: index[res.stmt]})
+		for n, row := range rows {
+			set.item = append(set.item, row)
+			if res.interleave == nil {
+				continue
+			}
+			for _, sub := range res.interleave[n] {
+				if err := emit(sub); err != nil {
+					return err
+				}
+			}
+		}
+		set.item = append(set.item, query.StreamItemEndOfResult{})
+		return nil
+	}
+	switch {
+	case opt.Delta:
+		if len(schema.Set) != len(list) {
+			return nil, fmt.Errorf("memrunner: query %q: row delta of interleaved results not supported", q.Name)
+		}
+		item, err := r.store.delta(q.Name, callerKey(opt, param), opt.Version, schema, list)
+		if err != nil {
+			return nil, fmt.Errorf("memrunner: query %q: %w", q.Name, err)
+		}
+		set.item = append(set.item, item...)
+	default:
+		for _, res := range list {
+			if err := emit(res); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if x.cursor != nil {
+		token, err := authz.Cursor(source[searched], x.cursor, key)
+		if err != nil {
+			return nil, fmt.Errorf("memrunner: query %q: %w", q.Name, err)
+		}
+		set.item = append(set.item, query.StreamItemCursor{Token: token})
+	}
+	set.item = append(set.item, query.StreamItemEndOfSet{})
+	x.tx.commit()
+	return set, nil
 }
 
-func (ms *MemoryStore) AddQuery(q *query.Query) error {
-	return nil
+// StreamingResultSet returns the items of a query that has run.
+type StreamingResultSet struct {
+	item []query.StreamItem
 }
 
-type StreamingResultSet struct{}
+func (s *StreamingResultSet) Next() (query.StreamItem, error) {
+	if len(s.item) == 0 {
+		return nil, io.EOF
+	}
+	item := s.item[0]
+	s.item = s.item[1:]
+	return item, nil
+}
 
-func (StreamingResultSet) Next() (query.StreamItem, error) {
-	return nil, io.EOF
+// encodeRows returns the rows of the result encoded by the column types
+// of the result schema.
+func encodeRows(rs *query.ResultSchema, res *result) ([]query.StreamItemRow, error) {
+	rows := make([]query.StreamItemRow, len(res.row))
+	for n, row := range res.row {
+		fields := make([]query.StreamField, len(row))
+		for j, v := range row {
+			col := rs.Column[j]
+			f, err := query.EncodeField(col.Type, v)
+			if err != nil {
+				return nil, fmt.Errorf("column %q: %v", col.QueryName, err)
+			}
+			fields[j] = f
+		}
+		rows[n] = query.StreamItemRow{Row: fields, Allow: res.allow[n]}
+	}
+	return rows, nil
 }
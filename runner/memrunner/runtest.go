@@ -0,0 +1,256 @@
+// Copyright 2018 solidcoredata authors.
+
+package memrunner
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/solidcoredata/dbc/compile"
+	"github.com/solidcoredata/dbc/query"
+	"github.com/solidcoredata/dbc/runner"
+)
+
+// RunTest runs the query of the test on a memory store that holds only the
+// test input, and compares the results with the test output. Each input
+// table is narrowed to the columns of the query interface, see
+// query.Interface, plus the columns the input lists. The input need only
+// list the used columns that may not be null and have no default, so the
+// test is unaffected by schema changes the query does not depend on.
+// Tables without input are empty.
+//
+// The returned error describes the first difference. Rows that differ are
+// listed as a diff of the wanted and returned rows.
+func RunTest(s *query.Store, t *compile.Test) error {
+	var used []query.Stmt
+	for _, q := range s.Query {
+		if q.Name == t.Query {
+			used = q.Stmt
+		}
+	}
+	iface, err := query.Interface(s, used...)
+	if err != nil {
+		return fmt.Errorf("memrunner: test %q: %w", t.Name, err)
+	}
+	ms := &MemoryStore{}
+	input := make(map[string]compile.TestRows, len(t.Input))
+	for _, in := range t.Input {
+		input[in.Table] = in
+	}
+	for _, st := range s.Table {
+		in, ok := input[st.Name]
+		if !ok {
+			if err := ms.AddTable(st, nil); err != nil {
+				return fmt.Errorf("memrunner: test %q: %w", t.Name, err)
+			}
+			continue
+		}
+		var it *query.StoreTable
+		for _, x := range iface.Table {
+			if x.Name == st.Name {
+				it = x
+			}
+		}
+		nt, data, err := narrow(st, it, in)
+		if err == nil {
+			err = ms.AddTable(nt, data)
+		}
+		if err != nil {
+			return fmt.Errorf("memrunner: test %q: %w", t.Name, err)
+		}
+	}
+
+	opt := runner.Option{QueryName: t.Query, Port: t.Port, Role: t.Role}
+	for _, p := range t.Param {
+		opt.Param = append(opt.Param, runner.Param{Name: p.Name, Value: p.Value})
+	}
+	stream, err := NewMemoryStoreRunner(ms).Run(s, opt)
+	var buf *query.ResultSetBuffer
+	if err == nil {
+		buf, err = query.ReadAll(stream)
+	}
+	switch {
+	case len(t.Error) > 0 && err == nil:
+		return fmt.Errorf("memrunner: test %q: got no error, want error %q", t.Name, t.Error)
+	case len(t.Error) > 0 && !strings.Contains(err.Error(), t.Error):
+		return fmt.Errorf("memrunner: test %q: got error %q, want error %q", t.Name, err, t.Error)
+	case len(t.Error) > 0:
+		return nil
+	case err != nil:
+		return fmt.Errorf("memrunner: test %q: %w", t.Name, err)
+	}
+
+	// Results of interleaved statements are within the rows of their
+	// parent, so the top level results have a schema.
+	var got []*query.ResultBuffer
+	for i := range buf.Set {
+		if buf.Set[i].Schema != nil {
+			got = append(got, &buf.Set[i])
+		}
+	}
+	if len(got) != len(t.Output) {
+		return fmt.Errorf("memrunner: test %q: got %d results, want %d", t.Name, len(got), len(t.Output))
+	}
+	for i, out := range t.Output {
+		if err := compareResult(got[i], out); err != nil {
+			return fmt.Errorf("memrunner: test %q: result %d: %w", t.Name, i, err)
+		}
+	}
+	return nil
+}
+
+// narrow returns a copy of the table with only the columns of the
+// interface table it, if any, and the input columns, in table order, along
+// with the input rows ordered to match. Columns the input does not list
+// are null, so they must be serial, nullable, or have a default.
+func narrow(st, it *query.StoreTable, in compile.TestRows) (*query.StoreTable, [][]interface{}, error) {
+	nt := *st
+	nt.Column = nil
+	nt.Port = nil
+	var index []int
+	listed := 0
+	for _, col := range st.Column {
+		j := -1
+		for i, name := range in.Column {
+			if name == col.Name {
+				j = i
+			}
+		}
+		if j < 0 {
+			if it == nil || !hasColumn(it, col.Name) {
+				continue
+			}
+			if !col.Serial && col.Default == nil && (!col.Nullable || col.Key) {
+				return nil, nil, fmt.Errorf("input table %q does not list column %q the query uses", st.Name, col.Name)
+			}
+		} else {
+			listed++
+		}
+		nt.Column = append(nt.Column, col)
+		index = append(index, j)
+	}
+	if listed != len(in.Column) {
+		return nil, nil, fmt.Errorf("input table %q lists an unknown column", st.Name)
+	}
+	data := make([][]interface{}, len(in.Row))
+	for n, row := range in.Row {
+		data[n] = make([]interface{}, len(index))
+		for i, j := range index {
+			if j >= 0 {
+				data[n][i] = row[j]
+			}
+		}
+	}
+	return &nt, data, nil
+}
+
+func hasColumn(t *query.StoreTable, name string) bool {
+	for _, col := range t.Column {
+		if col.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// compareResult compares the listed columns of the returned rows with the
+// wanted rows, in order.
+func compareResult(res *query.ResultBuffer, out compile.TestRows) error {
+	index := make([]int, len(out.Column))
+	for i, name := range out.Column {
+		index[i] = -1
+		for j, col := range res.Schema.Column {
+			if col.QueryName == name {
+				index[i] = j
+			}
+		}
+		if index[i] < 0 {
+			return fmt.Errorf("result has no column %q", name)
+		}
+	}
+	want := make([]string, len(out.Row))
+	for n, row := range out.Row {
+		text := make([]string, len(row))
+		for i, v := range row {
+			col := res.Schema.Column[index[i]]
+			v, err := convertValue(col.Type, v)
+			if err != nil {
+				return fmt.Errorf("row %d column %q: %v", n+1, col.QueryName, err)
+			}
+			text[i] = col.QueryName + "=" + testText(v)
+		}
+		want[n] = strings.Join(text, ", ")
+	}
+	got := make([]string, len(res.Row))
+	for n, row := range res.Row {
+		text := make([]string, len(index))
+		for i, j := range index {
+			text[i] = res.Schema.Column[j].QueryName + "=" + testText(row.Column[j].Value)
+		}
+		got[n] = strings.Join(text, ", ")
+	}
+	if d := diffLines(want, got); len(d) > 0 {
+		return fmt.Errorf("rows differ (-want +got):\n%s", d)
+	}
+	return nil
+}
+
+// testText returns the source form of a value.
+func testText(v interface{}) string {
+	switch v := v.(type) {
+	case time.Time:
+		return "'" + v.Format(time.RFC3339Nano) + "'"
+	case *big.Rat:
+		return v.RatString()
+	case []byte:
+		return fmt.Sprintf("'%x'", v)
+	}
+	return query.LiteralString(v)
+}
+
+// diffLines returns the lines of want and got, each marked by "-" if only
+// in want, "+" if only in got, or " " if in both, or the empty string if
+// the lines are the same. Lines in both are found by their longest common
+// sequence.
+func diffLines(want, got []string) string {
+	// common[i][j] is the length of the longest common sequence of
+	// want[i:] and got[j:].
+	common := make([][]int, len(want)+1)
+	for i := range common {
+		common[i] = make([]int, len(got)+1)
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		for j := len(got) - 1; j >= 0; j-- {
+			switch {
+			case want[i] == got[j]:
+				common[i][j] = common[i+1][j+1] + 1
+			case common[i+1][j] >= common[i][j+1]:
+				common[i][j] = common[i+1][j]
+			default:
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+	if common[0][0] == len(want) && len(want) == len(got) {
+		return ""
+	}
+	b := &strings.Builder{}
+	i, j := 0, 0
+	for i < len(want) || j < len(got) {
+		switch {
+		case i < len(want) && j < len(got) && want[i] == got[j]:
+			b.WriteString("  " + want[i] + "\n")
+			i++
+			j++
+		case j == len(got) || (i < len(want) && common[i+1][j] >= common[i][j+1]):
+			b.WriteString("- " + want[i] + "\n")
+			i++
+		default:
+			b.WriteString("+ " + got[j] + "\n")
+			j++
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 solidcoredata authors.
+
+package memrunner
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/solidcoredata/dbc/compile"
+	"github.com/solidcoredata/dbc/parser"
+	"github.com/solidcoredata/dbc/query"
+)
+
+const testSource = `package lib
+
+-- Only the columns the query uses are listed.
+all_books_order test {
+	query all_books
+	input author (id, name) {
+		1, 'Austen'
+		2, 'Woolf'
+	}
+	input book (name, author) {
+		'Emma', 1
+		'Orlando', 2
+		'Persuasion', 1
+	}
+	output (name, "Author") {
+		'Persuasion', 'Austen'
+		'Emma', 'Austen'
+		'Orlando', 'Woolf'
+	}
+}
+
+long_books_half test {
+	query long_books
+	param min_pages = 150
+	input book (id, name, pages) {
+		1, 'Long', 900
+		2, 'Sea of Stories', 500
+		3, 'Seamount', 200
+		4, 'Minimum', 150
+		5, 'Short', 90
+	}
+	output (id, half) {
+		2, 250
+		3, 100
+	}
+}
+
+long_books_missing test {
+	query long_books
+	input book (id, name, pages) {
+		1, 'Long', 200
+	}
+	error 'missing parameter "min_pages"'
+}
+
+all_books_wrong test {
+	query all_books
+	input author (id, name) {
+		1, 'Austen'
+	}
+	input book (name, author) {
+		'Emma', 1
+		'Persuasion', 1
+	}
+	output (name) {
+		'Emma'
+		'Persuasion'
+	}
+}
+
+all_books_no_author test {
+	query all_books
+	input book (name) {
+		'Emma'
+	}
+	output (name, "Author") {
+	}
+}
+`
+
+func TestRunTest(t *testing.T) {
+	ctx := context.Background()
+	f, err := parser.Parse(ctx, "lib.scd", execSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf, err := parser.Parse(ctx, "lib_test.scd", testSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range append(f.Errors, tf.Errors...) {
+		t.Fatal(e)
+	}
+	pkg := &compile.Package{Name: "lib", File: []*parser.File{f}, TestFile: []*parser.File{tf}}
+	s, err := compile.CompilePackages(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := compile.CompileTests(s, pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := len(list), 5; g != w {
+		t.Fatalf("got %d tests, want %d", g, w)
+	}
+	for _, x := range list[:3] {
+		if err := RunTest(s, x); err != nil {
+			t.Errorf("%s: %v", x.Name, err)
+		}
+	}
+	err = RunTest(s, list[3])
+	want := "rows differ (-want +got):\n- name='Emma'\n  name='Persuasion'\n+ name='Emma'\n"
+	if err == nil || !strings.HasSuffix(err.Error(), want) {
+		t.Fatalf("got %v, want diff:\n%s", err, want)
+	}
+	err = RunTest(s, list[4])
+	if err == nil || !strings.Contains(err.Error(), `input table "book" does not list column "author" the query uses`) {
+		t.Fatalf("got %v, want unlisted column error", err)
+	}
+
+	// The columns of a read rule are used by each query of the table.
+	for _, st := range s.Table {
+		if st.Name == "book" {
+			st.Read = []query.Param{{Q: query.Binary(query.ExpNotEqual, query.Column("book", "author"), query.Literal(int64(2)))}}
+		}
+	}
+	err = RunTest(s, list[1])
+	if err == nil || !strings.Contains(err.Error(), `input table "book" does not list column "author" the query uses`) {
+		t.Fatalf("got %v, want unlisted rule column error", err)
+	}
+
+	// Each compiled query has an interface the store satisfies.
+	for _, q := range s.Query {
+		iface, err := query.Interface(s, q.Stmt...)
+		if err == nil {
+			err = query.Satisfies(s, iface)
+		}
+		if err != nil {
+			t.Errorf("%s: %v", q.Name, err)
+		}
+	}
+}
@@ -1,11 +1,8 @@
 package memrunner
 
 import (
-	"fmt"
-	"io"
 	"testing"
 
-	"github.com/solidcoredata/dbc/internal/elist"
 	"github.com/solidcoredata/dbc/query"
 	"github.com/solidcoredata/dbc/runner"
 )
@@ -16,6 +13,26 @@ func TestSimple(t *testing.T) {
 	}
 
 	err := ms.AddTable(&query.StoreTable{
+		Name: "Account",
+		Column: []*query.StoreColumn{
+			{Name: "ID", Type: query.TypeInteger, Key: true, Serial: true},
+		},
+	}, [][]interface{}{{1}, {2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ms.AddTable(&query.StoreTable{
+		Name: "AccountOrganization",
+		Column: []*query.StoreColumn{
+			{Name: "Account", Type: query.TypeInteger, Key: true},
+			{Name: "Organization", Type: query.TypeInteger, Key: true},
+		},
+	}, [][]interface{}{{1, 10}, {2, 30}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ms.AddTable(&query.StoreTable{
 		Name:    "Book",
 		Alias:   "b",
 		Display: "Library Books",
@@ -24,10 +41,21 @@ func TestSimple(t *testing.T) {
 		Column: []*query.StoreColumn{
 			{Name: "ID", Type: query.TypeInteger, Key: true, Serial: true},
 			{Name: "Name", Type: query.TypeString, Display: "Book Name"},
+			{Name: "Organization", Type: query.TypeInteger},
 		},
 		Read: []query.Param{
 			{
-				Q:     "exists (select top 1 1 from Account a join AccountOrganization ao on a.ID = ao.Account where ao.Organization = b.Organization)",
+				Q: query.Exists(&query.SubQuery{
+					From: []*query.ResultTableSchema{
+						{Name: "Account", Alias: "a"},
+						{Name: "AccountOrganization", Alias: "ao"},
+					},
+					Where: query.And(
+						query.Equal(query.Column("a", "ID"), query.Column("ao", "Account")),
+						query.Equal(query.Column("ao", "Organization"), query.Column("b", "Organization")),
+						query.Equal(query.Column("a", "ID"), query.Parameter("Account")),
+					),
+				}),
 				Input: []query.Input{{Type: query.TypeInteger, Name: "Account"}},
 			},
 		},
@@ -42,14 +70,25 @@ func TestSimple(t *testing.T) {
 			},
 		},
 	}, [][]interface{}{
-		{1, "Never a Dull Moment"},
-		{2, "To Kill a Bird"},
+		{1, "Never a Dull Moment", 10},
+		{2, "To Kill a Bird", 20},
 	})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ms.AddQuery(&query.Query{ /*
+	book := &query.ResultTableSchema{Name: "Book", Alias: "b", IsArity: true}
+	err = ms.AddQuery(&query.Query{
+		Name: "Book",
+		Stmt: []query.Stmt{
+			{
+				From: []*query.ResultTableSchema{book},
+				Return: []*query.ColumnSchema{
+					{Table: book, StoreName: "ID", QueryName: "ID", Type: query.TypeInteger, Key: true, Serial: true},
+					{Table: book, StoreName: "Name", QueryName: "Name", Type: query.TypeString, Display: "Book Name"},
+				},
+			},
+		}, /*
 					Type: "jsonnet",
 					Query: `
 			local t = import("table");
@@ -136,6 +175,15 @@ func TestSimple(t *testing.T) {
 
 	r := NewMemoryStoreRunner(ms)
 	st := ms.Store()
+	if len(st.Table) != 3 || st.Table[2].Name != "Book" {
+		t.Fatalf("store tables: %v", st.Table)
+	}
+	if len(st.Query) != 1 || st.Query[0].Name != "Book" {
+		t.Fatalf("store queries: %v", st.Query)
+	}
+	if rows := ms.lookup("Book").row; len(rows) != 2 || rows[1][0] != int64(2) {
+		t.Fatalf("book rows: %v", rows)
+	}
 
 	stream, err := r.Run(st, runner.Option{
 		QueryName: "Book",
@@ -150,45 +198,37 @@ func TestSimple(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	set, err := bufferFromStream(stream)
+	set, err := query.ReadAll(stream)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_ = set
-}
-
-func bufferFromStream(stream query.StreamingResultSet) (*query.ResultSetBuffer, error) {
-	set := &query.ResultSetBuffer{}
+	if len(set.Set) != 1 || len(set.Set[0].Row) != 1 {
+		t.Fatalf("got results %+v", set.Set)
+	}
+	if v := set.Set[0].Row[0].Column[1]; v.Value != "Never a Dull Moment" || v.Schema.QueryName != "Name" {
+		t.Fatalf("got value %+v", v)
+	}
 
-	var el elist.EList
-	var result *query.ResultBuffer
-	for {
-		item, err := stream.Next()
+	// Only books of the organizations of the account may be read.
+	for account, want := range map[int]string{
+		1: "ID=1, Name=Never a Dull Moment\n",
+		2: "",
+	} {
+		stream, err = r.Run(st, runner.Option{
+			QueryName: "Book",
+			Port:      "internal",
+			Role:      []string{"user"},
+			Param:     []runner.Param{{Name: "Account", Value: account}},
+		})
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
+			t.Fatal(err)
 		}
-
-		switch v := item.(type) {
-		default:
-			return nil, fmt.Errorf("unknown state: %v", v.StreamState())
-		case query.StreamItemResultSetSchema:
-			set.Schema = v.Schema
-			set.Set = make([]query.ResultBuffer, len(v.Schema.Set))
-		case query.StreamItemResult:
-			result = &set.Set[v.SchemaIndex]
-			result.Schema = set.Schema.Set[v.SchemaIndex]
-		case query.StreamItemRow:
-			// result.Row = append(result.Row, v.Row)
-		case query.StreamItemEndOfResult:
-			result = nil
-		case query.StreamItemEndOfSet:
-			break
-		case query.StreamItemError:
-			el.Add(v.Error)
+		if got := readResults(t, stream); len(got) != 1 || got[0] != want {
+			t.Fatalf("account %d got %q, want %q", account, got, want)
 		}
 	}
-	return set, el.ErrNil()
+	_, err = r.Run(st, runner.Option{QueryName: "Book", Port: "internal", Role: []string{"user"}})
+	if err == nil {
+		t.Fatal("expected missing Account parameter error")
+	}
 }
@@ -0,0 +1,284 @@
+// Copyright 2018 solidcoredata authors.
+
+package memrunner
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/solidcoredata/dbc/query"
+)
+
+// MemoryStore holds tables, their rows, and named queries in memory.
+// It is the reference backend: other runners should return the same
+// results for the same store.
+type MemoryStore struct {
+	Version int64
+
+	mu    sync.RWMutex
+	table []*memTable
+	query []query.Query
+
+	// Rows of the results of each version token, oldest first, kept to
+	// return row deltas.
+	version []resultVersion
+
+	// Key that seals the cursors of keyset pages, made on first use.
+	keyOnce sync.Once
+	key     []byte
+	keyErr  error
+}
+
+// memTable is a table definition and its rows. Each row has one value per
+// column, stored as the Go type of the column data type.
+type memTable struct {
+	def    *query.StoreTable
+	key    []int           // Index of each key column.
+	keys   map[string]bool // Key value of each row.
+	serial map[int]int64   // Last value of each serial column.
+	row    [][]interface{}
+}
+
+// Store returns the definitions of the tables and queries in the store.
+func (ms *MemoryStore) Store() *query.Store {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return ms.store()
+}
+
+// store returns the definitions of the store. The caller must hold the
+// lock.
+func (ms *MemoryStore) store() *query.Store {
+	s := &query.Store{
+		Table: make([]*query.StoreTable, len(ms.table)),
+		Query: append([]query.Query(nil), ms.query...),
+	}
+	for i, t := range ms.table {
+		s.Table[i] = t.def
+	}
+	return s
+}
+
+// lookup returns the named table, or nil if not found.
+func (ms *MemoryStore) lookup(name string) *memTable {
+	for _, t := range ms.table {
+		if t.def.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// AddTable adds the table definition along with its initial rows. Each row
+// lists a value for each column in order. Values are checked against the
+// column type, nullability, and table key. A NULL serial column is set to
+// the next serial value, and a NULL column that is not nullable is set to
+// its default.
+func (ms *MemoryStore) AddTable(t *query.StoreTable, data [][]interface{}) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if len(t.Name) == 0 {
+		return fmt.Errorf("memrunner: table missing name")
+	}
+	if ms.lookup(t.Name) != nil {
+		return fmt.Errorf("memrunner: table %q already exists", t.Name)
+	}
+	if len(t.Column) == 0 {
+		return fmt.Errorf("memrunner: table %q has no columns", t.Name)
+	}
+	mt := &memTable{
+		def:    t,
+		keys:   make(map[string]bool, len(data)),
+		serial: make(map[int]int64),
+	}
+	seen := make(map[string]bool, len(t.Column))
+	for i, col := range t.Column {
+		if seen[col.Name] {
+			return fmt.Errorf("memrunner: table %q column %q declared more than once", t.Name, col.Name)
+		}
+		seen[col.Name] = true
+		if col.Type <= query.TypeUnknown || col.Type > query.TypeArray {
+			return fmt.Errorf("memrunner: table %q column %q has unknown type %v", t.Name, col.Name, col.Type)
+		}
+		if col.Serial {
+			if col.Type != query.TypeInteger {
+				return fmt.Errorf("memrunner: table %q serial column %q must be an integer", t.Name, col.Name)
+			}
+			mt.serial[i] = 0
+		}
+		if col.Key {
+			mt.key = append(mt.key, i)
+		}
+	}
+	for i, row := range data {
+		if err := mt.insert(row); err != nil {
+			return fmt.Errorf("memrunner: table %q row %d: %v", t.Name, i, err)
+		}
+	}
+	ms.table = append(ms.table, mt)
+	return nil
+}
+
+// column returns the index of the named column, or -1 if not found.
+func (mt *memTable) column(name string) int {
+	for i, col := range mt.def.Column {
+		if col.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// clone returns a copy of the table that may be changed without changing mt.
+// Rows are replaced rather than changed, so only the row list is copied.
+func (mt *memTable) clone() *memTable {
+	c := &memTable{
+		def:    mt.def,
+		key:    mt.key,
+		keys:   make(map[string]bool, len(mt.keys)),
+		serial: make(map[int]int64, len(mt.serial)),
+		row:    append([][]interface{}(nil), mt.row...),
+	}
+	for k := range mt.keys {
+		c.keys[k] = true
+	}
+	for i, n := range mt.serial {
+		c.serial[i] = n
+	}
+	return c
+}
+
+// checkRow returns the row values converted to the column types. When fill
+// is set, a NULL serial column is set to the next serial value and a NULL
+// column that is not nullable is set to its default.
+func (mt *memTable) checkRow(row []interface{}, fill bool) ([]interface{}, error) {
+	cols := mt.def.Column
+	if len(row) != len(cols) {
+		return nil, fmt.Errorf("got %d values, want %d", len(row), len(cols))
+	}
+	out := make([]interface{}, len(cols))
+	for i, col := range cols {
+		v, err := convertValue(col.Type, row[i])
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %v", col.Name, err)
+		}
+		if v == nil && fill {
+			switch {
+			case col.Serial:
+				v = mt.serial[i] + 1
+			case col.Default != nil && !col.Nullable:
+				v, err = convertValue(col.Type, col.Default)
+				if err != nil {
+					return nil, fmt.Errorf("column %q default: %v", col.Name, err)
+				}
+			}
+		}
+		if v == nil && (!col.Nullable || col.Key) {
+			return nil, fmt.Errorf("column %q may not be null", col.Name)
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// useSerial records the serial values of the row as used.
+func (mt *memTable) useSerial(row []interface{}) {
+	for i, n := range mt.serial {
+		if v, ok := row[i].(int64); ok && v > n {
+			mt.serial[i] = v
+		}
+	}
+}
+
+// insert checks and converts the row values before adding the row.
+func (mt *memTable) insert(row []interface{}) error {
+	out, err := mt.checkRow(row, true)
+	if err != nil {
+		return err
+	}
+	if len(mt.key) > 0 {
+		k := mt.rowKey(out)
+		if mt.keys[k] {
+			return fmt.Errorf("duplicate key %s", k)
+		}
+		mt.keys[k] = true
+	}
+	mt.useSerial(out)
+	mt.row = append(mt.row, out)
+	return nil
+}
+
+// update replaces the row at index i after checking the new values.
+func (mt *memTable) update(i int, row []interface{}) error {
+	out, err := mt.checkRow(row, false)
+	if err != nil {
+		return err
+	}
+	if len(mt.key) > 0 {
+		old, k := mt.rowKey(mt.row[i]), mt.rowKey(out)
+		if k != old {
+			if mt.keys[k] {
+				return fmt.Errorf("duplicate key %s", k)
+			}
+			delete(mt.keys, old)
+			mt.keys[k] = true
+		}
+	}
+	mt.useSerial(out)
+	mt.row[i] = out
+	return nil
+}
+
+// remove deletes the rows at each index.
+func (mt *memTable) remove(index map[int]bool) {
+	list := mt.row[:0]
+	for i, row := range mt.row {
+		if !index[i] {
+			list = append(list, row)
+			continue
+		}
+		if len(mt.key) > 0 {
+			delete(mt.keys, mt.rowKey(row))
+		}
+	}
+	for i := len(list); i < len(mt.row); i++ {
+		mt.row[i] = nil
+	}
+	mt.row = list
+}
+
+// rowKey returns the key value of the row.
+func (mt *memTable) rowKey(row []interface{}) string {
+	list := make([]string, len(mt.key))
+	for i, ki := range mt.key {
+		list[i] = keyString(row[ki])
+	}
+	return "(" + strings.Join(list, ", ") + ")"
+}
+
+// AddQuery adds a named query. Each table and column the query uses,
+// as query.Interface finds them, must already be in the store.
+func (ms *MemoryStore) AddQuery(q *query.Query) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if len(q.Name) == 0 {
+		return fmt.Errorf("memrunner: query missing name")
+	}
+	for _, existing := range ms.query {
+		if existing.Name == q.Name {
+			return fmt.Errorf("memrunner: query %q already exists", q.Name)
+		}
+	}
+	if len(q.Stmt) == 0 {
+		return fmt.Errorf("memrunner: query %q has no statements", q.Name)
+	}
+	if _, err := query.Interface(ms.store(), q.Stmt...); err != nil {
+		return fmt.Errorf("memrunner: query %q: %w", q.Name, err)
+	}
+	ms.query = append(ms.query, *q)
+	return nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2018 solidcoredata authors.
+
+package memrunner
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/solidcoredata/dbc/query"
+)
+
+func accountTable() *query.StoreTable {
+	return &query.StoreTable{
+		Name: "Account",
+		Column: []*query.StoreColumn{
+			{Name: "ID", Type: query.TypeInteger, Key: true, Serial: true},
+			{Name: "Name", Type: query.TypeString},
+			{Name: "Balance", Type: query.TypeDecimal, Default: int64(0)},
+			{Name: "Owner", Type: query.TypeUUID, Nullable: true},
+		},
+	}
+}
+
+func TestAddTable(t *testing.T) {
+	list := []struct {
+		name string
+		row  [][]interface{}
+		err  string
+	}{
+		{name: "valid", row: [][]interface{}{
+			{1, "a", "1.50", nil},
+			{nil, "b", nil, "6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		}},
+		{name: "count", row: [][]interface{}{{1, "a"}}, err: "got 2 values, want 4"},
+		{name: "type", row: [][]interface{}{{1, 2, nil, nil}}, err: `column "Name": int is not a valid String value`},
+		{name: "null", row: [][]interface{}{{1, nil, nil, nil}}, err: `column "Name" may not be null`},
+		{name: "decimal", row: [][]interface{}{{1, "a", "x", nil}}, err: `column "Balance": invalid Decimal value "x"`},
+		{name: "uuid", row: [][]interface{}{{1, "a", nil, "abc"}}, err: `column "Owner": invalid UUID value "abc"`},
+		{name: "key", row: [][]interface{}{{1, "a", nil, nil}, {1, "b", nil, nil}}, err: "row 1: duplicate key (1)"},
+	}
+	for _, item := range list {
+		t.Run(item.name, func(t *testing.T) {
+			ms := &MemoryStore{}
+			err := ms.AddTable(accountTable(), item.row)
+			if len(item.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), item.err) {
+					t.Fatalf("got error %v, want %q", err, item.err)
+				}
+				if len(ms.Store().Table) != 0 {
+					t.Fatal("table added after error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			row := ms.lookup("Account").row
+			if len(row) != len(item.row) {
+				t.Fatalf("got %d rows, want %d", len(row), len(item.row))
+			}
+			if row[1][0] != int64(2) {
+				t.Fatalf("got serial %v, want 2", row[1][0])
+			}
+			if r := row[1][2].(*big.Rat); r.Sign() != 0 {
+				t.Fatalf("got default %v, want 0", r)
+			}
+			if row[0][3] != nil {
+				t.Fatalf("got owner %v, want NULL", row[0][3])
+			}
+		})
+	}
+}
+
+func TestAddTableDefinition(t *testing.T) {
+	ms := &MemoryStore{}
+	if err := ms.AddTable(accountTable(), nil); err != nil {
+		t.Fatal(err)
+	}
+	list := []struct {
+		table *query.StoreTable
+		err   string
+	}{
+		{accountTable(), `table "Account" already exists`},
+		{&query.StoreTable{}, "table missing name"},
+		{&query.StoreTable{Name: "Empty"}, `table "Empty" has no columns`},
+		{&query.StoreTable{Name: "T", Column: []*query.StoreColumn{{Name: "A"}}}, `column "A" has unknown type Unknown`},
+		{&query.StoreTable{Name: "T", Column: []*query.StoreColumn{{Name: "A", Type: query.TypeString, Serial: true}}}, `serial column "A" must be an integer`},
+		{&query.StoreTable{Name: "T", Column: []*query.StoreColumn{{Name: "A", Type: query.TypeString}, {Name: "A", Type: query.TypeString}}}, `column "A" declared more than once`},
+	}
+	for _, item := range list {
+		err := ms.AddTable(item.table, nil)
+		if err == nil || !strings.Contains(err.Error(), item.err) {
+			t.Errorf("got error %v, want %q", err, item.err)
+		}
+	}
+}
+
+func TestAddQuery(t *testing.T) {
+	ms := &MemoryStore{}
+	if err := ms.AddTable(accountTable(), nil); err != nil {
+		t.Fatal(err)
+	}
+	a := &query.ResultTableSchema{Name: "Account", Alias: "a", IsArity: true}
+	q := &query.Query{Name: "Accounts", Stmt: []query.Stmt{{From: []*query.ResultTableSchema{a}}}}
+	if err := ms.AddQuery(q); err != nil {
+		t.Fatal(err)
+	}
+	list := []struct {
+		query *query.Query
+		err   string
+	}{
+		{q, `query "Accounts" already exists`},
+		{&query.Query{}, "query missing name"},
+		{&query.Query{Name: "None"}, `query "None" has no statements`},
+		{&query.Query{Name: "Other", Stmt: []query.Stmt{{
+			From: []*query.ResultTableSchema{a},
+			Where: query.Exists(&query.SubQuery{
+				From: []*query.ResultTableSchema{{Name: "Org", Alias: "o"}},
+			}),
+		}}}, `query "Other": query: table "Org" not found`},
+		{&query.Query{Name: "Return", Stmt: []query.Stmt{{
+			From:   []*query.ResultTableSchema{a},
+			Return: []*query.ColumnSchema{{Table: a, StoreName: "Email", QueryName: "Email"}},
+		}}}, `query "Return": query: column Account.Email not found`},
+		{&query.Query{Name: "Order", Stmt: []query.Stmt{{
+			From:  []*query.ResultTableSchema{a},
+			Order: []query.Order{{Exp: query.Column("a", "Email")}},
+		}}}, `query "Order": query: column Account.Email not found`},
+		{&query.Query{Name: "Insert", Stmt: []query.Stmt{{
+			Insert: []*query.ColumnSchema{{Table: &query.ResultTableSchema{Name: "Org", Alias: "o"}, StoreName: "Name", QueryName: "Name"}},
+			Set:    []query.Exp{query.Literal("x")},
+		}}}, `query "Insert": query: table "Org" not found`},
+	}
+	for _, item := range list {
+		err := ms.AddQuery(item.query)
+		if err == nil || !strings.Contains(err.Error(), item.err) {
+			t.Errorf("got error %v, want %q", err, item.err)
+		}
+	}
+	if st := ms.Store(); len(st.Query) != 1 {
+		t.Fatalf("got %d queries, want 1", len(st.Query))
+	}
+}
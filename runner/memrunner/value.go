@@ -0,0 +1,171 @@
+// Copyright 2018 solidcoredata authors.
+
+package memrunner
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/solidcoredata/dbc/query"
+)
+
+// convertValue returns v as the Go type of t, as listed in package query.
+// Compatible Go types, such as int for TypeInteger, a string for TypeUUID,
+// or RFC 3339 text for the time types, are converted.
+func convertValue(t query.DataType, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	bad := func() (interface{}, error) {
+		return nil, fmt.Errorf("%T is not a valid %v value", v, t)
+	}
+	switch t {
+	default:
+		return nil, fmt.Errorf("unknown data type %v", t)
+	case query.TypeString:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case query.TypeBinary:
+		switch v := v.(type) {
+		case []byte:
+			return append([]byte(nil), v...), nil
+		case string:
+			return []byte(v), nil
+		}
+	case query.TypeBoolean:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case query.TypeInteger:
+		if i, ok := toInt64(v); ok {
+			return i, nil
+		}
+	case query.TypeFloat:
+		switch f := v.(type) {
+		case float64:
+			return f, nil
+		case float32:
+			return float64(f), nil
+		}
+		if i, ok := toInt64(v); ok {
+			return float64(i), nil
+		}
+	case query.TypeDecimal, query.TypeRational:
+		switch r := v.(type) {
+		case *big.Rat:
+			return new(big.Rat).Set(r), nil
+		case float64:
+			if out := new(big.Rat).SetFloat64(r); out != nil {
+				return out, nil
+			}
+		case string:
+			if out, ok := new(big.Rat).SetString(r); ok {
+				return out, nil
+			}
+			return nil, fmt.Errorf("invalid %v value %q", t, r)
+		}
+		if i, ok := toInt64(v); ok {
+			return new(big.Rat).SetInt64(i), nil
+		}
+	case query.TypeTime, query.TypeDate, query.TypeDatez, query.TypeTimestamp, query.TypeTimestampZ:
+		switch tm := v.(type) {
+		case time.Time:
+			return tm, nil
+		case string:
+			for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"} {
+				if out, err := time.Parse(layout, tm); err == nil {
+					return out, nil
+				}
+			}
+			return nil, fmt.Errorf("invalid %v value %q", t, tm)
+		}
+	case query.TypeUUID:
+		switch u := v.(type) {
+		case [16]byte:
+			return u, nil
+		case []byte:
+			if len(u) == 16 {
+				var out [16]byte
+				copy(out[:], u)
+				return out, nil
+			}
+		case string:
+			var out [16]byte
+			b, err := hex.DecodeString(strings.ReplaceAll(u, "-", ""))
+			if err != nil || len(b) != len(out) {
+				return nil, fmt.Errorf("invalid %v value %q", t, u)
+			}
+			copy(out[:], b)
+			return out, nil
+		}
+	case query.TypeJSON:
+		var b []byte
+		switch j := v.(type) {
+		case json.RawMessage:
+			b = j
+		case []byte:
+			b = j
+		case string:
+			b = []byte(j)
+		default:
+			return bad()
+		}
+		if !json.Valid(b) {
+			return nil, fmt.Errorf("invalid %v value", t)
+		}
+		return json.RawMessage(append([]byte(nil), b...)), nil
+	case query.TypeArray:
+		if a, ok := v.([]interface{}); ok {
+			return append([]interface{}(nil), a...), nil
+		}
+	}
+	return bad()
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint:
+		if uint64(v) <= 1<<63-1 {
+			return int64(v), true
+		}
+	case uint64:
+		if v <= 1<<63-1 {
+			return int64(v), true
+		}
+	}
+	return 0, false
+}
+
+// keyString returns a string that is equal for equal stored values.
+func keyString(v interface{}) string {
+	switch v := v.(type) {
+	case *big.Rat:
+		return v.RatString()
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case json.RawMessage:
+		return string(v)
+	}
+	return fmt.Sprintf("%#v", v)
+}
@@ -0,0 +1,190 @@
+// Copyright 2018 solidcoredata authors.
+
+package runner
+
+import (
+	"sort"
+
+	"github.com/solidcoredata/dbc/query"
+)
+
+// Report is the access granted to each role of each port of a store, for
+// auditing the authorization policy. Lists are sorted by name so reports
+// of different releases may be compared.
+type Report struct {
+	Open    []string     `json:"open,omitempty"`    // Tables that declare no ports and allow any caller full access.
+	Port    []PortReport `json:"port"`              // Ports declared by any table.
+	Warning []string     `json:"warning,omitempty"` // Grants that exceed their limit, see query.CheckAuthn.
+}
+
+// PortReport is the access granted to each role of a port.
+type PortReport struct {
+	Name string       `json:"name"`
+	Role []RoleReport `json:"role"`
+}
+
+// RoleReport is the access a caller with a single role is granted to
+// each table that declares ports, and the queries the caller may run.
+type RoleReport struct {
+	Name  string        `json:"name"`
+	Table []TableReport `json:"table"`
+	Query []QueryReport `json:"query"`
+}
+
+// Access is the operations an Authn grant allows.
+type Access struct {
+	Read   bool `json:"read"`
+	Return bool `json:"return"`
+	Insert bool `json:"insert"`
+	Update bool `json:"update"`
+	Delete bool `json:"delete"`
+}
+
+// NewAccess returns the operations the grant allows.
+func NewAccess(a query.Authn) Access {
+	return Access{
+		Read:   a.Satisfies(query.AllowRead),
+		Return: a.Satisfies(query.AllowReturn),
+		Insert: a.Satisfies(query.AllowInsert),
+		Update: a.Satisfies(query.AllowUpdate),
+		Delete: a.Satisfies(query.AllowDelete),
+	}
+}
+
+// TableReport is the access to a table and its columns. Read holds the
+// conditions each read row must match; Deny fields hold the predicates
+// that deny an operation on a row. Predicates are in source form.
+type TableReport struct {
+	Name       string         `json:"name"`
+	Access     Access         `json:"access"`
+	Read       []string       `json:"read,omitempty"`
+	DenyRead   string         `json:"deny_read,omitempty"`
+	DenyInsert string         `json:"deny_insert,omitempty"`
+	DenyUpdate string         `json:"deny_update,omitempty"`
+	DenyDelete string         `json:"deny_delete,omitempty"`
+	Column     []ColumnReport `json:"column"`
+}
+
+// ColumnReport is the access to a column of a table.
+type ColumnReport struct {
+	Name       string `json:"name"`
+	Access     Access `json:"access"`
+	DenyRead   string `json:"deny_read,omitempty"`
+	DenyUpdate string `json:"deny_update,omitempty"`
+}
+
+// QueryReport reports if a caller may run a query. Row rules are not
+// evaluated, so a query that is allowed may still be denied on a row.
+type QueryReport struct {
+	Name  string `json:"name"`
+	Allow bool   `json:"allow"`
+	Deny  string `json:"deny,omitempty"` // Reason the query is denied.
+}
+
+// NewReport returns the authorization report of the store.
+func NewReport(s *query.Store) *Report {
+	r := &Report{}
+	var portName []string
+	ports := make(map[string]map[string]bool)
+	for _, t := range s.Table {
+		if len(t.Port) == 0 {
+			r.Open = append(r.Open, t.Name)
+			continue
+		}
+		for name, p := range t.Port {
+			roles := ports[name]
+			if roles == nil {
+				roles = make(map[string]bool)
+				ports[name] = roles
+				portName = append(portName, name)
+			}
+			for role := range p.RoleAuthn {
+				roles[role] = true
+			}
+			for _, cp := range p.Column {
+				for role := range cp.RoleAuthn {
+					roles[role] = true
+				}
+			}
+		}
+	}
+	sort.Strings(r.Open)
+	sort.Strings(portName)
+
+	for _, port := range portName {
+		pr := PortReport{Name: port}
+		for _, role := range sortedSet(ports[port]) {
+			pr.Role = append(pr.Role, roleReport(s, port, role))
+		}
+		r.Port = append(r.Port, pr)
+	}
+	for _, w := range query.CheckAuthn(s) {
+		r.Warning = append(r.Warning, w.String())
+	}
+	return r
+}
+
+func roleReport(s *query.Store, port, role string) RoleReport {
+	a := NewAuthz(s, Option{Port: port, Role: []string{role}})
+	rr := RoleReport{Name: role}
+	for _, t := range s.Table {
+		if len(t.Port) == 0 {
+			continue
+		}
+		p := t.Port[port]
+		tr := TableReport{
+			Name:       t.Name,
+			Access:     NewAccess(a.Table(t.Name)),
+			DenyRead:   predicate(p.DenyRead),
+			DenyInsert: predicate(p.DenyInsert),
+			DenyUpdate: predicate(p.DenyUpdate),
+			DenyDelete: predicate(p.DenyDelete),
+		}
+		for _, rp := range t.Read {
+			if e := predicate(rp); len(e) > 0 {
+				tr.Read = append(tr.Read, e)
+			}
+		}
+		for i, col := range t.Column {
+			cr := ColumnReport{Name: col.Name, Access: NewAccess(a.Column(t.Name, col.Name))}
+			if i < len(p.Column) {
+				cr.DenyRead = predicate(p.Column[i].DenyRead)
+				cr.DenyUpdate = predicate(p.Column[i].DenyUpdate)
+			}
+			tr.Column = append(tr.Column, cr)
+		}
+		rr.Table = append(rr.Table, tr)
+	}
+	sort.Slice(rr.Table, func(i, j int) bool { return rr.Table[i].Name < rr.Table[j].Name })
+
+	for _, q := range s.Query {
+		qr := QueryReport{Name: q.Name, Allow: true}
+		for i := range q.Stmt {
+			if err := a.Check(&q.Stmt[i]); err != nil {
+				qr.Allow, qr.Deny = false, err.Error()
+				break
+			}
+		}
+		rr.Query = append(rr.Query, qr)
+	}
+	sort.Slice(rr.Query, func(i, j int) bool { return rr.Query[i].Name < rr.Query[j].Name })
+	return rr
+}
+
+// predicate returns the predicate of a rule in source form, or an empty
+// string if the rule is unset.
+func predicate(p query.Param) string {
+	if p.Q.IsZero() {
+		return ""
+	}
+	return p.Q.String()
+}
+
+func sortedSet(m map[string]bool) []string {
+	list := make([]string, 0, len(m))
+	for k := range m {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 solidcoredata authors.
+
+package runner
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/solidcoredata/dbc/query"
+)
+
+func TestReport(t *testing.T) {
+	s := authzStore()
+	web := s.Table[0].Port["web"]
+	web.DenyDelete = query.Param{Q: query.Binary(query.ExpGreater, query.Column("b", "price"), query.Literal(int64(10)))}
+	s.Table[0].Port["web"] = web
+	b := &query.ResultTableSchema{Name: "book", Alias: "b", IsArity: true}
+	s.Query = []query.Query{
+		{Name: "price", Stmt: []query.Stmt{{From: []*query.ResultTableSchema{b}, Return: []*query.ColumnSchema{{Table: b, StoreName: "price", QueryName: "price"}}}}},
+		{Name: "name", Stmt: []query.Stmt{{From: []*query.ResultTableSchema{b}, Return: []*query.ColumnSchema{{Table: b, StoreName: "name", QueryName: "name"}}}}},
+	}
+
+	r := NewReport(s)
+	if g, w := strings.Join(r.Open, ","), "open"; g != w {
+		t.Fatalf("got open tables %q, want %q", g, w)
+	}
+	if len(r.Port) != 1 || r.Port[0].Name != "web" || len(r.Port[0].Role) != 2 {
+		t.Fatalf("got ports %+v", r.Port)
+	}
+	editor, reader := r.Port[0].Role[0], r.Port[0].Role[1]
+	if editor.Name != "editor" || reader.Name != "reader" {
+		t.Fatalf("got roles %q, %q", editor.Name, reader.Name)
+	}
+	book := editor.Table[0]
+	if want := (Access{Read: true, Return: true, Update: true}); book.Access != want {
+		t.Errorf("editor book got %+v, want %+v", book.Access, want)
+	}
+	if g, w := book.DenyDelete, "b.price > 10"; g != w {
+		t.Errorf("got deny delete %q, want %q", g, w)
+	}
+	if want := (Access{Read: true}); reader.Table[0].Column[2].Access != want {
+		t.Errorf("reader price got %+v, want %+v", reader.Table[0].Column[2].Access, want)
+	}
+	for _, q := range reader.Query {
+		if q.Allow != (q.Name == "name") {
+			t.Errorf("reader query %s allow %t, deny %q", q.Name, q.Allow, q.Deny)
+		}
+	}
+	for _, q := range editor.Query {
+		if !q.Allow {
+			t.Errorf("editor query %s denied: %s", q.Name, q.Deny)
+		}
+	}
+	// The editor price grant exceeds the table grant.
+	if len(r.Warning) != 1 || !strings.Contains(r.Warning[0], `column "price" port "web" role "editor"`) {
+		t.Errorf("got warnings %q", r.Warning)
+	}
+
+	buf, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back Report
+	if err := json.Unmarshal(buf, &back); err != nil {
+		t.Fatal(err)
+	}
+	if g := back.Port[0].Role[1].Table[0]; g.DenyDelete != book.DenyDelete || g.Column[2].Access != reader.Table[0].Column[2].Access {
+		t.Errorf("JSON report got %s", buf)
+	}
+}
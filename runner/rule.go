@@ -0,0 +1,329 @@
+// Copyright 2018 solidcoredata authors.
+
+package runner
+
+import (
+	"fmt"
+
+	"github.com/solidcoredata/dbc/query"
+)
+
+// Row rules are the predicates of a table that limit access to each row:
+// the StoreTable.Read conditions, which a row must match to be read, and
+// the Deny predicates of the caller port, which deny an operation on a row
+// when true. A predicate that is NULL also denies the operation.
+//
+// Predicates refer to the row by the table alias, or by the table name if
+// the table has no alias, and to caller parameters by name. Each input of
+// a predicate must be given as a parameter by the caller.
+
+// ColumnDeny holds the conditions that deny access to a value of a
+// returned column. A condition is unset if access is never denied.
+type ColumnDeny struct {
+	Read   query.Exp // The value is returned as NULL.
+	Update query.Exp // The value may not be updated.
+}
+
+// Rules are the row rules of a statement that the runner applies to each
+// row, after the rules that limit the matched rows are added to the
+// statement condition.
+type Rules struct {
+	Insert     query.Exp    // The inserted row is denied.
+	Column     []ColumnDeny // Deny conditions of each Return column.
+	Interleave []*Rules     // Rules of each interleaved statement.
+
+	// Lock holds the lock conditions of the updated and deleted tables,
+	// see query.Lock. They are not part of the statement condition. If no
+	// row is matched, or a matched row fails them, the runner returns
+	// ErrConflict, as does a SQL backend that changes no row.
+	Lock []query.Exp
+}
+
+// Bind returns a copy of the statement with the row rules of each table
+// it reads, updates, or deletes added to its condition, along with the
+// rules the runner must apply to each row. The caller must give the lock
+// parameter of each lock condition. Sub-query tables have their read rules
+// added to the sub-query condition, and interleaved statements are bound
+// in turn.
+func (a *Authz) Bind(st *query.Stmt) (*query.Stmt, *Rules, error) {
+	out := *st
+	out.Anchor = nil
+	out.ExpList = nil
+
+	b := &binder{a: a}
+	where := b.exp(st.Condition())
+	var cond []query.Exp
+	if !where.IsZero() {
+		cond = append(cond, where)
+	}
+	for _, rt := range st.From {
+		cond = append(cond, b.read(rt)...)
+	}
+	updated := make(map[string]bool)
+	for _, col := range st.Update {
+		if col.Table == nil {
+			continue
+		}
+		if d := b.column(col.Table, col.StoreName, "update"); !d.IsZero() {
+			cond = append(cond, query.Not(d))
+		}
+		if updated[col.Table.Alias] {
+			continue
+		}
+		updated[col.Table.Alias] = true
+		if d := b.deny(col.Table, "update"); !d.IsZero() {
+			cond = append(cond, query.Not(d))
+		}
+	}
+	for _, rt := range st.Delete {
+		if d := b.deny(rt, "delete"); !d.IsZero() {
+			cond = append(cond, query.Not(d))
+		}
+	}
+	lock := query.Lock(a.store, st)
+	for _, e := range lock {
+		if name := e.Arg[1].Name; !a.param[name] {
+			b.errorf("statement requires lock parameter %q", name)
+		}
+	}
+	if len(cond) > 0 {
+		out.Where = query.And(cond...)
+	}
+
+	out.Select = make([]query.Exp, len(st.Select))
+	for i, e := range st.Select {
+		out.Select[i] = b.exp(e)
+	}
+	out.Order = make([]query.Order, len(st.Order))
+	for i, o := range st.Order {
+		out.Order[i] = query.Order{Exp: b.exp(o.Exp), Desc: o.Desc}
+	}
+	out.Set = make([]query.Exp, len(st.Set))
+	for i, e := range st.Set {
+		out.Set[i] = b.exp(e)
+	}
+
+	r := &Rules{Column: make([]ColumnDeny, len(st.Return)), Lock: lock}
+	if len(st.Insert) > 0 && st.Insert[0].Table != nil {
+		r.Insert = b.deny(st.Insert[0].Table, "insert")
+	}
+	for i, col := range st.Return {
+		if col.Table == nil || (i < len(st.Select) && st.Select[i].Op != query.ExpColumn) {
+			continue
+		}
+		r.Column[i] = ColumnDeny{
+			Read:   b.column(col.Table, col.StoreName, "read"),
+			Update: b.column(col.Table, col.StoreName, "update"),
+		}
+	}
+	if b.err != nil {
+		return nil, nil, b.err
+	}
+	out.Interleave = make([]query.Interleave, len(st.Interleave))
+	r.Interleave = make([]*Rules, len(st.Interleave))
+	for i, il := range st.Interleave {
+		bound, rules, err := a.Bind(&il.Stmt)
+		if err != nil {
+			return nil, nil, err
+		}
+		out.Interleave[i] = query.Interleave{Name: il.Name, Stmt: *bound}
+		r.Interleave[i] = rules
+	}
+	return &out, r, nil
+}
+
+// binder finds the row rules of tables, bound to the table alias used
+// by a statement.
+type binder struct {
+	a   *Authz
+	err error
+}
+
+func (b *binder) errorf(f string, v ...interface{}) {
+	if b.err == nil {
+		b.err = fmt.Errorf("runner: "+f, v...)
+	}
+}
+
+// rule returns the predicate of a rule of table t, bound to alias,
+// or an unset expression if there is no predicate.
+func (b *binder) rule(t *query.StoreTable, p query.Param, alias string) query.Exp {
+	if p.Q.IsZero() {
+		return query.Exp{}
+	}
+	for _, in := range p.Input {
+		if !b.a.param[in.Name] {
+			b.errorf("table %q rule requires parameter %q", t.Name, in.Name)
+		}
+	}
+	from := t.Alias
+	if len(from) == 0 {
+		from = t.Name
+	}
+	return rebind(p.Q, from, alias)
+}
+
+func (b *binder) port(rt *query.ResultTableSchema) (*query.StoreTable, query.StoreTablePort) {
+	t := b.a.table(rt.Name)
+	if t == nil {
+		return nil, query.StoreTablePort{}
+	}
+	return t, t.Port[b.a.port]
+}
+
+// read returns the conditions a row of the table must match to be read.
+func (b *binder) read(rt *query.ResultTableSchema) []query.Exp {
+	t, port := b.port(rt)
+	if t == nil {
+		return nil
+	}
+	var list []query.Exp
+	for _, p := range t.Read {
+		if e := b.rule(t, p, rt.Alias); !e.IsZero() {
+			list = append(list, e)
+		}
+	}
+	if e := b.rule(t, port.DenyRead, rt.Alias); !e.IsZero() {
+		list = append(list, query.Not(e))
+	}
+	return list
+}
+
+// deny returns the table predicate that denies the operation on a row.
+func (b *binder) deny(rt *query.ResultTableSchema, op string) query.Exp {
+	t, port := b.port(rt)
+	if t == nil {
+		return query.Exp{}
+	}
+	switch op {
+	case "insert":
+		return b.rule(t, port.DenyInsert, rt.Alias)
+	case "update":
+		return b.rule(t, port.DenyUpdate, rt.Alias)
+	case "delete":
+		return b.rule(t, port.DenyDelete, rt.Alias)
+	}
+	return query.Exp{}
+}
+
+// column returns the column predicate that denies the operation on a value.
+func (b *binder) column(rt *query.ResultTableSchema, column, op string) query.Exp {
+	t, port := b.port(rt)
+	if t == nil {
+		return query.Exp{}
+	}
+	for i, col := range t.Column {
+		if col.Name != column || i >= len(port.Column) {
+			continue
+		}
+		if op == "read" {
+			return b.rule(t, port.Column[i].DenyRead, rt.Alias)
+		}
+		return b.rule(t, port.Column[i].DenyUpdate, rt.Alias)
+	}
+	return query.Exp{}
+}
+
+// exp returns the expression with the read rules of each sub-query table
+// added to the sub-query condition.
+func (b *binder) exp(e query.Exp) query.Exp {
+	if len(e.Arg) > 0 {
+		arg := make([]query.Exp, len(e.Arg))
+		for i, x := range e.Arg {
+			arg[i] = b.exp(x)
+		}
+		e.Arg = arg
+	}
+	if e.Sub != nil {
+		sub := *e.Sub
+		var cond []query.Exp
+		if w := b.exp(sub.Where); !w.IsZero() {
+			cond = append(cond, w)
+		}
+		for _, rt := range sub.From {
+			cond = append(cond, b.read(rt)...)
+		}
+		sub.Where = query.Exp{}
+		if len(cond) > 0 {
+			sub.Where = query.And(cond...)
+		}
+		sub.Select = make([]query.Exp, len(e.Sub.Select))
+		for i, s := range e.Sub.Select {
+			sub.Select[i] = b.exp(s)
+		}
+		e.Sub = &sub
+	}
+	return e
+}
+
+// rebind returns the expression with each column of alias from referring
+// to alias to instead. Sub-queries that declare from are not changed, and
+// a sub-query that declares to is given another alias first, so the
+// columns it declares are not confused with the columns of to.
+func rebind(e query.Exp, from, to string) query.Exp {
+	if e.Op == query.ExpColumn && e.Alias == from {
+		e.Alias = to
+		return e
+	}
+	if len(e.Arg) > 0 {
+		arg := make([]query.Exp, len(e.Arg))
+		for i, x := range e.Arg {
+			arg[i] = rebind(x, from, to)
+		}
+		e.Arg = arg
+	}
+	if e.Sub != nil {
+		for _, rt := range e.Sub.From {
+			if rt.Alias == from {
+				return e
+			}
+		}
+		sub := *e.Sub
+		for i, rt := range sub.From {
+			if rt.Alias != to {
+				continue
+			}
+			fresh := freshAlias(e, from, to)
+			sub.From = append([]*query.ResultTableSchema(nil), sub.From...)
+			nt := *rt
+			nt.Alias = fresh
+			sub.From[i] = &nt
+			sub.Where = rebind(sub.Where, to, fresh)
+			sel := make([]query.Exp, len(sub.Select))
+			for j, s := range sub.Select {
+				sel[j] = rebind(s, to, fresh)
+			}
+			sub.Select = sel
+		}
+		sub.Where = rebind(sub.Where, from, to)
+		sel := make([]query.Exp, len(sub.Select))
+		for i, s := range sub.Select {
+			sel[i] = rebind(s, from, to)
+		}
+		sub.Select = sel
+		e.Sub = &sub
+	}
+	return e
+}
+
+// freshAlias returns an alias based on to that is not used within e and is
+// neither from nor to.
+func freshAlias(e query.Exp, from, to string) string {
+	used := map[string]bool{from: true, to: true}
+	query.Walk(e, func(e query.Exp) bool {
+		if e.Op == query.ExpColumn {
+			used[e.Alias] = true
+		}
+		if e.Sub != nil {
+			for _, rt := range e.Sub.From {
+				used[rt.Alias] = true
+			}
+		}
+		return true
+	})
+	for i := 1; ; i++ {
+		if a := fmt.Sprintf("%s%d", to, i); !used[a] {
+			return a
+		}
+	}
+}
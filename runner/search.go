@@ -0,0 +1,281 @@
+// Copyright 2018 solidcoredata authors.
+
+package runner
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/solidcoredata/dbc/query"
+)
+
+// Filter limits the rows to those where the Return column compares to
+// Value. Op is one of =, <>, <, <=, >, >=, like, or in, where Value is a
+// []interface{} of the listed values. A NULL Value matches no rows.
+type Filter struct {
+	Column string // QueryName of a Return column.
+	Op     string
+	Value  interface{}
+}
+
+// Sort orders the rows by the Return column.
+type Sort struct {
+	Column string // QueryName of a Return column.
+	Desc   bool
+}
+
+// Page returns Limit rows after skipping Offset rows of the rows the
+// statement returns. A page never returns more rows than the statement
+// limit, and a zero Limit keeps the statement limit.
+type Page struct {
+	Limit  int64
+	Offset int64
+}
+
+var filterOp = map[string]query.ExpOp{
+	"=":    query.ExpEqual,
+	"<>":   query.ExpNotEqual,
+	"!=":   query.ExpNotEqual,
+	"<":    query.ExpLess,
+	"<=":   query.ExpLessEqual,
+	">":    query.ExpGreater,
+	">=":   query.ExpGreaterEqual,
+	"like": query.ExpLike,
+	"in":   query.ExpIn,
+}
+
+// SearchParam returns the name of the parameter that holds a value of a
+// search filter, such as "search.0" or "search.0.1" for the second value
+// of an in list.
+func SearchParam(filter int, value ...int) string {
+	name := "search." + strconv.Itoa(filter)
+	for _, v := range value {
+		name += "." + strconv.Itoa(v)
+	}
+	return name
+}
+
+// Search returns a copy of the statement with the Filter, Sort, and Page
+// of the option applied, along with the parameters that hold the
+// filter values. Filters are added to the statement condition. Sort keys
+// come before the statement order, and a Page is taken from the rows within
+// the statement limit and offset.
+//
+// Filter and sort columns must be Return columns of the statement, and are
+// compared by the value the statement returns. Values are only passed as
+// parameters, never as query text. The caller must be allowed to read each
+// filter and sort column, and a column with a read rule for the caller port
+// may not be searched, as the search would reveal the hidden values. Each
+// filter value must be of the column type. The returned statement is then
+// checked like any other.
+func (a *Authz) Search(st *query.Stmt, opt Option) (*query.Stmt, []Param, error) {
+	if len(opt.Filter) == 0 && len(opt.Sort) == 0 && opt.Page == (Page{}) {
+		return st, nil, nil
+	}
+	if len(st.Return) == 0 {
+		return nil, nil, fmt.Errorf("runner: search of a statement that returns no columns")
+	}
+	value := func(name string, v ...interface{}) (query.Exp, error) {
+		for i, col := range st.Return {
+			if col.QueryName != name {
+				continue
+			}
+			computed := col.Table == nil || (len(st.Select) == len(st.Return) && st.Select[i].Op != query.ExpColumn)
+			if err := a.searchColumn(col, computed, v...); err != nil {
+				return query.Exp{}, err
+			}
+			if len(st.Select) == len(st.Return) {
+				return st.Select[i], nil
+			}
+			if col.Table == nil {
+				break
+			}
+			return query.Column(col.Table.Alias, col.StoreName), nil
+		}
+		return query.Exp{}, fmt.Errorf("runner: search column %q is not returned", name)
+	}
+
+	out := *st
+	out.Anchor = nil
+	out.ExpList = nil
+	var cond []query.Exp
+	if where := st.Condition(); !where.IsZero() {
+		cond = append(cond, where)
+	}
+	var param []Param
+	for i, f := range opt.Filter {
+		op, ok := filterOp[f.Op]
+		if !ok {
+			return nil, nil, fmt.Errorf("runner: search column %q has unknown operator %q", f.Column, f.Op)
+		}
+		if op != query.ExpIn {
+			if _, ok := f.Value.(string); op == query.ExpLike && f.Value != nil && !ok {
+				return nil, nil, fmt.Errorf("runner: search column %q like requires a text value", f.Column)
+			}
+			left, err := value(f.Column, f.Value)
+			if err != nil {
+				return nil, nil, err
+			}
+			param = append(param, Param{Name: SearchParam(i), Value: f.Value})
+			cond = append(cond, query.Binary(op, left, query.Parameter(SearchParam(i))))
+			continue
+		}
+		list, ok := f.Value.([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, nil, fmt.Errorf("runner: search column %q in requires a list of values", f.Column)
+		}
+		left, err := value(f.Column, list...)
+		if err != nil {
+			return nil, nil, err
+		}
+		in := query.Exp{Op: query.ExpIn, Arg: []query.Exp{left}}
+		for j, v := range list {
+			param = append(param, Param{Name: SearchParam(i, j), Value: v})
+			in.Arg = append(in.Arg, query.Parameter(SearchParam(i, j)))
+		}
+		cond = append(cond, in)
+	}
+	if len(cond) > 0 {
+		out.Where = query.And(cond...)
+	}
+
+	if len(opt.Sort) > 0 {
+		out.Order = make([]query.Order, 0, len(opt.Sort)+len(st.Order))
+		for _, s := range opt.Sort {
+			e, err := value(s.Column)
+			if err != nil {
+				return nil, nil, err
+			}
+			out.Order = append(out.Order, query.Order{Exp: e, Desc: s.Desc})
+		}
+		out.Order = append(out.Order, st.Order...)
+	}
+
+	if opt.Page != (Page{}) {
+		if opt.Page.Limit < 0 || opt.Page.Offset < 0 {
+			return nil, nil, fmt.Errorf("runner: search page limit %d and offset %d may not be negative", opt.Page.Limit, opt.Page.Offset)
+		}
+		if err := page(&out, st, opt.Page); err != nil {
+			return nil, nil, err
+		}
+	}
+	return &out, param, nil
+}
+
+// page sets the limit and offset of out to those of the page within the
+// limit and offset of the statement. A statement limit must be a literal,
+// so the page limit can be clamped to it.
+func page(out, st *query.Stmt, p Page) error {
+	offset := query.Literal(p.Offset)
+	switch {
+	case st.Offset.IsZero():
+	case st.Offset.Op == query.ExpLiteral:
+		n, ok := st.Offset.Value.(int64)
+		if !ok {
+			return fmt.Errorf("runner: search page of a statement whose offset %s is not an integer", st.Offset)
+		}
+		offset = query.Literal(n + p.Offset)
+	default:
+		offset = query.Binary(query.ExpAdd, st.Offset, offset)
+	}
+	out.Offset = offset
+
+	out.Limit = query.Exp{}
+	if p.Limit > 0 {
+		out.Limit = query.Literal(p.Limit)
+	}
+	if st.Limit.IsZero() {
+		return nil
+	}
+	n, ok := st.Limit.Value.(int64)
+	if st.Limit.Op != query.ExpLiteral || !ok {
+		if p.Limit == 0 && p.Offset == 0 {
+			out.Limit = st.Limit
+			return nil
+		}
+		return fmt.Errorf("runner: search page of a statement whose limit %s is not a literal", st.Limit)
+	}
+	n -= p.Offset
+	if n < 0 {
+		n = 0
+	}
+	if p.Limit == 0 || p.Limit > n {
+		out.Limit = query.Literal(n)
+	}
+	return nil
+}
+
+// searchColumn returns an error if the caller may not search the returned
+// column, or if a filter value is not of the column type. A computed column
+// is not read from a table, so only its type is checked.
+func (a *Authz) searchColumn(col *query.ColumnSchema, computed bool, value ...interface{}) error {
+	if col.Type != query.TypeUnknown {
+		for _, v := range value {
+			if !searchType(col.Type, v) {
+				return fmt.Errorf("runner: search column %q value %T is not a %v value", col.QueryName, v, col.Type)
+			}
+		}
+	}
+	if computed {
+		return nil
+	}
+	if err := a.needColumn(col.Table.Name, col.StoreName, query.AllowRead); err != nil {
+		return err
+	}
+	t := a.table(col.Table.Name)
+	if t == nil {
+		return nil
+	}
+	p := t.Port[a.port]
+	for i, c := range t.Column {
+		if c.Name == col.StoreName && i < len(p.Column) && !p.Column[i].DenyRead.Q.IsZero() {
+			return fmt.Errorf("runner: search column %q has a read rule", col.QueryName)
+		}
+	}
+	return nil
+}
+
+// searchType reports if the filter value may be compared to a value of the
+// data type. Numbers may be of any Go number type, and text may stand for
+// values that have a text form.
+func searchType(t query.DataType, v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	var integer, float bool
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		integer = true
+	case reflect.Float32, reflect.Float64:
+		float = true
+	}
+	_, text := v.(string)
+	switch t {
+	case query.TypeString:
+		return text
+	case query.TypeBinary:
+		_, ok := v.([]byte)
+		return ok || text
+	case query.TypeBoolean:
+		_, ok := v.(bool)
+		return ok
+	case query.TypeInteger:
+		return integer
+	case query.TypeFloat:
+		return integer || float
+	case query.TypeDecimal, query.TypeRational:
+		_, ok := v.(*big.Rat)
+		return ok || integer || float || text
+	case query.TypeTime, query.TypeDate, query.TypeDatez, query.TypeTimestamp, query.TypeTimestampZ:
+		_, ok := v.(time.Time)
+		return ok || text
+	case query.TypeUUID:
+		_, ok := v.([16]byte)
+		return ok || text
+	}
+	return true
+}
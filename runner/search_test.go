@@ -0,0 +1,131 @@
+// Copyright 2018 solidcoredata authors.
+
+package runner
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/solidcoredata/dbc/query"
+)
+
+func TestSearch(t *testing.T) {
+	s := authzStore()
+	book := &query.ResultTableSchema{Name: "book", Alias: "b", IsArity: true}
+	col := func(name string, dt query.DataType) *query.ColumnSchema {
+		return &query.ColumnSchema{Table: book, StoreName: name, QueryName: name, Type: dt}
+	}
+	st := &query.Stmt{
+		From:   []*query.ResultTableSchema{book},
+		Where:  query.Binary(query.ExpGreater, query.Column("b", "id"), query.Literal(int64(0))),
+		Return: []*query.ColumnSchema{col("id", query.TypeInteger), col("name", query.TypeString)},
+		Order:  []query.Order{{Exp: query.Column("b", "id")}},
+		Limit:  query.Literal(int64(100)),
+	}
+	a := NewAuthz(s, Option{Port: "web", Role: []string{"reader"}})
+	before := st.Condition().String()
+
+	if got, param, err := a.Search(st, Option{}); got != st || param != nil || err != nil {
+		t.Fatalf("empty search got %v %v %v, want the statement", got, param, err)
+	}
+
+	got, param, err := a.Search(st, Option{
+		Filter: []Filter{
+			{Column: "name", Op: "like", Value: "E%"},
+			{Column: "id", Op: "in", Value: []interface{}{1, 2}},
+		},
+		Sort: []Sort{{Column: "name", Desc: true}},
+		Page: Page{Limit: 10, Offset: 20},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := got.Condition().String(), "and (b.id > 0, b.name like search.0, b.id in (search.1.0, search.1.1))"; g != w {
+		t.Errorf("condition got %q, want %q", g, w)
+	}
+	wantParam := []Param{{"search.0", "E%"}, {"search.1.0", 1}, {"search.1.1", 2}}
+	if !reflect.DeepEqual(param, wantParam) {
+		t.Errorf("params got %v, want %v", param, wantParam)
+	}
+	if len(got.Order) != 2 || got.Order[0].Exp.Name != "name" || !got.Order[0].Desc || got.Order[1].Exp.Name != "id" {
+		t.Errorf("order got %v, want name desc then id", got.Order)
+	}
+	if got.Limit.Value != int64(10) || got.Offset.Value != int64(20) {
+		t.Errorf("page got limit %v offset %v, want 10 and 20", got.Limit, got.Offset)
+	}
+	if st.Condition().String() != before || len(st.Order) != 1 {
+		t.Errorf("source statement changed: %v", st.Condition())
+	}
+
+	list := []struct {
+		role   string
+		filter Filter
+		err    string
+	}{
+		{"reader", Filter{Column: "price", Op: "="}, `search column "price" is not returned`},
+		{"reader", Filter{Column: "name", Op: "~"}, `unknown operator "~"`},
+		{"reader", Filter{Column: "name", Op: "in", Value: "x"}, "requires a list of values"},
+		{"reader", Filter{Column: "id", Op: "=", Value: "1"}, "value string is not a Integer value"},
+		{"reader", Filter{Column: "id", Op: "in", Value: []interface{}{1, 2.5}}, "value float64 is not a Integer value"},
+		{"reader", Filter{Column: "id", Op: "like", Value: 1}, "like requires a text value"},
+		{"other", Filter{Column: "name", Op: "=", Value: "x"}, "not allowed"},
+	}
+	for _, item := range list {
+		a := NewAuthz(s, Option{Port: "web", Role: []string{item.role}})
+		_, _, err := a.Search(st, Option{Filter: []Filter{item.filter}})
+		if err == nil || !strings.Contains(err.Error(), item.err) {
+			t.Errorf("%s %v: got %v, want %q", item.role, item.filter, err, item.err)
+		}
+	}
+	if _, _, err := a.Search(st, Option{Page: Page{Limit: -1}}); err == nil {
+		t.Error("expected negative page error")
+	}
+
+	// A page is taken from the rows within the statement limit.
+	for _, item := range []struct {
+		page          Page
+		limit, offset int64
+	}{
+		{Page{Limit: 500}, 100, 0},
+		{Page{Offset: 90}, 10, 90},
+		{Page{Limit: 20, Offset: 90}, 10, 90},
+		{Page{Limit: 20, Offset: 200}, 0, 200},
+	} {
+		got, _, err := a.Search(st, Option{Page: item.page})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Limit.Value != item.limit || got.Offset.Value != item.offset {
+			t.Errorf("page %+v got limit %v offset %v, want %d and %d", item.page, got.Limit, got.Offset, item.limit, item.offset)
+		}
+	}
+	withOffset := *st
+	withOffset.Offset = query.Literal(int64(30))
+	got, _, err = a.Search(&withOffset, Option{Page: Page{Limit: 10, Offset: 80}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Limit.Value != int64(10) || got.Offset.Value != int64(110) {
+		t.Errorf("page of offset statement got limit %v offset %v, want 10 and 110", got.Limit, got.Offset)
+	}
+	withParam := *st
+	withParam.Limit = query.Parameter("limit")
+	if _, _, err := a.Search(&withParam, Option{Page: Page{Limit: 10}}); err == nil || !strings.Contains(err.Error(), "limit limit is not a literal") {
+		t.Errorf("got %v, want limit parameter error", err)
+	}
+	if _, _, err := a.Search(&query.Stmt{}, Option{Sort: []Sort{{Column: "id"}}}); err == nil {
+		t.Error("expected error for a statement without return columns")
+	}
+
+	// A column with a read rule may not be searched.
+	port := s.Table[0].Port["web"]
+	port.Column[1].DenyRead = query.Param{Q: query.Equal(query.Column("book", "id"), query.Literal(int64(1)))}
+	s.Table[0].Port["web"] = port
+	_, _, err = a.Search(st, Option{Sort: []Sort{{Column: "name"}}})
+	var ae *AuthzError
+	if err == nil || errors.As(err, &ae) || !strings.Contains(err.Error(), "read rule") {
+		t.Errorf("got %v, want read rule error", err)
+	}
+}
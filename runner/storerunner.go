@@ -1,9 +1,23 @@
 package runner
 
 import (
+	"errors"
+
 	"github.com/solidcoredata/dbc/query"
 )
 
+// ErrVersion is returned when a runner does not know the version token
+// a row delta was requested from, or the token is of another query or
+// caller. The caller should run the query again without the Version to get
+// all of the rows.
+var ErrVersion = errors.New("runner: unknown result version")
+
+// ErrConflict is returned when an update or delete of a table with lock
+// columns matches a row whose lock values differ from the lock parameters,
+// as the row changed since the caller read it, or matches no row, as it
+// may have been deleted. See query.Lock.
+var ErrConflict = errors.New("runner: lock conflict, row lock values changed")
+
 type Param struct {
 	Name  string
 	Value interface{}
@@ -15,6 +29,25 @@ type Option struct {
 	Port  string
 	Role  []string
 	Param []Param
+
+	// Delta requests a version token with the results. If Version is also
+	// set to a token of a prior run of the query with the same port, roles,
+	// and parameters, each result holds only the rows inserted, updated, and
+	// deleted since then.
+	Delta   bool
+	Version string
+
+	// Filter, Sort, and Page search the rows of the query, such as for a
+	// list screen. See Authz.Search.
+	Filter []Filter
+	Sort   []Sort
+	Page   Page
+
+	// Keyset pages the searched rows by key rather than by offset. A full
+	// page of Page.Limit rows ends with a cursor, which is passed back as
+	// Cursor to get the next page. See Authz.Keyset.
+	Keyset bool
+	Cursor string
 }
 
 type StoreRunner interface {